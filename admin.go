@@ -0,0 +1,152 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Commands shown by "admin commands".
+const adminCommandsShown = 20
+
+// func admin {{{
+
+// admin jobs
+// admin commands
+// admin replay {id}
+// admin offboard {@slackusername}
+//
+// Display state of the application itself.
+func admin(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opAdmin)
+	if !ok {
+		return slackResponse{Text: help(ctx, "admin")}
+	}
+	switch p.view {
+	case "jobs":
+		return adminJobs(ctx)
+	case "commands":
+		return adminCommands(ctx)
+	case "replay":
+		return adminReplay(ctx, p)
+	case "offboard":
+		return adminOffboard(ctx, p)
+	}
+	return slackResponse{Text: help(ctx, "admin")}
+} // }}}
+
+// func adminCommands {{{
+
+// admin commands
+//
+// Display the commands received most recently, with ids to replay them by.
+func adminCommands(ctx context.Context) slackResponse {
+	if commandLogDays == 0 {
+		return slackResponse{Text: "Commands aren't recorded, set `command_log_days` to record them"}
+	}
+	ids, entries, err := loadCommands(ctx, adminCommandsShown)
+	if err != nil {
+		log.Warningf(ctx, "(admin) error loading commands - %s", err)
+		return slackResponse{Text: errorExternal}
+	}
+	if len(entries) == 0 {
+		return slackResponse{Text: "No commands recorded"}
+	}
+	var str []string
+	for i, e := range entries {
+		str = append(str, fmt.Sprintf("`%d` %s <@%s|%s> in #%s: `%s %s`",
+			ids[i], e.Time.In(timezone).Format(dateFormat), e.UserId, e.UserName, e.ChannelName, e.Command, e.Text))
+	}
+	return slackResponse{Text: "Recent commands, newest first:", Attachments: []attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}}}
+} // }}}
+
+// func adminReplay {{{
+
+// admin replay {id}
+//
+// Decode the recorded command again as the user who sent it, from the workspace
+// and channel it was sent in, so commands from shared channels are limited the
+// same way. Read only operations are run, others only show what they would be
+// run with, so a reported problem can be reproduced safely.
+func adminReplay(ctx context.Context, p opAdmin) slackResponse {
+	entry, err := loadCommand(ctx, p.id)
+	if err != nil {
+		log.Warningf(ctx, "(admin) error loading command %d - %s", p.id, err)
+		return slackResponse{Text: errorExternal}
+	}
+	if entry == nil {
+		return slackResponse{Text: fmt.Sprintf("Sorry, there's no command %d %s", p.id, humanErrorEmoji)}
+	}
+	sr := slackCommandParams{
+		UserId:       entry.UserId,
+		UserName:     entry.UserName,
+		TeamId:       entry.TeamId,
+		EnterpriseId: entry.EnterpriseId,
+		ChannelId:    entry.ChannelId,
+		ChannelName:  entry.ChannelName,
+		Command:      entry.Command,
+		Text:         entry.Text,
+	}
+	ctx = context.WithValue(ctx, ctxKeyUserId, entry.UserId)
+	ctx = context.WithValue(ctx, ctxKeyCommand, entry.Command)
+
+	res := slackResponse{Text: fmt.Sprintf("Replaying `%s %s` of <@%s|%s> from %s:",
+		entry.Command, entry.Text, entry.UserId, entry.UserName, entry.Time.In(timezone).Format(dateFormat))}
+	var str []string
+	if entry.Response != "" {
+		str = append(str, "Original response: "+entry.Response)
+	}
+	operation, params, errstr := decodeOperationParams(ctx, sr)
+	switch {
+	case errstr == errorInput:
+		str = append(str, fmt.Sprintf("Decoding `%s` failed on invalid input", operation))
+	case errstr != "":
+		str = append(str, fmt.Sprintf("Decoding `%s` failed: %s", operation, errstr))
+	case stringInSlice(operation, readOnlyOperations) || isPreview(params):
+		replayed := dispatch(ctx, sr)
+		str = append(str, "Replayed response: "+replayed.Text)
+		res.Attachments = append(res.Attachments, replayed.Attachments...)
+	default:
+		str = append(str, fmt.Sprintf("`%s` changes state, not run. Decoded parameters: `%+v`", operation, params))
+	}
+	res.Attachments = append([]attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}}, res.Attachments...)
+	return res
+} // }}}
+
+// func isPreview {{{
+
+// Check if the parameters are of "schedule {team} preview", which only displays.
+func isPreview(params interface{}) bool {
+	p, ok := params.(opCadence)
+	return ok && p.preview > 0
+} // }}}
+
+// func cleanupCronHandler {{{
+
+// Cron handler deleting commands recorded longer than "command_log_days" ago.
+func cleanupCronHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	// Only AppEngine cron is allowed to call this.
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		log.Warningf(ctx, "(cron) request not from cron")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	// Commands recorded before the log was disabled are kept for the shortest retention.
+	days := commandLogDays
+	if days == 0 {
+		days = 1
+	}
+	n, err := deleteCommandsBefore(ctx, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		log.Warningf(ctx, "(cron) error deleting commands - %s", err)
+		http.Error(w, "error deleting commands", http.StatusInternalServerError)
+		return
+	}
+	log.Infof(ctx, "(cron) deleted %d commands", n)
+	w.WriteHeader(http.StatusOK)
+} // }}}
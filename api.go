@@ -0,0 +1,49 @@
+package slackoncallbot
+
+import (
+	"encoding/json"
+	"errors"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Base URL of Slack Web API.
+const slackAPIURL = "https://slack.com/api/"
+
+// Common part of all Slack Web API responses.
+type slackAPIResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// func callSlackAPI {{{
+
+// Call a Slack Web API method with form encoded params.
+// "token" is added automatically. A non-ok response from Slack is returned as an error.
+func callSlackAPI(ctx context.Context, method string, params url.Values) error {
+	params.Set("token", slackAPIToken)
+	req, err := http.NewRequest("POST", slackAPIURL+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	start := time.Now()
+	res, err := slackHTTPClient(ctx).Do(req.WithContext(ctx))
+	observeSlackLatency(ctx, time.Since(start))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var sr slackAPIResponse
+	if err = json.NewDecoder(res.Body).Decode(&sr); err != nil {
+		return err
+	}
+	if !sr.Ok {
+		return errors.New(method + ": " + sr.Error)
+	}
+	return nil
+} // }}}
@@ -0,0 +1,152 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"strings"
+	"time"
+)
+
+// func assign {{{
+
+// assign {team} {@slackusername} {from..to}
+//
+// Put the user on primary on-call of the team for the days, over whoever the
+// rotation says. Without a user, display the assignments of the team.
+func assign(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opAssign)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "assign")}
+	}
+
+	res := slackResponse{}
+	if p.id == "" {
+		oncallMut.RLock()
+		r := findRotation(p.team)
+		if r == nil {
+			oncallMut.RUnlock()
+			res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+			return res
+		}
+		str := describeAssignments(r, time.Now())
+		oncallMut.RUnlock()
+		if str == nil {
+			res.Text = fmt.Sprintf("No assignments for %s", p.team)
+			return res
+		}
+		res.Text = "Assignments for: " + p.team
+		res.Attachments = []attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}}
+		return res
+	}
+
+	// Make sure the requested user exists.
+	if !p.cancel {
+		u, err := getSlackUserDetail(ctx, p.id, false)
+		if err != nil {
+			log.Warningf(ctx, "(assign) error getting user %s - %s", p.name, err)
+			res.Text = errorExternal
+			return res
+		}
+		if u == nil {
+			res.Text = fmt.Sprintf("Sorry! <@%s> doesn't exist in Slack %s", p.name, humanErrorEmoji)
+			return res
+		}
+	}
+
+	now := time.Now()
+	oncallMut.Lock()
+	r := findRotation(p.team)
+	if r == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	// Drop the ones over by now while at it.
+	var current []*assignmentProperty
+	for _, a := range assignments[r.Team] {
+		if a.End.After(now) {
+			current = append(current, a)
+		}
+	}
+	assignments[r.Team] = current
+
+	var detail string
+	if p.cancel {
+		var kept []*assignmentProperty
+		var removed int
+		for _, a := range current {
+			if a.Id != p.id {
+				kept = append(kept, a)
+				continue
+			}
+			if err := deleteState(ctx, a.Key); err != nil {
+				log.Warningf(ctx, "(assign) error deleting assignment - %s", err)
+				kept = append(kept, a)
+				continue
+			}
+			removed++
+		}
+		assignments[r.Team] = kept
+		if removed == 0 {
+			oncallMut.Unlock()
+			res.Text = fmt.Sprintf("Sorry, <@%s> has no upcoming assignments for %s %s", p.name, p.team, humanErrorEmoji)
+			return res
+		}
+		detail = fmt.Sprintf("removed %d assignment(s) of <@%s>", removed, p.name)
+	} else {
+		start, end := assignmentRange(r, p.from, p.to)
+		if !end.After(now) {
+			oncallMut.Unlock()
+			res.Text = fmt.Sprintf("Sorry, %s is over already %s", describeRange(start, end, teamLocation(r)), humanErrorEmoji)
+			return res
+		}
+		for _, a := range current {
+			if a.Start.Before(end) && start.Before(a.End) {
+				oncallMut.Unlock()
+				res.Text = fmt.Sprintf("Sorry, <@%s> is assigned to %s %s already %s", a.Name, p.team, describeRange(a.Start, a.End, teamLocation(r)), humanErrorEmoji)
+				return res
+			}
+		}
+		a := &assignmentProperty{Team: r.Team, Name: p.name, Id: p.id, Start: start, End: end, CreatedBy: p.by.name}
+		if err := saveAssignment(ctx, a); err != nil {
+			log.Warningf(ctx, "(assign) error saving assignment - %s", err)
+			oncallMut.Unlock()
+			res.Text = errorExternal
+			return res
+		}
+		assignments[r.Team] = insertAssignment(current, a)
+		detail = fmt.Sprintf("assigned <@%s> %s", p.name, describeRange(start, end, teamLocation(r)))
+	}
+	recordHistory(ctx, r.Team, "assign", p.by.name, detail, r.Rotations)
+	oncallMut.Unlock()
+
+	res.Text = fmt.Sprintf("Success! %s%s for %s\nNew list:", strings.ToUpper(detail[:1]), detail[1:], p.team)
+	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
+	return res
+} // }}}
+
+// func describeAssignments {{{
+
+// Return a line per assignment of the team not over at the time.
+// Caller must hold oncallMut.
+func describeAssignments(r *oncallProperty, t time.Time) (str []string) {
+	for _, a := range assignments[r.Team] {
+		if !a.End.After(t) {
+			continue
+		}
+		s := fmt.Sprintf("<@%s|%s> %s", a.Id, a.Name, describeRange(a.Start, a.End, teamLocation(r)))
+		if !t.Before(a.Start) {
+			s += " - _now_"
+		}
+		str = append(str, s)
+	}
+	return
+} // }}}
+
+// func describeRange {{{
+
+// Human readable time range in the timezone.
+func describeRange(start, end time.Time, loc *time.Location) string {
+	return fmt.Sprintf("from %s to %s", start.In(loc).Format(dateFormat), end.In(loc).Format(dateFormat))
+} // }}}
@@ -0,0 +1,95 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"github.com/fladz/slack-oncall-command/oncall"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"strings"
+)
+
+// func at {{{
+
+// at {team} {time}
+//
+// Display who was primary and secondary on-call of the team at a past time, ie.
+// for incident reviews. The on-call list is the one recorded by the last change
+// before the time, with handoffs of the cadence since then applied. Assignments
+// and the latest override are taken into account, shifts and quiet hours are
+// the current ones.
+func at(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opAt)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "at")}
+	}
+
+	entry, err := loadHistoryAt(ctx, p.team, p.at)
+	if err != nil {
+		log.Warningf(ctx, "(at) error loading history - %s", err)
+		return slackResponse{Text: errorExternal}
+	}
+	assigned, err := loadAssignmentsAt(ctx, p.team, p.at)
+	if err != nil {
+		log.Warningf(ctx, "(at) error loading assignments - %s", err)
+		return slackResponse{Text: errorExternal}
+	}
+
+	oncallMut.RLock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.RUnlock()
+		return slackResponse{Text: fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)}
+	}
+	if entry == nil {
+		oncallMut.RUnlock()
+		return slackResponse{Text: fmt.Sprintf("Sorry, no changes of %s were recorded before %s, so there's no list to tell from %s", p.team, p.at.In(timezone).Format(dateFormat), humanErrorEmoji)}
+	}
+	then := *current
+	then.Rotations = entry.Rotations
+	// The list recorded had every handoff up to the change applied.
+	then.Paused = false
+	then.Advanced = cadenceAdvances(&then, entry.Time)
+	team := engineTeam(&then)
+	oncallMut.RUnlock()
+
+	team.Covers = nil
+	if then.OverrideId != "" && !p.at.Before(then.OverrideStart) && p.at.Before(then.OverrideUntil) {
+		team.Covers = append(team.Covers, oncall.Cover{
+			Member: oncall.Member{ID: then.OverrideId, Name: then.OverrideName, Label: "override"},
+			Start:  then.OverrideStart,
+			End:    then.OverrideUntil,
+		})
+	}
+	for _, a := range assigned {
+		team.Covers = append(team.Covers, oncall.Cover{
+			Member: oncall.Member{ID: a.Id, Name: a.Name, Label: "assigned"},
+			Start:  a.Start,
+			End:    a.End,
+		})
+	}
+
+	res := oncall.Resolve(team, p.at)
+	var str []string
+	for _, role := range []struct {
+		name string
+		m    *oncall.Member
+	}{{"Primary", res.Primary}, {"Secondary", res.Secondary}} {
+		if role.m == nil {
+			str = append(str, role.name+": _nobody_")
+			continue
+		}
+		s := fmt.Sprintf("%s: <@%s|%s>", role.name, role.m.ID, role.m.Name)
+		if role.m.Label == "override" || role.m.Label == "assigned" {
+			s += " (" + role.m.Label + ")"
+		}
+		str = append(str, s)
+	}
+	return slackResponse{
+		Text: fmt.Sprintf("On-call of %s at %s:", p.team, p.at.In(timezone).Format("Mon "+dateFormat)),
+		Attachments: []attachment{{
+			Color:  defaultColor,
+			Text:   strings.Join(str, "\n"),
+			Footer: fmt.Sprintf("from the list as of %s (%s by %s), current shifts and quiet hours", entry.Time.In(timezone).Format(dateFormat), entry.Operation, entry.By),
+		}},
+	}
+} // }}}
@@ -0,0 +1,116 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// func phoneAuditCronHandler {{{
+
+// Cron handler nagging on-call list members without a phone, or with a phone set
+// by "setphone" longer ago than "phone_audit_months", to update it. Managers get
+// how many of their team's members have a phone.
+func phoneAuditCronHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	// Only AppEngine cron is allowed to call this.
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		log.Warningf(ctx, "(cron) request not from cron")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if phoneAuditMonths < 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err := ensureState(ctx); err != nil {
+		http.Error(w, "error loading state", http.StatusInternalServerError)
+		return
+	}
+	overrides, err := loadPhoneOverrideDates(ctx)
+	if err != nil {
+		log.Warningf(ctx, "(cron) error loading phone overrides - %s", err)
+		http.Error(w, "error loading phone overrides", http.StatusInternalServerError)
+		return
+	}
+
+	// Copy over teams so we don't hold the lock while talking to Slack.
+	oncallMut.RLock()
+	teams := make([]oncallProperty, 0, len(rotations))
+	for _, t := range rotations {
+		teams = append(teams, *t)
+	}
+	oncallMut.RUnlock()
+
+	// Users to nag, and teams of theirs.
+	missing := map[string][]string{}
+	stale := map[string][]string{}
+	var order []string
+	staleBefore := time.Now().AddDate(0, -phoneAuditMonths, 0)
+	for _, t := range teams {
+		var ok int
+		var bad []string
+		for _, u := range t.Rotations {
+			user, err := getSlackUserDetail(ctx, u.Id, false)
+			if err != nil {
+				log.Warningf(ctx, "(cron) error getting user %s - %s", u.Name, err)
+				continue
+			}
+			if user == nil {
+				continue
+			}
+			if _, seen := missing[u.Id]; !seen {
+				if _, seen = stale[u.Id]; !seen {
+					order = append(order, u.Id)
+				}
+			}
+			switch {
+			case user.phone == "":
+				missing[u.Id] = append(missing[u.Id], t.Team)
+				bad = append(bad, fmt.Sprintf("<@%s|%s> (no phone)", u.Id, u.Name))
+			case phoneAuditMonths > 0 && user.phoneOverride && overrides[u.Id].Before(staleBefore):
+				stale[u.Id] = append(stale[u.Id], t.Team)
+				bad = append(bad, fmt.Sprintf("<@%s|%s> (phone set %s)", u.Id, u.Name, overrides[u.Id].In(timezone).Format("2006-01-02")))
+			default:
+				ok++
+			}
+		}
+		if len(t.Rotations) == 0 {
+			continue
+		}
+		text := fmt.Sprintf("Phone audit of %s: %d of %d in the on-call list have an up to date phone.", t.Team, ok, ok+len(bad))
+		if len(bad) > 0 {
+			text += " They were asked to update it: " + strings.Join(bad, ", ")
+		}
+		for _, m := range t.Managers {
+			postDM(ctx, m.Id, text)
+		}
+	}
+
+	for _, id := range order {
+		switch {
+		case missing[id] != nil:
+			postDM(ctx, id, fmt.Sprintf("Your Slack profile has no phone number, so on-call lists of %s can't show how to reach you. Please add it to your Slack profile.", strings.Join(missing[id], ", ")))
+		case stale[id] != nil:
+			postDM(ctx, id, fmt.Sprintf("The phone number on-call lists of %s show for you was set over %d month(s) ago. Please check it's still right, and update it with `%s setphone` or in your Slack profile.", strings.Join(stale[id], ", "), phoneAuditMonths, command))
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+} // }}}
+
+// func postDM {{{
+
+// Send the text to the user as a DM, logging failures.
+func postDM(ctx context.Context, id, text string) {
+	params := url.Values{}
+	params.Set("channel", id)
+	params.Set("text", text)
+	if err := callSlackAPI(ctx, "chat.postMessage", params); err != nil {
+		log.Warningf(ctx, "error sending DM to %s - %s", id, err)
+	}
+} // }}}
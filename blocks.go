@@ -0,0 +1,191 @@
+package slackoncallbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Longest text of a section block Slack takes.
+	maxBlockText = 3000
+	// Most blocks Slack takes in a message.
+	maxBlocks = 50
+)
+
+// func renderResponse {{{
+
+// Render the response to the command as Block Kit blocks instead of legacy
+// attachments if the team it's for is configured with "block_kit". Responses
+// without attachments are the same either way.
+func renderResponse(res slackResponse, text string) slackResponse {
+	if len(blockKitTeams) == 0 || len(res.Attachments) == 0 {
+		return res
+	}
+	if !blockKitTeams["ALL"] && !blockKitTeams[requestTeam(text)] {
+		return res
+	}
+	return blockKitResponse(res)
+} // }}}
+
+// func requestTeam {{{
+
+// Return the team the command is run for, empty if it has none or the team
+// doesn't exist.
+func requestTeam(text string) string {
+	stuff := strings.Fields(text)
+	if len(stuff) < 2 {
+		return ""
+	}
+	// "copy" changes the second team.
+	idx := 1
+	if resolveOperation(strings.ToLower(stuff[0])) == "copy" {
+		idx = 2
+	}
+	if len(stuff) <= idx {
+		return ""
+	}
+	oncallMut.RLock()
+	defer oncallMut.RUnlock()
+	if r := findRotation(strings.ToUpper(stuff[idx])); r != nil {
+		return r.Team
+	}
+	return ""
+} // }}}
+
+// func blockKitResponse {{{
+
+// Convert the attachments of the response into blocks. Titles and texts become
+// sections, footers context and buttons or menus actions, with the callback id as
+// the block id so the interactive endpoint can tell them apart as before.
+// The text of the response is kept for notifications.
+func blockKitResponse(res slackResponse) slackResponse {
+	var blocks []block
+	if res.Text != "" {
+		blocks = append(blocks, sectionBlocks(res.Text)...)
+	}
+	for i, a := range res.Attachments {
+		if i > 0 || res.Text != "" {
+			blocks = append(blocks, block{Type: "divider"})
+		}
+		if a.Title != "" {
+			blocks = append(blocks, sectionBlocks(a.Title)...)
+		}
+		if a.Text != "" {
+			blocks = append(blocks, sectionBlocks(a.Text)...)
+		}
+		if a.Footer != "" {
+			blocks = append(blocks, block{Type: "context", Elements: []blockElement{{Type: "mrkdwn", Text: a.Footer}}})
+		}
+		if len(a.Actions) == 0 {
+			continue
+		}
+		actions := block{Type: "actions", BlockId: a.CallbackId}
+		for _, act := range a.Actions {
+			e := blockElement{Type: "button", ActionId: act.Name, Text: &blockText{Type: "plain_text", Text: act.Text}, Value: act.Value}
+			// Block Kit only takes these two, the rest is the default.
+			if act.Style == "primary" || act.Style == "danger" {
+				e.Style = act.Style
+			}
+			if act.Type == "select" {
+				e = blockElement{Type: "static_select", ActionId: act.Name, Placeholder: &blockText{Type: "plain_text", Text: act.Text}}
+				for _, o := range act.Options {
+					e.Options = append(e.Options, blockOption{Text: blockText{Type: "plain_text", Text: o.Text}, Value: o.Value})
+				}
+			}
+			actions.Elements = append(actions.Elements, e)
+		}
+		blocks = append(blocks, actions)
+	}
+	res.Blocks = capBlocks(blocks)
+	res.Attachments = nil
+	return res
+} // }}}
+
+// func capBlocks {{{
+
+// Cut the blocks to the most Slack takes, counting what's left out in a context
+// block where the cut is. Buttons and menus are kept so the response can still
+// be acted on, ie. to page through it.
+func capBlocks(blocks []block) []block {
+	if len(blocks) <= maxBlocks {
+		return blocks
+	}
+	budget := maxBlocks - 1
+	for _, b := range blocks {
+		if b.Type == "actions" {
+			budget--
+		}
+	}
+	var capped []block
+	at, skipped := -1, 0
+	for _, b := range blocks {
+		if b.Type != "actions" {
+			if budget <= 0 {
+				if at < 0 {
+					at = len(capped)
+				}
+				skipped++
+				continue
+			}
+			budget--
+		}
+		capped = append(capped, b)
+	}
+	note := block{Type: "context", Elements: []blockElement{{Type: "mrkdwn", Text: fmt.Sprintf("_%d more block(s) not shown_", skipped)}}}
+	capped = append(capped[:at], append([]block{note}, capped[at:]...)...)
+	if len(capped) > maxBlocks {
+		capped = capped[:maxBlocks]
+	}
+	return capped
+} // }}}
+
+// func sectionBlocks {{{
+
+// Return the text as sections, split at lines to fit in each.
+func sectionBlocks(text string) (blocks []block) {
+	var lines []string
+	var size int
+	for _, l := range strings.Split(text, "\n") {
+		if len(l) > maxBlockText {
+			l = truncate(l, maxBlockText-3)
+		}
+		if size+len(l)+1 > maxBlockText && lines != nil {
+			blocks = append(blocks, block{Type: "section", Text: &blockText{Type: "mrkdwn", Text: strings.Join(lines, "\n")}})
+			lines, size = nil, 0
+		}
+		lines = append(lines, l)
+		size += len(l) + 1
+	}
+	return append(blocks, block{Type: "section", Text: &blockText{Type: "mrkdwn", Text: strings.Join(lines, "\n")}})
+} // }}}
+
+// func postResponseURL {{{
+
+// Send the response to the "response_url" of an interaction, replacing the
+// message clicked. Slack ignores responses to Block Kit interactions otherwise.
+func postResponseURL(ctx context.Context, responseURL string, res slackResponse) error {
+	res.ReplaceOriginal = true
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", responseURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	r, err := slackHTTPClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return errors.New("response_url: " + r.Status)
+	}
+	return nil
+} // }}}
@@ -0,0 +1,305 @@
+package slackoncallbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/*.golden")
+
+// In-memory Datastore answering the calls the operations make, so they run as
+// they do on App Engine. Filters and orders of queries aren't applied, entities
+// of the kind are returned in the order they were first put.
+type testDatastore struct {
+	entities []proto.Message
+	lastId   int64
+}
+
+// func testDatastore.call {{{
+
+func (d *testDatastore) call(ctx context.Context, service, method string, in, out proto.Message) error {
+	if service != "datastore_v3" {
+		return fmt.Errorf("%s.%s not available in tests", service, method)
+	}
+	req, res := reflect.ValueOf(in).Elem(), reflect.ValueOf(out).Elem()
+	switch method {
+	case "Put":
+		keys := res.FieldByName("Key")
+		for i := 0; i < req.FieldByName("Entity").Len(); i++ {
+			e := proto.Clone(req.FieldByName("Entity").Index(i).Interface().(proto.Message))
+			key := reflect.ValueOf(e).Elem().FieldByName("Key")
+			if last := testKeyElement(key); last.FieldByName("Id").IsNil() && last.FieldByName("Name").IsNil() {
+				d.lastId++
+				last.FieldByName("Id").Set(reflect.ValueOf(proto.Int64(d.lastId)))
+			}
+			if j := d.find(key); j >= 0 {
+				d.entities[j] = e
+			} else {
+				d.entities = append(d.entities, e)
+			}
+			keys.Set(reflect.Append(keys, key))
+		}
+	case "Get":
+		found := res.FieldByName("Entity")
+		for i := 0; i < req.FieldByName("Key").Len(); i++ {
+			key := req.FieldByName("Key").Index(i)
+			r := reflect.New(found.Type().Elem().Elem())
+			if j := d.find(key); j >= 0 {
+				r.Elem().FieldByName("Entity").Set(reflect.ValueOf(d.entities[j]))
+			} else {
+				r.Elem().FieldByName("Key").Set(key)
+			}
+			found.Set(reflect.Append(found, r))
+		}
+	case "Delete":
+		for i := 0; i < req.FieldByName("Key").Len(); i++ {
+			if j := d.find(req.FieldByName("Key").Index(i)); j >= 0 {
+				d.entities = append(d.entities[:j], d.entities[j+1:]...)
+			}
+		}
+	case "RunQuery":
+		kind := req.FieldByName("Kind").Elem().String()
+		result := res.FieldByName("Result")
+		for _, e := range d.entities {
+			if testKeyElement(reflect.ValueOf(e).Elem().FieldByName("Key")).FieldByName("Type").Elem().String() == kind {
+				result.Set(reflect.Append(result, reflect.ValueOf(e)))
+			}
+		}
+		res.FieldByName("MoreResults").Set(reflect.ValueOf(proto.Bool(false)))
+	case "BeginTransaction":
+		res.FieldByName("Handle").Set(reflect.ValueOf(proto.Uint64(1)))
+		res.FieldByName("App").Set(req.FieldByName("App"))
+	case "Commit", "Rollback":
+	default:
+		return fmt.Errorf("%s.%s not available in tests", service, method)
+	}
+	return nil
+} // }}}
+
+// func testDatastore.find {{{
+
+// Return the index of the entity with the key, -1 if there's none.
+func (d *testDatastore) find(key reflect.Value) int {
+	for i, e := range d.entities {
+		if proto.Equal(reflect.ValueOf(e).Elem().FieldByName("Key").Interface().(proto.Message), key.Interface().(proto.Message)) {
+			return i
+		}
+	}
+	return -1
+} // }}}
+
+// func testKeyElement {{{
+
+// Return the last element of the key path, holding its kind and id or name.
+func testKeyElement(key reflect.Value) reflect.Value {
+	path := key.Elem().FieldByName("Path").Elem().FieldByName("Element")
+	return path.Index(path.Len() - 1).Elem()
+} // }}}
+
+// Commands whose responses are compared with testdata/{name}.golden, and the
+// "response_max_lines" they're paginated to. setup adds to the teams seeded.
+var testCommands = map[string]struct {
+	text  string
+	lines int
+	setup func()
+}{
+	"help":       {"help", 5, nil},
+	"list":       {"list OPS", 5, nil},
+	"teams":      {"list", 5, nil},
+	"primary":    {"next OPS", 5, nil},
+	"export":     {"export OPS", 5, nil},
+	"add":        {"add OPS <@U05|erin>", 5, nil},
+	"swap":       {"swap OPS 1 2", 5, nil},
+	"flush":      {"flush OPS", 5, nil},
+	"unregister": {"unregister OPS", 5, nil},
+	"history":    {"history OPS", 5, nil},
+	"pick":       {"history", 5, nil},
+	"schedule":   {"schedule OPS preview 3", 5, nil},
+	"long": {"list BIG", 0, func() {
+		big := &oncallProperty{Team: "BIG", Notes: strings.Repeat("ü", 2000), Updated: testUpdated, UpdatedBy: "alice"}
+		for i := 0; i < 40; i++ {
+			big.Rotations = append(big.Rotations, RotationProperty{Name: "bob", Id: "U02", Label: strings.Repeat("é", 60)})
+		}
+		rotations = append(rotations, big)
+	}},
+	"capped": {"list all", 0, func() {
+		for i := 0; i < 30; i++ {
+			rotations = append(rotations, &oncallProperty{
+				Team:      fmt.Sprintf("T%02d", i),
+				Managers:  []ManagerProperty{{Name: "alice", Id: "U01"}},
+				Rotations: []RotationProperty{{Name: "carol", Id: "U03"}},
+				Updated:   testUpdated,
+				UpdatedBy: "alice",
+			})
+		}
+	}},
+}
+
+// Last update of the teams seeded.
+var testUpdated = time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+// func testDispatch {{{
+
+// Seed the teams, users and history of OPS, then run the command as alice, a
+// superuser, through dispatch and paginate as oncallHandler does.
+func testDispatch(name string) slackResponse {
+	c := testCommands[name]
+	ctx := appengine.WithAPICallFunc(context.Background(), (&testDatastore{}).call)
+	responseMaxLines = c.lines
+	slackUsers = map[string]*slackUser{}
+	for _, u := range []struct{ id, name, phone string }{
+		{"U01", "alice", "555-0101"}, {"U02", "bob", "555-0102"}, {"U03", "carol", "555-0103"}, {"U04", "dave", ""}, {"U05", "erin", "555-0105"},
+	} {
+		slackUsers[u.id] = &slackUser{name: u.name, phone: u.phone, retrieved: time.Now(), isSuperuser: u.name == "alice"}
+	}
+	// Under the lock, so the index of rotations is rebuilt.
+	oncallMut.Lock()
+	rotations = oncallProperties{
+		{
+			Key:       datastore.NewKey(ctx, oncallKind, "OPS", 0, nil),
+			Team:      "OPS",
+			Managers:  []ManagerProperty{{Name: "alice", Id: "U01"}},
+			Rotations: []RotationProperty{{Name: "bob", Id: "U02", Label: "eu"}, {Name: "carol", Id: "U03"}, {Name: "dave", Id: "U04"}},
+			Updated:   testUpdated,
+			UpdatedBy: "alice",
+			// Far ahead, so handoffs don't depend on when the test runs.
+			Cadence: "weekly",
+			Anchor:  time.Date(2099, 1, 5, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			Key:       datastore.NewKey(ctx, oncallKind, "DB", 0, nil),
+			Team:      "DB",
+			Managers:  []ManagerProperty{{Name: "erin", Id: "U05"}},
+			Rotations: []RotationProperty{{Name: "carol", Id: "U03"}},
+			Updated:   testUpdated,
+			UpdatedBy: "erin",
+		},
+	}
+	if c.setup != nil {
+		c.setup()
+	}
+	oncallMut.Unlock()
+	// Newest first, as loadHistory returns them.
+	for i := 12; i > 0; i-- {
+		entry := &historyProperty{
+			Team:      "OPS",
+			Operation: "rotate",
+			Detail:    "rotated, <@bob> is now primary",
+			By:        "alice",
+			Time:      time.Date(2026, 10, i, 9, 0, 0, 0, time.UTC),
+			Rotations: rotations[0].Rotations,
+		}
+		if _, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, historyKind, nil), entry); err != nil {
+			panic(err)
+		}
+	}
+
+	res := dispatch(ctx, slackCommandParams{Command: "/oncall", Text: c.text, UserId: "U01", UserName: "alice", ChannelId: "C01"})
+	return paginate(res, c.text, 0)
+} // }}}
+
+// func TestRenderResponse {{{
+
+// Both renderings of the response to each command are compared with
+// testdata/{name}.golden, "go test -update" rewrites them. Changes made now are
+// shown as "{now}".
+func TestRenderResponse(t *testing.T) {
+	os.Setenv("GAE_APPLICATION", "s~test")
+	saved, savedUsers, savedLines, savedKit := rotations, slackUsers, responseMaxLines, blockKitTeams
+	defer func() {
+		oncallMut.Lock()
+		rotations, slackUsers, responseMaxLines, blockKitTeams = saved, savedUsers, savedLines, savedKit
+		oncallMut.Unlock()
+	}()
+
+	for name, c := range testCommands {
+		before := time.Now()
+		blockKitTeams = nil
+		legacy := renderResponse(testDispatch(name), c.text)
+		blockKitTeams = map[string]bool{"ALL": true}
+		blocks := renderResponse(testDispatch(name), c.text)
+		if len(legacy.Blocks) != 0 {
+			t.Errorf("%s: legacy response has blocks", name)
+		}
+		if len(blocks.Attachments) != 0 {
+			t.Errorf("%s: block kit response has attachments", name)
+		}
+		if len(blocks.Blocks) > maxBlocks {
+			t.Errorf("%s: %d blocks, over %d", name, len(blocks.Blocks), maxBlocks)
+		}
+		for _, b := range blocks.Blocks {
+			if b.Text != nil && (len(b.Text.Text) > maxBlockText || !utf8.ValidString(b.Text.Text)) {
+				t.Errorf("%s: invalid section text of %d bytes", name, len(b.Text.Text))
+			}
+		}
+
+		var got bytes.Buffer
+		for _, r := range []struct {
+			title string
+			res   slackResponse
+		}{{"legacy", legacy}, {"block_kit", blocks}} {
+			out, err := json.MarshalIndent(r.res, "", "  ")
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			fmt.Fprintf(&got, "-- %s --\n%s\n", r.title, out)
+		}
+		out := got.Bytes()
+		for _, now := range []time.Time{before, time.Now()} {
+			out = bytes.Replace(out, []byte(now.In(timezone).Format(dateFormat)), []byte("{now}"), -1)
+		}
+		path := filepath.Join("testdata", name+".golden")
+		if *updateGolden {
+			if err := ioutil.WriteFile(path, out, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !bytes.Equal(out, want) {
+			t.Errorf("%s: rendering differs from %s\ngot:\n%s", name, path, out)
+		}
+	}
+} // }}}
+
+// func TestCapBlocks {{{
+
+func TestCapBlocks(t *testing.T) {
+	var blocks []block
+	for i := 0; i < 60; i++ {
+		blocks = append(blocks, block{Type: "section", Text: &blockText{Type: "mrkdwn", Text: fmt.Sprint(i)}})
+	}
+	blocks = append(blocks, block{Type: "actions", BlockId: callbackPage})
+
+	capped := capBlocks(blocks)
+	if len(capped) != maxBlocks {
+		t.Fatalf("got %d blocks, want %d", len(capped), maxBlocks)
+	}
+	if last := capped[len(capped)-1]; last.Type != "actions" {
+		t.Errorf("last block is %s, want the actions kept", last.Type)
+	}
+	note := capped[len(capped)-2]
+	if note.Type != "context" || note.Elements[0].Text != "_12 more block(s) not shown_" {
+		t.Errorf("got note %+v", note)
+	}
+	if len(capBlocks(blocks[:maxBlocks])) != maxBlocks {
+		t.Errorf("blocks within the limit were cut")
+	}
+} // }}}
@@ -0,0 +1,85 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// func broadcastPrimaries {{{
+
+// broadcast-primaries {message}
+//
+// DM the message to the current primary on-call of every team at once, ie. for an
+// org-wide emergency, and reply with who got it. Someone primary of several teams
+// gets a single DM naming all of them.
+func broadcastPrimaries(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opBroadcast)
+	if !ok || p.message == "" {
+		return slackResponse{Text: help(ctx, "broadcast-primaries")}
+	}
+
+	// Teams of each primary, and teams without one.
+	teams := map[string][]string{}
+	var ids, uncovered []string
+	oncallMut.RLock()
+	for _, t := range rotations {
+		u, ok := memberByRole(t, rolePrimary)
+		if !ok {
+			uncovered = append(uncovered, t.Team)
+			continue
+		}
+		if teams[u.Id] == nil {
+			ids = append(ids, u.Id)
+		}
+		teams[u.Id] = append(teams[u.Id], t.Team)
+	}
+	oncallMut.RUnlock()
+	if len(ids) == 0 {
+		return slackResponse{Text: fmt.Sprintf("Sorry, no team has a primary on-call to broadcast to %s", humanErrorEmoji)}
+	}
+
+	// Send them all at once, it's an emergency.
+	failed := make([]bool, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			params := url.Values{}
+			params.Set("channel", id)
+			params.Set("text", fmt.Sprintf("<@%s> broadcast to primary on-call of every team (you're primary of %s) from <@%s|%s>: %s", id, strings.Join(teams[id], ", "), p.by.id, p.by.name, p.message))
+			if err := callSlackAPI(ctx, "chat.postMessage", params); err != nil {
+				log.Warningf(ctx, "(broadcast-primaries) error sending to %s - %s", id, err)
+				failed[i] = true
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	var sent, notSent []string
+	for i, id := range ids {
+		if failed[i] {
+			notSent = append(notSent, fmt.Sprintf("<@%s> (%s)", id, strings.Join(teams[id], ", ")))
+		} else {
+			sent = append(sent, fmt.Sprintf("<@%s> (%s)", id, strings.Join(teams[id], ", ")))
+		}
+	}
+	if len(sent) == 0 {
+		return slackResponse{Text: errorExternal}
+	}
+
+	res := slackResponse{Text: fmt.Sprintf("Broadcast delivered to %d of %d primary on-call", len(sent), len(ids))}
+	att := attachment{Color: defaultColor, Text: "Delivered: " + strings.Join(sent, ", ")}
+	if notSent != nil {
+		att.Text += "\nFailed: " + strings.Join(notSent, ", ")
+	}
+	if uncovered != nil {
+		att.Text += "\nNo primary on-call: " + strings.Join(uncovered, ", ")
+	}
+	res.Attachments = []attachment{att}
+	return res
+} // }}}
@@ -0,0 +1,78 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// func shiftCap {{{
+
+// cap {team} {shifts|off}
+//
+// Set the most primary shifts each member of the team serves in a month.
+// Reordering the list ("swap", "move", "rotate", ...) is refused if it would go
+// over it, and scheduled handoffs going over it are flagged to the managers.
+// Without shifts, display the current cap.
+func shiftCap(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opCap)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "cap")}
+	}
+	return setLimit(ctx, capLimit, p.team, p.by, p.shifts, p.show)
+} // }}}
+
+// Monthly cap of primary shifts, looking at the month so far and until its end.
+var capLimit = primaryLimit{
+	op:    "cap",
+	value: func(r *oncallProperty) *int { return &r.MonthlyCap },
+	window: func(shifts int, now time.Time) (time.Time, time.Time) {
+		return monthStart(now), monthStart(now).AddDate(0, 1, 0)
+	},
+	breach: capBreach,
+	rule: func(shifts int) string {
+		return fmt.Sprintf("serve at most %d primary shift(s) a month", shifts)
+	},
+	none: "no cap of primary shifts",
+	detail: func(shifts int) string {
+		if shifts == 0 {
+			return "removed cap of primary shifts"
+		}
+		return fmt.Sprintf("set cap of %d primary shift(s) a month", shifts)
+	},
+	warn: func(r *oncallProperty) string { return "" },
+}
+
+// func capBreach {{{
+
+// Check if any of the primaries starting would start more primary shifts this
+// month than the cap, given the shifts served this month.
+func capBreach(shifts int, w primaryWalk) (primaryStart, string) {
+	count := map[string]int{}
+	for id, s := range w.served {
+		count[id] = s.shifts
+	}
+	for _, s := range w.starts {
+		if count[s.member.ID]++; count[s.member.ID] > shifts {
+			return s, fmt.Sprintf("for shift %d of the month", count[s.member.ID])
+		}
+	}
+	return primaryStart{}, ""
+} // }}}
+
+// func monthShifts {{{
+
+// Count the primary shifts each member of the team started or was serving this
+// month until now, keyed by user id, from the history of the month.
+// Caller must hold oncallMut.
+func monthShifts(r *oncallProperty, entries []*historyProperty, now time.Time) map[string]servedShifts {
+	return primaryShifts(r, entries, monthStart(now), now)
+} // }}}
+
+// func monthStart {{{
+
+// Start of the month of the time, in the configured timezone.
+func monthStart(t time.Time) time.Time {
+	t = t.In(timezone)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, timezone)
+} // }}}
@@ -0,0 +1,41 @@
+package slackoncallbot
+
+import (
+	"errors"
+	"github.com/slack-go/slack"
+	"golang.org/x/net/context"
+)
+
+// func teamChannel {{{
+
+// Return the channel the team is bound to, the first one "restrict" allows
+// changes from. Handoff notices, gap alerts and reports without a destination
+// go there.
+// Caller must hold oncallMut.
+func teamChannel(r *oncallProperty) (ChannelProperty, bool) {
+	if len(r.Channels) == 0 {
+		return ChannelProperty{}, false
+	}
+	return r.Channels[0], true
+} // }}}
+
+// func joinChannel {{{
+
+// Make sure we are a member of the channel so we can post to it, joining it if
+// we aren't. Only public channels can be joined, we have to be invited to
+// private ones.
+func joinChannel(ctx context.Context, id string) error {
+	api := newSlackClient(ctx)
+	channel, err := api.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{ChannelID: id})
+	if err != nil {
+		return err
+	}
+	if channel.IsMember {
+		return nil
+	}
+	if channel.IsPrivate {
+		return errors.New("not invited to private channel")
+	}
+	_, _, _, err = api.JoinConversationContext(ctx, id)
+	return err
+} // }}}
@@ -0,0 +1,109 @@
+package slackoncallbot
+
+import (
+	"github.com/slack-go/slack"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Shared HTTP/Slack clients used when "slack_transport" is "direct".
+	// Both are goroutine-safe, the request context is passed per call instead.
+	sharedHTTPClient  *http.Client
+	sharedSlackClient *slack.Client
+	sharedClientOnce  sync.Once
+	// Number of new and reused connections made by the shared transport.
+	connNew, connReused int64
+)
+
+// Transport wrapper counting connection reuse of the shared transport.
+type countingTransport struct {
+	base http.RoundTripper
+}
+
+// func countingTransport.RoundTrip {{{
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&connReused, 1)
+			} else {
+				atomic.AddInt64(&connNew, 1)
+			}
+		},
+	}
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+} // }}}
+
+// func initSharedClients {{{
+
+// Prepare the shared keep-alive transport and Slack client.
+func initSharedClients() {
+	sharedHTTPClient = &http.Client{
+		Transport: &limitedTransport{base: &countingTransport{base: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:        20,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 5 * time.Second,
+		}}},
+	}
+	sharedSlackClient = slack.New(slackAPIToken, slack.OptionHTTPClient(sharedHTTPClient))
+} // }}}
+
+// func slackHTTPClient {{{
+
+// Return HTTP client to talk to Slack for this request.
+// Either way, calls go through the Slack API rate limiter.
+//
+// By default a new urlfetch client bound to the request context is returned.
+// With "slack_transport" set to "direct", the shared keep-alive client is returned
+// and the caller is expected to pass the context with each request.
+func slackHTTPClient(ctx context.Context) *http.Client {
+	if !directTransport {
+		c := urlfetch.Client(ctx)
+		c.Transport = &limitedTransport{base: c.Transport, ctx: ctx}
+		return c
+	}
+	sharedClientOnce.Do(initSharedClients)
+	return sharedHTTPClient
+} // }}}
+
+// func newOrgSlackClient {{{
+
+// Return a Slack API client using the token of another workspace or org, from
+// "slack_org_tokens". It goes through the rate limiter like every other.
+func newOrgSlackClient(ctx context.Context, token string) *slack.Client {
+	return slack.New(token, slack.OptionHTTPClient(slackHTTPClient(ctx)))
+} // }}}
+
+// func newSlackClient {{{
+
+// Return a Slack API client for this request.
+//
+// With urlfetch each request gets its own client bound to the request context,
+// so the request deadline is honored and concurrent requests don't step on each
+// other's transport. With the direct transport the shared client is reused, the
+// *Context API methods carry the deadline instead.
+func newSlackClient(ctx context.Context) *slack.Client {
+	if !directTransport {
+		return slack.New(slackAPIToken, slack.OptionHTTPClient(slackHTTPClient(ctx)))
+	}
+	sharedClientOnce.Do(initSharedClients)
+	if debug {
+		log.Infof(ctx, "slack connections: new=%d, reused=%d", atomic.LoadInt64(&connNew), atomic.LoadInt64(&connReused))
+	}
+	return sharedSlackClient
+} // }}}
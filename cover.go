@@ -0,0 +1,197 @@
+package slackoncallbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"net/url"
+	"time"
+)
+
+// func coverNeeded {{{
+
+// cover-needed {team} {from} {to}
+// cover-needed {team} take {offer}
+//
+// Post an offer to the team's channel for someone to cover primary on-call of the
+// team while the requestor is away, with a button to take it. The offer is saved
+// and the button only carries its id, so it can't be made up. The first member
+// of the team taking it gets an override for the time, and they, the requestor
+// and the managers of the team are told.
+func coverNeeded(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opCoverNeeded)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "cover-needed")}
+	}
+	if p.take {
+		return takeCover(ctx, p)
+	}
+
+	res := slackResponse{}
+	oncallMut.RLock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.RUnlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	channel, bound := teamChannel(current)
+	member := inRotation(current.Rotations, p.by.id)
+	oncallMut.RUnlock()
+	if !member {
+		res.Text = fmt.Sprintf("Sorry, you are not in the on-call list for %s %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if !bound {
+		res.Text = fmt.Sprintf("Sorry, %s has no channel to post the offer to, ask a superuser to bind one with `%s restrict` %s", p.team, commandName(ctx), humanErrorEmoji)
+		return res
+	}
+
+	offer := &offerProperty{Team: p.team, From: p.from, To: p.to, Requester: p.by.id, RequesterName: p.by.name, Created: time.Now()}
+	if err := saveOffer(ctx, offer); err != nil {
+		log.Warningf(ctx, "(cover-needed) error saving offer - %s", err)
+		res.Text = errorExternal
+		return res
+	}
+	value := fmt.Sprintf("cover-needed %s take %d", p.team, offer.Key.IntID())
+	att, err := json.Marshal([]attachment{{
+		Text:       "Can you cover it?",
+		Color:      defaultColor,
+		CallbackId: callbackCover,
+		Actions: []attachmentAction{
+			{Name: "take", Text: "I'll take it", Type: "button", Style: "primary", Value: value},
+		},
+	}})
+	if err != nil {
+		log.Warningf(ctx, "(cover-needed) error encoding offer - %s", err)
+		res.Text = errorExternal
+		return res
+	}
+	msg := url.Values{}
+	msg.Set("channel", channel.Id)
+	msg.Set("text", fmt.Sprintf("<@%s|%s> needs someone to cover primary on-call of %s %s.", p.by.id, p.by.name, p.team, describeRange(p.from, p.to, timezone)))
+	msg.Set("attachments", string(att))
+	if err = callSlackAPI(ctx, "chat.postMessage", msg); err != nil {
+		log.Warningf(ctx, "(cover-needed) error posting offer to %s - %s", channel.Name, err)
+		res.Text = errorExternal
+		return res
+	}
+	res.Text = fmt.Sprintf("Success! Posted the offer to <#%s|%s>, you'll be told once someone takes it", channel.Id, channel.Name)
+	return res
+} // }}}
+
+// func takeCover {{{
+
+// Give the requestor, a member of the team, an override covering the saved
+// offer, unless someone took it already.
+func takeCover(ctx context.Context, p opCoverNeeded) slackResponse {
+	res := slackResponse{}
+	offer, err := loadOffer(ctx, p.offer)
+	if err != nil {
+		log.Warningf(ctx, "(cover-needed) error loading offer %d - %s", p.offer, err)
+		res.Text = errorExternal
+		return res
+	}
+	if offer == nil || offer.Team != p.team || !offer.To.After(offer.From) {
+		log.Warningf(ctx, "(cover-needed) invalid offer %d of %s", p.offer, p.team)
+		res.Text = fmt.Sprintf("Sorry, the offer does not exist %s", humanErrorEmoji)
+		return res
+	}
+	if offer.TakenById != "" {
+		res.Text = fmt.Sprintf("Sorry, <@%s> took it already %s", offer.TakenById, humanErrorEmoji)
+		return res
+	}
+	if offer.Requester == p.by.id {
+		res.Text = fmt.Sprintf("Sorry, you can't take your own offer %s", humanErrorEmoji)
+		return res
+	}
+	now := time.Now()
+	if !offer.To.After(now) {
+		res.Text = fmt.Sprintf("Sorry, the offer is over %s", humanErrorEmoji)
+		return res
+	}
+	// Already started, it's a plain override.
+	start := offer.From
+	if !start.After(now) {
+		start = time.Time{}
+	}
+
+	oncallMut.Lock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if !inRotation(current.Rotations, p.by.id) {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, you are not in the on-call list for %s %s", p.team, humanErrorEmoji)
+		return res
+	}
+	// Only one override per team, whoever set the current one got there first.
+	if current.OverrideId != "" && now.Before(current.OverrideUntil) {
+		u := current.OverrideId
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, %s already has an override by <@%s>, ask a manager %s", p.team, u, humanErrorEmoji)
+		return res
+	}
+	// Whoever marks the offer taken first gets it.
+	if err = takeOffer(ctx, offer.Key, p.by.id, p.by.name); err != nil {
+		oncallMut.Unlock()
+		if err == errOfferTaken {
+			res.Text = fmt.Sprintf("Sorry, someone took it already %s", humanErrorEmoji)
+			return res
+		}
+		log.Warningf(ctx, "(cover-needed) error taking offer %d - %s", p.offer, err)
+		res.Text = errorExternal
+		return res
+	}
+	previous := *current
+	current.OverrideName = p.by.name
+	current.OverrideId = p.by.id
+	current.OverrideStart = start
+	current.OverrideUntil = offer.To
+	current.OverrideBy = p.by.name
+	if err = replaceState(ctx, "cover-needed", p.by.name, &previous, current); err != nil {
+		log.Warningf(ctx, "(cover-needed) error saving state - %s", err)
+		*current = previous
+		oncallMut.Unlock()
+		if err = releaseOffer(ctx, offer.Key); err != nil {
+			log.Warningf(ctx, "(cover-needed) error releasing offer %d - %s", p.offer, err)
+		}
+		res.Text = errorExternal
+		return res
+	}
+	detail := fmt.Sprintf("<@%s> covers primary for <@%s> %s", p.by.name, offer.RequesterName, describeRange(offer.From, offer.To, timezone))
+	recordHistory(ctx, p.team, "cover-needed", p.by.name, detail, current.Rotations)
+	notify := []string{offer.Requester}
+	for _, m := range current.Managers {
+		notify = append(notify, m.Id)
+	}
+	oncallMut.Unlock()
+
+	text := fmt.Sprintf("<@%s|%s> covers primary on-call of %s for <@%s> %s.", p.by.id, p.by.name, p.team, offer.Requester, describeRange(offer.From, offer.To, timezone))
+	notified := map[string]bool{p.by.id: true}
+	for _, id := range notify {
+		if notified[id] {
+			continue
+		}
+		notified[id] = true
+		postDM(ctx, id, text)
+	}
+	res.Text = fmt.Sprintf("Thanks! You cover primary on-call of %s for <@%s> %s", p.team, offer.Requester, describeRange(offer.From, offer.To, timezone))
+	return res
+} // }}}
+
+// func inRotation {{{
+
+// Check if the user is in the on-call list.
+func inRotation(r []RotationProperty, id string) bool {
+	for _, u := range r {
+		if u.Id == id {
+			return true
+		}
+	}
+	return false
+} // }}}
@@ -0,0 +1,66 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"github.com/fladz/slack-oncall-command/oncall"
+	"strings"
+	"time"
+)
+
+// func coverageIssues {{{
+
+// Return a line per overlap of the override and assignments of the team, and per
+// time nobody would be primary on-call, from the time for coverageDays. Along
+// with it, a key which only changes when the issues do, so managers are told of
+// each of them once.
+// Caller must hold oncallMut.
+func coverageIssues(r *oncallProperty, now time.Time) (str []string, key string) {
+	team := engineTeam(r)
+	loc := teamLocation(r)
+	to := now.AddDate(0, 0, coverageDays)
+	var keys []string
+	for _, c := range team.Conflicts(now, to) {
+		str = append(str, fmt.Sprintf("%s of <@%s|%s> and %s of <@%s|%s> overlap %s, only <@%s|%s> is primary",
+			describeCover(c.Winner), c.Winner.Member.ID, c.Winner.Member.Name, describeCover(c.Loser), c.Loser.Member.ID, c.Loser.Member.Name,
+			describeCoverage(c.Start, c.End, now, to, loc), c.Winner.Member.ID, c.Winner.Member.Name))
+		keys = append(keys, fmt.Sprintf("conflict %s %s %d", c.Winner.Member.ID, c.Loser.Member.ID, c.End.Unix()))
+	}
+	// Teams without anyone in the rotation are told so by "list" already.
+	if len(rotationMembers(r.Rotations)) == 0 {
+		return str, strings.Join(keys, ",")
+	}
+	for _, g := range team.Gaps(now, to) {
+		str = append(str, "nobody is primary "+describeCoverage(g.Start, g.End, now, to, loc))
+		if g.End.Equal(to) {
+			keys = append(keys, "gap open")
+		} else {
+			keys = append(keys, fmt.Sprintf("gap %d", g.End.Unix()))
+		}
+	}
+	return str, strings.Join(keys, ",")
+} // }}}
+
+// func describeCover {{{
+
+// Human readable kind of cover.
+func describeCover(c oncall.Cover) string {
+	if c.Member.Label == "assigned" {
+		return "assignment"
+	}
+	return c.Member.Label
+} // }}}
+
+// func describeCoverage {{{
+
+// Human readable time of a coverage issue, open ended if it lasts until the end
+// of the times checked.
+func describeCoverage(start, end, now, to time.Time, loc *time.Location) string {
+	from := "now"
+	if start.After(now) {
+		from = start.In(loc).Format(dateFormat)
+	}
+	if !end.Before(to) {
+		return fmt.Sprintf("from %s on", from)
+	}
+	return fmt.Sprintf("from %s to %s", from, end.In(loc).Format(dateFormat))
+} // }}}
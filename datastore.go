@@ -1,10 +1,13 @@
 package slackoncallbot
 
 import (
+	"errors"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
 	"sort"
+	"strings"
+	"time"
 )
 
 // func loadState {{{
@@ -49,3 +52,728 @@ func saveState(ctx context.Context, entity *oncallProperty) error {
 func deleteState(ctx context.Context, key *datastore.Key) error {
 	return datastore.Delete(ctx, key)
 } // }}}
+
+// func renameState {{{
+
+// Move the team to a new key, along with its undo snapshot.
+// Both old and new teams are written in one transaction so the team never
+// exists under both or neither name.
+func renameState(ctx context.Context, entity, renamed *oncallProperty) error {
+	key := datastore.NewKey(ctx, oncallKind, renamed.Team, 0, nil)
+	err := datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		if _, err := datastore.Put(tc, key, renamed); err != nil {
+			return err
+		}
+		if err := datastore.Delete(tc, entity.Key); err != nil {
+			return err
+		}
+		var snapshot snapshotProperty
+		oldKey := datastore.NewKey(tc, snapshotKind, entity.Team, 0, nil)
+		if err := datastore.Get(tc, oldKey, &snapshot); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return nil
+			}
+			return err
+		}
+		snapshot.Team = renamed.Team
+		snapshot.State.Team, snapshot.State.Key = renamed.Team, nil
+		if _, err := datastore.Put(tc, datastore.NewKey(tc, snapshotKind, renamed.Team, 0, nil), &snapshot); err != nil {
+			return err
+		}
+		return datastore.Delete(tc, oldKey)
+	}, &datastore.TransactionOptions{XG: true})
+	if err != nil {
+		return err
+	}
+	renamed.Key = key
+	return nil
+} // }}}
+
+// func renameTeamRecords {{{
+
+// Point history, scheduled reports, assignments and the planned change of the
+// team to its new name.
+func renameTeamRecords(ctx context.Context, team, name string) error {
+	var entries []*historyProperty
+	keys, err := datastore.NewQuery(historyKind).Filter("team =", team).GetAll(ctx, &entries)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		e.Team = name
+	}
+	// PutMulti takes up to 500 entities at once.
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 500 {
+			n = 500
+		}
+		if _, err = datastore.PutMulti(ctx, keys[:n], entries[:n]); err != nil {
+			return err
+		}
+		keys, entries = keys[n:], entries[n:]
+	}
+
+	reports, err := loadReports(ctx, team)
+	if err != nil {
+		return err
+	}
+	for _, r := range reports {
+		r.Team = name
+		if err = saveReport(ctx, r); err != nil {
+			return err
+		}
+	}
+
+	var assigned []*assignmentProperty
+	if keys, err = datastore.NewQuery(assignmentKind).Filter("team =", team).GetAll(ctx, &assigned); err != nil {
+		return err
+	}
+	for _, a := range assigned {
+		a.Team = name
+	}
+	if len(keys) > 0 {
+		if _, err = datastore.PutMulti(ctx, keys, assigned); err != nil {
+			return err
+		}
+	}
+
+	planned, err := loadPlan(ctx, team)
+	if err != nil || planned == nil {
+		return err
+	}
+	if err = deletePlan(ctx, planned); err != nil {
+		return err
+	}
+	planned.Team = name
+	return savePlan(ctx, planned)
+} // }}}
+
+// func deleteTeamRecords {{{
+
+// Delete scheduled reports, assignments and the planned change of the team once
+// it's unregistered, so they aren't left behind without a team or picked up by
+// a new team of the same name. History is kept. Returns how many reports and
+// assignments there were, and if there was a plan.
+func deleteTeamRecords(ctx context.Context, team string) (reports, assigned int, planned bool, err error) {
+	entries, err := loadReports(ctx, team)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	keys := make([]*datastore.Key, 0, len(entries))
+	for _, r := range entries {
+		keys = append(keys, r.Key)
+	}
+	if err = datastore.DeleteMulti(ctx, keys); err != nil {
+		return 0, 0, false, err
+	}
+	reports = len(keys)
+
+	if keys, err = datastore.NewQuery(assignmentKind).Filter("team =", team).KeysOnly().GetAll(ctx, nil); err != nil {
+		return reports, 0, false, err
+	}
+	if err = datastore.DeleteMulti(ctx, keys); err != nil {
+		return reports, 0, false, err
+	}
+	assigned = len(keys)
+
+	plan, err := loadPlan(ctx, team)
+	if err != nil || plan == nil {
+		return reports, assigned, false, err
+	}
+	return reports, assigned, true, deletePlan(ctx, plan)
+} // }}}
+
+// func loadPhoneOverrides {{{
+
+// Load phone numbers set by "setphone".
+func loadPhoneOverrides(ctx context.Context) error {
+	var phones []phoneProperty
+	keys, err := datastore.NewQuery(phoneKind).GetAll(ctx, &phones)
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]string, len(phones))
+	for i, p := range phones {
+		overrides[keys[i].StringID()] = p.Phone
+	}
+	slackMut.Lock()
+	phoneOverrides = overrides
+	slackMut.Unlock()
+	log.Infof(ctx, "loaded phone overrides, %d entries loaded", len(overrides))
+	return nil
+} // }}}
+
+// func loadPhoneOverrideDates {{{
+
+// Get when each phone number set by "setphone" was last set, by Slack user_id.
+func loadPhoneOverrideDates(ctx context.Context) (map[string]time.Time, error) {
+	var phones []phoneProperty
+	keys, err := datastore.NewQuery(phoneKind).GetAll(ctx, &phones)
+	if err != nil {
+		return nil, err
+	}
+	dates := make(map[string]time.Time, len(phones))
+	for i, p := range phones {
+		dates[keys[i].StringID()] = p.Updated
+	}
+	return dates, nil
+} // }}}
+
+// func savePhoneOverride {{{
+
+// Save phone number of the user, or delete it if phone is empty.
+func savePhoneOverride(ctx context.Context, id, phone, by string) error {
+	key := datastore.NewKey(ctx, phoneKind, id, 0, nil)
+	if phone == "" {
+		if err := datastore.Delete(ctx, key); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		return nil
+	}
+	_, err := datastore.Put(ctx, key, &phoneProperty{Phone: phone, Updated: time.Now(), UpdatedBy: by})
+	return err
+} // }}}
+
+// func loadIdentity {{{
+
+// Get identities of the user in other systems, empty if none were linked.
+func loadIdentity(ctx context.Context, id string) (*identityProperty, error) {
+	var identity identityProperty
+	err := datastore.Get(ctx, datastore.NewKey(ctx, identityKind, id, 0, nil), &identity)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	return &identity, nil
+} // }}}
+
+// func loadIdentities {{{
+
+// Get identities in other systems of every user who linked any, by Slack user_id.
+func loadIdentities(ctx context.Context) (map[string]identityProperty, error) {
+	var identities []identityProperty
+	keys, err := datastore.NewQuery(identityKind).GetAll(ctx, &identities)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]identityProperty, len(identities))
+	for i, identity := range identities {
+		m[keys[i].StringID()] = identity
+	}
+	return m, nil
+} // }}}
+
+// func saveIdentity {{{
+
+// Save identities of the user, or delete them if none is left.
+func saveIdentity(ctx context.Context, id string, identity *identityProperty) error {
+	key := datastore.NewKey(ctx, identityKind, id, 0, nil)
+	if identity.PagerDuty == "" && identity.GitHub == "" {
+		if err := datastore.Delete(ctx, key); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		return nil
+	}
+	identity.Updated = time.Now()
+	_, err := datastore.Put(ctx, key, identity)
+	return err
+} // }}}
+
+// func recordCommand {{{
+
+// Record the command received and the response to it, without the token and the
+// response URL. Phone numbers given to "setphone" are masked.
+// Failing to record is only logged.
+func recordCommand(ctx context.Context, sr slackCommandParams, res slackResponse) {
+	text := sr.Text
+	if stuff := strings.SplitN(text, " ", 3); len(stuff) == 3 && resolveOperation(strings.ToLower(stuff[0])) == "setphone" {
+		text = stuff[0] + " " + stuff[1] + " ***"
+	}
+	entry := &commandLogProperty{
+		Time:         time.Now(),
+		UserId:       sr.UserId,
+		UserName:     sr.UserName,
+		TeamId:       sr.TeamId,
+		EnterpriseId: sr.EnterpriseId,
+		ChannelId:    sr.ChannelId,
+		ChannelName:  sr.ChannelName,
+		Command:      sr.Command,
+		Text:         truncate(text, commandLogMax),
+		Response:     truncate(res.Text, commandLogMax),
+	}
+	if _, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, commandLogKind, nil), entry); err != nil {
+		log.Warningf(ctx, "error recording command - %s", err)
+	}
+} // }}}
+
+// func loadCommands {{{
+
+// Get the last "limit" commands received, newest first, along with their ids.
+func loadCommands(ctx context.Context, limit int) ([]int64, []commandLogProperty, error) {
+	var entries []commandLogProperty
+	keys, err := datastore.NewQuery(commandLogKind).Order("-time").Limit(limit).GetAll(ctx, &entries)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := make([]int64, len(keys))
+	for i, k := range keys {
+		ids[i] = k.IntID()
+	}
+	return ids, entries, nil
+} // }}}
+
+// func loadCommand {{{
+
+// Get the command received with the id. Nil if there's no such command.
+func loadCommand(ctx context.Context, id int64) (*commandLogProperty, error) {
+	var entry commandLogProperty
+	err := datastore.Get(ctx, datastore.NewKey(ctx, commandLogKind, "", id, nil), &entry)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+} // }}}
+
+// func deleteCommandsBefore {{{
+
+// Delete commands received before the time, returning how many were deleted.
+func deleteCommandsBefore(ctx context.Context, t time.Time) (int, error) {
+	keys, err := datastore.NewQuery(commandLogKind).Filter("time <", t).KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Datastore takes up to 500 keys per call.
+	for i := 0; i < len(keys); i += 500 {
+		end := i + 500
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err = datastore.DeleteMulti(ctx, keys[i:end]); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+} // }}}
+
+// func saveOffer {{{
+
+// Save the offer of "cover-needed", with a new key the first time.
+func saveOffer(ctx context.Context, entity *offerProperty) error {
+	if entity.Key == nil {
+		entity.Key = datastore.NewIncompleteKey(ctx, offerKind, nil)
+	}
+	key, err := datastore.Put(ctx, entity.Key, entity)
+	if err == nil {
+		entity.Key = key
+	}
+	return err
+} // }}}
+
+// func loadOffer {{{
+
+// Get the offer of "cover-needed" by its id. nil is returned if there is none.
+func loadOffer(ctx context.Context, id int64) (*offerProperty, error) {
+	var entity offerProperty
+	key := datastore.NewKey(ctx, offerKind, "", id, nil)
+	if err := datastore.Get(ctx, key, &entity); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entity.Key = key
+	return &entity, nil
+} // }}}
+
+// Returned by takeOffer if someone took the offer first.
+var errOfferTaken = errors.New("offer taken already")
+
+// func takeOffer {{{
+
+// Mark the offer taken by the user, in a transaction so only the first one
+// clicking it gets it. errOfferTaken is returned if someone did already.
+func takeOffer(ctx context.Context, key *datastore.Key, id, name string) error {
+	return datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		var entity offerProperty
+		if err := datastore.Get(tc, key, &entity); err != nil {
+			return err
+		}
+		if entity.TakenById != "" {
+			return errOfferTaken
+		}
+		entity.TakenById, entity.TakenBy = id, name
+		_, err := datastore.Put(tc, key, &entity)
+		return err
+	}, nil)
+} // }}}
+
+// func releaseOffer {{{
+
+// Open the offer again, when the override taking it couldn't be saved.
+func releaseOffer(ctx context.Context, key *datastore.Key) error {
+	var entity offerProperty
+	if err := datastore.Get(ctx, key, &entity); err != nil {
+		return err
+	}
+	entity.TakenById, entity.TakenBy = "", ""
+	_, err := datastore.Put(ctx, key, &entity)
+	return err
+} // }}}
+
+// func loadPlan {{{
+
+// Get the planned change of the team. nil is returned if there is none.
+func loadPlan(ctx context.Context, team string) (*planProperty, error) {
+	var entity planProperty
+	key := datastore.NewKey(ctx, planKind, team, 0, nil)
+	if err := datastore.Get(ctx, key, &entity); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entity.Key = key
+	return &entity, nil
+} // }}}
+
+// func loadPlansDue {{{
+
+// Get planned changes of every team due by the time.
+func loadPlansDue(ctx context.Context, t time.Time) ([]*planProperty, error) {
+	var plans []*planProperty
+	keys, err := datastore.NewQuery(planKind).Filter("apply <=", t).GetAll(ctx, &plans)
+	if err != nil {
+		return nil, err
+	}
+	for i := range plans {
+		plans[i].Key = keys[i]
+	}
+	return plans, nil
+} // }}}
+
+// func savePlan {{{
+
+// Save the planned change of the team, replacing any previous one.
+func savePlan(ctx context.Context, entity *planProperty) error {
+	key, err := datastore.Put(ctx, datastore.NewKey(ctx, planKind, entity.Team, 0, nil), entity)
+	if err == nil {
+		entity.Key = key
+	}
+	return err
+} // }}}
+
+// func deletePlan {{{
+
+// Delete the planned change of the team.
+func deletePlan(ctx context.Context, entity *planProperty) error {
+	if err := datastore.Delete(ctx, entity.Key); err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+	return nil
+} // }}}
+
+// func loadJob {{{
+
+// Get the last run of the cron job, empty if it never ran.
+func loadJob(ctx context.Context, name string) (*jobProperty, error) {
+	var job jobProperty
+	err := datastore.Get(ctx, datastore.NewKey(ctx, jobKind, name, 0, nil), &job)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	return &job, nil
+} // }}}
+
+// func saveJob {{{
+
+// Save the last run of the cron job.
+func saveJob(ctx context.Context, name string, job *jobProperty) error {
+	_, err := datastore.Put(ctx, datastore.NewKey(ctx, jobKind, name, 0, nil), job)
+	return err
+} // }}}
+
+// func loadOpAliases {{{
+
+// Load operation aliases set by "opalias".
+func loadOpAliases(ctx context.Context) error {
+	var entries []opAliasProperty
+	keys, err := datastore.NewQuery(opAliasKind).GetAll(ctx, &entries)
+	if err != nil {
+		return err
+	}
+	aliases := make(map[string]string, len(entries))
+	for i, e := range entries {
+		aliases[keys[i].StringID()] = e.Operation
+	}
+	opAliasMut.Lock()
+	opAliases = aliases
+	opAliasMut.Unlock()
+	log.Infof(ctx, "loaded operation aliases, %d entries loaded", len(aliases))
+	return nil
+} // }}}
+
+// func saveOpAlias {{{
+
+// Save the operation alias, or delete it if operation is empty.
+func saveOpAlias(ctx context.Context, alias, operation, by string) error {
+	key := datastore.NewKey(ctx, opAliasKind, alias, 0, nil)
+	if operation == "" {
+		if err := datastore.Delete(ctx, key); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		return nil
+	}
+	_, err := datastore.Put(ctx, key, &opAliasProperty{Operation: operation, Updated: time.Now(), UpdatedBy: by})
+	return err
+} // }}}
+
+// func loadAssignments {{{
+
+// Load assignments of all teams not over yet.
+func loadAssignments(ctx context.Context) error {
+	var entries []*assignmentProperty
+	keys, err := datastore.NewQuery(assignmentKind).Filter("end >", time.Now()).GetAll(ctx, &entries)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string][]*assignmentProperty)
+	for i, e := range entries {
+		e.Key = keys[i]
+		loaded[e.Team] = insertAssignment(loaded[e.Team], e)
+	}
+	oncallMut.Lock()
+	assignments = loaded
+	oncallMut.Unlock()
+	log.Infof(ctx, "loaded assignments, %d entries loaded", len(entries))
+	return nil
+} // }}}
+
+// func loadAssignmentsAt {{{
+
+// Get assignments of the team covering the time, including ones already over.
+func loadAssignmentsAt(ctx context.Context, team string, t time.Time) ([]*assignmentProperty, error) {
+	var entries, covering []*assignmentProperty
+	if _, err := datastore.NewQuery(assignmentKind).Filter("team =", team).Filter("end >", t).GetAll(ctx, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !t.Before(e.Start) {
+			covering = insertAssignment(covering, e)
+		}
+	}
+	return covering, nil
+} // }}}
+
+// func saveAssignment {{{
+
+// Save an assignment in datastore.
+func saveAssignment(ctx context.Context, entity *assignmentProperty) error {
+	if entity.Key == nil {
+		entity.Key = datastore.NewIncompleteKey(ctx, assignmentKind, nil)
+	}
+	key, err := datastore.Put(ctx, entity.Key, entity)
+	if err != nil {
+		return err
+	}
+	entity.Key = key
+	return nil
+} // }}}
+
+// func saveSnapshot {{{
+
+// Save the whole state of the team before it's changed by the operation, so the
+// change can be reverted by "undo". Only the last change per team is kept.
+// Caller must hold oncallMut.
+func saveSnapshot(ctx context.Context, op, by string, entity *oncallProperty) error {
+	snapshot := &snapshotProperty{
+		Team:      entity.Team,
+		Operation: op,
+		State:     copyState(entity),
+		Updated:   entity.Updated,
+		UpdatedBy: entity.UpdatedBy,
+		Taken:     time.Now(),
+		TakenBy:   by,
+	}
+	_, err := datastore.Put(ctx, datastore.NewKey(ctx, snapshotKind, entity.Team, 0, nil), snapshot)
+	return err
+} // }}}
+
+// func copyState {{{
+
+// Return a copy of the team with slices of its own, to keep it as it was before
+// a change as some operations modify them in place.
+func copyState(entity *oncallProperty) oncallProperty {
+	c := *entity
+	c.Managers = append([]ManagerProperty(nil), entity.Managers...)
+	c.Rotations = append([]RotationProperty(nil), entity.Rotations...)
+	c.Channels = append([]ChannelProperty(nil), entity.Channels...)
+	c.Aliases = append([]string(nil), entity.Aliases...)
+	c.Shifts = append([]ShiftProperty(nil), entity.Shifts...)
+	c.Permissions = append([]PermissionProperty(nil), entity.Permissions...)
+	return c
+} // }}}
+
+// func replaceState {{{
+
+// Save the team along with the snapshot of its previous state, in one transaction
+// so a failed change can't leave behind a snapshot "undo" would wrongly apply.
+// Caller must hold oncallMut.
+func replaceState(ctx context.Context, op, by string, previous, entity *oncallProperty) error {
+	return datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		if err := saveSnapshot(tc, op, by, previous); err != nil {
+			return err
+		}
+		return saveState(tc, entity)
+	}, &datastore.TransactionOptions{XG: true})
+} // }}}
+
+// func removeState {{{
+
+// Delete the team along with saving the snapshot of it, in one transaction, so
+// "undo" can register it again as it was.
+// Caller must hold oncallMut.
+func removeState(ctx context.Context, op, by string, entity *oncallProperty) error {
+	return datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		if err := saveSnapshot(tc, op, by, entity); err != nil {
+			return err
+		}
+		return datastore.Delete(tc, entity.Key)
+	}, &datastore.TransactionOptions{XG: true})
+} // }}}
+
+// func resetState {{{
+
+// Save the team and delete its snapshot in one transaction, for changes "undo"
+// can't be applied on top of, ie. scheduled handoffs. Reverting the snapshot
+// afterwards would silently revert them as well.
+// Caller must hold oncallMut.
+func resetState(ctx context.Context, entity *oncallProperty) error {
+	return datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		if err := saveState(tc, entity); err != nil {
+			return err
+		}
+		return datastore.Delete(tc, datastore.NewKey(tc, snapshotKind, entity.Team, 0, nil))
+	}, &datastore.TransactionOptions{XG: true})
+} // }}}
+
+// func loadSnapshot {{{
+
+// Get the last snapshot of the team. nil is returned if there is none.
+func loadSnapshot(ctx context.Context, team string) (*snapshotProperty, error) {
+	var snapshot snapshotProperty
+	key := datastore.NewKey(ctx, snapshotKind, team, 0, nil)
+	if err := datastore.Get(ctx, key, &snapshot); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, nil
+		}
+		return nil, err
+	}
+	snapshot.Key = key
+	return &snapshot, nil
+} // }}}
+
+// func recordHistory {{{
+
+// Append a change made to the team in the change log.
+// Failing to record is only logged, the change itself is already done.
+func recordHistory(ctx context.Context, team, op, by, detail string, r []RotationProperty) {
+	entry := &historyProperty{
+		Team:      team,
+		Operation: op,
+		Detail:    detail,
+		By:        by,
+		Time:      time.Now(),
+		Rotations: append([]RotationProperty(nil), r...),
+	}
+	if _, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, historyKind, nil), entry); err != nil {
+		log.Warningf(ctx, "(%s) error recording history - %s", op, err)
+	}
+} // }}}
+
+// func loadHistory {{{
+
+// Get the last "limit" changes of the team, newest first.
+func loadHistory(ctx context.Context, team string, limit int) ([]*historyProperty, error) {
+	var entries []*historyProperty
+	q := datastore.NewQuery(historyKind).Filter("team =", team).Order("-time").Limit(limit)
+	if _, err := q.GetAll(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+} // }}}
+
+// func loadHistoryAt {{{
+
+// Get the last change of the team at or before the time, nil if there's none.
+func loadHistoryAt(ctx context.Context, team string, t time.Time) (*historyProperty, error) {
+	var entries []*historyProperty
+	q := datastore.NewQuery(historyKind).Filter("team =", team).Filter("time <=", t).Order("-time").Limit(1)
+	if _, err := q.GetAll(ctx, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return entries[0], nil
+} // }}}
+
+// func loadHistorySince {{{
+
+// Get changes of the team since the time, oldest first.
+func loadHistorySince(ctx context.Context, team string, since time.Time) ([]*historyProperty, error) {
+	var entries []*historyProperty
+	q := datastore.NewQuery(historyKind).Filter("team =", team).Filter("time >=", since).Order("-time")
+	if _, err := q.GetAll(ctx, &entries); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+} // }}}
+
+// func countHistory {{{
+
+// Count changes made to the team since the time.
+func countHistory(ctx context.Context, team string, since time.Time) (int, error) {
+	q := datastore.NewQuery(historyKind).Filter("team =", team).Filter("time >=", since).Order("-time").KeysOnly()
+	return q.Count(ctx)
+} // }}}
+
+// func loadReports {{{
+
+// Get scheduled reports from datastore.
+// If team is empty, reports of all teams are returned.
+func loadReports(ctx context.Context, team string) ([]*reportProperty, error) {
+	var reports []*reportProperty
+	q := datastore.NewQuery(reportKind)
+	if team != "" {
+		q = q.Filter("team =", team)
+	}
+	keys, err := q.GetAll(ctx, &reports)
+	if err != nil {
+		return nil, err
+	}
+	for i := range reports {
+		reports[i].Key = keys[i]
+	}
+	return reports, nil
+} // }}}
+
+// func saveReport {{{
+
+// Save a scheduled report in datastore.
+func saveReport(ctx context.Context, entity *reportProperty) error {
+	if entity.Key == nil {
+		entity.Key = datastore.NewIncompleteKey(ctx, reportKind, nil)
+	}
+	key, err := datastore.Put(ctx, entity.Key, entity)
+	if err != nil {
+		return err
+	}
+	entity.Key = key
+	return nil
+} // }}}
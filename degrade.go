@@ -0,0 +1,97 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Weight of the newest sample in the Slack API latency moving average.
+const latencyWeight = 0.2
+
+var (
+	// Moving average of Slack API latency, and whether optional work is
+	// currently being skipped because of it.
+	latencyAverage time.Duration
+	degraded       bool
+	// Number of optional work skipped per kind, logged when leaving degraded mode.
+	degradeSkipped = make(map[string]int)
+	// Same since the instance started, shown by "admin jobs".
+	degradeSkippedTotal = make(map[string]int)
+	degradeMut          sync.Mutex
+)
+
+// func observeSlackLatency {{{
+
+// Record latency of a Slack API call and update the degraded state.
+//
+// Optional work is skipped once the average latency is above "degrade_latency",
+// and resumed when it drops below half of it. The gap keeps us from flapping.
+func observeSlackLatency(ctx context.Context, d time.Duration) {
+	if degradeLatency <= 0 {
+		return
+	}
+	degradeMut.Lock()
+	defer degradeMut.Unlock()
+	if latencyAverage == 0 {
+		latencyAverage = d
+	} else {
+		latencyAverage = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(latencyAverage))
+	}
+	switch {
+	case !degraded && latencyAverage > degradeLatency:
+		degraded = true
+		log.Warningf(ctx, "Slack API latency %s over %s, skipping optional work", latencyAverage, degradeLatency)
+	case degraded && latencyAverage < degradeLatency/2:
+		degraded = false
+		log.Infof(ctx, "Slack API latency back to %s, resuming optional work (skipped: %v)", latencyAverage, degradeSkipped)
+		degradeSkipped = make(map[string]int)
+	}
+} // }}}
+
+// func skipOptional {{{
+
+// Return true if optional work should be skipped to keep the response within deadline.
+// "what" is used to count how many times each kind of work was skipped.
+func skipOptional(ctx context.Context, what string) bool {
+	degradeMut.Lock()
+	defer degradeMut.Unlock()
+	if !degraded {
+		return false
+	}
+	degradeSkipped[what]++
+	degradeSkippedTotal[what]++
+	if debug {
+		log.Infof(ctx, "degraded (latency %s), skipping %s", latencyAverage, what)
+	}
+	return true
+} // }}}
+
+// func describeDegrade {{{
+
+// Human readable Slack API latency of this instance, whether optional work is
+// skipped because of it and how many times each kind was since the start.
+func describeDegrade() string {
+	if degradeLatency <= 0 {
+		return "Slack API latency isn't tracked (\"degrade_latency\" is 0)"
+	}
+	degradeMut.Lock()
+	defer degradeMut.Unlock()
+	s := fmt.Sprintf("Slack API latency %s (skipping optional work over %s)", latencyAverage, degradeLatency)
+	if degraded {
+		s += " " + externalErrorEmoji + " _degraded_"
+	}
+	var skipped []string
+	for what, n := range degradeSkippedTotal {
+		skipped = append(skipped, fmt.Sprintf("%s %d", what, n))
+	}
+	sort.Strings(skipped)
+	if len(skipped) == 0 {
+		return s + ", nothing skipped"
+	}
+	return s + ", skipped: " + strings.Join(skipped, ", ")
+} // }}}
@@ -0,0 +1,145 @@
+package slackoncallbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// func digest {{{
+
+// digest {team} {#channel}
+//
+// Set the channel the weekly digest of the team is posted to. Without a channel,
+// display the current one.
+func digest(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opDigest)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "digest")}
+	}
+
+	res := slackResponse{}
+	oncallMut.Lock()
+	defer oncallMut.Unlock()
+	r := findRotation(p.team)
+	if r == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if p.show {
+		if r.DigestId == "" {
+			res.Text = fmt.Sprintf("%s has no weekly digest", p.team)
+		} else {
+			res.Text = fmt.Sprintf("Weekly digest of %s is posted to <#%s|%s>", p.team, r.DigestId, r.DigestName)
+		}
+		return res
+	}
+
+	previousId, previousName := r.DigestId, r.DigestName
+	r.DigestId, r.DigestName = p.channel.Id, p.channel.Name
+	if err := resetState(ctx, r); err != nil {
+		log.Warningf(ctx, "(digest) error saving state - %s", err)
+		r.DigestId, r.DigestName = previousId, previousName
+		res.Text = errorExternal
+		return res
+	}
+	if p.channel.Id == "" {
+		recordHistory(ctx, p.team, "digest", p.by.name, "stopped weekly digest", r.Rotations)
+		res.Text = fmt.Sprintf("Success! Weekly digest of %s is stopped", p.team)
+		return res
+	}
+	recordHistory(ctx, p.team, "digest", p.by.name, fmt.Sprintf("weekly digest to <#%s|%s>", p.channel.Id, p.channel.Name), r.Rotations)
+	res.Text = fmt.Sprintf("Success! Weekly digest of %s will be posted to <#%s|%s>", p.team, p.channel.Id, p.channel.Name)
+	return res
+} // }}}
+
+// func generateDigest {{{
+
+// Return the week ahead of the team - who's primary now and after each handoff
+// within the week, the secondary and managers.
+// Caller must hold oncallMut.
+func generateDigest(r *oncallProperty, now time.Time) attachment {
+	weekEnd := now.AddDate(0, 0, 7)
+	var str []string
+	next := weekEnd
+	first := 0
+	if r.Cadence != "" {
+		first = cadenceAdvances(r, now) + 1
+		if t := handoffTime(r, first); t.Before(next) {
+			next = t
+		}
+	}
+	str = append(str, "Now - "+describePrimaryPeriod(r, now, next))
+	for k := first; r.Cadence != "" && handoffTime(r, k).Before(weekEnd); k++ {
+		start, end := handoffTime(r, k), handoffTime(r, k+1)
+		if end.After(weekEnd) {
+			end = weekEnd
+		}
+		str = append(str, start.Format("Mon "+dateFormat)+" - "+describePrimaryPeriod(r, start, end))
+	}
+	if u, ok := memberByRole(r, roleSecondary); ok {
+		str = append(str, fmt.Sprintf("Secondary now: <@%s|%s>", u.Id, u.Name))
+	}
+	var managers []string
+	for _, m := range r.Managers {
+		managers = append(managers, fmt.Sprintf("<@%s|%s>", m.Id, m.Name))
+	}
+	if managers != nil {
+		str = append(str, "Managers: "+strings.Join(managers, ", "))
+	}
+	return attachment{Color: defaultColor, Text: strings.Join(str, "\n"), Footer: describeCadence(r)}
+} // }}}
+
+// func digestCronHandler {{{
+
+// Cron handler posting the weekly digest of every team with a digest channel.
+func digestCronHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	// Only AppEngine cron is allowed to call this.
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		log.Warningf(ctx, "(cron) request not from cron")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := ensureState(ctx); err != nil {
+		http.Error(w, "error loading state", http.StatusInternalServerError)
+		return
+	}
+
+	// Render the digests first so we don't hold the lock while talking to Slack.
+	type post struct {
+		team, channel string
+		att           attachment
+	}
+	var posts []post
+	now := time.Now()
+	oncallMut.RLock()
+	for _, t := range rotations {
+		if t.DigestId != "" {
+			posts = append(posts, post{t.Team, t.DigestId, generateDigest(t, now)})
+		}
+	}
+	oncallMut.RUnlock()
+
+	for _, p := range posts {
+		att, err := json.Marshal([]attachment{p.att})
+		if err != nil {
+			log.Warningf(ctx, "(cron) error encoding digest of %s - %s", p.team, err)
+			continue
+		}
+		params := url.Values{}
+		params.Set("channel", p.channel)
+		params.Set("text", fmt.Sprintf("This week's on-call for %s:", p.team))
+		params.Set("attachments", string(att))
+		if err = callSlackAPI(ctx, "chat.postMessage", params); err != nil {
+			log.Warningf(ctx, "(cron) error posting digest of %s - %s", p.team, err)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+} // }}}
@@ -0,0 +1,139 @@
+package slackoncallbot
+
+import (
+	"encoding/csv"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// One person in the directory.
+type directoryRow struct {
+	Team  string
+	Role  string
+	Name  string
+	Phone string
+	Label string
+}
+
+// Printable page of the directory.
+var directoryTemplate = template.Must(template.New("directory").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>On-call directory</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { width: 100%; border-collapse: collapse; }
+th, td { padding: 0.3em 0.5em; border-bottom: 1px solid #ccc; text-align: left; }
+td.team { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>On-call directory</h1>
+<table>
+<tr><th>Team</th><th>Role</th><th>Name</th><th>Phone</th><th>Label</th></tr>
+{{range .Rows}}<tr><td class="team">{{.Team}}</td><td>{{.Role}}</td><td>{{.Name}}</td><td>{{.Phone}}</td><td>{{.Label}}</td></tr>
+{{end}}</table>
+<p>generated: {{.Updated}}</p>
+</body>
+</html>
+`))
+
+// func directory {{{
+
+// directory
+//
+// Reply with links to the directory of every team, for printing or saving while
+// Slack is up, since the links need no Slack to open.
+func directory(ctx context.Context, params interface{}) slackResponse {
+	if _, ok := params.(opDirectory); !ok {
+		return slackResponse{Text: help(ctx, "directory")}
+	}
+	link := signedURL("/directory")
+	if link == "" {
+		return slackResponse{Text: fmt.Sprintf("Sorry, directory needs \"public_url\" and \"wallboard_token\" configured %s", humanErrorEmoji)}
+	}
+	return slackResponse{Text: fmt.Sprintf("On-call directory of every team (links valid for %d hours):\n<%s|printable page> | <%s&format=csv|CSV>", int(signedLinkTTL.Hours()), link, link)}
+} // }}}
+
+// func directoryHandler {{{
+
+// GET /directory?token={wallboard_token}&format={html|csv}
+// GET /directory?expires={unix}&sig={signature}&format={html|csv}
+//
+// Managers, current primary and their phones of every team, as a printable page
+// or CSV, to reach people when Slack itself is down.
+// Disabled unless "wallboard_token" is configured, like the wallboard.
+func directoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+
+	if wallboardToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !viewAllowed(r) {
+		log.Warningf(ctx, "(directory) invalid token from %s", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := ensureState(ctx); err != nil {
+		http.Error(w, "error loading state", http.StatusInternalServerError)
+		return
+	}
+
+	// Copy over people so we don't hold the lock while talking to Slack.
+	var rows []directoryRow
+	var ids []string
+	oncallMut.RLock()
+	for _, t := range rotations {
+		for _, m := range t.Managers {
+			rows = append(rows, directoryRow{Team: t.Team, Role: "manager", Name: "@" + m.Name})
+			ids = append(ids, m.Id)
+		}
+		if u, ok := memberByRole(t, rolePrimary); ok {
+			rows = append(rows, directoryRow{Team: t.Team, Role: rolePrimary, Name: "@" + u.Name, Label: u.Label})
+			ids = append(ids, u.Id)
+		}
+	}
+	oncallMut.RUnlock()
+
+	for i, id := range ids {
+		if user, err := getSlackUserDetail(ctx, id, false); err != nil || user == nil || user.phone == "" {
+			rows[i].Phone = "-"
+		} else {
+			rows[i].Phone = user.phone
+		}
+	}
+
+	now := time.Now().In(timezone).Format(dateFormat)
+	if r.FormValue("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="oncall-directory.csv"`)
+		c := csv.NewWriter(w)
+		c.Write([]string{"team", "role", "name", "phone", "label"})
+		for _, row := range rows {
+			c.Write([]string{row.Team, row.Role, row.Name, row.Phone, row.Label})
+		}
+		if c.Flush(); c.Error() != nil {
+			log.Warningf(ctx, "(directory) error writing csv - %s", c.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := directoryTemplate.Execute(w, struct {
+		Rows    []directoryRow
+		Updated string
+	}{rows, now})
+	if err != nil {
+		log.Warningf(ctx, "(directory) error rendering - %s", err)
+	}
+} // }}}
@@ -0,0 +1,97 @@
+package slackoncallbot
+
+import (
+	"github.com/fladz/slack-oncall-command/oncall"
+)
+
+// Who's on-call is worked out by the oncall package, which other services can
+// embed as well. These convert the stored team to and from its types.
+
+// func engineTeam {{{
+
+// Return the team as the oncall package takes it, with the override and the
+// assignments as covers.
+// Caller must hold oncallMut.
+func engineTeam(r *oncallProperty) *oncall.Team {
+	t := &oncall.Team{
+		Name:     r.Team,
+		Rotation: engineRotation(r.Rotations),
+		Schedule: teamSchedule(r),
+		Quiet:    engineShift(r.Quiet),
+	}
+	for _, s := range r.Shifts {
+		t.Shifts = append(t.Shifts, engineShift(s))
+	}
+	if r.OverrideId != "" {
+		t.Covers = append(t.Covers, oncall.Cover{
+			Member: oncall.Member{ID: r.OverrideId, Name: r.OverrideName, Label: "override"},
+			Start:  r.OverrideStart,
+			End:    r.OverrideUntil,
+		})
+	}
+	for _, a := range assignments[r.Team] {
+		t.Covers = append(t.Covers, oncall.Cover{
+			Member: oncall.Member{ID: a.Id, Name: a.Name, Label: "assigned"},
+			Start:  a.Start,
+			End:    a.End,
+		})
+	}
+	return t
+} // }}}
+
+// func teamSchedule {{{
+
+// Return the cadence of the team as the oncall package takes it.
+func teamSchedule(r *oncallProperty) oncall.Schedule {
+	return oncall.Schedule{
+		Cadence:  r.Cadence,
+		Anchor:   r.Anchor,
+		Location: teamLocation(r),
+		Advanced: r.Advanced,
+		Paused:   r.Paused,
+	}
+} // }}}
+
+// func engineRotation {{{
+
+func engineRotation(r []RotationProperty) oncall.Rotation {
+	rotation := make(oncall.Rotation, len(r))
+	for i, u := range r {
+		rotation[i] = engineMember(u)
+	}
+	return rotation
+} // }}}
+
+// func engineMember {{{
+
+func engineMember(u RotationProperty) oncall.Member {
+	return oncall.Member{
+		ID:           u.Id,
+		Name:         u.Name,
+		Label:        u.Label,
+		Shadow:       u.Shadow,
+		AwayUntil:    u.AwayUntil,
+		FallbackID:   u.FallbackId,
+		FallbackName: u.FallbackName,
+	}
+} // }}}
+
+// func rotationMember {{{
+
+func rotationMember(m oncall.Member) RotationProperty {
+	return RotationProperty{
+		Id:           m.ID,
+		Name:         m.Name,
+		Label:        m.Label,
+		Shadow:       m.Shadow,
+		AwayUntil:    m.AwayUntil,
+		FallbackId:   m.FallbackID,
+		FallbackName: m.FallbackName,
+	}
+} // }}}
+
+// func engineShift {{{
+
+func engineShift(s ShiftProperty) oncall.Shift {
+	return oncall.Shift{Label: s.Label, Start: s.Start, End: s.End}
+} // }}}
@@ -0,0 +1,131 @@
+package slackoncallbot
+
+import (
+	"encoding/json"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Envelope of requests sent from Slack Events API.
+type slackEvent struct {
+	Token     string `json:"token"`
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Event     struct {
+		Type      string `json:"type"`
+		Channel   string `json:"channel"`
+		MessageTs string `json:"message_ts"`
+		Links     []struct {
+			Domain string `json:"domain"`
+			URL    string `json:"url"`
+		} `json:"links"`
+	} `json:"event"`
+	// The event happened in a channel shared with other orgs.
+	IsExtSharedChannel bool `json:"is_ext_shared_channel"`
+}
+
+// func eventsHandler {{{
+
+// HTTP handler for Slack Events API.
+//
+// Currently only "link_shared" is handled, so on-call URLs pasted in Slack are
+// unfurled with the current primary on-call of the team.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	defer r.Body.Close()
+
+	var ev slackEvent
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+		log.Warningf(ctx, "(events) error decoding request: %s", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if debug {
+		log.Infof(ctx, "Event: %+v", ev)
+	}
+
+	// Make sure the token we received is what we expect.
+	if ev.Token != slackCommandToken {
+		log.Warningf(ctx, "(events) invalid token %s", ev.Token)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch ev.Type {
+	case "url_verification":
+		// Handshake when the request URL is configured in Slack.
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(ev.Challenge))
+		return
+	case "event_callback":
+		if ev.Event.Type == "link_shared" {
+			if err := ensureState(ctx); err == nil {
+				unfurlLinks(ctx, ev)
+			}
+		}
+	}
+
+	// Slack only needs to know we got it.
+	w.WriteHeader(http.StatusOK)
+} // }}}
+
+// func unfurlLinks {{{
+
+// Unfurl on-call URLs in a link_shared event.
+// In channels shared with other orgs, only teams bound to the channel (see
+// "restrict") are, like commands run from there, so the primary and their
+// phone aren't shown to other orgs.
+//
+// URLs look like https://{domain}/api/v1/teams/{team}/oncall
+func unfurlLinks(ctx context.Context, ev slackEvent) {
+	unfurls := make(map[string]attachment)
+	for _, l := range ev.Event.Links {
+		team := teamFromOncallURL(l.URL)
+		if team == "" {
+			continue
+		}
+		if ev.IsExtSharedChannel && !boundToChannel(team, ev.Event.Channel) {
+			log.Infof(ctx, "(unfurl) not unfurling %s in shared channel %s", team, ev.Event.Channel)
+			continue
+		}
+		unfurls[l.URL] = generateRoleAttachment(ctx, team, rolePrimary)
+	}
+	if len(unfurls) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(unfurls)
+	if err != nil {
+		log.Warningf(ctx, "(unfurl) error encoding unfurls - %s", err)
+		return
+	}
+	params := url.Values{}
+	params.Set("channel", ev.Event.Channel)
+	params.Set("ts", ev.Event.MessageTs)
+	params.Set("unfurls", string(b))
+	if err = callSlackAPI(ctx, "chat.unfurl", params); err != nil {
+		log.Warningf(ctx, "(unfurl) error unfurling links - %s", err)
+	}
+} // }}}
+
+// func teamFromOncallURL {{{
+
+// Return the team name in an on-call URL, or empty string if the URL is not one.
+func teamFromOncallURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	items := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(items) != 5 || items[0] != "api" || items[1] != "v1" || items[2] != "teams" || items[4] != "oncall" {
+		return ""
+	}
+	return strings.ToUpper(items[3])
+} // }}}
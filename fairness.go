@@ -0,0 +1,232 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"github.com/fladz/slack-oncall-command/oncall"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Primary shifts a member served, and for how long.
+type servedShifts struct {
+	shifts int
+	served time.Duration
+	// Start and end of the last shift.
+	start, last time.Time
+}
+
+// Members of the on-call list in the order they'd be primary, those who served
+// least first.
+type servedOrder struct {
+	members []RotationProperty
+	served  map[string]servedShifts
+}
+
+func (s servedOrder) Len() int {
+	return len(s.members)
+}
+
+func (s servedOrder) Less(i, j int) bool {
+	return s.served[s.members[i].Id].served < s.served[s.members[j].Id].served
+}
+
+func (s servedOrder) Swap(i, j int) {
+	s.members[i], s.members[j] = s.members[j], s.members[i]
+}
+
+// Times in order.
+type timeSlice []time.Time
+
+func (t timeSlice) Len() int {
+	return len(t)
+}
+
+func (t timeSlice) Less(i, j int) bool {
+	return t[i].Before(t[j])
+}
+
+func (t timeSlice) Swap(i, j int) {
+	t[i], t[j] = t[j], t[i]
+}
+
+// func fairness {{{
+
+// fairness {team} {months} {rebalance}
+//
+// Display how many primary shifts each member of the team served over the last
+// months, from the list recorded in history and the cadence. With "rebalance",
+// propose the list with the current primary first and the members who served
+// least after, applied once confirmed.
+func fairness(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opFairness)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "fairness")}
+	}
+
+	now := time.Now()
+	since := now.AddDate(0, -p.months, 0)
+	entries, err := loadHistoryFrom(ctx, p.team, since)
+	if err != nil {
+		log.Warningf(ctx, "(fairness) error loading history - %s", err)
+		return slackResponse{Text: errorExternal}
+	}
+
+	oncallMut.RLock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.RUnlock()
+		return slackResponse{Text: fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)}
+	}
+	if len(entries) == 0 {
+		oncallMut.RUnlock()
+		return slackResponse{Text: fmt.Sprintf("Sorry, no changes of %s were recorded, so there's no list to count from %s", p.team, humanErrorEmoji)}
+	}
+	served := primaryShifts(current, entries, since, now)
+	members := append([]RotationProperty(nil), current.Rotations...)
+	offset := rotationOffset(current, now)
+	oncallMut.RUnlock()
+
+	if p.rebalance {
+		change := func(r []RotationProperty) ([]RotationProperty, string, string) {
+			// Reorder as the members will be primary, then undo the cadence's
+			// offset so the current primary stays.
+			r = advanceRotation(r, offset)
+			idx := rotationMembers(r)
+			if len(idx) < 3 {
+				return nil, "", fmt.Sprintf("Sorry, %s needs at least 3 members in the rotation to rebalance %s", p.team, humanErrorEmoji)
+			}
+			order := servedOrder{served: served}
+			for _, i := range idx[1:] {
+				order.members = append(order.members, r[i])
+			}
+			sort.Stable(order)
+			for k, i := range idx[1:] {
+				r[i] = order.members[k]
+			}
+			return advanceRotation(r, len(idx)-offset), fmt.Sprintf("rebalanced by primary shifts of the last %d months", p.months), ""
+		}
+		if ctx.Value(ctxKeyConfirmed) == nil {
+			return previewRotations(ctx, p.team, fmt.Sprintf("fairness %s %d rebalance", p.team, p.months), change)
+		}
+		return updateRotations(ctx, "fairness", p.team, p.by, change)
+	}
+
+	var str []string
+	listed := map[string]bool{}
+	for _, u := range members {
+		if u.Shadow || listed[u.Id] {
+			continue
+		}
+		listed[u.Id] = true
+		str = append(str, fmt.Sprintf("<@%s|%s>: %s", u.Id, u.Name, describeServed(served[u.Id])))
+	}
+	var former []string
+	for id, s := range served {
+		if !listed[id] {
+			former = append(former, fmt.Sprintf("<@%s>: %s", id, describeServed(s)))
+		}
+	}
+	sort.Strings(former)
+	if former != nil {
+		str = append(str, "No longer in the list:")
+		str = append(str, former...)
+	}
+	return slackResponse{
+		Text: fmt.Sprintf("Primary shifts of %s in the last %d months:", p.team, p.months),
+		Attachments: []attachment{{
+			Color:  defaultColor,
+			Text:   strings.Join(str, "\n"),
+			Footer: "from the lists recorded in history and the current cadence, shifts, overrides and assignments aside",
+		}},
+	}
+} // }}}
+
+// func primaryShifts {{{
+
+// Count the primary shifts each member served from the time until now, keyed by
+// user id. The primary is resolved at every change recorded and every handoff
+// of the cadence, from the list recorded by the last change before. Entries are
+// oldest first, starting with the last one before the time if any.
+// Caller must hold oncallMut.
+func primaryShifts(r *oncallProperty, entries []*historyProperty, since, now time.Time) map[string]servedShifts {
+	// Moments the primary may change at.
+	moments := []time.Time{since}
+	for _, e := range entries {
+		if e.Time.After(since) {
+			moments = append(moments, e.Time)
+		}
+	}
+	if r.Cadence != "" {
+		for k := cadenceAdvances(r, since) + 1; handoffTime(r, k).Before(now); k++ {
+			moments = append(moments, handoffTime(r, k))
+		}
+	}
+	sort.Sort(timeSlice(moments))
+
+	served := map[string]servedShifts{}
+	var last string
+	next := 0
+	for i, t := range moments {
+		for next < len(entries) && !entries[next].Time.After(t) {
+			next++
+		}
+		if next == 0 {
+			continue
+		}
+		entry := entries[next-1]
+		then := *r
+		then.Rotations = entry.Rotations
+		then.Paused = false
+		then.Advanced = cadenceAdvances(&then, entry.Time)
+		team := engineTeam(&then)
+		team.Covers = nil
+		m := oncall.Resolve(team, t).Primary
+		if m == nil {
+			last = ""
+			continue
+		}
+		end := now
+		if i+1 < len(moments) {
+			end = moments[i+1]
+		}
+		s := served[m.ID]
+		if m.ID != last {
+			s.shifts++
+			s.start = t
+		}
+		s.served += end.Sub(t)
+		s.last = end
+		served[m.ID] = s
+		last = m.ID
+	}
+	return served
+} // }}}
+
+// func loadHistoryFrom {{{
+
+// Get the list of the team as of the time and every change since, oldest first,
+// as primaryShifts takes them.
+func loadHistoryFrom(ctx context.Context, team string, since time.Time) ([]*historyProperty, error) {
+	base, err := loadHistoryAt(ctx, team, since)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := loadHistorySince(ctx, team, since)
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		entries = append([]*historyProperty{base}, entries...)
+	}
+	return entries, nil
+} // }}}
+
+// func describeServed {{{
+
+// Human readable primary shifts served.
+func describeServed(s servedShifts) string {
+	return fmt.Sprintf("%d shift(s), %.1f day(s)", s.shifts, s.served.Hours()/24)
+} // }}}
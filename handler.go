@@ -7,6 +7,7 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/log"
+	"math/rand"
 	"net/http"
 	"sort"
 	"strings"
@@ -29,7 +30,19 @@ func init() {
 	// Prepare user structs
 	slackUsers = make(map[string]*slackUser, 0)
 
-	// Start request handler.
+	// Start request handlers.
+	http.HandleFunc("/interactive", interactiveHandler)
+	http.HandleFunc("/events", eventsHandler)
+	for _, job := range cronJobs {
+		http.HandleFunc("/cron/"+job.name, trackJob(job))
+	}
+	http.HandleFunc(offboardTaskPath, offboardTaskHandler)
+	http.HandleFunc("/jobs", jobsHandler)
+	http.HandleFunc("/wallboard", wallboardHandler)
+	http.HandleFunc("/directory", directoryHandler)
+	http.HandleFunc("/identities", identitiesHandler)
+	http.HandleFunc("/livez", livezHandler)
+	http.HandleFunc("/readyz", readyzHandler)
 	http.HandleFunc("/", oncallHandler)
 } // }}}
 
@@ -90,20 +103,74 @@ func oncallHandler(w http.ResponseWriter, r *http.Request) {
 	ctx = context.WithValue(ctx, ctxKeyUserId, sr.UserId)
 
 	// If this is the first time called, get the current list of oncall rotation first.
+	if err = ensureState(ctx); err != nil {
+		sendResponse(ctx, w, slackResponse{Text: errorExternal})
+		return
+	}
+
+	// Ok let's send it!
+	res := paginate(dispatch(ctx, sr), sr.Text, 0)
+	if commandLogDays > 0 {
+		recordCommand(ctx, sr, res)
+	}
+	sendResponse(ctx, w, renderResponse(res, sr.Text))
+	return
+} // }}}
+
+// func ensureState {{{
+
+// Load on-call state from datastore and set manager flags if this instance
+// hasn't done so yet.
+func ensureState(ctx context.Context) error {
 	if len(rotations) == 0 {
-		if err = loadState(ctx); err != nil {
+		if err := loadState(ctx); err != nil {
 			log.Warningf(ctx, "error loading oncall state - %s", err)
-			sendResponse(ctx, w, slackResponse{Text: errorExternal})
-			return
+			return err
+		}
+	}
+	if phoneOverrides == nil {
+		if err := loadPhoneOverrides(ctx); err != nil {
+			log.Warningf(ctx, "error loading phone overrides - %s", err)
+			return err
+		}
+	}
+	if opAliases == nil {
+		if err := loadOpAliases(ctx); err != nil {
+			log.Warningf(ctx, "error loading operation aliases - %s", err)
+			return err
 		}
-		// Loaded information, let's set "manager" flag to users.
-		if err = loadManagers(ctx); err != nil {
-			log.Warningf(ctx, "error loading managers - %s", err)
-			sendResponse(ctx, w, slackResponse{Text: errorExternal})
-			return
+	}
+	if assignments == nil {
+		if err := loadAssignments(ctx); err != nil {
+			log.Warningf(ctx, "error loading assignments - %s", err)
+			return err
 		}
 	}
+	// Loaded information, let's set "manager" flag to users.
+	// This needs a Slack lookup per manager, so it's put off while Slack is slow.
+	if managersLoaded || skipOptional(ctx, "manager preload") {
+		return nil
+	}
+	// Flags are counters, so don't run this twice at once. A failed run takes
+	// back the flags it set and the next request tries again.
+	managersLoaded = true
+	if err := loadManagers(ctx); err != nil {
+		log.Warningf(ctx, "error loading managers - %s", err)
+		managersLoaded = false
+		return err
+	}
+	return nil
+} // }}}
+
+// func dispatch {{{
 
+// Decode the requested operation and run it.
+// This is shared by the slash command endpoint and the interactive message endpoint.
+func dispatch(ctx context.Context, sr slackCommandParams) slackResponse {
+	ctx = context.WithValue(ctx, ctxKeyCommand, sr.Command)
+	if externalRequest(sr) {
+		ctx = context.WithValue(ctx, ctxKeyExternal, true)
+	}
 	// Decode parameters passed.
 	operation, params, errstr := decodeOperationParams(ctx, sr)
 	if errstr != "" {
@@ -111,40 +178,130 @@ func oncallHandler(w http.ResponseWriter, r *http.Request) {
 		case errorInput:
 			// In case of input errors, display help text for the operation
 			// they tried to run.
-			sendResponse(ctx, w, slackResponse{Text: help(ctx, operation)})
+			return slackResponse{Text: help(ctx, operation)}
 		default:
 			// Anything else, print out the error string itself.
-			sendResponse(ctx, w, slackResponse{Text: errstr})
+			return slackResponse{Text: errstr}
 		}
-		return
 	}
 
-	var res slackResponse
 	switch operation {
 	case "list": // List current oncall rotations.
-		res = list(ctx, params)
+		return list(ctx, params)
+	case "next": // Show only the primary on-call.
+		return next(ctx, params)
 	case "add": // Add a user in rotation.
-		res = add(ctx, params)
+		return add(ctx, params)
 	case "flush": // Flush a current rotation.
-		res = flush(ctx, params)
+		return flush(ctx, params)
+	case "flush-managers": // Remove all managers of a team.
+		return flushManagers(ctx, params)
 	case "remove": // Remove a user from rotation.
-		res = remove(ctx, params)
+		return remove(ctx, params)
 	case "swap": // Swap 2 positions in a rotation.
-		res = swap(ctx, params)
+		return swap(ctx, params)
+	case "rotate": // Advance a rotation by one.
+		return rotate(ctx, params)
+	case "move": // Move a member to another position.
+		return move(ctx, params)
+	case "cadence", "schedule": // Set how often a rotation advances.
+		return cadence(ctx, params)
+	case "simulate": // Projected handoffs under another cadence.
+		return simulate(ctx, params)
+	case "copy": // Copy a rotation from another team.
+		return copyRotation(ctx, params)
+	case "shuffle": // Randomize order of a rotation.
+		return shuffle(ctx, params)
+	case "reverse": // Flip order of a rotation.
+		return reverse(ctx, params)
+	case "label": // Change label of a member.
+		return label(ctx, params)
+	case "override": // Let someone cover primary for a while.
+		return override(ctx, params)
+	case "shift": // Time windows of regions.
+		return shift(ctx, params)
+	case "digest": // Weekly post of who's on-call.
+		return digest(ctx, params)
+	case "onboard": // New members shadowing the primary first.
+		return onboard(ctx, params)
+	case "rest": // Minimum days between primary shifts.
+		return rest(ctx, params)
+	case "cap": // Most primary shifts a month.
+		return shiftCap(ctx, params)
+	case "streak": // Most days in a row as primary.
+		return streak(ctx, params)
+	case "plan": // Flush or replacement of the list at a later time.
+		return plan(ctx, params)
+	case "cover-needed": // Offer for someone to cover primary on-call.
+		return coverNeeded(ctx, params)
+	case "handoff": // Incoming primary accepting a scheduled handoff.
+		return handoff(ctx, params)
+	case "quiet": // Hours the primary isn't paged in.
+		return quiet(ctx, params)
+	case "assign": // Dated schedule over the rotation.
+		return assign(ctx, params)
+	case "away": // Skip a member for a while.
+		return away(ctx, params)
+	case "fallback": // Backup of a member.
+		return fallback(ctx, params)
+	case "note": // Text shown with the on-call list.
+		return note(ctx, params)
+	case "describe": // What a team covers.
+		return describe(ctx, params)
+	case "undo": // Revert the last change of a team.
+		return undo(ctx, params)
+	case "history": // Show recent changes of a team.
+		return history(ctx, params)
+	case "at": // Who was on-call at a past time.
+		return at(ctx, params)
+	case "stats": // Summarize a team.
+		return stats(ctx, params)
+	case "fairness": // Primary shifts served per member.
+		return fairness(ctx, params)
+	case "export": // Dump a team as JSON.
+		return export(ctx, params)
+	case "directory": // Links to every team's contacts.
+		return directory(ctx, params)
+	case "broadcast-primaries": // Message every team's primary on-call.
+		return broadcastPrimaries(ctx, params)
+	case "admin": // State of the application itself.
+		return admin(ctx, params)
+	case "page": // Send a message to the primary on-call.
+		return page(ctx, params)
 	case "register": // Add a new team to manage oncall list for.
-		res = register(ctx, params)
+		return register(ctx, params)
 	case "unregister": // Remove a manager from a team.
-		res = unregister(ctx, params)
+		return unregister(ctx, params)
+	case "promote", "demote": // Change managers from the on-call list.
+		return promote(ctx, params)
+	case "opalias": // Other names of operations.
+		return opalias(ctx, params)
+	case "import": // Replace a team from JSON.
+		return importTeam(ctx, params)
+	case "rename": // Change name of a team.
+		return rename(ctx, params)
 	case "update":
-		res = update(ctx, params)
-	default: // Dump available operations and params.
-		sendResponse(ctx, w, slackResponse{Text: help(ctx, "")})
-		return
+		return update(ctx, params)
+	case "setphone": // Set phone number for users without one in Slack.
+		return setphone(ctx, params)
+	case "permit": // Change roles required for operations of a team.
+		return permit(ctx, params)
+	case "restrict": // Limit channels a team can be changed from.
+		return restrict(ctx, params)
+	case "alias", "unalias": // Other names of a team.
+		return alias(ctx, params)
+	case "whoami": // Show teams the requestor belongs to.
+		return whoami(ctx, params)
+	case "link-me": // Identities in other systems.
+		return linkMe(ctx, params)
+	case "report": // Schedule periodic reports of a rotation.
+		return report(ctx, params)
+	case "pick": // Team was omitted, let the user pick one.
+		return pickTeam(ctx, params)
 	}
 
-	// Ok let's send it!
-	sendResponse(ctx, w, res)
-	return
+	// Dump available operations and params.
+	return slackResponse{Text: help(ctx, "")}
 } // }}}
 
 // func help {{{
@@ -155,25 +312,126 @@ func oncallHandler(w http.ResponseWriter, r *http.Request) {
 // This will be called when "help" operation is issued, no/unknown operation is issued,
 // or any of user input is invalid. (ie. missing parameters)
 func help(ctx context.Context, scope string) string {
+	return strings.Replace(helpTemplate(ctx, scope), "{command}", commandName(ctx), -1)
+} // }}}
+
+// func helpTemplate {{{
+
+// Return help text of the scope for the requestor, before the command is filled in.
+func helpTemplate(ctx context.Context, scope string) string {
 	str := "Usage:\n"
 	if scope != "" {
 		switch scope {
 		case "list":
 			return str + helpList
+		case "next":
+			return str + helpNext
 		case "add":
 			return str + helpAdd
 		case "remove":
 			return str + helpRemove
 		case "swap":
 			return str + helpSwap
+		case "rotate":
+			return str + helpRotate
+		case "move":
+			return str + helpMove
+		case "cadence":
+			return str + helpCadence
+		case "schedule":
+			return str + helpSchedule
+		case "simulate":
+			return str + helpSimulate
+		case "copy":
+			return str + helpCopy
+		case "shuffle", "reverse":
+			return str + helpShuffle
+		case "label":
+			return str + helpLabel
+		case "override":
+			return str + helpOverride
+		case "shift":
+			return str + helpShift
+		case "digest":
+			return str + helpDigest
+		case "onboard":
+			return str + helpOnboard
+		case "rest":
+			return str + helpRest
+		case "cap":
+			return str + helpCap
+		case "streak":
+			return str + helpStreak
+		case "plan":
+			return str + helpPlan
+		case "cover-needed":
+			return str + helpCover
+		case "handoff":
+			return str + helpHandoff
+		case "quiet":
+			return str + helpQuiet
+		case "assign":
+			return str + helpAssign
+		case "away":
+			return str + helpAway
+		case "fallback":
+			return str + helpFallback
+		case "note":
+			return str + helpNote
+		case "describe":
+			return str + helpDescribe
+		case "undo":
+			return str + helpUndo
+		case "history":
+			return str + helpHistory
+		case "at":
+			return str + helpAt
+		case "stats":
+			return str + helpStats
+		case "fairness":
+			return str + helpFairness
+		case "export":
+			return str + helpExport
+		case "directory":
+			return str + helpDirectory
+		case "broadcast-primaries":
+			return str + helpBroadcast
+		case "admin":
+			return str + helpAdmin
+		case "page":
+			return str + helpPage
 		case "flush":
 			return str + helpFlush
+		case "flush-managers":
+			return str + helpFlushMgr
 		case "register":
 			return str + helpRegister
 		case "unregister":
 			return str + helpUnregister
+		case "rename":
+			return str + helpRename
+		case "import":
+			return str + helpImport
 		case "update":
 			return str + helpUpdate
+		case "setphone":
+			return str + helpSetphone
+		case "restrict":
+			return str + helpRestrict
+		case "permit":
+			return str + helpPermit
+		case "alias", "unalias":
+			return str + helpAlias
+		case "opalias":
+			return str + helpOpalias
+		case "promote", "demote":
+			return str + helpPromote
+		case "link-me":
+			return str + helpLinkMe
+		case "whoami":
+			return str + helpWhoami
+		case "report":
+			return str + helpReport
 		}
 	}
 
@@ -181,13 +439,13 @@ func help(ctx context.Context, scope string) string {
 	id, ok := ctx.Value(ctxKeyUserId).(string)
 	if ok {
 		if userIsExempt(ctx, id) {
-			return str + strings.Join([]string{helpList, helpUpdate, helpAdd, helpRemove, helpSwap, helpFlush, helpRegister, helpUnregister}, "\n")
+			return str + strings.Join([]string{helpList, helpNext, helpPage, helpAt, helpHistory, helpStats, helpFairness, helpExport, helpWhoami, helpLinkMe, helpUpdate, helpSetphone, helpAdd, helpRemove, helpLabel, helpNote, helpDescribe, helpSwap, helpMove, helpCopy, helpOverride, helpAssign, helpCover, helpShift, helpQuiet, helpAway, helpFallback, helpRotate, helpHandoff, helpShuffle, helpCadence, helpSchedule, helpSimulate, helpFlush, helpPlan, helpUndo, helpReport, helpDigest, helpOnboard, helpRest, helpCap, helpStreak, helpAlias, helpPromote, helpRegister, helpUnregister, helpRename, helpImport, helpRestrict, helpPermit, helpOpalias, helpFlushMgr, helpDirectory, helpBroadcast, helpAdmin}, "\n")
 		}
 		if userIsManager(ctx, id) {
-			return str + strings.Join([]string{helpList, helpUpdate, helpAdd, helpRemove, helpSwap, helpFlush}, "\n")
+			return str + strings.Join([]string{helpList, helpNext, helpPage, helpAt, helpHistory, helpStats, helpFairness, helpExport, helpWhoami, helpLinkMe, helpUpdate, helpSetphone, helpAdd, helpRemove, helpLabel, helpNote, helpDescribe, helpSwap, helpMove, helpCopy, helpOverride, helpAssign, helpCover, helpShift, helpQuiet, helpAway, helpFallback, helpRotate, helpHandoff, helpShuffle, helpCadence, helpSchedule, helpSimulate, helpFlush, helpPlan, helpUndo, helpReport, helpDigest, helpOnboard, helpRest, helpCap, helpStreak, helpAlias, helpPromote}, "\n")
 		}
 	}
-	return str + strings.Join([]string{helpList, helpUpdate}, "\n")
+	return str + strings.Join([]string{helpList, helpNext, helpPage, helpAt, helpHistory, helpStats, helpFairness, helpExport, helpWhoami, helpLinkMe, helpUpdate, helpSetphone, helpAway, helpFallback, helpCover, helpHandoff}, "\n")
 } // }}}
 
 // func list {{{
@@ -201,6 +459,9 @@ func list(ctx context.Context, params interface{}) slackResponse {
 	if !ok {
 		return slackResponse{Text: help(ctx, "list")}
 	}
+	if p.all {
+		return listAllRotations(ctx)
+	}
 	if p.team == "" {
 		// Display list of manager(s)/team.
 		return listTeams(ctx)
@@ -208,11 +469,26 @@ func list(ctx context.Context, params interface{}) slackResponse {
 	return listRotation(ctx, p.team)
 } // }}}
 
+// func next {{{
+
+// next {team} {role}
+//
+// Display only the primary (or secondary if requested) on-call of the team, for people
+// who need to reach someone right now and don't want to parse the whole list.
+func next(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opNext)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "next")}
+	}
+	return slackResponse{Attachments: []attachment{generateRoleAttachment(ctx, p.team, p.role)}}
+} // }}}
+
 // func add {{{
 
-// add {team} {@slack_username} {label}
+// add {team} {@slack_username} {label} {position}
 //
-// Add the user in the team's rotation.
+// Add the user in the team's rotation, at the end or at the position if given.
+// If the user is already in the rotation, name and label are updated and the position is kept.
 // "label" is optional, this could be used to identify the user's "area of responsibility" if a team
 // has multiple different areas.
 //
@@ -258,13 +534,15 @@ func add(ctx context.Context, params interface{}) slackResponse {
 	var updatedBy string
 	oncallMut.Lock()
 	if len(current.Rotations) == 0 {
+		// Keep the current state so this change can be undone.
+		previous := copyState(current)
 		// Add and save.
-		current.Rotations = append(current.Rotations, RotationProperty{Name: p.name, Id: p.id, Label: p.label})
+		current.Rotations = append(current.Rotations, RotationProperty{Name: p.name, Id: p.id, Label: p.label, Shadow: p.shadow})
 		updated = current.Updated
 		updatedBy = current.UpdatedBy
 		current.Updated = time.Now()
 		current.UpdatedBy = p.by.name
-		if err = saveState(ctx, current); err != nil {
+		if err = replaceState(ctx, "add", p.by.name, &previous, current); err != nil {
 			log.Warningf(ctx, "(add) error saving state - %s", err)
 			// Revert the changes.
 			current.Rotations = nil
@@ -274,6 +552,7 @@ func add(ctx context.Context, params interface{}) slackResponse {
 			oncallMut.Unlock()
 			return res
 		}
+		recordHistory(ctx, p.team, "add", p.by.name, fmt.Sprintf("added <@%s>", p.name), current.Rotations)
 		res.Text = fmt.Sprintf("Success! <@%s> added to the on-call list for %s\nNew list:", p.name, p.team)
 		oncallMut.Unlock()
 		res.Attachments = []attachment{generateOncallList(ctx, p.team)}
@@ -286,30 +565,36 @@ func add(ctx context.Context, params interface{}) slackResponse {
 		// Make sure there is no dupe.
 		if current.Rotations[i].Id == p.id {
 			// If there's a dupe, possibly the name and/or label was changed.
-			if p.name == current.Rotations[i].Name && p.label == current.Rotations[i].Label {
+			if p.name == current.Rotations[i].Name && p.label == current.Rotations[i].Label && p.shadow == current.Rotations[i].Shadow {
 				res.Text = fmt.Sprintf("<@%s> already assigned %s rotation %s", p.name, p.team, humanErrorEmoji)
 				oncallMut.Unlock()
 				return res
 			}
+			// Keep the current state so this change can be undone.
+			previous := copyState(current)
 			currentName = current.Rotations[i].Name
 			currentLabel = current.Rotations[i].Label
-			// Same user, different name or label. In this case we ignore the position. We'll just update the diffs.
+			currentShadow := current.Rotations[i].Shadow
+			// Same user, different name, label or shadow flag. In this case we ignore the position. We'll just update the diffs.
 			updated = current.Updated
 			updatedBy = current.UpdatedBy
 			current.Rotations[i].Name = p.name
 			current.Rotations[i].Label = p.label
+			current.Rotations[i].Shadow = p.shadow
 			current.Updated = time.Now()
 			current.UpdatedBy = p.by.name
-			if err := saveState(ctx, current); err != nil {
+			if err := replaceState(ctx, "add", p.by.name, &previous, current); err != nil {
 				log.Warningf(ctx, "(add) error saving state - %s", err)
 				current.Rotations[i].Name = currentName
 				current.Rotations[i].Label = currentLabel
+				current.Rotations[i].Shadow = currentShadow
 				current.Updated = updated
 				current.UpdatedBy = updatedBy
 				res.Text = errorExternal
 				oncallMut.Unlock()
 				return res
 			}
+			recordHistory(ctx, p.team, "add", p.by.name, fmt.Sprintf("updated <@%s>", p.name), current.Rotations)
 			res.Text = fmt.Sprintf("Success! Information updated for <@%s>\nNew list:", p.name)
 			oncallMut.Unlock()
 			res.Attachments = []attachment{generateOncallList(ctx, p.team)}
@@ -317,15 +602,36 @@ func add(ctx context.Context, params interface{}) slackResponse {
 		}
 	}
 
-	// Ok, the user doesn't exist in rotation. Let's append.
+	// Ok, the user doesn't exist in rotation. Let's append, or insert if position is given.
+	if p.position > len(current.Rotations)+1 {
+		res.Text = fmt.Sprintf("Sorry, position %d is out of range, %s has %d people in the on-call list %s", p.position, p.team, len(current.Rotations), humanErrorEmoji)
+		oncallMut.Unlock()
+		return res
+	}
+	// Keep the current state so this change can be undone.
+	previous := copyState(current)
 	updated = current.Updated
 	updatedBy = current.UpdatedBy
-	current.Rotations = append(current.Rotations, RotationProperty{Name: p.name, Id: p.id, Label: p.label})
+	r := current.Rotations
+	entry := RotationProperty{Name: p.name, Id: p.id, Label: p.label, Shadow: p.shadow}
+	// New members of teams onboarding shadow the primary first.
+	if !p.shadow && current.Onboarding > 0 && current.Cadence != "" && len(rotationMembers(r)) > 0 {
+		entry.Shadow = true
+		entry.ShadowLeft = current.Onboarding
+	}
+	if p.position > 0 {
+		current.Rotations = make([]RotationProperty, 0, len(r)+1)
+		current.Rotations = append(current.Rotations, r[:p.position-1]...)
+		current.Rotations = append(current.Rotations, entry)
+		current.Rotations = append(current.Rotations, r[p.position-1:]...)
+	} else {
+		current.Rotations = append(r, entry)
+	}
 	current.Updated = time.Now()
 	current.UpdatedBy = p.by.name
-	if err = saveState(ctx, current); err != nil {
+	if err = replaceState(ctx, "add", p.by.name, &previous, current); err != nil {
 		log.Warningf(ctx, "(add) error saving state - %s", err)
-		current.Rotations = current.Rotations[:(len(current.Rotations) - 1)]
+		current.Rotations = r
 		current.Updated = updated
 		current.UpdatedBy = updatedBy
 		res.Text = errorExternal
@@ -333,6 +639,7 @@ func add(ctx context.Context, params interface{}) slackResponse {
 		return res
 	}
 
+	recordHistory(ctx, p.team, "add", p.by.name, fmt.Sprintf("added <@%s>", p.name), current.Rotations)
 	res.Text = fmt.Sprintf("Success! <@%s> added to the on-call list for %s\nNew list:", p.name, p.team)
 	oncallMut.Unlock()
 	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
@@ -362,13 +669,15 @@ func flush(ctx context.Context, params interface{}) slackResponse {
 	// Backup current rotation in case the update fails.
 	oncallMut.Lock()
 	defer oncallMut.Unlock()
+	// Keep the current state so this change can be undone.
+	previous := copyState(current)
 	r := current.Rotations
 	updated := current.Updated
 	updatedBy := current.UpdatedBy
 	current.Rotations = nil
 	current.Updated = time.Now()
 	current.UpdatedBy = p.by.name
-	if err := saveState(ctx, current); err != nil {
+	if err := replaceState(ctx, "flush", p.by.name, &previous, current); err != nil {
 		log.Warningf(ctx, "(flush) error saving state - %s", err)
 		current.Rotations = r
 		current.Updated = updated
@@ -377,7 +686,56 @@ func flush(ctx context.Context, params interface{}) slackResponse {
 		return res
 	}
 
-	res.Text = fmt.Sprintf("Success! Removed all on-call list from %s", p.team)
+	recordHistory(ctx, p.team, "flush", p.by.name, "flushed the on-call list", nil)
+	res.Text = fmt.Sprintf("Success! Removed all on-call list from %s, the list is now empty", p.team)
+	res.Attachments = []attachment{restoreAttachment(ctx, p.team, nil, r)}
+	return res
+} // }}}
+
+// func flushManagers {{{
+
+// flush-managers {team}
+//
+// Remove every manager of the team, keeping the team and its on-call list.
+func flushManagers(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opFlush)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "flush-managers")}
+	}
+
+	res := slackResponse{}
+	oncallMut.Lock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if len(current.Managers) == 0 {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Team %s has no managers %s", p.team, humanErrorEmoji)
+		return res
+	}
+	// Keep the current state so this change can be undone.
+	previous := copyState(current)
+	current.Managers = make([]ManagerProperty, 0)
+	current.Updated = time.Now()
+	current.UpdatedBy = p.by.name
+	if err := replaceState(ctx, "flush-managers", p.by.name, &previous, current); err != nil {
+		log.Warningf(ctx, "(flush-managers) error saving state - %s", err)
+		*current = previous
+		oncallMut.Unlock()
+		res.Text = errorExternal
+		return res
+	}
+	recordHistory(ctx, p.team, "flush-managers", p.by.name, fmt.Sprintf("removed all %d manager(s)", len(previous.Managers)), current.Rotations)
+	oncallMut.Unlock()
+
+	// Now remove "manager" flag from those users.
+	for _, m := range previous.Managers {
+		userSubManagerFlag(ctx, m.Id)
+	}
+	res.Text = fmt.Sprintf("Success! Removed all managers from %s", p.team)
 	return res
 } // }}}
 
@@ -388,7 +746,7 @@ func flush(ctx context.Context, params interface{}) slackResponse {
 // Remove the user from the team's rotation.
 func remove(ctx context.Context, params interface{}) slackResponse {
 	p, ok := params.(opRemove)
-	if !ok || p.team == "" || p.name == "" || p.id == "" {
+	if !ok || p.team == "" || (p.position == 0 && (p.name == "" || p.id == "")) {
 		return slackResponse{Text: help(ctx, "remove")}
 	}
 
@@ -407,25 +765,49 @@ func remove(ctx context.Context, params interface{}) slackResponse {
 		oncallMut.Unlock()
 		return res
 	}
-	updated := current.Updated
-	updatedBy := current.UpdatedBy
-	r := current.Rotations
+	// Removing by position, find out who's there.
+	if p.position > 0 {
+		position := labeledPosition(current.Rotations, p.label, p.position)
+		if position == 0 {
+			res.Text = fmt.Sprintf("Sorry, there's no position %s in the on-call list for %s %s", describePosition(p.label, p.position), p.team, humanErrorEmoji)
+			oncallMut.Unlock()
+			return res
+		}
+		p.position = position
+		p.id = current.Rotations[p.position-1].Id
+		p.name = current.Rotations[p.position-1].Name
+	} else if p.label != "" {
+		// The user has to be in the list with the label.
+		found := false
+		for _, u := range current.Rotations {
+			if u.Id == p.id && u.Label == p.label {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res.Text = fmt.Sprintf("Sorry, <@%s> is not in the on-call list for %s with label %s %s", p.name, p.team, p.label, humanErrorEmoji)
+			oncallMut.Unlock()
+			return res
+		}
+	}
 	// Find the staff requested for removal.
 	for i := 0; i < len(current.Rotations); i++ {
-		if current.Rotations[i].Id == p.id {
+		if current.Rotations[i].Id == p.id && (p.position == 0 || i == p.position-1) {
+			// Keep the current state so this change can be undone.
+			previous := copyState(current)
 			// This is the requested user to be removed.
 			current.Rotations = append(current.Rotations[:i], current.Rotations[i+1:]...)
 			current.Updated = time.Now()
 			current.UpdatedBy = p.by.name
-			if err := saveState(ctx, current); err != nil {
+			if err := replaceState(ctx, "remove", p.by.name, &previous, current); err != nil {
 				log.Warningf(ctx, "(remove) error saving state - %s", err)
-				current.Rotations = r
-				current.Updated = updated
-				current.UpdatedBy = updatedBy
+				*current = previous
 				res.Text = errorExternal
 				oncallMut.Unlock()
 				return res
 			}
+			recordHistory(ctx, p.team, "remove", p.by.name, fmt.Sprintf("removed <@%s>", p.name), current.Rotations)
 			res.Text = fmt.Sprintf("Success! <@%s> removed from the on-call list for %s\nNew list:", p.name, p.team)
 			oncallMut.Unlock()
 			res.Attachments = []attachment{generateOncallList(ctx, p.team)}
@@ -449,13 +831,44 @@ func swap(ctx context.Context, params interface{}) slackResponse {
 		return slackResponse{Text: help(ctx, "swap")}
 	}
 
-	res := slackResponse{}
+	if len(p.labels) != 2 {
+		p.labels = []string{"", ""}
+	}
+	a, b := describePosition(p.labels[0], p.positions[0]), describePosition(p.labels[1], p.positions[1])
 	// If given position_A and position_B are same, nothing to do.
-	if p.positions[0] == p.positions[1] {
-		res.Text = "position_A and position_B are same, nothing to do!"
-		return res
+	if a == b {
+		return slackResponse{Text: "position_A and position_B are same, nothing to do!"}
+	}
+
+	change := func(r []RotationProperty) ([]RotationProperty, string, string) {
+		// Positions within a label are counted in the current list.
+		i, j := labeledPosition(r, p.labels[0], p.positions[0]), labeledPosition(r, p.labels[1], p.positions[1])
+		// If there's less than 2 staff in rotation, we cannot swap.
+		if len(r) < 2 || i == 0 || j == 0 || i == j {
+			return nil, "", fmt.Sprintf("Sorry, swap could not be completed! Check _position_a_ and _position_b_ %s", humanErrorEmoji)
+		}
+		r[i-1], r[j-1] = r[j-1], r[i-1]
+		return r, fmt.Sprintf("swapped position %d and %d", i, j), ""
+	}
+	if confirmReorder && ctx.Value(ctxKeyConfirmed) == nil {
+		return previewRotations(ctx, p.team, fmt.Sprintf("swap %s %s %s", p.team, a, b), change)
+	}
+	return updateRotations(ctx, "swap", p.team, p.by, change)
+} // }}}
+
+// func rotate {{{
+
+// rotate {team}
+//
+// Advance the {team} rotation - position 1 moves to the end of the list and
+// everyone else moves up by one, so the secondary always becomes the next primary.
+func rotate(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opRotate)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "rotate")}
 	}
 
+	res := slackResponse{}
 	// Get the current rotation of the team.
 	current := getCurrentRotation(p.team)
 	if current == nil {
@@ -463,27 +876,57 @@ func swap(ctx context.Context, params interface{}) slackResponse {
 		return res
 	}
 
-	// If there's less than 2 staff in rotation, we cannot swap.
+	limitHistory, err := loadLimitHistory(ctx, "rotate", p.team, time.Now())
+	if err != nil {
+		log.Warningf(ctx, "(rotate) error loading history - %s", err)
+		res.Text = errorExternal
+		return res
+	}
+
+	// If there's less than 2 staff in rotation, nothing to rotate.
 	oncallMut.Lock()
-	rlen := len(current.Rotations)
-	if rlen < 2 || rlen < p.positions[0] || rlen < p.positions[1] {
-		res.Text = fmt.Sprintf("Sorry, swap could not be completed! Check _position_a_ and _position_b_ %s", humanErrorEmoji)
+	members := rotationMembers(current.Rotations)
+	if len(members) < 2 {
+		res.Text = fmt.Sprintf("Sorry, team %s needs at least 2 people in the on-call list to rotate %s", p.team, humanErrorEmoji)
+		oncallMut.Unlock()
+		return res
+	}
+	// Members away are skipped, the next one not away (or covered by a fallback) becomes primary.
+	now := time.Now()
+	n := 1
+	for n < len(members) {
+		if _, ok := memberOnDuty(current.Rotations[members[n]], now); ok {
+			break
+		}
+		n++
+	}
+	if n == len(members) {
+		res.Text = fmt.Sprintf("Sorry, everyone else in the on-call list for %s is away %s", p.team, humanErrorEmoji)
 		oncallMut.Unlock()
 		return res
 	}
+	if errstr := checkLimits("rotate", current, advanceRotation(current.Rotations, n), limitHistory); errstr != "" {
+		res.Text = errstr
+		oncallMut.Unlock()
+		return res
+	}
+
+	// Keep the current state so this change can be undone.
+	previous := copyState(current)
 
 	// Copy over current rotation first.
 	currentRotation := current.Rotations
 	currentUpdated := current.Updated
 	currentUpdatedBy := current.UpdatedBy
 
-	// Swap and save the new rotation in state.
-	current.Rotations[p.positions[0]-1], current.Rotations[p.positions[1]-1] =
-		current.Rotations[p.positions[1]-1], current.Rotations[p.positions[0]-1]
-	current.Updated = time.Now()
+	// Build the new order in a new slice so the backup above stays intact.
+	// Shadows keep their positions.
+	newRotation := advanceRotation(currentRotation, n)
+	current.Rotations = newRotation
+	current.Updated = now
 	current.UpdatedBy = p.by.name
-	if err := saveState(ctx, current); err != nil {
-		log.Warningf(ctx, "(swap) error saving state - %s", err)
+	if err := replaceState(ctx, "rotate", p.by.name, &previous, current); err != nil {
+		log.Warningf(ctx, "(rotate) error saving state - %s", err)
 		// Replace the rotation list
 		current.Rotations = currentRotation
 		current.Updated = currentUpdated
@@ -493,203 +936,1671 @@ func swap(ctx context.Context, params interface{}) slackResponse {
 		return res
 	}
 
-	res.Text = fmt.Sprintf("Success! Swapped position %d and %d in the on-call list for %s\nNew list:", p.positions[0], p.positions[1], p.team)
+	onDuty := onDutyMembers(current, now)
+	primary, _ := memberOnDuty(newRotation[onDuty[0]], now)
+	recordHistory(ctx, p.team, "rotate", p.by.name, fmt.Sprintf("rotated, <@%s> is now %s", primary.Name, rolePrimary), current.Rotations)
+	res.Text = fmt.Sprintf("Success! Rotated the on-call list for %s, <@%s> is now %s", p.team, primary.Name, rolePrimary)
+	if len(onDuty) > 1 {
+		secondary, _ := memberOnDuty(newRotation[onDuty[1]], now)
+		res.Text += fmt.Sprintf(" and <@%s> %s", secondary.Name, roleSecondary)
+	}
+	res.Text += "\nNew list:"
 	oncallMut.Unlock()
 	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
 	return res
 } // }}}
 
-// func register {{{
+// func move {{{
 
-// register {team} {@slack_username}
+// move {team} {from} {to}
 //
-// Register a new team to be mamaged by this oncall process.
-// If "@slack_username" is defined, set the person as the team manager.
-// This operation can also be used to assign an additional manager to existing team.
-func register(ctx context.Context, params interface{}) slackResponse {
-	p, ok := params.(opRegister)
-	if !ok || p.team == "" || (p.name != "" && p.id == "") {
-		return slackResponse{Text: help(ctx, "register")}
+// Take the member at position {from} out of the {team} rotation and put them back
+// at position {to}, everyone in between shifts by one.
+func move(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opMove)
+	if !ok || p.team == "" || p.from < 1 || p.to < 1 {
+		return slackResponse{Text: help(ctx, "move")}
 	}
-
-	res := slackResponse{}
-	// If the manager is provided, make sure the person exists.
-	if p.name != "" {
-		u, err := getSlackUserDetail(ctx, p.id, false)
-		if err != nil {
-			log.Warningf(ctx, "(register) error getting user %s - %s", p.name, err)
-			res.Text = errorExternal
-			return res
-		}
-		if u == nil {
-			res.Text = fmt.Sprintf("Sorry! <@%s> doesn't exist in Slack %s", p.name, humanErrorEmoji)
-			return res
-		}
+	if p.from == p.to {
+		return slackResponse{Text: "from and to are same, nothing to do!"}
 	}
 
-	// Check if the team already exists.
-	r := getCurrentRotation(p.team)
-	if r == nil {
-		r = &oncallProperty{Team: p.team, Managers: make([]ManagerProperty, 0)}
-		if p.name != "" {
-			r.Managers = append(r.Managers, ManagerProperty{Name: p.name, Id: p.id})
-		}
-		r.Updated = time.Now()
-		r.UpdatedBy = p.by.name
-		// Save the state first.
-		if err := saveState(ctx, r); err != nil {
-			log.Warningf(ctx, "(register) error saving state - %s", err)
-			res.Text = errorExternal
-			return res
-		}
-		// Saved in external storage, let's save in memory now.
-		oncallMut.Lock()
-		rotations = append(rotations, r)
-		sort.Sort(rotations)
-		oncallMut.Unlock()
-		if p.name == "" {
-			res.Text = fmt.Sprintf("Success! New team %s registered", p.team)
-			return res
-		} else {
-			res.Text = fmt.Sprintf("Success! New team %s registered, with manager <@%s>", p.team, p.name)
-			userAddManagerFlag(ctx, p.id)
-			return res
+	change := func(r []RotationProperty) ([]RotationProperty, string, string) {
+		if len(r) < p.from || len(r) < p.to {
+			return nil, "", fmt.Sprintf("Sorry, move could not be completed! Check _from_ and _to_ %s", humanErrorEmoji)
 		}
+		u := r[p.from-1]
+		r = append(r[:p.from-1], r[p.from:]...)
+		r = append(r[:p.to-1], append([]RotationProperty{u}, r[p.to-1:]...)...)
+		return r, fmt.Sprintf("moved <@%s> from position %d to %d", u.Name, p.from, p.to), ""
+	}
+	if confirmReorder && ctx.Value(ctxKeyConfirmed) == nil {
+		return previewRotations(ctx, p.team, fmt.Sprintf("move %s %d %d", p.team, p.from, p.to), change)
 	}
+	return updateRotations(ctx, "move", p.team, p.by, change)
+} // }}}
 
-	// The row already exists, do we need to add this manager?
-	if p.name == "" {
-		res.Text = fmt.Sprintf("Team %s is already registered %s", p.team, humanErrorEmoji)
-		return res
+// func note {{{
+
+// note {team} {text}
+//
+// Set text shown with the {team} on-call list, ie. runbook links or escalation
+// instructions.
+func note(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opNote)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "note")}
 	}
 
-	// Let's check and add the manager now.
+	res := slackResponse{}
 	oncallMut.Lock()
-	defer oncallMut.Unlock()
-	for _, m := range r.Managers {
-		if m.Id == p.id {
-			res.Text = fmt.Sprintf("Sorry, <@%s> is already a manager of %s %s", p.name, p.team, humanErrorEmoji)
-			return res
-		}
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
 	}
-	currentTime := r.Updated
-	currentRequestor := r.UpdatedBy
-	r.Managers = append(r.Managers, ManagerProperty{Name: p.name, Id: p.id})
-	r.Updated = time.Now()
-	r.UpdatedBy = p.by.name
-	if err := saveState(ctx, r); err != nil {
-		log.Warningf(ctx, "(register) error saving state - %s", err)
-		// Failed saving in storage, revert the change so next time this will again be a new change.
-		r.Updated = currentTime
-		r.UpdatedBy = currentRequestor
-		r.Managers = r.Managers[:(len(r.Managers) - 1)]
+	previous := current.Notes
+	current.Notes = p.text
+	if err := resetState(ctx, current); err != nil {
+		log.Warningf(ctx, "(note) error saving state - %s", err)
+		current.Notes = previous
+		oncallMut.Unlock()
 		res.Text = errorExternal
 		return res
 	}
-	res.Text = fmt.Sprintf("Success! <@%s> added as a manager of team %s", p.name, p.team)
-	userAddManagerFlag(ctx, p.id)
+	if p.text == "" {
+		recordHistory(ctx, p.team, "note", p.by.name, "cleared note", current.Rotations)
+		res.Text = fmt.Sprintf("Success! Note of %s cleared", p.team)
+	} else {
+		recordHistory(ctx, p.team, "note", p.by.name, "set note: "+p.text, current.Rotations)
+		res.Text = fmt.Sprintf("Success! Note of %s updated\nNew list:", p.team)
+	}
+	oncallMut.Unlock()
+
+	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
 	return res
 } // }}}
 
-// func unregister {{{
+// func describe {{{
 
-// unregister {team} {@slack_username}
+// describe {team} {text}
 //
-// If @slack_username is defined, remove the manager from the team.
-// If @slack_username is not defined, remove the team from managed team list.
-func unregister(ctx context.Context, params interface{}) slackResponse {
-	p, ok := params.(opUnregister)
-	if !ok || p.team == "" || (p.name != "" && p.id == "") {
-		return slackResponse{Text: help(ctx, "unregister")}
+// Set what the {team} covers, shown as the title of its on-call list so people
+// browsing teams know which one to reach.
+func describe(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opDescribe)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "describe")}
 	}
 
 	res := slackResponse{}
-	// Let's check if we have this team.
-	r := getCurrentRotation(p.team)
-	if r == nil {
-		res.Text = fmt.Sprintf("Team %s is not registered in oncall command %s:", p.team, humanErrorEmoji)
+	oncallMut.Lock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
 		return res
 	}
-
-	// If manager parameter value is not defined, delete the team itself.
-	oncallMut.Lock()
-	defer oncallMut.Unlock()
-	if p.name == "" {
-		for i := 0; i < len(rotations); i++ {
-			if rotations[i].Team == p.team {
-				// This is the one to remove, delete from state first.
-				// Get list of managers of the team.
-				var managers = make([]string, len(rotations[i].Managers))
-				for i, m := range rotations[i].Managers {
-					managers[i] = m.Id
-				}
-				if err := deleteState(ctx, rotations[i].Key); err != nil {
-					log.Warningf(ctx, "(unregister) error deleting state - %s", err)
-					res.Text = errorExternal
-					return res
-				}
-				// Deleted from state, let's delete from memory and return.
-				rotations = append(rotations[:i], rotations[i+1:]...)
-				res.Text = fmt.Sprintf("Success! Team %s removed from oncall command", p.team)
-				// Now remove "manager" flag from those users.
-				for _, i := range managers {
-					userSubManagerFlag(ctx, i)
-				}
-				return res
-			}
-		}
-		res.Text = fmt.Sprintf("Team %s is already unregistered %s", p.team, humanErrorEmoji)
+	previous := current.Description
+	current.Description = p.text
+	if err := resetState(ctx, current); err != nil {
+		log.Warningf(ctx, "(describe) error saving state - %s", err)
+		current.Description = previous
+		oncallMut.Unlock()
+		res.Text = errorExternal
 		return res
 	}
+	if p.text == "" {
+		recordHistory(ctx, p.team, "describe", p.by.name, "cleared description", current.Rotations)
+		res.Text = fmt.Sprintf("Success! Description of %s cleared", p.team)
+	} else {
+		recordHistory(ctx, p.team, "describe", p.by.name, "set description: "+p.text, current.Rotations)
+		res.Text = fmt.Sprintf("Success! Description of %s updated\nNew list:", p.team)
+	}
+	oncallMut.Unlock()
 
-	// Let's check if we have this manager.
-	for i := 0; i < len(r.Managers); i++ {
-		if r.Managers[i].Id == p.id {
-			// Demote this person.
-			r.Managers = append(r.Managers[:i], r.Managers[i+1:]...)
-			updated := r.Updated
-			updatedBy := r.UpdatedBy
+	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
+	return res
+} // }}}
+
+// func override {{{
+
+// override {team} {@slackusername} {until}
+//
+// Let someone cover primary on-call of the {team} until the time, without changing
+// the list. The override simply stops applying after the time.
+func override(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opOverride)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "override")}
+	}
+
+	res := slackResponse{}
+	// Make sure the requested user exists.
+	if p.id != "" {
+		u, err := getSlackUserDetail(ctx, p.id, false)
+		if err != nil {
+			log.Warningf(ctx, "(override) error getting user %s - %s", p.name, err)
+			res.Text = errorExternal
+			return res
+		}
+		if u == nil {
+			res.Text = fmt.Sprintf("Sorry! <@%s> doesn't exist in Slack %s", p.name, humanErrorEmoji)
+			return res
+		}
+	}
+
+	oncallMut.Lock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	_, active := activeOverride(current, time.Now())
+	_, upcoming := upcomingOverride(current, time.Now())
+	if p.id == "" && !active && !upcoming {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, %s has no override %s", p.team, humanErrorEmoji)
+		return res
+	}
+	previous := *current
+	current.OverrideName = p.name
+	current.OverrideId = p.id
+	current.OverrideStart = p.start
+	current.OverrideUntil = p.until
+	current.OverrideBy = p.by.name
+	if err := resetState(ctx, current); err != nil {
+		log.Warningf(ctx, "(override) error saving state - %s", err)
+		*current = previous
+		oncallMut.Unlock()
+		res.Text = errorExternal
+		return res
+	}
+	if p.id == "" {
+		recordHistory(ctx, p.team, "override", p.by.name, fmt.Sprintf("ended override by <@%s>", previous.OverrideName), current.Rotations)
+		res.Text = fmt.Sprintf("Success! Override of %s ended", p.team)
+	} else {
+		detail := fmt.Sprintf("<@%s> covers primary until %s", p.name, p.until.In(timezone).Format(dateFormat))
+		if !p.start.IsZero() {
+			detail = fmt.Sprintf("<@%s> covers primary %s", p.name, describeRange(p.start, p.until, timezone))
+		}
+		if active && !p.start.IsZero() {
+			detail += fmt.Sprintf(", replacing the override by <@%s>", previous.OverrideName)
+		}
+		recordHistory(ctx, p.team, "override", p.by.name, detail, current.Rotations)
+		res.Text = fmt.Sprintf("Success! %s for %s", detail, p.team)
+		if warning := overrideStreak(current, p.start, p.until); warning != "" {
+			res.Text += fmt.Sprintf("\nHeads up! %s %s", warning, humanErrorEmoji)
+		}
+		res.Text += "\nNew list:"
+	}
+	oncallMut.Unlock()
+
+	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
+	return res
+} // }}}
+
+// func away {{{
+
+// away {team} {@slackusername} {until}
+//
+// Mark a member of the {team} rotation away until the time, or back. Members away
+// keep their position, but are skipped when rotating, paging and picking who's
+// primary/secondary.
+func away(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opAway)
+	if !ok || p.team == "" || p.id == "" {
+		return slackResponse{Text: help(ctx, "away")}
+	}
+
+	return updateRotations(ctx, "away", p.team, p.by, func(r []RotationProperty) ([]RotationProperty, string, string) {
+		idx := -1
+		for i, u := range r {
+			if u.Id == p.id {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, "", fmt.Sprintf("Sorry, <@%s> is not in the on-call list for %s %s", p.name, p.team, humanErrorEmoji)
+		}
+		if p.until.IsZero() {
+			if !memberAway(r[idx], time.Now()) {
+				return nil, "", fmt.Sprintf("Sorry, <@%s> is not away %s", p.name, humanErrorEmoji)
+			}
+			r[idx].AwayUntil = time.Time{}
+			return r, fmt.Sprintf("marked <@%s> back", r[idx].Name), ""
+		}
+		r[idx].AwayUntil = p.until
+		return r, fmt.Sprintf("marked <@%s> away until %s", r[idx].Name, p.until.In(timezone).Format(dateFormat)), ""
+	})
+} // }}}
+
+// func fallback {{{
+
+// fallback {team} {@backup} for {@slackusername}
+//
+// Set who covers a member of the {team} rotation while the member is away, and is
+// paged before the managers when the member isn't reachable.
+func fallback(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opFallback)
+	if !ok || p.team == "" || p.id == "" {
+		return slackResponse{Text: help(ctx, "fallback")}
+	}
+
+	// Make sure the fallback exists.
+	if p.fallbackId != "" {
+		u, err := getSlackUserDetail(ctx, p.fallbackId, false)
+		if err != nil {
+			log.Warningf(ctx, "(fallback) error getting user %s - %s", p.fallbackName, err)
+			return slackResponse{Text: errorExternal}
+		}
+		if u == nil {
+			return slackResponse{Text: fmt.Sprintf("Sorry! <@%s> doesn't exist in Slack %s", p.fallbackName, humanErrorEmoji)}
+		}
+	}
+
+	return updateRotations(ctx, "fallback", p.team, p.by, func(r []RotationProperty) ([]RotationProperty, string, string) {
+		idx := -1
+		for i, u := range r {
+			if u.Id == p.id {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, "", fmt.Sprintf("Sorry, <@%s> is not in the on-call list for %s %s", p.name, p.team, humanErrorEmoji)
+		}
+		if p.fallbackId == "" {
+			if r[idx].FallbackId == "" {
+				return nil, "", fmt.Sprintf("Sorry, <@%s> has no fallback %s", p.name, humanErrorEmoji)
+			}
+			r[idx].FallbackName, r[idx].FallbackId = "", ""
+			return r, fmt.Sprintf("cleared fallback of <@%s>", r[idx].Name), ""
+		}
+		r[idx].FallbackName, r[idx].FallbackId = p.fallbackName, p.fallbackId
+		return r, fmt.Sprintf("set <@%s> as fallback of <@%s>", p.fallbackName, r[idx].Name), ""
+	})
+} // }}}
+
+// func label {{{
+
+// label {team} {@slackusername|position} {label}
+//
+// Change label of a member of the {team} rotation, keeping the position.
+func label(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opLabel)
+	if !ok || p.team == "" || (p.id == "" && p.position == 0) {
+		return slackResponse{Text: help(ctx, "label")}
+	}
+
+	return updateRotations(ctx, "label", p.team, p.by, func(r []RotationProperty) ([]RotationProperty, string, string) {
+		idx := p.position - 1
+		if p.position == 0 {
+			for i, u := range r {
+				if u.Id == p.id {
+					idx = i
+					break
+				}
+			}
+		}
+		if idx < 0 || idx >= len(r) {
+			return nil, "", fmt.Sprintf("Sorry, label could not be changed! Check _@slackusername_ or _position_ %s", humanErrorEmoji)
+		}
+		r[idx].Label = p.label
+		if p.label == "" {
+			return r, fmt.Sprintf("cleared label of <@%s>", r[idx].Name), ""
+		}
+		return r, fmt.Sprintf("changed label of <@%s> to \"%s\"", r[idx].Name, p.label), ""
+	})
+} // }}}
+
+// func shuffle {{{
+
+// shuffle {team}
+//
+// Put the {team} rotation in random order, ie. at the start of a new quarter so
+// the same person isn't always the first primary.
+func shuffle(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opShuffle)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "shuffle")}
+	}
+
+	return updateRotations(ctx, "shuffle", p.team, p.by, func(r []RotationProperty) ([]RotationProperty, string, string) {
+		if len(r) < 2 {
+			return nil, "", fmt.Sprintf("Sorry, team %s needs at least 2 people in the on-call list to shuffle %s", p.team, humanErrorEmoji)
+		}
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		shuffled := make([]RotationProperty, len(r))
+		for i, j := range rnd.Perm(len(r)) {
+			shuffled[i] = r[j]
+		}
+		return shuffled, "shuffled order", ""
+	})
+} // }}}
+
+// func reverse {{{
+
+// reverse {team}
+//
+// Reverse the {team} rotation, for lists planned back to front.
+func reverse(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opShuffle)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "reverse")}
+	}
+
+	return updateRotations(ctx, "reverse", p.team, p.by, func(r []RotationProperty) ([]RotationProperty, string, string) {
+		if len(r) < 2 {
+			return nil, "", fmt.Sprintf("Sorry, team %s needs at least 2 people in the on-call list to reverse %s", p.team, humanErrorEmoji)
+		}
+		reversed := make([]RotationProperty, len(r))
+		for i, u := range r {
+			reversed[len(r)-1-i] = u
+		}
+		return reversed, "reversed order", ""
+	})
+} // }}}
+
+// func copyRotation {{{
+
+// copy {source_team} {team} {managers}
+//
+// Replace the on-call list of the team with the one of the source team, for teams
+// staffed by the same people. Managers of the source team are added to the team
+// if requested.
+func copyRotation(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opCopy)
+	if !ok || p.source == "" || p.team == "" {
+		return slackResponse{Text: help(ctx, "copy")}
+	}
+
+	res := slackResponse{}
+	oncallMut.Lock()
+	source := findRotation(p.source)
+	current := findRotation(p.team)
+	if source == nil || current == nil {
+		if source == nil {
+			res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.source, humanErrorEmoji)
+		} else {
+			res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		}
+		oncallMut.Unlock()
+		return res
+	}
+
+	// Keep the current state so this change can be undone.
+	op := "copy"
+	if p.managers {
+		op = "copy managers"
+	}
+	previous := copyState(current)
+	var added []string
+	current.Rotations = append([]RotationProperty(nil), source.Rotations...)
+	if p.managers {
+		current.Managers = append([]ManagerProperty(nil), current.Managers...)
+		for _, m := range source.Managers {
+			if !hasManager(current.Managers, m.Id) {
+				current.Managers = append(current.Managers, m)
+				added = append(added, m.Id)
+			}
+		}
+	}
+	current.Updated = time.Now()
+	current.UpdatedBy = p.by.name
+	if err := replaceState(ctx, op, p.by.name, &previous, current); err != nil {
+		log.Warningf(ctx, "(copy) error saving state - %s", err)
+		*current = previous
+		res.Text = errorExternal
+		oncallMut.Unlock()
+		return res
+	}
+	detail := fmt.Sprintf("copied on-call list from %s", p.source)
+	if len(added) > 0 {
+		detail += fmt.Sprintf(" and added %d manager(s)", len(added))
+	}
+	recordHistory(ctx, p.team, "copy", p.by.name, detail, current.Rotations)
+	oncallMut.Unlock()
+
+	// New managers need the manager flag.
+	for _, id := range added {
+		userAddManagerFlag(ctx, id)
+	}
+
+	res.Text = fmt.Sprintf("Success! %s%s in the on-call list for %s\nNew list:", strings.ToUpper(detail[:1]), detail[1:], p.team)
+	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
+	return res
+} // }}}
+
+// func cadence {{{
+
+// cadence {team} {daily|weekly|biweekly} {date} {time}
+// schedule {team} {daily|weekly|biweekly} {day} {time} {timezone}
+//
+// Set how often the primary on-call of the team hands off to the next person.
+// The primary is then computed from the time elapsed since the first handoff,
+// rather than whoever happens to be on position 1.
+// Whoever is the primary right now stays the primary until the next handoff.
+// "schedule" sets the next handoff on the day and time instead of the first one,
+// and handoffs happen in the team's timezone.
+func cadence(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opCadence)
+	if !ok || p.team == "" {
+		if p.schedule {
+			return slackResponse{Text: help(ctx, "schedule")}
+		}
+		return slackResponse{Text: help(ctx, "cadence")}
+	}
+	if p.preview > 0 {
+		return schedulePreview(ctx, p)
+	}
+
+	res := slackResponse{}
+	current := getCurrentRotation(p.team)
+	if current == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+
+	oncallMut.Lock()
+	previous := *current
+	// Fix the current order first, so the current primary doesn't change by this.
+	if offset := rotationOffset(current, time.Now()); offset > 0 {
+		current.Rotations = advanceRotation(current.Rotations, offset)
+	}
+	now := time.Now()
+	if p.timezone != "" {
+		current.Timezone = p.timezone
+	}
+	switch {
+	case p.cadence == "":
+	case p.schedule:
+		p.anchor = scheduleAnchor(p.cadence, p.weekday, p.hour, p.minute, teamLocation(current), now)
+	default:
+		// Date and time were given in the configured timezone, take them in the team's.
+		a := p.anchor
+		p.anchor = time.Date(a.Year(), a.Month(), a.Day(), a.Hour(), a.Minute(), 0, 0, teamLocation(current))
+	}
+	current.Cadence = p.cadence
+	current.Anchor = p.anchor
+	current.Advanced = 0
+	current.Reminded = 0
+	if p.cadence != "" {
+		current.Advanced = cadenceAdvances(current, time.Now())
+	}
+	current.Updated = time.Now()
+	current.UpdatedBy = p.by.name
+	// Kept for "undo" like changes of the list, the order may have been fixed above.
+	if err := replaceState(ctx, "cadence", p.by.name, &previous, current); err != nil {
+		log.Warningf(ctx, "(cadence) error saving state - %s", err)
+		*current = previous
+		res.Text = errorExternal
+		oncallMut.Unlock()
+		return res
+	}
+	if p.cadence == "" {
+		res.Text = fmt.Sprintf("Success! %s will no longer rotate automatically", p.team)
+	} else {
+		res.Text = fmt.Sprintf("Success! %s will rotate %s, next handoff %s", p.team, p.cadence, handoffTime(current, current.Advanced+1).Format(dateFormat))
+	}
+	recordHistory(ctx, p.team, "cadence", p.by.name, describeCadence(current), current.Rotations)
+	oncallMut.Unlock()
+
+	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
+	return res
+} // }}}
+
+// func schedulePreview {{{
+
+// schedule {team} preview {n}
+//
+// Show who will be primary on-call of the team after each of the next n handoffs,
+// with the current order, cadence, aways, overrides and assignments.
+func schedulePreview(ctx context.Context, p opCadence) slackResponse {
+	res := slackResponse{}
+	oncallMut.RLock()
+	defer oncallMut.RUnlock()
+	r := findRotation(p.team)
+	if r == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if r.Cadence == "" {
+		res.Text = fmt.Sprintf("Sorry, %s rotates manually, set its `schedule` first %s", p.team, humanErrorEmoji)
+		return res
+	}
+
+	res.Text = fmt.Sprintf("Next %d handoffs of %s (%s):", p.preview, p.team, describeCadence(r))
+	res.Attachments = []attachment{{Color: defaultColor, Text: strings.Join(upcomingHandoffs(r, time.Now(), p.preview), "\n")}}
+	return res
+} // }}}
+
+// func upcomingHandoffs {{{
+
+// Return a line per the next n handoffs of the team after the time, with who
+// will be primary on-call until the following one.
+// Caller must hold oncallMut.
+func upcomingHandoffs(r *oncallProperty, t time.Time, n int) (str []string) {
+	first := cadenceAdvances(r, t) + 1
+	for k := first; k < first+n; k++ {
+		start, end := handoffTime(r, k), handoffTime(r, k+1)
+		str = append(str, start.Format("Mon "+dateFormat)+" - "+describePrimaryPeriod(r, start, end))
+	}
+	return
+} // }}}
+
+// func describePrimaryPeriod {{{
+
+// Human readable primary on-call of the team from the start, along with overrides
+// ending and assignments starting before the end.
+// Caller must hold oncallMut.
+func describePrimaryPeriod(r *oncallProperty, start, end time.Time) string {
+	var s string
+	loc := teamLocation(r)
+	if u, ok := primaryCover(r, start); ok {
+		s = fmt.Sprintf("<@%s|%s> (%s)", u.Id, u.Name, u.Label)
+	} else if members := onDutyMembers(r, start); len(members) > 0 {
+		m := r.Rotations[members[0]]
+		u, _ := memberOnDuty(m, start)
+		s = fmt.Sprintf("<@%s|%s>", u.Id, u.Name)
+		if u.Id != m.Id {
+			s += fmt.Sprintf(" (fallback for <@%s|%s>)", m.Id, m.Name)
+		}
+	} else {
+		s = "_nobody_"
+	}
+	if r.OverrideId != "" && start.Before(r.OverrideStart) && r.OverrideStart.Before(end) {
+		s += fmt.Sprintf(", <@%s|%s> overrides from %s", r.OverrideId, r.OverrideName, r.OverrideStart.In(loc).Format(dateFormat))
+	}
+	if r.OverrideId != "" && start.Before(r.OverrideUntil) && r.OverrideUntil.Before(end) {
+		s += fmt.Sprintf(", override ends %s", r.OverrideUntil.In(loc).Format(dateFormat))
+	}
+	for _, a := range assignments[r.Team] {
+		if start.Before(a.Start) && a.Start.Before(end) {
+			s += fmt.Sprintf(", <@%s|%s> assigned from %s", a.Id, a.Name, a.Start.In(loc).Format(dateFormat))
+		}
+	}
+	return s
+} // }}}
+
+// func describeCadence {{{
+
+// Human readable cadence of the team.
+// Caller must hold oncallMut.
+func describeCadence(r *oncallProperty) string {
+	if r.Cadence == "" {
+		return "rotates manually"
+	}
+	str := fmt.Sprintf("rotates %s at %s", r.Cadence, r.Anchor.In(teamLocation(r)).Format("Mon 15:04"))
+	if r.Timezone != "" {
+		str += " " + r.Timezone
+	}
+	if r.Paused {
+		str += " (paused, handoff declined)"
+	}
+	return str
+} // }}}
+
+// func updateRotations {{{
+
+// Replace the on-call list of the team with the one returned by "change".
+//
+// "change" gets a copy of the current list it's free to modify, and returns the new
+// list with a short description of the change, or an error message for the user.
+// The previous state is kept for "undo" and the change is recorded in history.
+func updateRotations(ctx context.Context, op, team string, by opRequestor, change func([]RotationProperty) ([]RotationProperty, string, string)) slackResponse {
+	res := slackResponse{}
+	current := getCurrentRotation(team)
+	if current == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", team, humanErrorEmoji)
+		return res
+	}
+	limitHistory, err := loadLimitHistory(ctx, op, team, time.Now())
+	if err != nil {
+		log.Warningf(ctx, "(%s) error loading history - %s", op, err)
+		res.Text = errorExternal
+		return res
+	}
+
+	oncallMut.Lock()
+	newRotation, detail, errstr := change(append([]RotationProperty(nil), current.Rotations...))
+	if errstr == "" {
+		errstr = checkLimits(op, current, newRotation, limitHistory)
+	}
+	if errstr != "" {
+		res.Text = errstr
+		oncallMut.Unlock()
+		return res
+	}
+
+	// Keep the current state so this change can be undone.
+	previous := copyState(current)
+
+	currentRotation := current.Rotations
+	currentUpdated := current.Updated
+	currentUpdatedBy := current.UpdatedBy
+	current.Rotations = newRotation
+	current.Updated = time.Now()
+	current.UpdatedBy = by.name
+	if err := replaceState(ctx, op, by.name, &previous, current); err != nil {
+		log.Warningf(ctx, "(%s) error saving state - %s", op, err)
+		current.Rotations = currentRotation
+		current.Updated = currentUpdated
+		current.UpdatedBy = currentUpdatedBy
+		res.Text = errorExternal
+		oncallMut.Unlock()
+		return res
+	}
+	recordHistory(ctx, team, op, by.name, detail, current.Rotations)
+	oncallMut.Unlock()
+
+	res.Text = fmt.Sprintf("Success! %s%s in the on-call list for %s\nNew list:", strings.ToUpper(detail[:1]), detail[1:], team)
+	res.Attachments = []attachment{generateOncallList(ctx, team)}
+	return res
+} // }}}
+
+// func previewRotations {{{
+
+// Reply with the on-call list of the team before and after the change, with buttons
+// to confirm or cancel it. Confirming re-runs the command from the interactive handler.
+func previewRotations(ctx context.Context, team, cmd string, change func([]RotationProperty) ([]RotationProperty, string, string)) slackResponse {
+	res := slackResponse{Type: "ephemeral"}
+	oncallMut.RLock()
+	current := findRotation(team)
+	if current == nil {
+		oncallMut.RUnlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", team, humanErrorEmoji)
+		return res
+	}
+	before := append([]RotationProperty(nil), current.Rotations...)
+	oncallMut.RUnlock()
+
+	op := strings.Fields(cmd)[0]
+	after, detail, errstr := change(append([]RotationProperty(nil), before...))
+	if errstr == "" {
+		limitHistory, err := loadLimitHistory(ctx, op, team, time.Now())
+		if err != nil {
+			log.Warningf(ctx, "(%s) error loading history - %s", op, err)
+			res.Text = errorExternal
+			return res
+		}
+		oncallMut.RLock()
+		errstr = checkLimits(op, current, after, limitHistory)
+		oncallMut.RUnlock()
+	}
+	if errstr != "" {
+		res.Text = errstr
+		return res
+	}
+
+	listNames := func(r []RotationProperty) string {
+		var str []string
+		for idx, u := range r {
+			str = append(str, fmt.Sprintf("%d: <@%s|%s>", idx+1, u.Id, u.Name))
+		}
+		return strings.Join(str, "\n")
+	}
+	res.Text = fmt.Sprintf("Please confirm - %s in the on-call list for %s", detail, team)
+	res.Attachments = []attachment{
+		{Color: defaultColor, Title: "Before", Text: listNames(before)},
+		{
+			Color:      defaultColor,
+			Title:      "After",
+			Text:       listNames(after),
+			CallbackId: callbackConfirm,
+			Actions: []attachmentAction{
+				{Name: "confirm", Text: "Confirm", Type: "button", Value: cmd, Style: "primary"},
+				{Name: "cancel", Text: "Cancel", Type: "button", Value: cmd},
+			},
+		},
+	}
+	return res
+} // }}}
+
+// func undo {{{
+
+// undo {team}
+//
+// Revert the last change made to the team by add, remove, swap, rotate, flush,
+// register, unregister, cadence, handoff or cover-needed, bringing back the whole
+// team as it was. If the team itself was unregistered, it's registered again.
+// Only the last change can be reverted, and an undo can't be undone. Changes
+// which can't be reverted, ie. scheduled handoffs or settings, drop it.
+func undo(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opUndo)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "undo")}
+	}
+
+	res := slackResponse{}
+	snapshot, err := loadSnapshot(ctx, p.team)
+	if err != nil {
+		log.Warningf(ctx, "(undo) error loading snapshot - %s", err)
+		res.Text = errorExternal
+		return res
+	}
+	if snapshot == nil {
+		res.Text = fmt.Sprintf("Sorry, there is nothing to undo for %s %s", p.team, humanErrorEmoji)
+		return res
+	}
+	// Manager changes can only be reverted by someone who could've made them.
+	if (snapshot.Operation == "register" || snapshot.Operation == "unregister" || snapshot.Operation == "copy managers" || snapshot.Operation == "import" || snapshot.Operation == "flush-managers") && !userIsExempt(ctx, p.by.id) {
+		log.Warningf(ctx, "(undo) user %s has no perm to revert %s", p.by.name, snapshot.Operation)
+		res.Text = errorNoPerm
+		return res
+	}
+
+	oncallMut.Lock()
+	current := findRotation(p.team)
+	created := current == nil
+	if created {
+		// The team was unregistered, bring it back.
+		current = &oncallProperty{Team: p.team}
+	}
+	previous := *current
+	restored := snapshot.State
+	if restored.Team == "" {
+		// Taken before the whole team was kept, only the lists come back.
+		restored = copyState(current)
+		restored.Managers = snapshot.Managers
+		restored.Rotations = snapshot.Rotations
+	}
+	restored.Team, restored.Key = p.team, current.Key
+	if !created {
+		// Only track what the cron told already, kept as they are.
+		restored.Reminded, restored.Gap, restored.Coverage = current.Reminded, current.Gap, current.Coverage
+	}
+	restored.Updated = time.Now()
+	restored.UpdatedBy = p.by.name
+	*current = restored
+	// Snapshot is consumed along with it, so running undo twice won't flip back and forth.
+	if err = resetState(ctx, current); err != nil {
+		log.Warningf(ctx, "(undo) error saving state - %s", err)
+		*current = previous
+		res.Text = errorExternal
+		oncallMut.Unlock()
+		return res
+	}
+	if created {
+		rotations = append(rotations, current)
+		sort.Sort(rotations)
+	}
+	recordHistory(ctx, p.team, "undo", p.by.name, fmt.Sprintf("reverted `%s` by <@%s>", snapshot.Operation, snapshot.TakenBy), current.Rotations)
+	oncallMut.Unlock()
+
+	// Fix manager flags of managers who came back or went away.
+	for _, m := range restored.Managers {
+		if !hasManager(previous.Managers, m.Id) {
+			userAddManagerFlag(ctx, m.Id)
+		}
+	}
+	for _, m := range previous.Managers {
+		if !hasManager(restored.Managers, m.Id) {
+			userSubManagerFlag(ctx, m.Id)
+		}
+	}
+
+	res.Text = fmt.Sprintf("Success! Reverted `%s` made by <@%s> on %s\nNew list:", snapshot.Operation, snapshot.TakenBy, snapshot.Taken.In(timezone).Format(dateFormat))
+	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
+	return res
+} // }}}
+
+// func hasManager {{{
+
+// Check if the user is in the manager list.
+func hasManager(managers []ManagerProperty, id string) bool {
+	for _, m := range managers {
+		if m.Id == id {
+			return true
+		}
+	}
+	return false
+} // }}}
+
+// func register {{{
+
+// register {team} {@slack_username}
+//
+// Register a new team to be mamaged by this oncall process.
+// If "@slack_username" is defined, set the person as the team manager.
+// This operation can also be used to assign an additional manager to existing team.
+func register(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opRegister)
+	if !ok || p.team == "" || (p.name != "" && p.id == "") {
+		return slackResponse{Text: help(ctx, "register")}
+	}
+
+	res := slackResponse{}
+	// If the manager is provided, make sure the person exists.
+	if p.name != "" {
+		u, err := getSlackUserDetail(ctx, p.id, false)
+		if err != nil {
+			log.Warningf(ctx, "(register) error getting user %s - %s", p.name, err)
+			res.Text = errorExternal
+			return res
+		}
+		if u == nil {
+			res.Text = fmt.Sprintf("Sorry! <@%s> doesn't exist in Slack %s", p.name, humanErrorEmoji)
+			return res
+		}
+	}
+
+	// Check if the team already exists.
+	r := getCurrentRotation(p.team)
+	if r == nil {
+		r = &oncallProperty{Team: p.team, Managers: make([]ManagerProperty, 0)}
+		if p.name != "" {
+			r.Managers = append(r.Managers, ManagerProperty{Name: p.name, Id: p.id})
+		}
+		r.Updated = time.Now()
+		r.UpdatedBy = p.by.name
+		// Save the state first.
+		if err := resetState(ctx, r); err != nil {
+			log.Warningf(ctx, "(register) error saving state - %s", err)
+			res.Text = errorExternal
+			return res
+		}
+		// Saved in external storage, let's save in memory now.
+		oncallMut.Lock()
+		rotations = append(rotations, r)
+		sort.Sort(rotations)
+		oncallMut.Unlock()
+		recordHistory(ctx, p.team, "register", p.by.name, "registered the team", nil)
+		if p.name == "" {
+			res.Text = fmt.Sprintf("Success! New team %s registered", p.team)
+			return res
+		} else {
+			res.Text = fmt.Sprintf("Success! New team %s registered, with manager <@%s>", p.team, p.name)
+			userAddManagerFlag(ctx, p.id)
+			return res
+		}
+	}
+
+	// The row already exists, do we need to add this manager?
+	if p.name == "" {
+		res.Text = fmt.Sprintf("Team %s is already registered %s", p.team, humanErrorEmoji)
+		return res
+	}
+
+	// Let's check and add the manager now.
+	oncallMut.Lock()
+	defer oncallMut.Unlock()
+	for _, m := range r.Managers {
+		if m.Id == p.id {
+			res.Text = fmt.Sprintf("Sorry, <@%s> is already a manager of %s %s", p.name, p.team, humanErrorEmoji)
+			return res
+		}
+	}
+	// Keep the current state so this change can be undone.
+	previous := copyState(r)
+	r.Managers = append(r.Managers, ManagerProperty{Name: p.name, Id: p.id})
+	r.Updated = time.Now()
+	r.UpdatedBy = p.by.name
+	if err := replaceState(ctx, "register", p.by.name, &previous, r); err != nil {
+		log.Warningf(ctx, "(register) error saving state - %s", err)
+		// Failed saving in storage, revert the change so next time this will again be a new change.
+		*r = previous
+		res.Text = errorExternal
+		return res
+	}
+	recordHistory(ctx, p.team, "register", p.by.name, fmt.Sprintf("added manager <@%s>", p.name), r.Rotations)
+	res.Text = fmt.Sprintf("Success! <@%s> added as a manager of team %s", p.name, p.team)
+	userAddManagerFlag(ctx, p.id)
+	return res
+} // }}}
+
+// func promote {{{
+
+// promote {team} {@slack_username}
+// demote {team} {@slack_username}
+//
+// Make a member of the on-call list a manager of the team, or remove a manager,
+// without asking a superuser to run register/unregister.
+func promote(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opPromote)
+	if !ok || p.team == "" || p.id == "" {
+		return slackResponse{Text: help(ctx, "promote")}
+	}
+	op := "promote"
+	if p.demote {
+		op = "demote"
+	}
+
+	res := slackResponse{}
+	oncallMut.Lock()
+	r := findRotation(p.team)
+	if r == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	managers := make([]ManagerProperty, 0, len(r.Managers)+1)
+	for _, m := range r.Managers {
+		if m.Id != p.id {
+			managers = append(managers, m)
+		}
+	}
+	if p.demote {
+		if len(managers) == len(r.Managers) {
+			oncallMut.Unlock()
+			res.Text = fmt.Sprintf("Sorry, <@%s> is not a manager of %s %s", p.name, p.team, humanErrorEmoji)
+			return res
+		}
+	} else {
+		if len(managers) != len(r.Managers) {
+			oncallMut.Unlock()
+			res.Text = fmt.Sprintf("Sorry, <@%s> is already a manager of %s %s", p.name, p.team, humanErrorEmoji)
+			return res
+		}
+		member := false
+		for _, u := range r.Rotations {
+			if u.Id == p.id {
+				member = true
+				break
+			}
+		}
+		if !member {
+			oncallMut.Unlock()
+			res.Text = fmt.Sprintf("Sorry, <@%s> is not in the on-call list for %s %s", p.name, p.team, humanErrorEmoji)
+			return res
+		}
+		managers = append(managers, ManagerProperty{Name: p.name, Id: p.id})
+	}
+
+	// Keep the current state so this change can be undone.
+	previous := copyState(r)
+	r.Managers = managers
+	r.Updated = time.Now()
+	r.UpdatedBy = p.by.name
+	if err := replaceState(ctx, op, p.by.name, &previous, r); err != nil {
+		log.Warningf(ctx, "(%s) error saving state - %s", op, err)
+		*r = previous
+		oncallMut.Unlock()
+		res.Text = errorExternal
+		return res
+	}
+	if p.demote {
+		recordHistory(ctx, p.team, op, p.by.name, fmt.Sprintf("removed manager <@%s>", p.name), r.Rotations)
+		res.Text = fmt.Sprintf("Success! <@%s> is no longer a manager of team %s", p.name, p.team)
+	} else {
+		recordHistory(ctx, p.team, op, p.by.name, fmt.Sprintf("added manager <@%s>", p.name), r.Rotations)
+		res.Text = fmt.Sprintf("Success! <@%s> added as a manager of team %s", p.name, p.team)
+	}
+	oncallMut.Unlock()
+
+	if p.demote {
+		userSubManagerFlag(ctx, p.id)
+	} else {
+		userAddManagerFlag(ctx, p.id)
+	}
+	return res
+} // }}}
+
+// func unregister {{{
+
+// unregister {team} {@slack_username}
+//
+// If @slack_username is defined, remove the manager from the team.
+// If @slack_username is not defined, remove the team from managed team list.
+func unregister(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opUnregister)
+	if !ok || p.team == "" || (p.name != "" && p.id == "") {
+		return slackResponse{Text: help(ctx, "unregister")}
+	}
+
+	res := slackResponse{}
+	// Let's check if we have this team.
+	r := getCurrentRotation(p.team)
+	if r == nil {
+		res.Text = fmt.Sprintf("Team %s is not registered in oncall command %s:", p.team, humanErrorEmoji)
+		return res
+	}
+
+	// If manager parameter value is not defined, delete the team itself.
+	oncallMut.Lock()
+	defer oncallMut.Unlock()
+	if p.name == "" {
+		for i := 0; i < len(rotations); i++ {
+			if rotations[i].Team == p.team {
+				// This is the one to remove, delete from state first.
+				// Get list of managers of the team.
+				var managers = make([]string, len(rotations[i].Managers))
+				for i, m := range rotations[i].Managers {
+					managers[i] = m.Id
+				}
+				// Kept as it was so this change can be undone.
+				if err := removeState(ctx, "unregister", p.by.name, rotations[i]); err != nil {
+					log.Warningf(ctx, "(unregister) error deleting state - %s", err)
+					res.Text = errorExternal
+					return res
+				}
+				// Deleted from state, let's delete from memory and return.
+				removed := rotations[i]
+				rotations = append(rotations[:i], rotations[i+1:]...)
+				delete(assignments, p.team)
+				recordHistory(ctx, p.team, "unregister", p.by.name, "unregistered the team", nil)
+				res.Text = fmt.Sprintf("Success! Team %s removed from oncall command", p.team)
+				a := restoreAttachment(ctx, p.team, removed.Managers, removed.Rotations)
+				// Undo registers the team again as it was, but not these.
+				reports, assigned, planned, err := deleteTeamRecords(ctx, p.team)
+				if err != nil {
+					log.Warningf(ctx, "(unregister) error deleting records of the team - %s", err)
+				}
+				var lost []string
+				if reports > 0 {
+					lost = append(lost, fmt.Sprintf("%d scheduled report(s)", reports))
+				}
+				if assigned > 0 {
+					lost = append(lost, fmt.Sprintf("%d assignment(s)", assigned))
+				}
+				if planned {
+					lost = append(lost, "the planned change")
+				}
+				if lost != nil {
+					a.Text += fmt.Sprintf("\n%s of the team were deleted too, undo won't bring them back.", strings.Join(lost, ", "))
+				}
+				res.Attachments = []attachment{a}
+				// Now remove "manager" flag from those users.
+				for _, i := range managers {
+					userSubManagerFlag(ctx, i)
+				}
+				return res
+			}
+		}
+		res.Text = fmt.Sprintf("Team %s is already unregistered %s", p.team, humanErrorEmoji)
+		return res
+	}
+
+	// Let's check if we have this manager.
+	for i := 0; i < len(r.Managers); i++ {
+		if r.Managers[i].Id == p.id {
+			// Keep the current state so this change can be undone.
+			previous := copyState(r)
+			// Demote this person.
+			r.Managers = append(r.Managers[:i], r.Managers[i+1:]...)
 			r.Updated = time.Now()
 			r.UpdatedBy = p.by.name
-			if err := saveState(ctx, r); err != nil {
+			if err := replaceState(ctx, "unregister", p.by.name, &previous, r); err != nil {
 				log.Warningf(ctx, "(unregister) error saving state - %s", err)
 				// Failed saving the state, revert changes.
-				r.Managers = append(r.Managers, ManagerProperty{Name: p.name, Id: p.id})
-				r.Updated = updated
-				r.UpdatedBy = updatedBy
+				*r = previous
 				res.Text = errorExternal
 				return res
 			}
-			res.Text = fmt.Sprintf("Success! Manager <@%s> removed as a manager from team %s", p.name, p.team)
-			// Remove the manager flag from this person as well.
-			userSubManagerFlag(ctx, p.id)
+			recordHistory(ctx, p.team, "unregister", p.by.name, fmt.Sprintf("removed manager <@%s>", p.name), r.Rotations)
+			res.Text = fmt.Sprintf("Success! Manager <@%s> removed as a manager from team %s", p.name, p.team)
+			res.Attachments = []attachment{restoreAttachment(ctx, p.team, []ManagerProperty{{Name: p.name, Id: p.id}}, nil)}
+			// Remove the manager flag from this person as well.
+			userSubManagerFlag(ctx, p.id)
+			return res
+		}
+	}
+
+	res.Text = fmt.Sprintf("Sorry, <@%s> is not a manager of team %s %s", p.name, p.team, humanErrorEmoji)
+	return res
+} // }}}
+
+// func restoreAttachment {{{
+
+// Return an attachment showing what a destructive change removed, with a button
+// reverting the change by "undo". Undo brings back the whole team as it was,
+// settings included.
+func restoreAttachment(ctx context.Context, team string, managers []ManagerProperty, members []RotationProperty) attachment {
+	var str []string
+	if len(managers) > 0 {
+		var names []string
+		for _, m := range managers {
+			names = append(names, fmt.Sprintf("<@%s|%s>", m.Id, m.Name))
+		}
+		str = append(str, "Managers: "+strings.Join(names, ", "))
+	}
+	for i, u := range members {
+		s := fmt.Sprintf("%d. <@%s|%s>", i+1, u.Id, u.Name)
+		if u.Label != "" {
+			s += fmt.Sprintf(" (%s)", u.Label)
+		}
+		str = append(str, s)
+	}
+	if len(str) == 0 {
+		str = append(str, "Nothing")
+	}
+	cmd := "undo " + team
+	return attachment{
+		Title:      "Removed",
+		Text:       strings.Join(str, "\n"),
+		Color:      defaultColor,
+		Footer:     fmt.Sprintf("Changed by mistake? Run `%s %s` or click below to restore", commandName(ctx), cmd),
+		CallbackId: callbackRestore,
+		Actions:    []attachmentAction{{Name: "undo", Text: "Undo", Type: "button", Value: cmd}},
+	}
+} // }}}
+
+// func rename {{{
+
+// rename {team} {newname}
+//
+// Rename a team. Team name is the datastore key, so the entity is moved to a new
+// key rather than updated in place.
+func rename(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opRename)
+	if !ok || p.team == "" || p.name == "" {
+		return slackResponse{Text: help(ctx, "rename")}
+	}
+
+	res := slackResponse{}
+	oncallMut.Lock()
+	defer oncallMut.Unlock()
+	r := findRotation(p.team)
+	if r == nil {
+		res.Text = fmt.Sprintf("Team %s is not registered in oncall command %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if findRotation(p.name) != nil {
+		res.Text = fmt.Sprintf("Team %s already exists %s", p.name, humanErrorEmoji)
+		return res
+	}
+
+	renamed := *r
+	renamed.Team = p.name
+	renamed.Key = nil
+	renamed.Updated = time.Now()
+	renamed.UpdatedBy = p.by.name
+	if err := renameState(ctx, r, &renamed); err != nil {
+		log.Warningf(ctx, "(rename) error renaming state - %s", err)
+		res.Text = errorExternal
+		return res
+	}
+	*r = renamed
+	sort.Sort(rotations)
+	if a, ok := assignments[p.team]; ok {
+		assignments[p.name] = a
+		delete(assignments, p.team)
+		for _, e := range a {
+			e.Team = p.name
+		}
+	}
+	// The team itself is renamed at this point, failing to carry over its history,
+	// reports and assignments only loses them, so just log.
+	if err := renameTeamRecords(ctx, p.team, p.name); err != nil {
+		log.Warningf(ctx, "(rename) error renaming history, reports and assignments - %s", err)
+	}
+	recordHistory(ctx, p.name, "rename", p.by.name, fmt.Sprintf("renamed from %s", p.team), r.Rotations)
+	res.Text = fmt.Sprintf("Success! Team %s is now %s", p.team, p.name)
+	return res
+} // }}}
+
+// func update {{{
+
+// update
+//
+// Update the requstor's Slack user profile cache.
+func update(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opUpdate)
+	if !ok || p.id == "" || p.name == "" {
+		return slackResponse{Text: help(ctx, "update")}
+	}
+	u, err := getSlackUserDetail(ctx, p.id, true)
+	if err != nil {
+		log.Warningf(ctx, "(update) error getting user info %s - %s", p.name, err)
+		return slackResponse{Text: errorExternal}
+	}
+	if u == nil {
+		return slackResponse{Text: fmt.Sprintf("Sorry! You don't exist in Slack %s", humanErrorEmoji)}
+	}
+	return slackResponse{Text: "Success! Your information is now up to date!"}
+} // }}}
+
+// func restrict {{{
+
+// restrict {team} {#channel} ...
+//
+// Limit the channels the team's on-call list can be changed from. The first one
+// is the team's channel we post notices to, so we join it if we can.
+func restrict(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opRestrict)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "restrict")}
+	}
+
+	res := slackResponse{}
+	var joinErr error
+	if len(p.channels) > 0 {
+		if joinErr = joinChannel(ctx, p.channels[0].Id); joinErr != nil {
+			log.Warningf(ctx, "(restrict) error joining %s - %s", p.channels[0].Name, joinErr)
+		}
+	}
+	oncallMut.Lock()
+	defer oncallMut.Unlock()
+	r := findRotation(p.team)
+	if r == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if p.show {
+		res.Text = fmt.Sprintf("%s can be changed from %s", p.team, describeChannels(r.Channels))
+		return res
+	}
+
+	previous := r.Channels
+	r.Channels = p.channels
+	if err := resetState(ctx, r); err != nil {
+		log.Warningf(ctx, "(restrict) error saving state - %s", err)
+		r.Channels = previous
+		res.Text = errorExternal
+		return res
+	}
+	detail := "changes allowed from " + describeChannels(r.Channels)
+	recordHistory(ctx, p.team, "restrict", p.by.name, detail, r.Rotations)
+	res.Text = fmt.Sprintf("Success! %s can now be changed from %s", p.team, describeChannels(r.Channels))
+	if c, ok := teamChannel(r); ok {
+		res.Text += fmt.Sprintf("\nHandoff notices, gap alerts and reports without a destination go to <#%s|%s>", c.Id, c.Name)
+		if joinErr != nil {
+			res.Text += fmt.Sprintf(", but I can't post there, please `/invite` me %s", humanErrorEmoji)
+		}
+	}
+	return res
+} // }}}
+
+// func permit {{{
+
+// permit {team} {operation} {role}
+//
+// Require the role, rather than the default one, to run the operation on the team.
+func permit(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opPermit)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "permit")}
+	}
+
+	res := slackResponse{}
+	oncallMut.Lock()
+	defer oncallMut.Unlock()
+	r := findRotation(p.team)
+	if r == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if p.operation == "" {
+		if len(r.Permissions) == 0 {
+			res.Text = fmt.Sprintf("%s uses the default permissions", p.team)
+			return res
+		}
+		var str []string
+		for _, perm := range r.Permissions {
+			s := fmt.Sprintf("`%s`: %s", perm.Operation, perm.Role)
+			if time.Now().Before(perm.Enforce) {
+				s += fmt.Sprintf(" - _shadow, enforced from %s_", perm.Enforce.In(timezone).Format(dateFormat))
+			}
+			str = append(str, s)
+		}
+		res.Text = fmt.Sprintf("Permissions of %s other than the default:", p.team)
+		res.Attachments = []attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}}
+		return res
+	}
+
+	previous := r.Permissions
+	perms := make([]PermissionProperty, 0, len(r.Permissions)+1)
+	for _, perm := range r.Permissions {
+		if perm.Operation != p.operation {
+			perms = append(perms, perm)
+		}
+	}
+	detail := fmt.Sprintf("`%s` requires the default role", p.operation)
+	if p.role != "" {
+		perm := PermissionProperty{Operation: p.operation, Role: p.role}
+		detail = fmt.Sprintf("`%s` requires %s", p.operation, p.role)
+		if p.shadow {
+			perm.Enforce = time.Now().Add(permitShadow)
+			detail += fmt.Sprintf(", logged only until %s", perm.Enforce.In(timezone).Format(dateFormat))
+		}
+		perms = append(perms, perm)
+	}
+	r.Permissions = perms
+	if err := resetState(ctx, r); err != nil {
+		log.Warningf(ctx, "(permit) error saving state - %s", err)
+		r.Permissions = previous
+		res.Text = errorExternal
+		return res
+	}
+	recordHistory(ctx, p.team, "permit", p.by.name, detail, r.Rotations)
+	res.Text = fmt.Sprintf("Success! %s of %s", detail, p.team)
+	return res
+} // }}}
+
+// func alias {{{
+
+// alias {team} {alias}
+// unalias {team} {alias}
+//
+// Add or remove another name the team can be looked up with.
+func alias(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opAlias)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "alias")}
+	}
+
+	res := slackResponse{}
+	oncallMut.Lock()
+	defer oncallMut.Unlock()
+	r := findRotation(p.team)
+	if r == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if p.alias == "" {
+		if len(r.Aliases) == 0 {
+			res.Text = fmt.Sprintf("%s has no aliases", p.team)
+		} else {
+			res.Text = fmt.Sprintf("%s is also called %s", p.team, strings.Join(r.Aliases, ", "))
+		}
+		return res
+	}
+
+	previous := r.Aliases
+	aliases := make([]string, 0, len(r.Aliases)+1)
+	for _, a := range r.Aliases {
+		if a != p.alias {
+			aliases = append(aliases, a)
+		}
+	}
+	if p.remove {
+		if len(aliases) == len(r.Aliases) {
+			res.Text = fmt.Sprintf("Sorry, %s is not an alias of %s %s", p.alias, p.team, humanErrorEmoji)
+			return res
+		}
+	} else {
+		// Names have to point to one team only.
+		if o := findRotation(p.alias); o != nil {
+			res.Text = fmt.Sprintf("Sorry, %s is already used by %s %s", p.alias, o.Team, humanErrorEmoji)
 			return res
 		}
+		aliases = append(aliases, p.alias)
+	}
+	r.Aliases = aliases
+	if err := resetState(ctx, r); err != nil {
+		log.Warningf(ctx, "(alias) error saving state - %s", err)
+		r.Aliases = previous
+		res.Text = errorExternal
+		return res
+	}
+	if p.remove {
+		recordHistory(ctx, p.team, "unalias", p.by.name, fmt.Sprintf("removed alias %s", p.alias), r.Rotations)
+		res.Text = fmt.Sprintf("Success! %s is no longer an alias of %s", p.alias, p.team)
+	} else {
+		recordHistory(ctx, p.team, "alias", p.by.name, fmt.Sprintf("added alias %s", p.alias), r.Rotations)
+		res.Text = fmt.Sprintf("Success! %s can now be called %s as well", p.team, p.alias)
 	}
+	return res
+} // }}}
 
-	res.Text = fmt.Sprintf("Sorry, <@%s> is not a manager of team %s %s", p.name, p.team, humanErrorEmoji)
+// func opalias {{{
+
+// opalias {alias} {operation}
+//
+// Let an operation be run by another name, ie. the verbs of a bot users are used to.
+func opalias(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opOpalias)
+	if !ok {
+		return slackResponse{Text: help(ctx, "opalias")}
+	}
+
+	res := slackResponse{}
+	if p.alias == "" {
+		opAliasMut.RLock()
+		var str []string
+		for a, o := range opAliases {
+			str = append(str, fmt.Sprintf("`%s` - `%s`", a, o))
+		}
+		opAliasMut.RUnlock()
+		if len(str) == 0 {
+			res.Text = "No operation aliases set"
+			return res
+		}
+		sort.Strings(str)
+		res.Text = "Operation aliases:"
+		res.Attachments = []attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}}
+		return res
+	}
+
+	opAliasMut.Lock()
+	defer opAliasMut.Unlock()
+	if _, ok := opAliases[p.alias]; !ok && p.operation == "" {
+		res.Text = fmt.Sprintf("Sorry, %s is not an operation alias %s", p.alias, humanErrorEmoji)
+		return res
+	}
+	if err := saveOpAlias(ctx, p.alias, p.operation, p.by.name); err != nil {
+		log.Warningf(ctx, "(opalias) error saving alias - %s", err)
+		res.Text = errorExternal
+		return res
+	}
+	if p.operation == "" {
+		delete(opAliases, p.alias)
+		res.Text = fmt.Sprintf("Success! `%s` is no longer an operation alias", p.alias)
+	} else {
+		opAliases[p.alias] = p.operation
+		res.Text = fmt.Sprintf("Success! `%s` now runs `%s`", p.alias, p.operation)
+	}
 	return res
 } // }}}
 
-// func update {{{
+// func describeChannels {{{
 
-// update
+// Human readable list of channels.
+func describeChannels(channels []ChannelProperty) string {
+	if len(channels) == 0 {
+		return "any channel"
+	}
+	var names []string
+	for _, c := range channels {
+		names = append(names, fmt.Sprintf("<#%s|%s>", c.Id, c.Name))
+	}
+	return strings.Join(names, ", ")
+} // }}}
+
+// func setphone {{{
+
+// setphone {@slackusername} {number}
 //
-// Update the requstor's Slack user profile cache.
-func update(ctx context.Context, params interface{}) slackResponse {
-	p, ok := params.(opUpdate)
-	if !ok || p.id == "" || p.name == "" {
-		return slackResponse{Text: help(ctx, "update")}
+// Set phone number of the user for on-call lists, used only while the user's
+// Slack profile has no phone.
+func setphone(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opSetphone)
+	if !ok || p.id == "" {
+		return slackResponse{Text: help(ctx, "setphone")}
 	}
-	u, err := getSlackUserDetail(ctx, p.id, true)
+	if err := savePhoneOverride(ctx, p.id, p.phone, p.by.name); err != nil {
+		log.Warningf(ctx, "(setphone) error saving phone - %s", err)
+		return slackResponse{Text: errorExternal}
+	}
+
+	slackMut.Lock()
+	if p.phone == "" {
+		delete(phoneOverrides, p.id)
+	} else {
+		phoneOverrides[p.id] = p.phone
+	}
+	// Replace the cached phone unless it came from the Slack profile.
+	if u := slackUsers[p.id]; u != nil && (u.phone == "" || u.phoneOverride) {
+		u.phone = p.phone
+		u.phoneOverride = p.phone != ""
+	}
+	slackMut.Unlock()
+
+	if p.phone == "" {
+		return slackResponse{Text: fmt.Sprintf("Success! Phone of <@%s|%s> is cleared", p.id, p.name)}
+	}
+	return slackResponse{Text: fmt.Sprintf("Success! Phone of <@%s|%s> is set to %s. It's used while their Slack profile has no phone.", p.id, p.name, p.phone)}
+} // }}}
+
+// func history {{{
+
+// history {team}
+//
+// Display the last changes made to the team, newest first.
+func history(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opHistory)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "history")}
+	}
+
+	entries, err := loadHistory(ctx, p.team, historySize)
 	if err != nil {
-		log.Warningf(ctx, "(update) error getting user info %s - %s", p.name, err)
+		log.Warningf(ctx, "(history) error loading history - %s", err)
 		return slackResponse{Text: errorExternal}
 	}
-	if u == nil {
-		return slackResponse{Text: fmt.Sprintf("Sorry! You don't exist in Slack %s", humanErrorEmoji)}
+	if len(entries) == 0 {
+		return slackResponse{Text: fmt.Sprintf("No changes recorded for %s", p.team)}
 	}
-	return slackResponse{Text: "Success! Your information is now up to date!"}
+
+	var str []string
+	for _, e := range entries {
+		str = append(str, fmt.Sprintf("%s <@%s>: %s", e.Time.In(timezone).Format(dateFormat), e.By, e.Detail))
+	}
+	return slackResponse{
+		Text:        "Recent changes for: " + p.team,
+		Attachments: []attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}},
+	}
+} // }}}
+
+// func stats {{{
+
+// stats {team}
+//
+// Summarize the team, so stale or under-staffed on-call lists stand out.
+func stats(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opStats)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "stats")}
+	}
+
+	oncallMut.RLock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.RUnlock()
+		return slackResponse{Text: fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)}
+	}
+	members := append([]RotationProperty(nil), current.Rotations...)
+	managers := len(current.Managers)
+	updated, updatedBy := current.Updated, current.UpdatedBy
+	monthlyCap := current.MonthlyCap
+	oncallMut.RUnlock()
+
+	var shadows int
+	var nophone []string
+	for _, u := range members {
+		if u.Shadow {
+			shadows++
+		}
+		user, err := getSlackUserDetail(ctx, u.Id, false)
+		if err != nil {
+			log.Warningf(ctx, "(stats) error getting user %s - %s", u.Name, err)
+			continue
+		}
+		if user == nil || user.phone == "" {
+			nophone = append(nophone, fmt.Sprintf("<@%s|%s>", u.Id, u.Name))
+		}
+	}
+	changes, err := countHistory(ctx, p.team, time.Now().AddDate(0, 0, -statsDays))
+	if err != nil {
+		log.Warningf(ctx, "(stats) error counting history - %s", err)
+		return slackResponse{Text: errorExternal}
+	}
+
+	str := []string{fmt.Sprintf("On-call list: %d member(s)", len(members)-shadows)}
+	if shadows > 0 {
+		str[0] += fmt.Sprintf(", %d shadow(s)", shadows)
+	}
+	str = append(str, fmt.Sprintf("Managers: %d", managers))
+	str = append(str, fmt.Sprintf("Last updated: %s by <@%s> (%d day(s) ago)", updated.In(timezone).Format(dateFormat), updatedBy, int(time.Since(updated).Hours()/24)))
+	if len(nophone) == 0 {
+		str = append(str, "Missing phone: none")
+	} else {
+		str = append(str, "Missing phone: "+strings.Join(nophone, ", "))
+	}
+	str = append(str, fmt.Sprintf("Changes in the last %d days: %d", statsDays, changes))
+
+	// Primary shifts left this month, with a cap.
+	if monthlyCap > 0 {
+		now := time.Now()
+		entries, err := loadHistoryFrom(ctx, p.team, monthStart(now))
+		if err != nil {
+			log.Warningf(ctx, "(stats) error loading history - %s", err)
+		} else {
+			oncallMut.RLock()
+			served := monthShifts(current, entries, now)
+			oncallMut.RUnlock()
+			var left []string
+			for _, u := range members {
+				if !u.Shadow {
+					left = append(left, fmt.Sprintf("<@%s|%s> %d", u.Id, u.Name, monthlyCap-served[u.Id].shifts))
+				}
+			}
+			str = append(str, fmt.Sprintf("Primary shifts left this month (cap %d): %s", monthlyCap, strings.Join(left, ", ")))
+		}
+	}
+	return slackResponse{
+		Text:        "Stats for: " + p.team,
+		Attachments: []attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}},
+	}
+} // }}}
+
+// func whoami {{{
+
+// whoami
+//
+// Display every team the requestor is in the on-call list of (with position and
+// label), and every team the requestor manages.
+func whoami(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opWhoami)
+	if !ok || p.id == "" {
+		return slackResponse{Text: help(ctx, "whoami")}
+	}
+
+	var oncall, managed []string
+	oncallMut.RLock()
+	idx := rotationsIndex()
+	for _, r := range idx.members[p.id] {
+		for i, u := range r.Rotations {
+			if u.Id != p.id {
+				continue
+			}
+			str := fmt.Sprintf("%s: position %d", r.Team, i+1)
+			if u.Label != "" {
+				str += fmt.Sprintf(" (%s)", u.Label)
+			}
+			oncall = append(oncall, str)
+		}
+	}
+	for _, r := range idx.managers[p.id] {
+		managed = append(managed, r.Team)
+	}
+	oncallMut.RUnlock()
+
+	identity, err := loadIdentity(ctx, p.id)
+	if err != nil {
+		log.Warningf(ctx, "(whoami) error loading identity of %s - %s", p.name, err)
+		identity = &identityProperty{}
+	}
+	linked := describeIdentity(identity)
+
+	if len(oncall) == 0 && len(managed) == 0 && linked == nil {
+		return slackResponse{Text: "You are not in any on-call list, nor managing any team"}
+	}
+
+	res := slackResponse{Text: fmt.Sprintf("On-call memberships of <@%s|%s>:", p.id, p.name)}
+	if len(oncall) > 0 {
+		res.Attachments = append(res.Attachments, attachment{Title: "On-call", Text: strings.Join(oncall, "\n"), Color: defaultColor})
+	}
+	if len(managed) > 0 {
+		res.Attachments = append(res.Attachments, attachment{Title: "Manager", Text: strings.Join(managed, "\n"), Color: defaultColor})
+	}
+	if linked != nil {
+		res.Attachments = append(res.Attachments, attachment{Title: "Linked", Text: strings.Join(linked, "\n"), Color: defaultColor})
+	}
+	return res
 } // }}}
 
 // func listTeams {{{
@@ -704,11 +2615,19 @@ func listTeams(ctx context.Context) slackResponse {
 	var str []string
 	oncallMut.RLock()
 	for _, r := range rotations {
+		if r.Description != "" {
+			str = append(str, fmt.Sprintf("%s: _%s_", r.Team, r.Description))
+		}
 		if len(r.Managers) == 0 {
 			str = append(str, fmt.Sprintf("%s: %s", r.Team, errorNoManager))
 			continue
 		}
 		for _, manager := range r.Managers {
+			// Phones of managers are nice to have here, don't look up uncached ones while Slack is slow.
+			if !userCached(manager.Id) && skipOptional(ctx, "manager phone") {
+				str = append(str, fmt.Sprintf("%s: <@%s|%s>", r.Team, manager.Id, manager.Name))
+				continue
+			}
 			// Get user info.
 			if user, err = getSlackUserDetail(ctx, manager.Id, false); err != nil || user == nil || user.phone == "" {
 				str = append(str, fmt.Sprintf("%s: <@%s|%s> :dir_phone: %s", r.Team, manager.Id, manager.Name, errorNoPhone))
@@ -737,12 +2656,48 @@ func listRotation(ctx context.Context, team string) slackResponse {
 	return slackResponse{Text: "On-call list for: " + team, Attachments: []attachment{generateOncallList(ctx, team)}}
 } // }}}
 
+// func listAllRotations {{{
+
+// Display oncall rotation of every team, one attachment per team.
+// Slack truncates messages with too many attachments, so teams over the limit are
+// only listed by name.
+func listAllRotations(ctx context.Context) slackResponse {
+	var teams []string
+	oncallMut.RLock()
+	for _, r := range rotations {
+		teams = append(teams, r.Team)
+	}
+	oncallMut.RUnlock()
+
+	res := slackResponse{Text: "On-call lists of all teams:"}
+	if len(teams) == 0 {
+		res.Text = "No teams registered yet"
+		return res
+	}
+	shown := teams
+	if len(teams) > maxAttachments {
+		// Leave room for the attachment listing the rest.
+		shown = teams[:maxAttachments-1]
+	}
+	for _, team := range shown {
+		att := generateOncallList(ctx, team)
+		att.Title = team + "\n" + att.Title
+		res.Attachments = append(res.Attachments, att)
+	}
+	if rest := teams[len(shown):]; len(rest) > 0 {
+		res.Attachments = append(res.Attachments, attachment{
+			Color: defaultColor,
+			Text:  fmt.Sprintf("...and %d more: %s\nUse `%s list {team}` to see them.", len(rest), strings.Join(rest, ", "), commandName(ctx)),
+		})
+	}
+	return res
+} // }}}
+
 // func generateOncallList {{{
 
 // Return on-call list along with list of managers for the requested team.
 func generateOncallList(ctx context.Context, team string) attachment {
 	var row *oncallProperty
-	var err error
 	att := attachment{Color: defaultColor}
 
 	// Get current list.
@@ -760,72 +2715,144 @@ func generateOncallList(ctx context.Context, team string) attachment {
 		return att
 	}
 	att.Footer = fmt.Sprintf("updated: %s by <@%s>", row.Updated.In(timezone).Format(dateFormat), row.UpdatedBy)
+	if row.Notes != "" {
+		att.Footer = row.Notes + " | " + att.Footer
+	}
+	if row.Cadence != "" {
+		att.Footer += fmt.Sprintf(" | %s, next handoff: %s", describeCadence(row), handoffTime(row, cadenceAdvances(row, time.Now())+1).Format(dateFormat))
+	}
+	// The dashboard shows every team, not for other workspaces.
+	if link := signedURL("/wallboard"); link != "" && ctx.Value(ctxKeyExternal) == nil {
+		att.Footer += fmt.Sprintf(" | <%s|open dashboard>", link)
+	}
 
-	// Copy over current oncall list in case any of managers or on-call staff is deleted from Slack
-	// and needs to be removed from on-call as well.
-	var newOncallList = oncallProperty{
-		Key:       row.Key,
-		Team:      row.Team,
-		Managers:  row.Managers,
-		Rotations: row.Rotations,
-		Updated:   row.Updated,
-		UpdatedBy: row.UpdatedBy,
+	// Dated assignments go with the list.
+	now := time.Now()
+	schedule := describeAssignments(row, now)
+	shifts := describeShifts(row, now)
+	quietstr := describeQuiet(row, now)
+	coverage, _ := coverageIssues(row, now)
+	if o, ok := upcomingOverride(row, now); ok {
+		schedule = append([]string{fmt.Sprintf("<@%s|%s> overrides %s", o.Id, o.Name, describeRange(row.OverrideStart, row.OverrideUntil, timezone))}, schedule...)
 	}
+	assigned := activeAssignment(row, now)
+	var assignedTo assignmentProperty
+	if assigned != nil {
+		assignedTo = *assigned
+	}
+
+	// Copy over current oncall list so Slack can be queried outside of the lock.
+	// Users who left Slack are removed by the "/cron/members" job.
+	var newOncallList = *row
 	oncallMut.RUnlock()
 
 	// Get list of managers.
-	var changed bool
-	tmp, str := getCurrentManagerOncallList(ctx, &newOncallList)
+	str := getCurrentManagerOncallList(ctx, &newOncallList)
 	if str == nil {
 		att.Title = errorNoManager
 	} else {
 		att.Title = strings.Join(str, "\n")
 	}
-	if tmp {
-		changed = tmp
+	if newOncallList.Description != "" {
+		att.Title = newOncallList.Description + "\n" + att.Title
 	}
 
 	// Then the actual list.
-	tmp, str = getCurrentOncallList(ctx, &newOncallList)
+	str = getCurrentOncallList(ctx, &newOncallList)
 	if str == nil {
 		att.Text = errorNoRotation
 	} else {
 		att.Text = strings.Join(str, "\n")
 	}
-	if tmp {
-		changed = tmp
+	// Someone covering the primary goes on top.
+	if o, ok := activeOverride(&newOncallList, time.Now()); ok {
+		overridestr := fmt.Sprintf("Override: <@%s|%s> :dir_phone: ", o.Id, o.Name)
+		if user, err := getSlackUserDetail(ctx, o.Id, false); err != nil || user == nil || user.phone == "" {
+			overridestr += errorNoPhone
+		} else {
+			overridestr += user.phone
+		}
+		overridestr += fmt.Sprintf(" - %s until %s", rolePrimary, newOncallList.OverrideUntil.In(timezone).Format(dateFormat))
+		att.Text = overridestr + "\n" + att.Text
+	} else if assigned != nil {
+		assignedstr := fmt.Sprintf("Assigned: <@%s|%s> :dir_phone: ", assignedTo.Id, assignedTo.Name)
+		if user, err := getSlackUserDetail(ctx, assignedTo.Id, false); err != nil || user == nil || user.phone == "" {
+			assignedstr += errorNoPhone
+		} else {
+			assignedstr += user.phone
+		}
+		assignedstr += fmt.Sprintf(" - %s until %s", rolePrimary, assignedTo.End.In(teamLocation(&newOncallList)).Format(dateFormat))
+		att.Text = assignedstr + "\n" + att.Text
+	}
+	if schedule != nil {
+		att.Text += "\nSchedule:\n" + strings.Join(schedule, "\n")
+	}
+	if shifts != nil {
+		att.Text += "\nShifts:\n" + strings.Join(shifts, "\n")
 	}
+	if quietstr != "" {
+		att.Text += "\nQuiet hours: " + quietstr
+	}
+	if coverage != nil {
+		att.Text += "\nCoverage :warning:\n" + strings.Join(coverage, "\n")
+	}
+	att.Footer = describeHealth(teamHealth(ctx, newOncallList, time.Now())) + " | " + att.Footer
 
-	// If the list changed, update state and memory.
-	if changed {
-		if err = saveState(ctx, &newOncallList); err == nil {
-			oncallMut.Lock()
-			log.Infof(ctx, "updated manager list (%s) len %d->%d", team, len(row.Managers), len(newOncallList.Managers))
-			row.Managers = newOncallList.Managers
-			log.Infof(ctx, "updated on-call list (%s) len %d->%d", team, len(row.Rotations), len(newOncallList.Rotations))
-			row.Rotations = newOncallList.Rotations
-			oncallMut.Unlock()
-		}
+	return att
+} // }}}
+
+// func generateRoleAttachment {{{
+
+// Return an attachment showing only the on-call of the team in the role (primary/secondary).
+func generateRoleAttachment(ctx context.Context, team, role string) attachment {
+	att := attachment{Color: defaultColor, Title: fmt.Sprintf("%s%s on-call for %s", strings.ToUpper(role[:1]), role[1:], team)}
+
+	current := getCurrentRotation(team)
+	if current == nil {
+		att.Text = fmt.Sprintf("Team %s does not exist %s", team, humanErrorEmoji)
+		return att
 	}
+	oncallMut.RLock()
+	u, ok := memberByRole(current, role)
+	if !ok {
+		oncallMut.RUnlock()
+		att.Text = fmt.Sprintf("No %s on-call set %s", role, humanErrorEmoji)
+		return att
+	}
+	att.Footer = fmt.Sprintf("updated: %s by <@%s>", current.Updated.In(timezone).Format(dateFormat), current.UpdatedBy)
+	if _, ok := activeOverride(current, time.Now()); ok && role == rolePrimary {
+		att.Footer += fmt.Sprintf(" | override until %s", current.OverrideUntil.In(timezone).Format(dateFormat))
+	} else if a := activeAssignment(current, time.Now()); a != nil && role == rolePrimary {
+		att.Footer += fmt.Sprintf(" | assigned until %s", a.End.In(teamLocation(current)).Format(dateFormat))
+	}
+	oncallMut.RUnlock()
 
+	att.Text = fmt.Sprintf("<@%s|%s> :dir_phone: ", u.Id, u.Name)
+	user, err := getSlackUserDetail(ctx, u.Id, false)
+	if err != nil || user == nil || user.phone == "" {
+		att.Text += errorNoPhone
+	} else {
+		att.Text += user.phone
+	}
+	if u.Label != "" {
+		att.Text += fmt.Sprintf(" (%s)", u.Label)
+	}
 	return att
 } // }}}
 
 // func getCurrentManagerOncallList {{{
 
-func getCurrentManagerOncallList(ctx context.Context, row *oncallProperty) (changed bool, str []string) {
+func getCurrentManagerOncallList(ctx context.Context, row *oncallProperty) (str []string) {
 	if len(row.Managers) == 0 {
 		return
 	}
 
-	for idx, m := range row.Managers {
+	for _, m := range row.Managers {
 		// Get info first.
 		user, err := getSlackUserDetail(ctx, m.Id, false)
 		if err == nil && user == nil {
-			// User doesn't exist in Slack, remove from list.
-			row.Managers = append(row.Managers[:idx], row.Managers[idx+1:]...)
-			changed = true
-			idx--
+			// User doesn't exist in Slack, left for the reconciliation job to remove.
+			str = append(str, fmt.Sprintf("Manager: ~<@%s|%s>~ - _no longer in Slack_", m.Id, m.Name))
 		} else {
 			if err != nil || user.phone == "" {
 				if err != nil {
@@ -843,20 +2870,19 @@ func getCurrentManagerOncallList(ctx context.Context, row *oncallProperty) (chan
 
 // func getCurrentOncallList {{{
 
-func getCurrentOncallList(ctx context.Context, row *oncallProperty) (changed bool, str []string) {
+func getCurrentOncallList(ctx context.Context, row *oncallProperty) (str []string) {
 	if len(row.Rotations) == 0 {
 		return
 	}
 
+	now := time.Now()
 	for idx, u := range row.Rotations {
 		user, err := getSlackUserDetail(ctx, u.Id, false)
 		var userstr string
 		if err == nil && user == nil {
-			// User doesn't exist in Slack, remove from list.
-			log.Warningf(ctx, "User %s not exists in Slack, removing from list", u.Name)
-			row.Rotations = append(row.Rotations[:idx], row.Rotations[idx+1:]...)
-			changed = true
-			idx--
+			// User doesn't exist in Slack, left for the reconciliation job to remove.
+			log.Warningf(ctx, "User %s not exists in Slack", u.Name)
+			str = append(str, fmt.Sprintf("%d: ~<@%s|%s>~ - _no longer in Slack_", idx+1, u.Id, u.Name))
 		} else {
 			userstr = fmt.Sprintf("%d: <@%s|%s> :dir_phone: ", idx+1, u.Id, u.Name)
 			if err != nil || user.phone == "" {
@@ -870,6 +2896,29 @@ func getCurrentOncallList(ctx context.Context, row *oncallProperty) (changed boo
 			if u.Label != "" {
 				userstr += fmt.Sprintf(" (%s)", u.Label)
 			}
+			role := rotationRole(row, idx+1, now)
+			if role != "" {
+				userstr += " - " + role
+			}
+			if u.Shadow {
+				userstr += " - _shadow_"
+			}
+			// Members onboarding go with the primary.
+			if role == rolePrimary {
+				for _, s := range row.Rotations {
+					if s.Shadow && s.ShadowLeft > 0 {
+						userstr += fmt.Sprintf("\n\tshadowing: <@%s|%s> (%d shifts left)", s.Id, s.Name, s.ShadowLeft)
+					}
+				}
+			}
+			if memberAway(u, now) {
+				userstr = fmt.Sprintf("~%s~ - _away until %s_", userstr, u.AwayUntil.In(timezone).Format(dateFormat))
+				if u.FallbackId != "" {
+					userstr += fmt.Sprintf(", covered by <@%s|%s>", u.FallbackId, u.FallbackName)
+				}
+			} else if u.FallbackId != "" {
+				userstr += fmt.Sprintf(" (fallback: <@%s|%s>)", u.FallbackId, u.FallbackName)
+			}
 			str = append(str, userstr)
 		}
 	}
@@ -0,0 +1,119 @@
+package slackoncallbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"net/url"
+	"time"
+)
+
+// func handoff {{{
+
+// handoff {team} {accept|decline|resume}
+//
+// Let the incoming primary of a scheduled handoff accept it, or decline it which
+// pauses scheduled handoffs of the team and tells its managers. Handoffs carry
+// on from "resume" once the managers sorted it out, ie. by "swap" or "override".
+func handoff(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opHandoff)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "handoff")}
+	}
+
+	res := slackResponse{}
+	oncallMut.Lock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	previous := *current
+	var detail string
+	switch p.action {
+	case "accept", "decline":
+		if current.Pending != p.by.id {
+			oncallMut.Unlock()
+			res.Text = fmt.Sprintf("Sorry, there's no handoff of %s waiting for you %s", p.team, humanErrorEmoji)
+			return res
+		}
+		current.Pending = ""
+		detail = "accepted handoff"
+		if p.action == "decline" {
+			current.Paused = true
+			detail = "declined handoff, scheduled handoffs paused"
+		}
+	case "resume":
+		if !current.Paused {
+			oncallMut.Unlock()
+			res.Text = fmt.Sprintf("Sorry, handoffs of %s aren't paused %s", p.team, humanErrorEmoji)
+			return res
+		}
+		// Carry on from the list as it is now, handoffs missed while paused are skipped.
+		current.Paused = false
+		current.Pending = ""
+		current.Advanced = cadenceAdvances(current, time.Now())
+		detail = "resumed scheduled handoffs"
+	}
+	current.Updated = time.Now()
+	current.UpdatedBy = p.by.name
+	// Kept for "undo", ie. of a declined handoff pausing the schedule.
+	if err := replaceState(ctx, "handoff", p.by.name, &previous, current); err != nil {
+		log.Warningf(ctx, "(handoff) error saving state - %s", err)
+		*current = previous
+		oncallMut.Unlock()
+		res.Text = errorExternal
+		return res
+	}
+	recordHistory(ctx, p.team, "handoff", p.by.name, detail, current.Rotations)
+	var managers []string
+	for _, m := range current.Managers {
+		managers = append(managers, m.Id)
+	}
+	oncallMut.Unlock()
+
+	switch p.action {
+	case "accept":
+		res.Text = fmt.Sprintf("Thanks! You are primary on-call of %s", p.team)
+	case "decline":
+		text := fmt.Sprintf("<@%s|%s> declined primary on-call of %s, scheduled handoffs are paused. Sort out who covers it, then run `%s handoff %s resume`.",
+			p.by.id, p.by.name, p.team, commandName(ctx), p.team)
+		for _, id := range managers {
+			postDM(ctx, id, text)
+		}
+		res.Text = fmt.Sprintf("Declined, the managers of %s have been told and handoffs are paused until they resume them", p.team)
+		if managers == nil {
+			res.Text = fmt.Sprintf("Declined, handoffs of %s are paused but it has no managers to tell %s", p.team, humanErrorEmoji)
+		}
+	case "resume":
+		res.Text = fmt.Sprintf("Success! Scheduled handoffs of %s are resumed\nNew list:", p.team)
+		res.Attachments = []attachment{generateOncallList(ctx, p.team)}
+	}
+	return res
+} // }}}
+
+// func askHandoff {{{
+
+// DM the incoming primary of the team the text, with buttons to accept or decline
+// the handoff.
+func askHandoff(ctx context.Context, team, id, text string) error {
+	att, err := json.Marshal([]attachment{{
+		Text:       "Can you take it?",
+		Color:      defaultColor,
+		CallbackId: callbackHandoff,
+		Actions: []attachmentAction{
+			{Name: "accept", Text: "Accept", Type: "button", Style: "primary", Value: "handoff " + team + " accept"},
+			{Name: "decline", Text: "Decline", Type: "button", Style: "danger", Value: "handoff " + team + " decline"},
+		},
+	}})
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("channel", id)
+	params.Set("text", text)
+	params.Set("attachments", string(att))
+	return callSlackAPI(ctx, "chat.postMessage", params)
+} // }}}
@@ -0,0 +1,34 @@
+package slackoncallbot
+
+import (
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+)
+
+// func livezHandler {{{
+
+// GET /livez
+//
+// Liveness probe. The instance is serving requests, nothing else is checked.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+} // }}}
+
+// func readyzHandler {{{
+
+// GET /readyz
+//
+// Readiness probe. The instance is ready once the on-call state is loaded from
+// Datastore, which is tried again here if it isn't yet.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if err := ensureState(ctx); err != nil {
+		log.Warningf(ctx, "(readyz) not ready - %s", err)
+		http.Error(w, "state not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+} // }}}
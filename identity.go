@@ -0,0 +1,99 @@
+package slackoncallbot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"strings"
+)
+
+// func linkMe {{{
+
+// link-me {pagerduty|github} {email|handle}
+//
+// Link the requestor's identity in another system, so integrations (ie. PagerDuty
+// sync) map Slack users without guessing from display names. Without a system,
+// display the requestor's identities.
+func linkMe(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opLinkMe)
+	if !ok || p.by.id == "" {
+		return slackResponse{Text: help(ctx, "link-me")}
+	}
+
+	identity, err := loadIdentity(ctx, p.by.id)
+	if err != nil {
+		log.Warningf(ctx, "(link-me) error loading identity of %s - %s", p.by.name, err)
+		return slackResponse{Text: errorExternal}
+	}
+	if p.system == "" {
+		str := describeIdentity(identity)
+		if str == nil {
+			return slackResponse{Text: "You have no linked identities"}
+		}
+		return slackResponse{Text: "Linked identities of <@" + p.by.id + ">:", Attachments: []attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}}}
+	}
+
+	switch p.system {
+	case "pagerduty":
+		identity.PagerDuty = p.handle
+	case "github":
+		identity.GitHub = p.handle
+	}
+	if err = saveIdentity(ctx, p.by.id, identity); err != nil {
+		log.Warningf(ctx, "(link-me) error saving identity of %s - %s", p.by.name, err)
+		return slackResponse{Text: errorExternal}
+	}
+	if p.handle == "" {
+		return slackResponse{Text: fmt.Sprintf("Success! Your %s identity is unlinked", p.system)}
+	}
+	return slackResponse{Text: fmt.Sprintf("Success! You are linked to %s in %s", p.handle, p.system)}
+} // }}}
+
+// func describeIdentity {{{
+
+// Return a line per linked system.
+func describeIdentity(identity *identityProperty) (str []string) {
+	if identity.PagerDuty != "" {
+		str = append(str, "pagerduty: "+identity.PagerDuty)
+	}
+	if identity.GitHub != "" {
+		str = append(str, "github: "+identity.GitHub)
+	}
+	return
+} // }}}
+
+// func identitiesHandler {{{
+
+// GET /identities?token={identities_token}
+//
+// Identities linked by "link-me" of every user as JSON, keyed by Slack user_id,
+// for integrations to map Slack users to their other accounts.
+// Disabled unless "identities_token" is configured. It's a token of its own, so
+// screens showing the wallboard can't read everyone's accounts, and there are
+// no pre-signed links to it.
+func identitiesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	if identitiesToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.FormValue("token")), []byte(identitiesToken)) != 1 {
+		log.Warningf(ctx, "(identities) invalid token from %s", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	identities, err := loadIdentities(ctx)
+	if err != nil {
+		log.Warningf(ctx, "(identities) error loading identities - %s", err)
+		http.Error(w, "error loading identities", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err = json.NewEncoder(w).Encode(identities); err != nil {
+		log.Warningf(ctx, "(identities) error writing - %s", err)
+	}
+} // }}}
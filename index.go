@@ -0,0 +1,113 @@
+package slackoncallbot
+
+import (
+	"sync"
+)
+
+// Lookup tables of rotations, so finding a team or the teams of a user doesn't
+// scan every team on each request.
+type rotationIndex struct {
+	// Team name and aliases to the team.
+	teams map[string]*oncallProperty
+	// Slack user_id to the teams the user is in the on-call list of.
+	members map[string][]*oncallProperty
+	// Slack user_id to the teams the user manages.
+	managers map[string][]*oncallProperty
+}
+
+// Lock of rotations.
+// Rotations are only ever changed under the write lock, so the index is marked
+// stale whenever the write lock is released, and rebuilt by the next lookup.
+type rotationsMutex struct {
+	sync.RWMutex
+}
+
+var (
+	// Index of rotations, nil until the first lookup.
+	index *rotationIndex
+	// Rotations changed since the index was built.
+	indexStale bool
+	// Mutex lock for the index. Always taken after oncallMut.
+	indexMut sync.Mutex
+)
+
+// func rotationsMutex.Unlock {{{
+
+func (m *rotationsMutex) Unlock() {
+	indexMut.Lock()
+	indexStale = true
+	indexMut.Unlock()
+	m.RWMutex.Unlock()
+} // }}}
+
+// func rotationsIndex {{{
+
+// Return the index of rotations, rebuilding it if rotations changed since it was
+// built. The index must not be kept after oncallMut is released.
+// Caller must hold oncallMut.
+func rotationsIndex() *rotationIndex {
+	indexMut.Lock()
+	defer indexMut.Unlock()
+	if index != nil && !indexStale {
+		return index
+	}
+
+	idx := &rotationIndex{
+		teams:    make(map[string]*oncallProperty, len(rotations)),
+		members:  make(map[string][]*oncallProperty),
+		managers: make(map[string][]*oncallProperty),
+	}
+	for _, r := range rotations {
+		idx.teams[r.Team] = r
+		for _, m := range r.Managers {
+			idx.managers[m.Id] = appendTeam(idx.managers[m.Id], r)
+		}
+		for _, u := range r.Rotations {
+			idx.members[u.Id] = appendTeam(idx.members[u.Id], r)
+		}
+	}
+	// Team names win over aliases.
+	for _, r := range rotations {
+		for _, a := range r.Aliases {
+			if _, ok := idx.teams[a]; !ok {
+				idx.teams[a] = r
+			}
+		}
+	}
+	index = idx
+	indexStale = false
+	return index
+} // }}}
+
+// func appendTeam {{{
+
+// Append the team unless it's already the last one, since a team is added once
+// per member or manager entry.
+func appendTeam(teams []*oncallProperty, r *oncallProperty) []*oncallProperty {
+	if len(teams) > 0 && teams[len(teams)-1] == r {
+		return teams
+	}
+	return append(teams, r)
+} // }}}
+
+// func userTeams {{{
+
+// Return teams the user manages or is in the on-call list of, sorted by team name.
+// Caller must hold oncallMut.
+func userTeams(id string) oncallProperties {
+	idx := rotationsIndex()
+	members, managers := idx.members[id], idx.managers[id]
+	teams := make(oncallProperties, 0, len(members)+len(managers))
+	for len(members) > 0 || len(managers) > 0 {
+		switch {
+		case len(managers) == 0 || (len(members) > 0 && members[0].Team < managers[0].Team):
+			teams, members = append(teams, members[0]), members[1:]
+		case len(members) == 0 || managers[0].Team < members[0].Team:
+			teams, managers = append(teams, managers[0]), managers[1:]
+		default:
+			// Both, ie. a manager also in the on-call list.
+			teams, members, managers = append(teams, members[0]), members[1:], managers[1:]
+		}
+	}
+	return teams
+} // }}}
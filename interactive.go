@@ -0,0 +1,181 @@
+package slackoncallbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// func interactiveHandler {{{
+
+// HTTP handler for interactive messages (buttons/menus) sent from Slack.
+//
+// Slack posts a form with a single "payload" field holding JSON. The selected
+// value is converted back into a slash command and dispatched the same way
+// oncallHandler does, so all the usual permission checks apply.
+func interactiveHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+
+	if err := r.ParseForm(); err != nil {
+		log.Warningf(ctx, "(interactive) error parsing request params from slack: %v", err)
+		sendResponse(ctx, w, slackResponse{Text: errorExternal})
+		return
+	}
+	defer r.Body.Close()
+
+	var p slackInteraction
+	if err := json.Unmarshal([]byte(r.FormValue("payload")), &p); err != nil {
+		log.Warningf(ctx, "(interactive) error decoding payload: %s", err)
+		sendResponse(ctx, w, slackResponse{Text: errorExternal})
+		return
+	}
+	if debug {
+		log.Infof(ctx, "Interaction: %+v", p)
+	}
+
+	// Make sure the token we received is what we expect.
+	if p.Token != slackCommandToken {
+		log.Warningf(ctx, "(interactive) invalid token %s", p.Token)
+		sendResponse(ctx, w, slackResponse{Text: errorExternal})
+		return
+	}
+	// Block Kit interactions come with the callback id as the block id, and are
+	// answered through "response_url" as Slack ignores the response to them.
+	if p.Type == "block_actions" {
+		if len(p.Actions) > 0 {
+			p.CallbackId = p.Actions[0].BlockId
+		}
+		for i, a := range p.Actions {
+			p.Actions[i].Name = a.ActionId
+			if a.SelectedOption.Value != "" {
+				p.Actions[i].SelectedOptions = []actionOption{{Value: a.SelectedOption.Value}}
+			}
+		}
+	}
+	respond := func(res slackResponse) {
+		if p.Type != "block_actions" {
+			sendResponse(ctx, w, res)
+			return
+		}
+		if err := postResponseURL(ctx, p.ResponseURL, res); err != nil {
+			log.Warningf(ctx, "(interactive) error responding - %s", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if len(p.Actions) == 0 {
+		log.Warningf(ctx, "(interactive) no action in payload")
+		respond(slackResponse{Text: errorInput})
+		return
+	}
+
+	// Rebuild the command the user would have typed.
+	var text string
+	var page int
+	switch p.CallbackId {
+	case callbackTeamPicker:
+		if len(p.Actions[0].SelectedOptions) == 0 {
+			respond(slackResponse{Text: errorInput})
+			return
+		}
+		text = p.Actions[0].SelectedOptions[0].Value
+	case callbackConfirm:
+		if p.Actions[0].Name != "confirm" {
+			respond(slackResponse{Text: "Cancelled, nothing has changed."})
+			return
+		}
+		text = p.Actions[0].Value
+		ctx = context.WithValue(ctx, ctxKeyConfirmed, true)
+	case callbackRestore, callbackHandoff, callbackCover:
+		text = p.Actions[0].Value
+	case callbackPage:
+		// The page to show goes before the command.
+		v := strings.SplitN(p.Actions[0].Value, " ", 2)
+		n, err := strconv.Atoi(v[0])
+		if err != nil || len(v) != 2 || n < 0 {
+			log.Warningf(ctx, "(interactive) invalid page %s", p.Actions[0].Value)
+			respond(slackResponse{Text: errorInput})
+			return
+		}
+		page, text = n, v[1]
+	default:
+		log.Warningf(ctx, "(interactive) unknown callback %s", p.CallbackId)
+		respond(slackResponse{Text: errorInput})
+		return
+	}
+
+	sr := slackCommandParams{
+		Token:        p.Token,
+		TeamId:       p.Team.Id,
+		TeamDomain:   p.Team.Domain,
+		EnterpriseId: p.Team.EnterpriseId,
+		ChannelId:    p.Channel.Id,
+		ChannelName:  p.Channel.Name,
+		UserId:       p.User.Id,
+		UserName:     p.User.Name,
+		Command:      command,
+		Text:         text,
+		ResponseURL:  p.ResponseURL,
+	}
+	ctx = context.WithValue(ctx, ctxKeyUserId, sr.UserId)
+	if err := ensureState(ctx); err != nil {
+		respond(slackResponse{Text: errorExternal})
+		return
+	}
+
+	respond(renderResponse(paginate(dispatch(ctx, sr), text, page), text))
+} // }}}
+
+// func pickTeam {{{
+
+// Reply with a menu of teams when a team-scoped operation was issued without the team.
+//
+// Superusers get every registered team, everyone else only gets teams they manage
+// or are in the on-call list of. Picking a team re-runs the original operation
+// with the team filled in.
+func pickTeam(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opPick)
+	if !ok || p.op == "" {
+		return slackResponse{Text: help(ctx, "")}
+	}
+
+	exempt := userIsExempt(ctx, p.by.id)
+	var options []actionOption
+	oncallMut.RLock()
+	teams := rotations
+	if !exempt {
+		teams = userTeams(p.by.id)
+	}
+	for _, r := range teams {
+		value := strings.Join(append([]string{p.op, r.Team}, p.args...), " ")
+		options = append(options, actionOption{Text: r.Team, Value: value})
+	}
+	oncallMut.RUnlock()
+
+	// Nothing to pick from, fallback to the usual help text.
+	if len(options) == 0 {
+		return slackResponse{Text: help(ctx, p.op)}
+	}
+
+	return slackResponse{
+		Type: "ephemeral",
+		Text: fmt.Sprintf("Which team do you want to `%s`?", p.op),
+		Attachments: []attachment{{
+			Color:      defaultColor,
+			CallbackId: callbackTeamPicker,
+			Actions: []attachmentAction{{
+				Name:    "team",
+				Text:    "Pick a team...",
+				Type:    "select",
+				Options: options,
+			}},
+		}},
+	}
+} // }}}
@@ -0,0 +1,243 @@
+package slackoncallbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Cron job as scheduled in cron.yaml.
+type cronJob struct {
+	name string
+	// How often cron.yaml runs it.
+	interval time.Duration
+	handler  http.HandlerFunc
+}
+
+// Every cron job, in the order shown by "admin jobs".
+var cronJobs = []cronJob{
+	{"reports", 10 * time.Minute, reportCronHandler},
+	{"rotate", 10 * time.Minute, rotationCronHandler},
+	{"health", 31 * 24 * time.Hour, healthCronHandler},
+	{"phones", 31 * 24 * time.Hour, phoneAuditCronHandler},
+	{"digest", 7 * 24 * time.Hour, digestCronHandler},
+	{"cleanup", 24 * time.Hour, cleanupCronHandler},
+	{"members", 24 * time.Hour, membersCronHandler},
+	{"plans", 10 * time.Minute, planCronHandler},
+}
+
+// Response writer remembering the status code of a cron run.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// func trackJob {{{
+
+// Wrap the cron handler of the job to record when it ran, how long it took and
+// whether it succeeded, then alert about jobs overdue.
+func trackJob(job cronJob) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Requests not from cron are refused by the handler, they aren't runs.
+		if r.Header.Get("X-Appengine-Cron") != "true" {
+			job.handler(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		job.handler(rec, r)
+
+		ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+		run, err := loadJob(ctx, job.name)
+		if err != nil {
+			log.Warningf(ctx, "(cron) error loading job %s - %s", job.name, err)
+			return
+		}
+		run.LastRun = start
+		run.DurationMs = int64(time.Since(start) / time.Millisecond)
+		run.Status = rec.status
+		if rec.status < http.StatusBadRequest {
+			run.LastSuccess = start
+			run.Alerted = false
+		}
+		if err = saveJob(ctx, job.name, run); err != nil {
+			log.Warningf(ctx, "(cron) error saving job %s - %s", job.name, err)
+			return
+		}
+		checkJobs(ctx)
+	}
+} // }}}
+
+// func jobOverdue {{{
+
+// Check if the job hasn't succeeded within twice its interval. Jobs which never
+// ran aren't overdue, they may just be newly deployed.
+func jobOverdue(job cronJob, run *jobProperty, now time.Time) bool {
+	if run.LastRun.IsZero() {
+		return false
+	}
+	last := run.LastSuccess
+	if last.IsZero() {
+		last = run.LastRun
+	}
+	return now.Sub(last) > 2*job.interval
+} // }}}
+
+// func checkJobs {{{
+
+// Post an alert to "alert_channel" for every job overdue, once until it succeeds
+// again.
+func checkJobs(ctx context.Context) {
+	if alertChannel == "" {
+		return
+	}
+	now := time.Now()
+	for _, job := range cronJobs {
+		run, err := loadJob(ctx, job.name)
+		if err != nil {
+			log.Warningf(ctx, "(cron) error loading job %s - %s", job.name, err)
+			continue
+		}
+		if run.Alerted || !jobOverdue(job, run, now) {
+			continue
+		}
+		params := url.Values{}
+		params.Set("channel", alertChannel)
+		params.Set("text", fmt.Sprintf("%s Background job `%s` hasn't succeeded since %s (runs every %s). Check `%s admin jobs` and the logs.",
+			externalErrorEmoji, job.name, describeJobTime(run.LastSuccess), describeInterval(job.interval), command))
+		if err = callSlackAPI(ctx, "chat.postMessage", params); err != nil {
+			log.Warningf(ctx, "(cron) error alerting about job %s - %s", job.name, err)
+			continue
+		}
+		run.Alerted = true
+		if err = saveJob(ctx, job.name, run); err != nil {
+			log.Warningf(ctx, "(cron) error saving job %s - %s", job.name, err)
+		}
+	}
+} // }}}
+
+// func adminJobs {{{
+
+// admin jobs
+//
+// Display last run, duration and result of every background job, and the Slack
+// API latency of the instance answering.
+func adminJobs(ctx context.Context) slackResponse {
+	now := time.Now()
+	var str []string
+	var overdue bool
+	for _, job := range cronJobs {
+		run, err := loadJob(ctx, job.name)
+		if err != nil {
+			log.Warningf(ctx, "(admin) error loading job %s - %s", job.name, err)
+			return slackResponse{Text: errorExternal}
+		}
+		s := fmt.Sprintf("`%s` (every %s): ", job.name, describeInterval(job.interval))
+		if run.LastRun.IsZero() {
+			str = append(str, s+"_never ran_")
+			continue
+		}
+		s += fmt.Sprintf("last run %s, took %dms, ", describeJobTime(run.LastRun), run.DurationMs)
+		if run.Status < http.StatusBadRequest {
+			s += "succeeded"
+		} else {
+			s += fmt.Sprintf("failed (%d), last success %s", run.Status, describeJobTime(run.LastSuccess))
+		}
+		if jobOverdue(job, run, now) {
+			s += " " + externalErrorEmoji + " _overdue_"
+			overdue = true
+		}
+		str = append(str, s)
+	}
+	res := slackResponse{Text: "Background jobs:", Attachments: []attachment{
+		{Color: defaultColor, Text: strings.Join(str, "\n")},
+		{Color: defaultColor, Text: describeDegrade()},
+	}}
+	if overdue {
+		res.Text = "Background jobs, some are overdue:"
+	}
+	return res
+} // }}}
+
+// func jobsHandler {{{
+
+// GET /jobs?token={wallboard_token}
+//
+// Last run, duration and result of every background job as JSON, for monitoring.
+// Disabled unless "wallboard_token" is configured, like the wallboard.
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if wallboardToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !viewAllowed(r) {
+		log.Warningf(ctx, "(jobs) invalid token from %s", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	type jobStatus struct {
+		Name        string    `json:"name"`
+		IntervalSec int64     `json:"interval_sec"`
+		LastRun     time.Time `json:"last_run"`
+		DurationMs  int64     `json:"duration_ms"`
+		Status      int       `json:"status"`
+		LastSuccess time.Time `json:"last_success"`
+		Overdue     bool      `json:"overdue"`
+	}
+	now := time.Now()
+	jobs := make([]jobStatus, 0, len(cronJobs))
+	for _, job := range cronJobs {
+		run, err := loadJob(ctx, job.name)
+		if err != nil {
+			log.Warningf(ctx, "(jobs) error loading job %s - %s", job.name, err)
+			http.Error(w, "error loading jobs", http.StatusInternalServerError)
+			return
+		}
+		jobs = append(jobs, jobStatus{
+			Name:        job.name,
+			IntervalSec: int64(job.interval / time.Second),
+			LastRun:     run.LastRun,
+			DurationMs:  run.DurationMs,
+			Status:      run.Status,
+			LastSuccess: run.LastSuccess,
+			Overdue:     jobOverdue(job, run, now),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(jobs); err != nil {
+		log.Warningf(ctx, "(jobs) error writing - %s", err)
+	}
+} // }}}
+
+// func describeJobTime {{{
+
+// Human readable time of a job run.
+func describeJobTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.In(timezone).Format(dateFormat)
+} // }}}
+
+// func describeInterval {{{
+
+// Human readable interval of a job.
+func describeInterval(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
+} // }}}
@@ -0,0 +1,258 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"github.com/fladz/slack-oncall-command/oncall"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// Limit on who serves primary on-call of a team, ie. "rest", "cap" or "streak". Reordering
+// the list is refused if it breaks a limit set, and scheduled handoffs breaking
+// one are flagged to the managers.
+type primaryLimit struct {
+	// Operation setting the limit.
+	op string
+	// Setting of the team, 0 if the limit isn't set.
+	value func(r *oncallProperty) *int
+	// Start of the primary shifts the limit looks back at from the time, and
+	// end of the handoffs checked ahead.
+	window func(n int, now time.Time) (since, until time.Time)
+	// Who breaks the limit first over the walk, and why.
+	breach func(n int, w primaryWalk) (primaryStart, string)
+	// What the limit makes members do, ie. "rest at least 2 day(s) between
+	// primary shifts", and what the team has without it.
+	rule func(n int) string
+	none string
+	// History detail of setting the limit, 0 to remove it.
+	detail func(n int) string
+	// Warning given when setting the limit, empty if none.
+	warn func(r *oncallProperty) string
+}
+
+// Every limit, in the order they're checked.
+var primaryLimits = []primaryLimit{restLimit, capLimit, streakLimit}
+
+// Member starting to serve primary on-call.
+type primaryStart struct {
+	member oncall.Member
+	at     time.Time
+	// Who was primary until then, empty if nobody or not known.
+	relieved string
+	// When the next one starts, or the end of the walk if nobody does.
+	end time.Time
+}
+
+// Primaries of a team over a window, as the limits check them.
+type primaryWalk struct {
+	// Shifts served from the start of the window until now, keyed by user id.
+	served map[string]servedShifts
+	// Primary now staying on until the first start, if any.
+	current *oncall.Member
+	// Members starting primary from now until the end of the window.
+	starts     []primaryStart
+	now, until time.Time
+}
+
+// func setLimit {{{
+
+// Set the limit of the team to n, 0 to remove it, or display it if show.
+func setLimit(ctx context.Context, l primaryLimit, team string, by opRequestor, n int, show bool) slackResponse {
+	res := slackResponse{}
+	// Shifts the new limit looks back at, loaded before taking oncallMut.
+	now := time.Now()
+	var entries []*historyProperty
+	var err error
+	if n > 0 {
+		since, _ := l.window(n, now)
+		entries, err = loadHistoryFrom(ctx, team, since)
+	}
+	oncallMut.Lock()
+	current := findRotation(team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", team, humanErrorEmoji)
+		return res
+	}
+	if show {
+		if v := *l.value(current); v == 0 {
+			res.Text = fmt.Sprintf("%s has %s", team, l.none)
+		} else {
+			res.Text = fmt.Sprintf("Members of %s %s", team, l.rule(v))
+		}
+		oncallMut.Unlock()
+		return res
+	}
+
+	previous := *current
+	*l.value(current) = n
+	current.Updated = now
+	current.UpdatedBy = by.name
+	if err := resetState(ctx, current); err != nil {
+		log.Warningf(ctx, "(%s) error saving state - %s", l.op, err)
+		*current = previous
+		oncallMut.Unlock()
+		res.Text = errorExternal
+		return res
+	}
+	recordHistory(ctx, team, l.op, by.name, l.detail(n), current.Rotations)
+
+	res.Text = fmt.Sprintf("Success! Members of %s now %s", team, l.rule(n))
+	if n == 0 {
+		res.Text = fmt.Sprintf("Success! %s has %s anymore", team, l.none)
+	} else if warning := l.warn(current); warning != "" {
+		res.Text += fmt.Sprintf("\nHeads up! %s %s", warning, humanErrorEmoji)
+	} else if err != nil {
+		log.Warningf(ctx, "(%s) error loading history - %s", l.op, err)
+	} else if violation := limitViolation(l, current, current.Rotations, entries, now); violation != "" {
+		res.Text += fmt.Sprintf("\nHeads up! The current list breaks it, %s %s", violation, humanErrorEmoji)
+	}
+	oncallMut.Unlock()
+	return res
+} // }}}
+
+// func limitsSince {{{
+
+// Return the start of the primary shifts the limits set on the team look back
+// at from the time. False if the team has none.
+// Caller must hold oncallMut.
+func limitsSince(r *oncallProperty, now time.Time) (since time.Time, ok bool) {
+	for _, l := range primaryLimits {
+		if n := *l.value(r); n > 0 {
+			s, _ := l.window(n, now)
+			if !ok || s.Before(since) {
+				since, ok = s, true
+			}
+		}
+	}
+	return
+} // }}}
+
+// func loadLimitHistory {{{
+
+// Load the history checkLimits takes for the operation on the team, before the
+// caller takes oncallMut so Datastore isn't queried while holding it. nil is
+// returned if the team has no limits or the operation doesn't reorder.
+func loadLimitHistory(ctx context.Context, op, team string, now time.Time) ([]*historyProperty, error) {
+	if !stringInSlice(op, reorderOperations) {
+		return nil, nil
+	}
+	oncallMut.RLock()
+	var since time.Time
+	var ok bool
+	if r := findRotation(team); r != nil {
+		since, ok = limitsSince(r, now)
+	}
+	oncallMut.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return loadHistoryFrom(ctx, team, since)
+} // }}}
+
+// func checkLimits {{{
+
+// Check if the operation reordering the list of the team into the one given
+// keeps every limit set on it, with the history loaded by loadLimitHistory.
+// Empty string is returned if the operation can go ahead.
+// Caller must hold oncallMut.
+func checkLimits(op string, r *oncallProperty, rotations []RotationProperty, entries []*historyProperty) string {
+	if !stringInSlice(op, reorderOperations) {
+		return ""
+	}
+	now := time.Now()
+	for _, l := range primaryLimits {
+		n := *l.value(r)
+		if n == 0 {
+			continue
+		}
+		if violation := limitViolation(l, r, rotations, entries, now); violation != "" {
+			return fmt.Sprintf("Sorry, members of %s %s and %s %s", r.Team, l.rule(n), violation, humanErrorEmoji)
+		}
+	}
+	return ""
+} // }}}
+
+// func limitViolation {{{
+
+// Check if the team with the list would break the limit, now or at the handoffs
+// of the cadence within its window. Past shifts are taken from the history as
+// loadHistoryFrom returns it, from the start of the window or before.
+// Empty string is returned if nobody would, otherwise who and when.
+// Caller must hold oncallMut.
+func limitViolation(l primaryLimit, r *oncallProperty, rotations []RotationProperty, entries []*historyProperty, now time.Time) string {
+	n := *l.value(r)
+	if n == 0 {
+		return ""
+	}
+	since, until := l.window(n, now)
+	w := upcomingPrimaries(r, rotations, now, until)
+	w.served = primaryShifts(r, entries, since, now)
+	s, why := l.breach(n, w)
+	if why == "" {
+		return ""
+	}
+	return fmt.Sprintf("<@%s|%s> would be primary %s, %s", s.member.ID, s.member.Name, describeRestTime(s.at, now), why)
+} // }}}
+
+// func handoffViolations {{{
+
+// Return a heads-up per limit of the team the member starting primary at the
+// handoff breaks, until the next one, with the history as limitViolation takes
+// it, and the state of the team before the handoff.
+// Caller must hold oncallMut.
+func handoffViolations(r *oncallProperty, entries []*historyProperty, start primaryStart) string {
+	var str string
+	for _, l := range primaryLimits {
+		n := *l.value(r)
+		if n == 0 {
+			continue
+		}
+		since, _ := l.window(n, start.at)
+		w := primaryWalk{served: primaryShifts(r, entries, since, start.at), starts: []primaryStart{start}, now: start.at, until: start.end}
+		if _, why := l.breach(n, w); why != "" {
+			str += fmt.Sprintf(" Heads up, <@%s|%s> is primary %s, members of %s %s.", start.member.ID, start.member.Name, why, r.Team, l.rule(n))
+		}
+	}
+	return str
+} // }}}
+
+// func upcomingPrimaries {{{
+
+// Return who would start primary on-call of the team with the list, now and at
+// each handoff of the cadence until the time, after the current primary. Teams
+// rotated manually are only walked until now, nobody knows when they'll rotate.
+// Shifts served aren't filled in.
+// Caller must hold oncallMut.
+func upcomingPrimaries(r *oncallProperty, rotations []RotationProperty, now, until time.Time) primaryWalk {
+	if r.Cadence == "" {
+		until = now
+	}
+	w := primaryWalk{now: now, until: until}
+	var primary string
+	if u, ok := memberByRole(r, rolePrimary); ok {
+		primary = u.Id
+		m := engineMember(u)
+		w.current = &m
+	}
+	planned := *r
+	planned.Rotations = rotations
+	team := engineTeam(&planned)
+	moments := []time.Time{now}
+	for k := cadenceAdvances(&planned, now) + 1; planned.Cadence != "" && handoffTime(&planned, k).Before(until); k++ {
+		moments = append(moments, handoffTime(&planned, k))
+	}
+	for _, t := range moments {
+		m := oncall.Resolve(team, t).Primary
+		if m == nil || m.ID == primary {
+			continue
+		}
+		if n := len(w.starts); n > 0 {
+			w.starts[n-1].end = t
+		}
+		w.starts = append(w.starts, primaryStart{member: *m, at: t, relieved: primary, end: until})
+		primary = m.ID
+	}
+	return w
+} // }}}
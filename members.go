@@ -0,0 +1,243 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// func membersCronHandler {{{
+
+// Cron handler looking up users deleted or deactivated in Slack, which are then
+// offboarded from every team by offboardTaskHandler. The report of it goes to
+// "alert_channel".
+func membersCronHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	// Only AppEngine cron is allowed to call this.
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		log.Warningf(ctx, "(cron) request not from cron")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := ensureState(ctx); err != nil {
+		http.Error(w, "error loading state", http.StatusInternalServerError)
+		return
+	}
+
+	// Users of every team, looked up outside of the lock.
+	ids := map[string]bool{}
+	oncallMut.RLock()
+	for _, t := range rotations {
+		for _, u := range t.Rotations {
+			ids[u.Id] = true
+			if u.FallbackId != "" {
+				ids[u.FallbackId] = true
+			}
+		}
+		for _, m := range t.Managers {
+			ids[m.Id] = true
+		}
+	}
+	oncallMut.RUnlock()
+
+	var gone []string
+	for id := range ids {
+		// Forced, so deactivated users don't linger in the cache.
+		user, err := getSlackUserDetail(ctx, id, true)
+		if err != nil {
+			log.Warningf(ctx, "(cron) error getting user %s - %s", id, err)
+			continue
+		}
+		if user == nil {
+			gone = append(gone, id)
+		}
+	}
+	if len(gone) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err := queueOffboard(ctx, gone, opRequestor{}); err != nil {
+		log.Warningf(ctx, "(cron) error queueing offboarding - %s", err)
+		http.Error(w, "error queueing offboarding", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+} // }}}
+
+// func adminOffboard {{{
+
+// admin offboard {@slackusername}
+//
+// Remove the user from the on-call list, managers and fallbacks of every team in
+// the background, the requestor gets a DM report once it's done.
+func adminOffboard(ctx context.Context, p opAdmin) slackResponse {
+	if err := queueOffboard(ctx, []string{p.userId}, p.by); err != nil {
+		log.Warningf(ctx, "(admin) error queueing offboarding - %s", err)
+		return slackResponse{Text: errorExternal}
+	}
+	return slackResponse{Text: fmt.Sprintf("Offboarding <@%s|%s> from every team, you'll get a DM once it's done", p.userId, p.userName)}
+} // }}}
+
+// func queueOffboard {{{
+
+// Queue offboarding of the users from every team, reported to the requestor by
+// DM, or to "alert_channel" if there's no requestor (ie. users gone from Slack).
+func queueOffboard(ctx context.Context, ids []string, by opRequestor) error {
+	params := url.Values{"user": ids}
+	params.Set("requester_id", by.id)
+	params.Set("requester_name", by.name)
+	_, err := taskqueue.Add(ctx, taskqueue.NewPOSTTask(offboardTaskPath, params), "")
+	return err
+} // }}}
+
+// func offboardTaskHandler {{{
+
+// POST /tasks/offboard
+//
+// Task removing the users from the on-call lists, managers and fallbacks of every
+// team, queued by queueOffboard. The managers left are told by DM, the change is
+// recorded in the history of each team, and a report of the teams changed and
+// those which failed is sent to the requestor.
+func offboardTaskHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	// Only AppEngine task queue is allowed to call this.
+	if r.Header.Get("X-Appengine-Queuename") == "" {
+		log.Warningf(ctx, "(offboard) request not from task queue")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := ensureState(ctx); err != nil {
+		http.Error(w, "error loading state", http.StatusInternalServerError)
+		return
+	}
+	by := opRequestor{id: r.PostForm.Get("requester_id"), name: r.PostForm.Get("requester_name")}
+	gone := map[string]bool{}
+	for _, id := range r.PostForm["user"] {
+		gone[id] = true
+	}
+
+	changed, failed := offboardUsers(ctx, gone, by)
+	report := describeOffboard(ctx, changed, failed)
+	if by.id != "" {
+		postDM(ctx, by.id, report)
+	} else if alertChannel != "" {
+		postDM(ctx, alertChannel, report)
+	}
+	// Teams which failed are in the report, retrying would offboard from the others twice.
+	w.WriteHeader(http.StatusOK)
+} // }}}
+
+// Users removed from a team by offboardUsers.
+type offboarded struct {
+	team     string
+	removed  []string
+	managers []string
+}
+
+// func offboardUsers {{{
+
+// Remove the users from the on-call list, managers and fallbacks of every team,
+// and tell the managers left. Return the teams changed, and those which failed.
+func offboardUsers(ctx context.Context, gone map[string]bool, by opRequestor) (changed []offboarded, failed []string) {
+	who, detail := "cron", "removed users no longer in Slack: "
+	if by.id != "" {
+		who, detail = by.name, "offboarded: "
+	}
+	now := time.Now()
+	oncallMut.Lock()
+	for _, t := range rotations {
+		rm := offboarded{team: t.Team}
+		seen := map[string]bool{}
+		note := func(id, name string) {
+			if !seen[id] {
+				seen[id] = true
+				rm.removed = append(rm.removed, fmt.Sprintf("<@%s|%s>", id, name))
+			}
+		}
+		var members []RotationProperty
+		for _, u := range t.Rotations {
+			if gone[u.Id] {
+				note(u.Id, u.Name)
+				continue
+			}
+			if gone[u.FallbackId] {
+				note(u.FallbackId, u.FallbackName)
+				u.FallbackId, u.FallbackName = "", ""
+			}
+			members = append(members, u)
+		}
+		var managers []ManagerProperty
+		for _, m := range t.Managers {
+			if gone[m.Id] {
+				note(m.Id, m.Name)
+				continue
+			}
+			managers = append(managers, m)
+			rm.managers = append(rm.managers, m.Id)
+		}
+		if rm.removed == nil {
+			continue
+		}
+
+		previous := *t
+		t.Rotations = members
+		t.Managers = managers
+		t.Updated = now
+		t.UpdatedBy = who
+		// Undoing an earlier change would bring the users back, it's dropped.
+		if err := resetState(ctx, t); err != nil {
+			log.Warningf(ctx, "(offboard) error saving state of %s - %s", t.Team, err)
+			*t = previous
+			failed = append(failed, t.Team)
+			continue
+		}
+		recordHistory(ctx, t.Team, "reconcile", who, detail+strings.Join(rm.removed, ", "), t.Rotations)
+		changed = append(changed, rm)
+	}
+	oncallMut.Unlock()
+
+	// Tell the managers outside of the lock.
+	for _, rm := range changed {
+		verb := "have"
+		if len(rm.removed) == 1 {
+			verb = "has"
+		}
+		text := fmt.Sprintf("%s left Slack and %s been removed from %s. Check `%s list %s` still has enough people on-call.",
+			strings.Join(rm.removed, ", "), verb, rm.team, commandName(ctx), rm.team)
+		if by.id != "" {
+			text = fmt.Sprintf("%s %s been offboarded from %s by <@%s|%s>. Check `%s list %s` still has enough people on-call.",
+				strings.Join(rm.removed, ", "), verb, rm.team, by.id, by.name, commandName(ctx), rm.team)
+		}
+		for _, id := range rm.managers {
+			postDM(ctx, id, text)
+		}
+	}
+	return
+} // }}}
+
+// func describeOffboard {{{
+
+// Human readable report of an offboarding, a line per team changed or failed.
+func describeOffboard(ctx context.Context, changed []offboarded, failed []string) string {
+	if len(changed) == 0 && len(failed) == 0 {
+		return "Offboarding is done, no team had them."
+	}
+	str := []string{fmt.Sprintf("Offboarding is done, %d team(s) changed and %d failed:", len(changed), len(failed))}
+	for _, rm := range changed {
+		str = append(str, fmt.Sprintf("%s: removed %s", rm.team, strings.Join(rm.removed, ", ")))
+	}
+	for _, team := range failed {
+		str = append(str, fmt.Sprintf("%s: %s failed, check `%s list %s` and run it again", team, externalErrorEmoji, commandName(ctx), team))
+	}
+	return strings.Join(str, "\n")
+} // }}}
@@ -1,13 +1,17 @@
 package slackoncallbot
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/fladz/slack-oncall-command/oncall"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/log"
+	"html"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // func loadConfiguration {{{
@@ -21,6 +25,19 @@ func loadConfiguration() {
 	}
 	slackCommandToken = os.Getenv("slack_command_token")
 	slackAPIToken = os.Getenv("slack_api_token")
+	homeTeamId = os.Getenv("slack_team_id")
+	// Tokens of other orgs as "id:token", comma separated.
+	if tmp = os.Getenv("slack_org_tokens"); tmp != "" {
+		for _, s := range strings.Split(tmp, ",") {
+			if kv := strings.SplitN(strings.TrimSpace(s), ":", 2); len(kv) == 2 && kv[0] != "" && kv[1] != "" {
+				orgTokens = append(orgTokens, orgToken{id: kv[0], token: kv[1]})
+			}
+		}
+	}
+	// Talk to Slack directly with the shared transport if configured.
+	if tmp = os.Getenv("slack_transport"); strings.ToLower(tmp) == "direct" {
+		directTransport = true
+	}
 	// Update command endpoint if defined.
 	if tmp = os.Getenv("command_endpoint"); tmp != "" {
 		command = tmp
@@ -33,6 +50,42 @@ func loadConfiguration() {
 		// Invalid timeout, use default.
 		opTimeout = time.Duration(3 * time.Second)
 	}
+	// Latency to start degrading at, default one third of the operation timeout.
+	if tmp = os.Getenv("degrade_latency"); tmp == "" {
+		degradeLatency = opTimeout / 3
+	} else if degradeLatency, err = time.ParseDuration(tmp); err != nil {
+		degradeLatency = opTimeout / 3
+	}
+	// Slack API calls per minute shared by all features, 0 to disable.
+	perMinute := 100
+	if tmp = os.Getenv("slack_rate_limit"); tmp != "" {
+		if perMinute, err = strconv.Atoi(tmp); err != nil || perMinute < 0 {
+			perMinute = 100
+		}
+	}
+	if perMinute > 0 {
+		slackLimiter = newTokenBucket(perMinute)
+	}
+	// Number of changes to display in history.
+	if historySize, err = strconv.Atoi(os.Getenv("history_size")); err != nil || historySize < 1 {
+		historySize = 10
+	}
+	// Preview reorders before applying them. Requires interactive components.
+	if tmp = os.Getenv("confirm_reorder"); strings.ToLower(tmp) == "true" {
+		confirmReorder = true
+	}
+	// Monthly phone audit, disabled unless set.
+	if phoneAuditMonths, err = strconv.Atoi(os.Getenv("phone_audit_months")); err != nil || phoneAuditMonths < 0 {
+		phoneAuditMonths = -1
+	}
+	// How long before scheduled handoffs to remind the primaries, 0 to disable.
+	if tmp = os.Getenv("handoff_reminder"); tmp != "" {
+		if handoffReminder, err = time.ParseDuration(tmp); err != nil || handoffReminder < 0 {
+			handoffReminder = 0
+		}
+	}
+	// Custom profile field to read phones from, if the standard one isn't used.
+	phoneField = strings.TrimSpace(os.Getenv("phone_field"))
 	// Update user cache timeout if defined.
 	if tmp = os.Getenv("user_cache_timeout"); tmp == "" {
 		tmp = "1d"
@@ -65,6 +118,33 @@ func loadConfiguration() {
 	} else {
 		adminFullName = "@admins"
 	}
+	// Wallboard is only served if a token is configured.
+	wallboardToken = os.Getenv("wallboard_token")
+	// Identities are only served if their own token is configured.
+	identitiesToken = os.Getenv("identities_token")
+	healthChannel = os.Getenv("health_channel")
+	alertChannel = os.Getenv("alert_channel")
+	// Commands are only recorded if a retention is set.
+	if commandLogDays, err = strconv.Atoi(os.Getenv("command_log_days")); err != nil || commandLogDays < 0 {
+		commandLogDays = 0
+	}
+	// Long responses are split into pages, unless set to "0".
+	responseMaxLines = defaultMaxLines
+	if tmp = os.Getenv("response_max_lines"); tmp != "" {
+		if responseMaxLines, err = strconv.Atoi(tmp); err != nil || responseMaxLines < 0 {
+			responseMaxLines = defaultMaxLines
+		}
+	}
+	publicURL = strings.TrimRight(os.Getenv("public_url"), "/")
+	// Teams migrated to Block Kit, comma separated or "all".
+	if tmp = os.Getenv("block_kit"); tmp != "" {
+		blockKitTeams = map[string]bool{}
+		for _, s := range strings.Split(tmp, ",") {
+			if s = strings.ToUpper(strings.TrimSpace(s)); s != "" {
+				blockKitTeams[s] = true
+			}
+		}
+	}
 	// For fun - use custom emoji's if configured.
 	if tmp = os.Getenv("input_error_emoji"); tmp != "" {
 		humanErrorEmoji = tmp
@@ -88,16 +168,75 @@ func setErrorText() {
 
 // func setHelpText {{{
 
-// Create static help text for each operation.
+// Create help text templates for each operation.
+// "{command}" is replaced by the slash command of each request when displayed.
 func setHelpText() {
-	helpList = fmt.Sprintf("`%s list`\n\tDisplay list of teams and their managers\n`%s list {team}`\n\tDisplay on-call list for _team_", command, command)
-	helpAdd = fmt.Sprintf("`%s add {team} {@slackusername} {label}`\n\tAdd _@slackusername_ to on-call list for _team_ with optional _label_", command)
-	helpFlush = fmt.Sprintf("`%s flush {team}`\n\tFlush the entire on-call list for _team_", command)
-	helpRemove = fmt.Sprintf("`%s remove {team} {@slackusername}`\n\tRemove _@slackusername_ from on-call list for _team_", command)
-	helpSwap = fmt.Sprintf("`%s swap {team} {position_a} {position_b}`\n\tSwap _position_a_ and _position_b_ in the on-call list for _team_", command)
-	helpRegister = fmt.Sprintf("`%s register {team} {@slackusername}`\n\tRegister a new _team_ with _@slackusername_ as it's manager", command)
-	helpUnregister = fmt.Sprintf("`%s unregister {team} {@slackusername}`\n\tUnregister _team_ from oncall command, or remove _@slackusername_ from _team_ manager list", command)
-	helpUpdate = fmt.Sprintf("`%s update`\n\tUpdate your Slack profile", command)
+	helpList = "`{command} list`\n\tDisplay list of teams and their managers\n`{command} list {team}`\n\tDisplay on-call list for _team_\n`{command} list all`\n\tDisplay on-call lists of all teams"
+	helpNext = "`{command} next {team} {role}`\n\tDisplay only the primary (or _role_ - primary/secondary) on-call for _team_ (also `{command} who {team}`)"
+	helpAdd = "`{command} add {team} {@slackusername} {label} {at} {position} {--shadow}`\n\tAdd _@slackusername_ to on-call list for _team_ with optional _label_, at the end or at optional _position_ (`at` _position_ if _label_ ends with a number). With `--shadow` the user is listed as a trainee but never on-call"
+	helpFlush = "`{command} flush {team}`\n\tFlush the entire on-call list for _team_"
+	helpRemove = "`{command} remove {team} {@slackusername|position} {label=label}`\n\tRemove _@slackusername_ (only if labeled _label_), or whoever is at _position_, from on-call list for _team_. Positions like `db:2` count only members labeled `db`"
+	helpSwap = "`{command} swap {team} {position_a} {position_b}`\n\tSwap _position_a_ and _position_b_ in the on-call list for _team_. Positions like `db:2` count only members labeled `db`"
+	helpRegister = "`{command} register {team} {@slackusername}`\n\tRegister a new _team_ with _@slackusername_ as it's manager"
+	helpUnregister = "`{command} unregister {team} {@slackusername}`\n\tUnregister _team_ from oncall command, or remove _@slackusername_ from _team_ manager list"
+	helpRename = "`{command} rename {team} {newname}`\n\tRename _team_ to _newname_, keeping its on-call list, managers and history"
+	helpSetphone = "`{command} setphone {@slackusername} {number}`\n\tSet phone number of _@slackusername_ shown when their Slack profile has none, omit _number_ to clear"
+	helpPermit = "`{command} permit {team}`\n\tDisplay roles required for operations on _team_ other than the default\n`{command} permit {team} {operation} {everyone|member|manager|superuser|default} {--shadow}`\n\tRequire the role to run _operation_ on _team_, ie. let members `flush` a sandbox team. With `--shadow` what the role would allow or deny is only logged for a week before it's enforced"
+	helpRestrict = "`{command} restrict {team} {#channel} ...`\n\tAllow changes to _team_ only from the channels, the first one gets handoffs and gap alerts\n`{command} restrict {team} off`\n\tAllow changes to _team_ from any channel"
+	helpAlias = "`{command} alias {team} {alias}`\n\tLet _team_ be called _alias_ as well, omit _alias_ to show current aliases\n`{command} unalias {team} {alias}`\n\tRemove _alias_ from _team_"
+	helpUpdate = "`{command} update`\n\tUpdate your Slack profile"
+	helpLinkMe = "`{command} link-me {pagerduty|github} {email|handle}`\n\tLink your PagerDuty email or GitHub handle, so integrations can find you. `off` unlinks it, no parameters show your links"
+	helpWhoami = "`{command} whoami`\n\tDisplay teams you are in the on-call list of, or manage"
+	helpRotate = "`{command} rotate {team}`\n\tMove position 1 to the end of the on-call list for _team_, everyone else moves up"
+	helpMove = "`{command} move {team} {from} {to}`\n\tMove the member at position _from_ to position _to_ in the on-call list for _team_"
+	helpCopy = "`{command} copy {source_team} {team} {managers}`\n\tReplace on-call list for _team_ with a copy of the one for _source_team_, add `managers` to copy its managers as well"
+	helpOverride = "`{command} override {team} {@slackusername} {until}`\n\tLet _@slackusername_ cover primary on-call of _team_ until _until_ (`YYYY-MM-DD HH:MM`, or a duration like `12h`/`3d`) without changing the list\n`{command} override {team} {@slackusername} {start} {end}`\n\tSchedule _@slackusername_ to cover primary on-call of _team_ from _start_ to _end_ (`YYYY-MM-DDTHH:MM`)\n`{command} override {team} off`\n\tEnd or cancel the override"
+	helpQuiet = "`{command} quiet {team} {HH:MM-HH:MM} {secondary|label}`\n\tHand primary on-call of _team_ to the secondary, or the first member labeled _label_, between the times every day, ie. overnight. `off` removes it, no times show the current ones"
+	helpAt = "`{command} at {team} {YYYY-MM-DD HH:MM}`\n\tShow who was primary and secondary on-call of _team_ at the time, ie. for incident reviews. A weekday and time like `tue 03:00` means the last one"
+	helpCover = "`{command} cover-needed {team} {from} {to}`\n\tPost an offer to the channel of _team_ for someone to cover your primary on-call from _from_ to _to_ (`YYYY-MM-DD` or `YYYY-MM-DDTHH:MM`), the first member taking it gets an override"
+	helpRest = "`{command} rest {team} {days}`\n\tRequire members of _team_ to rest _days_ (or weeks, ie. `2w`) after a primary shift before serving primary again, reordering the list is refused if it would break it. `off` removes it, no days shows the current minimum"
+	helpCap = "`{command} cap {team} {shifts}`\n\tLimit members of _team_ to _shifts_ primary shifts a month, reordering the list is refused if it would go over it and `stats` shows the shifts left. `off` removes it, no shifts shows the current cap"
+	helpStreak = "`{command} streak {team} {days}`\n\tLimit members of _team_ to _days_ (or weeks, ie. `1w`) in a row as primary, reordering the list is refused if it would break it. `off` removes it, no days shows the current limit"
+	helpPlan = "`{command} plan {team} flush {YYYY-MM-DD} {HH:MM}`\n\tFlush the on-call list for _team_ at the time\n`{command} plan {team} copy {source_team} {YYYY-MM-DD} {HH:MM}`\n\tReplace the on-call list for _team_ with the one of _source_team_ as it is at the time, ie. a team staged for a reorganization\n`{command} plan {team} cancel`\n\tCancel the planned change, no more parameters show it"
+	helpOnboard = "`{command} onboard {team} {shifts}`\n\tAdd new members of _team_ as shadows paired with the primary for their first _shifts_ scheduled handoffs, then put them in the rotation. `off` stops it, no shifts shows the current setting"
+	helpHandoff = "`{command} handoff {team} {accept|decline|resume}`\n\tAccept or decline the scheduled handoff of _team_ to you. Declining pauses handoffs and tells the managers, `resume` restarts them"
+	helpDigest = "`{command} digest {team} {#channel}`\n\tPost this week's on-call of _team_ to _#channel_ every Monday. `off` stops it, no channel shows the current one"
+	helpShift = "`{command} shift {team}`\n\tDisplay shifts of _team_\n`{command} shift {team} {label} {HH:MM-HH:MM}`\n\tOnly put members labeled _label_ on duty between the times, ie. for follow-the-sun regions\n`{command} shift {team} {label} off`\n\tRemove the shift of _label_"
+	helpAssign = "`{command} assign {team}`\n\tDisplay dated assignments of _team_\n`{command} assign {team} {@slackusername} {YYYY-MM-DD..YYYY-MM-DD}`\n\tPut _@slackusername_ on primary on-call of _team_ from the first to the last day, over the on-call list\n`{command} assign {team} {@slackusername} off`\n\tRemove upcoming assignments of _@slackusername_"
+	helpAway = "`{command} away {team} {@slackusername} {until}`\n\tMark _@slackusername_ away (ie. on vacation) until _until_ (`YYYY-MM-DD HH:MM`, or a duration like `12h`/`3d`), skipping them when rotating, paging and picking who's on-call for _team_\n`{command} away {team} {@slackusername} off`\n\tMark _@slackusername_ back"
+	helpFallback = "`{command} fallback {team} {@backup} for {@slackusername}`\n\tLet _@backup_ cover _@slackusername_ of on-call list for _team_ while they are away, and page _@backup_ before the managers when they are not reachable\n`{command} fallback {team} off for {@slackusername}`\n\tClear the fallback of _@slackusername_"
+	helpFairness = "`{command} fairness {team} {months}`\n\tDisplay how many primary shifts each member of _team_ served over the last _months_ (default 3)\n`{command} fairness {team} {months} rebalance`\n\tPropose an on-call list putting the members who served least next, to confirm before it's applied"
+	helpStats = "`{command} stats {team}`\n\tDisplay size, managers, last update, members without phone and recent changes of _team_"
+	helpFlushMgr = "`{command} flush-managers {team}`\n\tRemove every manager of _team_, keeping its on-call list"
+	helpPromote = "`{command} promote {team} {@slackusername}`\n\tMake _@slackusername_, a member of on-call list for _team_, a manager of _team_\n`{command} demote {team} {@slackusername}`\n\tRemove _@slackusername_ from _team_ manager list"
+	helpOpalias = "`{command} opalias {alias} {operation}`\n\tLet _operation_ be run as _alias_ as well (ie. `ls` for `list`), omit both to show current aliases\n`{command} opalias {alias} off`\n\tRemove _alias_"
+	helpDirectory = "`{command} directory`\n\tLink to managers, primary on-call and phones of every team as a printable page or CSV, for when Slack is down"
+	helpAdmin = "`{command} admin jobs`\n\tDisplay last run, duration and result of every background job\n`{command} admin commands`\n\tDisplay recently received commands\n`{command} admin replay {id}`\n\tDecode a received command again as its requestor, running it only if it doesn't change anything\n`{command} admin offboard {@slackusername}`\n\tRemove _@slackusername_ from the on-call list, managers and fallbacks of every team in the background, DMing you a report once it's done"
+	helpBroadcast = "`{command} broadcast-primaries {message}`\n\tDM _message_ to the primary on-call of every team at once, ie. for an org-wide emergency"
+	helpExport = "`{command} export {team}`\n\tDisplay managers and on-call list for _team_ as JSON, for backup or `import`"
+	helpImport = "`{command} import {team} {json}`\n\tReplace managers and on-call list for _team_ with the ones in _json_ (as printed by `export`)"
+	helpDescribe = "`{command} describe {team} {text}`\n\tDescribe what _team_ covers, shown with its on-call list, omit _text_ to clear"
+	helpNote = "`{command} note {team} {text}`\n\tShow _text_ (ie. runbook links) with the on-call list for _team_, omit _text_ to clear"
+	helpLabel = "`{command} label {team} {@slackusername|position} {label}`\n\tChange label of _@slackusername_, or whoever is at _position_, in the on-call list for _team_, omit _label_ to clear"
+	helpShuffle = "`{command} shuffle {team}`\n\tPut the on-call list for _team_ in random order\n`{command} reverse {team}`\n\tReverse the order of the on-call list for _team_"
+	helpCadence = "`{command} cadence {team} {daily|weekly|biweekly} {YYYY-MM-DD} {HH:MM}`\n\tHand off primary on-call of _team_ to the next person daily/weekly/every other week, starting from the date and time\n`{command} cadence {team} off`\n\tStop handing off automatically"
+	helpSimulate = "`{command} simulate {team} {daily|weekly|biweekly} from {YYYY-MM-DD} {HH:MM} {n}`\n\tShow who would be primary on-call of _team_ after each of the next _n_ handoffs (default 13) with the cadence starting from the date, without changing anything"
+	helpSchedule = "`{command} schedule {team} daily {HH:MM} {timezone}`\n`{command} schedule {team} {weekly|biweekly} {day} {HH:MM} {timezone}`\n\tHand off primary on-call of _team_ to the next person at the time (on _day_ for weekly/biweekly), in the optional _timezone_ (ie. `Europe/Berlin`) kept for _team_\n`{command} schedule {team} off`\n\tStop handing off automatically\n`{command} schedule {team} preview {n}`\n\tShow who will be primary on-call of _team_ after each of the next _n_ handoffs (default 13)"
+	helpUndo = "`{command} undo {team}`\n\tRevert the last change made to _team_"
+	helpPage = "`{command} page {team} {message}`\n\tSend _message_ to the primary on-call of _team_ as a DM, managers are notified too if the primary is away"
+	helpHistory = "`{command} history {team}`\n\tDisplay recent changes made to _team_"
+	helpReport = "`{command} report {team}`\n\tDisplay scheduled reports for _team_\n`{command} report {team} daily {HH:MM} to {#channel|@slackusername}`\n`{command} report {team} weekly {day} {HH:MM} to {#channel|@slackusername}`\n\tPost on-call list for _team_ to _#channel_ or _@slackusername_ periodically, the team's channel without `to`\n`{command} report {team} cancel {#channel|@slackusername}`\n\tStop posting reports for _team_ to _#channel_ or _@slackusername_"
+} // }}}
+
+// func commandName {{{
+
+// Return the slash command the request was made with, or the configured one
+// outside of requests.
+func commandName(ctx context.Context) string {
+	if c, ok := ctx.Value(ctxKeyCommand).(string); ok && c != "" {
+		return c
+	}
+	return command
 } // }}}
 
 // func decodeOperationParams {{{
@@ -111,33 +250,172 @@ func decodeOperationParams(ctx context.Context, params slackCommandParams) (stri
 	}
 	req := opRequestor{name: params.UserName, id: params.UserId}
 
-	var op = strings.ToLower(stuff[0])
+	var op = resolveOperation(strings.ToLower(stuff[0]))
+	stuff[0] = op
+	ctx = context.WithValue(ctx, ctxKeyOperation, op)
+	// Text pasted on its own line (ie. "import") follows the team after a newline only.
+	if len(stuff) > 1 {
+		if i := strings.Index(stuff[1], "\n"); i > 0 {
+			stuff = append([]string{stuff[0], stuff[1][:i], stuff[1][i:]}, stuff[2:]...)
+		}
+	}
+	if externalRequest(params) {
+		if errstr := checkExternal(ctx, op, stuff, params); errstr != "" {
+			return op, nil, errstr
+		}
+	}
+	if teamOmitted(op, stuff) {
+		return "pick", opPick{op: op, args: stuff[1:], by: req}, ""
+	}
+	resolveAliases(op, stuff)
+	if errstr := checkChannel(ctx, op, stuff, params.ChannelId); errstr != "" {
+		return op, nil, errstr
+	}
+	if errstr := checkPermission(ctx, op, stuff, req); errstr != "" {
+		return op, nil, errstr
+	}
 	switch op {
 	case "list":
 		return decodeListParams(ctx, stuff)
+	case "next", "who":
+		return decodeNextParams(ctx, stuff)
+	case "history":
+		return decodeHistoryParams(ctx, stuff)
+	case "at":
+		return decodeAtParams(ctx, stuff)
+	case "export":
+		return decodeExportParams(ctx, stuff)
+	case "directory":
+		return decodeDirectoryParams(ctx, req, stuff)
+	case "broadcast-primaries":
+		return decodeBroadcastParams(ctx, req, stuff)
+	case "admin":
+		return decodeAdminParams(ctx, req, stuff)
+	case "stats":
+		return decodeStatsParams(ctx, stuff)
+	case "fairness":
+		return decodeFairnessParams(ctx, req, stuff)
+	case "page":
+		return decodePageParams(ctx, req, stuff)
 	case "add":
 		return decodeAddParams(ctx, req, stuff)
 	case "remove":
 		return decodeRemoveParams(ctx, req, stuff)
 	case "swap":
 		return decodeSwapParams(ctx, req, stuff)
-	case "flush":
+	case "rotate":
+		return decodeRotateParams(ctx, req, stuff)
+	case "move":
+		return decodeMoveParams(ctx, req, stuff)
+	case "cadence":
+		return decodeCadenceParams(ctx, req, stuff)
+	case "schedule":
+		return decodeScheduleParams(ctx, req, stuff)
+	case "simulate":
+		return decodeSimulateParams(ctx, req, stuff)
+	case "copy":
+		return decodeCopyParams(ctx, req, stuff)
+	case "shuffle", "reverse":
+		return decodeShuffleParams(ctx, req, stuff)
+	case "label":
+		return decodeLabelParams(ctx, req, stuff)
+	case "override":
+		return decodeOverrideParams(ctx, req, stuff)
+	case "assign":
+		return decodeAssignParams(ctx, req, stuff)
+	case "shift":
+		return decodeShiftParams(ctx, req, stuff)
+	case "digest":
+		return decodeDigestParams(ctx, req, stuff)
+	case "handoff":
+		return decodeHandoffParams(ctx, req, stuff)
+	case "onboard":
+		return decodeOnboardParams(ctx, req, stuff)
+	case "rest":
+		return decodeRestParams(ctx, req, stuff)
+	case "cap":
+		return decodeCapParams(ctx, req, stuff)
+	case "streak":
+		return decodeStreakParams(ctx, req, stuff)
+	case "plan":
+		return decodePlanParams(ctx, req, stuff)
+	case "cover-needed":
+		return decodeCoverNeededParams(ctx, req, stuff)
+	case "quiet":
+		return decodeQuietParams(ctx, req, stuff)
+	case "away":
+		return decodeAwayParams(ctx, req, stuff)
+	case "fallback":
+		return decodeFallbackParams(ctx, req, stuff)
+	case "note":
+		return decodeNoteParams(ctx, req, stuff)
+	case "describe":
+		return decodeDescribeParams(ctx, req, stuff)
+	case "undo":
+		return decodeUndoParams(ctx, req, stuff)
+	case "flush", "flush-managers":
 		return decodeFlushParams(ctx, req, stuff)
 	case "register":
 		return decodeRegisterParams(ctx, req, stuff)
 	case "unregister":
 		return decodeUnregisterParams(ctx, req, stuff)
+	case "rename":
+		return decodeRenameParams(ctx, req, stuff)
+	case "import":
+		return decodeImportParams(ctx, req, stuff)
+	case "permit":
+		return decodePermitParams(ctx, req, stuff)
+	case "restrict":
+		return decodeRestrictParams(ctx, req, stuff)
+	case "alias", "unalias":
+		return decodeAliasParams(ctx, req, stuff)
+	case "opalias":
+		return decodeOpaliasParams(ctx, req, stuff)
+	case "promote", "demote":
+		return decodePromoteParams(ctx, req, stuff)
 	case "update":
 		return decodeUpdateParams(ctx, req)
+	case "setphone":
+		return decodeSetphoneParams(ctx, req, stuff)
+	case "whoami":
+		return "whoami", opWhoami{id: req.id, name: req.name}, ""
+	case "link-me":
+		return decodeLinkMeParams(ctx, req, stuff)
+	case "report":
+		return decodeReportParams(ctx, req, stuff)
 	}
 
 	// Anything else including unsupported operations, just return help text.
 	return "help", nil, ""
 } // }}}
 
+// func teamOmitted {{{
+
+// Check if a team-scoped operation was issued without the team.
+// The team is treated as missing if nothing follows the operation, or if what
+// follows is a Slack user or a position rather than a team name.
+func teamOmitted(op string, stuff []string) bool {
+	switch op {
+	case "next", "who", "at", "history", "stats", "fairness", "export", "add", "remove", "swap", "move", "rotate", "shuffle", "reverse", "label", "away", "fallback", "override", "assign", "cover-needed", "shift", "quiet", "digest", "onboard", "rest", "cap", "streak", "plan", "handoff", "note", "describe", "cadence", "schedule", "simulate", "promote", "demote", "undo", "flush", "flush-managers", "unregister", "report":
+	default:
+		return false
+	}
+	if len(stuff) == 1 {
+		return true
+	}
+	if strings.HasPrefix(stuff[1], "<@") {
+		return true
+	}
+	if _, err := strconv.Atoi(stuff[1]); err == nil {
+		return true
+	}
+	return false
+} // }}}
+
 // func decodeListParams {{{
 
 // list {team}
+// list all
 //   team - optional
 func decodeListParams(ctx context.Context, stuff []string) (string, interface{}, string) {
 	op := "list"
@@ -148,15 +426,259 @@ func decodeListParams(ctx context.Context, stuff []string) (string, interface{},
 		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
 		return op, opList{}, errorInput
 	}
+	// A team actually called "all" wins.
+	if strings.ToUpper(stuff[1]) == "ALL" && getCurrentRotation("ALL") == nil {
+		return op, opList{all: true}, ""
+	}
 	return op, opList{team: strings.ToUpper(stuff[1])}, ""
 } // }}}
 
+// func decodeNextParams {{{
+
+// next {team} {role}
+// who {team} {role}
+//   team - required
+//   role - optional, primary (default) or secondary
+func decodeNextParams(ctx context.Context, stuff []string) (string, interface{}, string) {
+	op := "next"
+	if len(stuff) < 2 || len(stuff) > 3 {
+		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opNext{team: strings.ToUpper(stuff[1]), role: rolePrimary}
+	if len(stuff) == 3 {
+		values.role = strings.ToLower(stuff[2])
+		if _, ok := rolePositions[values.role]; !ok {
+			log.Warningf(ctx, "(%s) invalid role - %v", op, stuff)
+			return op, nil, errorInput
+		}
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeHistoryParams {{{
+
+// history {team}
+//   team - required
+func decodeHistoryParams(ctx context.Context, stuff []string) (string, interface{}, string) {
+	op := "history"
+	if len(stuff) != 2 {
+		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	return op, opHistory{team: strings.ToUpper(stuff[1])}, ""
+} // }}}
+
+// func decodeAtParams {{{
+
+// at {team} {YYYY-MM-DD HH:MM}
+// at {team} {YYYY-MM-DDTHH:MM}
+// at {team} {weekday} {HH:MM}
+//   team - required
+//   time - required, in the past. A weekday is the last one, today included
+func decodeAtParams(ctx context.Context, stuff []string) (string, interface{}, string) {
+	op := "at"
+	if len(stuff) != 3 && len(stuff) != 4 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opAt{team: strings.ToUpper(stuff[1])}
+	now := time.Now()
+	var err error
+	switch {
+	case len(stuff) == 3:
+		values.at, err = time.ParseInLocation(overrideFormat, stuff[2], timezone)
+	default:
+		if d, ok := decodeWeekday(stuff[2]); ok {
+			h, m := decodeTimeOfDay(stuff[3])
+			if h < 0 {
+				log.Warningf(ctx, "(%s) invalid time %s", op, stuff[3])
+				return op, nil, errorInput
+			}
+			today := now.In(timezone)
+			values.at = time.Date(today.Year(), today.Month(), today.Day()-(int(today.Weekday())-int(d)+7)%7, h, m, 0, 0, timezone)
+			if values.at.After(now) {
+				values.at = values.at.AddDate(0, 0, -7)
+			}
+			break
+		}
+		values.at, err = time.ParseInLocation(dateFormat, stuff[2]+" "+stuff[3], timezone)
+	}
+	if err != nil || values.at.After(now) {
+		log.Warningf(ctx, "(%s) invalid time - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeStatsParams {{{
+
+// stats {team}
+//   team - required
+func decodeStatsParams(ctx context.Context, stuff []string) (string, interface{}, string) {
+	op := "stats"
+	if len(stuff) != 2 {
+		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	return op, opStats{team: strings.ToUpper(stuff[1])}, ""
+} // }}}
+
+// func decodeFairnessParams {{{
+
+// fairness {team} {months} {rebalance}
+//   team      - required
+//   months    - optional, defaultFairnessMonths if omitted
+//   rebalance - optional, propose a reordered list
+//
+// Rebalancing requires manager of the team or superuser permission.
+func decodeFairnessParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "fairness"
+	if len(stuff) < 2 || len(stuff) > 4 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opFairness{team: strings.ToUpper(stuff[1]), months: defaultFairnessMonths, by: r}
+	if strings.ToLower(stuff[len(stuff)-1]) == "rebalance" {
+		values.rebalance = true
+		stuff = stuff[:len(stuff)-1]
+	}
+	if len(stuff) == 3 {
+		n, err := strconv.Atoi(stuff[2])
+		if err != nil || n < 1 || n > maxFairnessMonths {
+			log.Warningf(ctx, "(%s) invalid input %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.months = n
+	} else if len(stuff) > 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	// Rebalancing requires permission.
+	if values.rebalance && !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeExportParams {{{
+
+// export {team}
+//   team - required
+func decodeExportParams(ctx context.Context, stuff []string) (string, interface{}, string) {
+	op := "export"
+	if len(stuff) != 2 {
+		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	return op, opExport{team: strings.ToUpper(stuff[1])}, ""
+} // }}}
+
+// func decodeDirectoryParams {{{
+
+// directory
+//
+// This operation requires superuser permission.
+func decodeDirectoryParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "directory"
+	if len(stuff) != 1 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	if !userIsExempt(ctx, r.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, r.name)
+		return op, nil, errorNoPerm
+	}
+	return op, opDirectory{by: r}, ""
+} // }}}
+
+// func decodeAdminParams {{{
+
+// admin jobs
+// admin commands
+// admin replay {id}
+// admin offboard {@slackusername}
+//   id - required for replay, as shown by "admin commands"
+//   @slackusername - required for offboard
+//
+// This operation requires superuser permission.
+func decodeAdminParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "admin"
+	if len(stuff) < 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opAdmin{view: strings.ToLower(stuff[1]), by: r}
+	switch {
+	case len(stuff) == 2 && (values.view == "jobs" || values.view == "commands"):
+	case len(stuff) == 3 && values.view == "replay":
+		id, err := strconv.ParseInt(stuff[2], 10, 64)
+		if err != nil || id <= 0 {
+			log.Warningf(ctx, "(%s) invalid command id %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.id = id
+	case len(stuff) == 3 && values.view == "offboard":
+		values.userId, values.userName = decodeUserEntity(stuff[2])
+		if values.userId == "" {
+			log.Warningf(ctx, "(%s) invalid user %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+	default:
+		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	if !userIsExempt(ctx, r.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, r.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeBroadcastParams {{{
+
+// broadcast-primaries {message}
+//   message - required
+//
+// This operation requires superuser permission.
+func decodeBroadcastParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "broadcast-primaries"
+	if len(stuff) < 2 {
+		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	if !userIsExempt(ctx, r.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, r.name)
+		return op, nil, errorNoPerm
+	}
+	return op, opBroadcast{message: strings.Join(stuff[1:], " "), by: r}, ""
+} // }}}
+
+// func decodePageParams {{{
+
+// page {team} {message}
+//   team    - required
+//   message - required
+func decodePageParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "page"
+	if len(stuff) < 3 {
+		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	return op, opPage{team: strings.ToUpper(stuff[1]), message: strings.Join(stuff[2:], " "), by: r}, ""
+} // }}}
+
 // func decodeAddParams {{{
 
-// add {team} {@slackusername} {label}
-//   team  - required
-//   name  - required
-//   label - optional
+// add {team} {@slackusername} {label} {position} {--shadow}
+// add {team} {@slackusername} {label} at {position} {--shadow}
+//   team     - required
+//   name     - required
+//   label    - optional
+//   position - optional, numeric last param, or "at" and the number. Added at the end
+//              if not given. With "at" the label itself can end with a number.
+//   --shadow - optional, anywhere after name. Added as a shadow (trainee).
 //
 // This operation requires manager of the team or superuser permission.
 func decodeAddParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
@@ -172,11 +694,39 @@ func decodeAddParams(ctx context.Context, r opRequestor, stuff []string) (string
 		return op, nil, errorInput
 	}
 	values := opAdd{name: name, id: id, team: strings.ToUpper(stuff[1]), by: r}
+	// "--shadow" can be anywhere after the user.
+	for i := 3; i < len(stuff); i++ {
+		if strings.ToLower(stuff[i]) == "--shadow" {
+			values.shadow = true
+			stuff = append(stuff[:i:i], stuff[i+1:]...)
+			break
+		}
+	}
 	// This operation requires some permission.
 	if !userHasPerm(ctx, values.by.id, values.team) {
 		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
 		return op, nil, errorNoPerm
 	}
+	if n := len(stuff); n > 4 && strings.ToLower(stuff[n-2]) == "at" {
+		// Explicit "at {position}", the position must be a number then.
+		in, err := strconv.Atoi(stuff[n-1])
+		if err != nil || in < 1 {
+			log.Warningf(ctx, "(%s) invalid position - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		values.position = in
+		stuff = stuff[:n-2]
+	} else if n > 3 {
+		// Trailing number is the position to add the user at.
+		if in, err := strconv.Atoi(stuff[n-1]); err == nil {
+			if in < 1 {
+				log.Warningf(ctx, "(%s) invalid position - %v", op, stuff)
+				return op, nil, errorInput
+			}
+			values.position = in
+			stuff = stuff[:n-1]
+		}
+	}
 	if len(stuff) > 3 {
 		values.label = strings.ToLower(strings.Join(stuff[3:], " "))
 	}
@@ -185,23 +735,43 @@ func decodeAddParams(ctx context.Context, r opRequestor, stuff []string) (string
 
 // func decodeRemoveParams {{{
 
-// remove {team} {@slackusername}
+// remove {team} {@slackusername} {label=label}
+// remove {team} {label:position}
 //   team - required
-//   name - required
+//   name or position - required, position can be counted within a label ("db:2")
+//   label - optional, only remove the user if the entry has the label
 //
 // This operation requires manager of the team or superuser permission.
 func decodeRemoveParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
 	op := "remove"
-	if len(stuff) != 3 {
+	if len(stuff) != 3 && len(stuff) != 4 {
 		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
 		return op, nil, errorInput
 	}
-	id, name := decodeUserEntity(stuff[2])
-	if id == "" || name == "" {
-		log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
-		return op, nil, errorInput
+	values := opRemove{team: strings.ToUpper(stuff[1]), by: r}
+	if label, pos, ok := decodePosition(stuff[2]); ok {
+		if pos < 1 || len(stuff) != 3 {
+			log.Warningf(ctx, "(%s) invalid position %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.position = pos
+		values.label = label
+	} else {
+		id, name := decodeUserEntity(stuff[2])
+		if id == "" || name == "" {
+			log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.name = name
+		values.id = id
+		if len(stuff) == 4 {
+			if !strings.HasPrefix(strings.ToLower(stuff[3]), "label=") || len(stuff[3]) == len("label=") {
+				log.Warningf(ctx, "(%s) invalid label %s", op, stuff[3])
+				return op, nil, errorInput
+			}
+			values.label = strings.ToLower(stuff[3][len("label="):])
+		}
 	}
-	values := opRemove{name: name, id: id, team: strings.ToUpper(stuff[1]), by: r}
 	// This operation requires permission.
 	if !userHasPerm(ctx, values.by.id, values.team) {
 		log.Warningf(ctx, "(remove) user %s has no perm", values.by.name)
@@ -214,8 +784,8 @@ func decodeRemoveParams(ctx context.Context, r opRequestor, stuff []string) (str
 
 // swap {team} {position_a} {position_b}
 //   team - required
-//   position_a - required
-//   position_b - required
+//   position_a - required, can be counted within a label ("db:1")
+//   position_b - required, can be counted within a label ("db:2")
 //
 // This operation requires manager of the team or superuser permission.
 func decodeSwapParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
@@ -224,39 +794,66 @@ func decodeSwapParams(ctx context.Context, r opRequestor, stuff []string) (strin
 		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
 		return op, nil, errorInput
 	}
+	values := opSwap{team: strings.ToUpper(stuff[1]), by: r}
 	// Make sure the positions are numeric.
-	in, err := strconv.Atoi(stuff[2])
-	if err != nil || in < 1 {
-		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
-		return op, nil, errorInput
-	}
-	values := opSwap{team: strings.ToUpper(stuff[1]), positions: []int{in}, by: r}
-	if in, err = strconv.Atoi(stuff[3]); err != nil || in < 1 {
-		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
-		return op, nil, errorInput
+	for _, s := range stuff[2:] {
+		label, in, ok := decodePosition(s)
+		if !ok || in < 1 {
+			log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		values.positions = append(values.positions, in)
+		values.labels = append(values.labels, label)
 	}
 	// This operation requires permission.
 	if !userHasPerm(ctx, values.by.id, values.team) {
 		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
 		return op, nil, errorNoPerm
 	}
-	values.positions = append(values.positions, in)
 	return op, values, ""
 } // }}}
 
-// func decodeFlushParams {{{
+// func decodePosition {{{
 
-// flush {team}
+// Decode a position in the on-call list, either a number or a number counted
+// within the members with a label ("db:2" - the second member labeled "db").
+func decodePosition(s string) (string, int, bool) {
+	var label string
+	if i := strings.LastIndex(s, ":"); i > 0 {
+		label, s = strings.ToLower(s[:i]), s[i+1:]
+	}
+	in, err := strconv.Atoi(s)
+	if err != nil {
+		return "", 0, false
+	}
+	return label, in, true
+} // }}}
+
+// func decodeMoveParams {{{
+
+// move {team} {from} {to}
 //   team - required
+//   from - required
+//   to   - required
 //
 // This operation requires manager of the team or superuser permission.
-func decodeFlushParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
-	op := "flush"
-	if len(stuff) != 2 {
+func decodeMoveParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "move"
+	if len(stuff) != 4 {
 		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
 		return op, nil, errorInput
 	}
-	values := opFlush{team: strings.ToUpper(stuff[1]), by: r}
+	values := opMove{team: strings.ToUpper(stuff[1]), by: r}
+	var err error
+	// Make sure the positions are numeric.
+	if values.from, err = strconv.Atoi(stuff[2]); err != nil || values.from < 1 {
+		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	if values.to, err = strconv.Atoi(stuff[3]); err != nil || values.to < 1 {
+		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+		return op, nil, errorInput
+	}
 	// This operation requires permission.
 	if !userHasPerm(ctx, values.by.id, values.team) {
 		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
@@ -265,90 +862,2056 @@ func decodeFlushParams(ctx context.Context, r opRequestor, stuff []string) (stri
 	return op, values, ""
 } // }}}
 
-// func decodeRegisterParams {{{
+// func decodeCopyParams {{{
 
-// register {team} {@slackusername}
-//   team - required
-//   name - optional
+// copy {source_team} {team} {managers}
+//   source_team - required
+//   team        - required
+//   managers    - optional
 //
-// This operation requires superuser permission.
-func decodeRegisterParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
-	op := "register"
-	if len(stuff) < 2 || len(stuff) > 3 {
+// This operation requires manager of the team or superuser permission.
+// Copying managers requires superuser permission, same as "register".
+func decodeCopyParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "copy"
+	if len(stuff) < 3 || len(stuff) > 4 {
 		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
 		return op, nil, errorInput
 	}
-	values := opRegister{team: strings.ToUpper(stuff[1]), by: r}
-	if len(stuff) == 3 {
-		// The manager info is given, let's decode.
-		id, name := decodeUserEntity(stuff[2])
-		if id == "" || name == "" {
-			log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+	values := opCopy{source: strings.ToUpper(stuff[1]), team: strings.ToUpper(stuff[2]), by: r}
+	if len(stuff) == 4 {
+		if strings.ToLower(stuff[3]) != "managers" {
+			log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
 			return op, nil, errorInput
 		}
-		values.name = name
-		values.id = id
+		values.managers = true
 	}
-	// This operation requires special permission - only "exempt" users can add a
-	// new team.
-	if !userIsExempt(ctx, values.by.id) {
+	if values.source == values.team {
+		log.Warningf(ctx, "(%s) same team - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	// This operation requires permission.
+	if values.managers && !userIsExempt(ctx, values.by.id) || !userHasPerm(ctx, values.by.id, values.team) {
 		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
 		return op, nil, errorNoPerm
 	}
 	return op, values, ""
 } // }}}
 
-// func decodeUnregisterParams {{{
+// func decodeCadenceParams {{{
 
-// unregister {team} {@slackusername}
-//   team - required
-//   name - optional
+// cadence {team} {daily|weekly|biweekly} {YYYY-MM-DD} {HH:MM}
+// cadence {team} off
+//   team    - required
+//   cadence - required, "bi-weekly" is taken as well
+//   date    - required unless off, first handoff
+//   time    - required unless off, time of handoffs
 //
-// This operation requires superuser permission.
-func decodeUnregisterParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
-	op := "unregister"
-	if len(stuff) < 2 || len(stuff) > 3 {
+// This operation requires manager of the team or superuser permission.
+func decodeCadenceParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "cadence"
+	if len(stuff) < 3 {
 		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
 		return op, nil, errorInput
 	}
-	values := opUnregister{team: strings.ToUpper(stuff[1]), by: r}
-	if len(stuff) == 3 {
-		id, name := decodeUserEntity(stuff[2])
-		if id == "" || name == "" {
-			log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+	values := opCadence{team: strings.ToUpper(stuff[1]), by: r}
+	if c := strings.Replace(strings.ToLower(stuff[2]), "-", "", 1); c != "off" {
+		if oncall.CadenceDays(c) == 0 || len(stuff) != 5 {
+			log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
 			return op, nil, errorInput
 		}
-		values.name = name
-		values.id = id
+		anchor, err := time.ParseInLocation("2006-01-02 15:04", stuff[3]+" "+stuff[4], timezone)
+		if err != nil {
+			log.Warningf(ctx, "(%s) invalid date - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		values.cadence = c
+		values.anchor = anchor
+	} else if len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
 	}
-	// This operation requires special permission - only "exempt" users can remove a
-	// manager from a team.
-	if !userIsExempt(ctx, values.by.id) {
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
 		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
 		return op, nil, errorNoPerm
 	}
 	return op, values, ""
 } // }}}
 
-// func decodeUpdateParams {{{
-//
-// update
+// func decodeSimulateParams {{{
+
+// simulate {team} {daily|weekly|biweekly} from {YYYY-MM-DD} {HH:MM} {n}
+//   team    - required
+//   cadence - required, "rotate-" prefix and "bi-weekly" are taken as well
+//   date    - required, first handoff
+//   time    - optional, the team's handoff time (or midnight) if omitted
+//   n       - optional, number of handoffs to show
 //
-// This operation updates the requested user's Slack information.
+// This operation requires manager of the team or superuser permission.
+func decodeSimulateParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "simulate"
+	if len(stuff) < 5 || len(stuff) > 7 || strings.ToLower(stuff[3]) != "from" {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opSimulate{team: strings.ToUpper(stuff[1]), hour: -1, handoffs: defaultPreview, by: r}
+	c := strings.TrimPrefix(strings.ToLower(stuff[2]), "rotate-")
+	if c = strings.Replace(c, "-", "", 1); oncall.CadenceDays(c) == 0 {
+		log.Warningf(ctx, "(%s) invalid cadence - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values.cadence = c
+	date, err := time.ParseInLocation("2006-01-02", stuff[4], timezone)
+	if err != nil {
+		log.Warningf(ctx, "(%s) invalid date - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values.date = date
+	for _, s := range stuff[5:] {
+		if h, m := decodeTimeOfDay(s); h >= 0 && values.hour < 0 {
+			values.hour, values.minute = h, m
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPreview {
+			log.Warningf(ctx, "(%s) invalid input %s", op, s)
+			return op, nil, errorInput
+		}
+		values.handoffs = n
+	}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeScheduleParams {{{
+
+// schedule {team} daily {HH:MM} {timezone}
+// schedule {team} {weekly|biweekly} {day} {HH:MM} {timezone}
+// schedule {team} off
+// schedule {team} preview {n}
+//   team     - required
+//   cadence  - required, "bi-weekly" is taken as well
+//   day      - required for weekly/biweekly, day of the handoffs
+//   time     - required unless off, time of the handoffs
+//   timezone - optional, IANA name (ie. "Europe/Berlin"), keeps the team's if omitted
+//   n        - optional, number of upcoming handoffs to preview
+//
+// This operation requires manager of the team or superuser permission, except
+// for preview.
+func decodeScheduleParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "schedule"
+	if len(stuff) < 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opCadence{team: strings.ToUpper(stuff[1]), schedule: true, by: r}
+	if strings.ToLower(stuff[2]) == "preview" {
+		values.preview = defaultPreview
+		switch len(stuff) {
+		case 3:
+		case 4:
+			n, err := strconv.Atoi(stuff[3])
+			if err != nil || n < 1 || n > maxPreview {
+				log.Warningf(ctx, "(%s) invalid number of handoffs %s", op, stuff[3])
+				return op, nil, errorInput
+			}
+			values.preview = n
+		default:
+			log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		// Nothing changes, anyone can look.
+		return op, values, ""
+	}
+	if c := strings.Replace(strings.ToLower(stuff[2]), "-", "", 1); c != "off" {
+		if oncall.CadenceDays(c) == 0 {
+			log.Warningf(ctx, "(%s) invalid cadence - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		values.cadence = c
+		i := 3
+		if c != "daily" {
+			if len(stuff) <= i {
+				log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+				return op, nil, errorInput
+			}
+			d, ok := decodeWeekday(stuff[i])
+			if !ok {
+				log.Warningf(ctx, "(%s) invalid day %s", op, stuff[i])
+				return op, nil, errorInput
+			}
+			values.weekday = d
+			i++
+		}
+		if len(stuff) <= i || len(stuff) > i+2 {
+			log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		if values.hour, values.minute = decodeTimeOfDay(stuff[i]); values.hour < 0 {
+			log.Warningf(ctx, "(%s) invalid time %s", op, stuff[i])
+			return op, nil, errorInput
+		}
+		if len(stuff) == i+2 {
+			if _, err := time.LoadLocation(stuff[i+1]); err != nil || strings.ToLower(stuff[i+1]) == "local" {
+				log.Warningf(ctx, "(%s) invalid timezone %s", op, stuff[i+1])
+				return op, nil, errorInput
+			}
+			values.timezone = stuff[i+1]
+		}
+	} else if len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeRotateParams {{{
+
+// rotate {team}
+//   team - required
+//
+// This operation requires manager of the team or superuser permission.
+func decodeRotateParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "rotate"
+	if len(stuff) != 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opRotate{team: strings.ToUpper(stuff[1]), by: r}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeNoteParams {{{
+
+// note {team} {text}
+//   team - required
+//   text - optional, clears the note if omitted
+//
+// This operation requires manager of the team or superuser permission.
+func decodeNoteParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "note"
+	if len(stuff) < 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opNote{team: strings.ToUpper(stuff[1]), text: strings.TrimSpace(strings.Join(stuff[2:], " ")), by: r}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeDescribeParams {{{
+
+// describe {team} {text}
+//   team - required
+//   text - optional, clears the description if omitted
+//
+// This operation requires manager of the team or superuser permission.
+func decodeDescribeParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "describe"
+	if len(stuff) < 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opDescribe{team: strings.ToUpper(stuff[1]), text: strings.TrimSpace(strings.Join(stuff[2:], " ")), by: r}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeOverrideParams {{{
+
+// override {team} {@slackusername} {until}
+// override {team} {@slackusername} {start} {end}
+// override {team} off
+//   team       - required
+//   name       - required unless off
+//   until      - required unless off or scheduled, "YYYY-MM-DD HH:MM" or a duration ("12h", "3d")
+//   start, end - schedule the override ahead instead, "YYYY-MM-DDTHH:MM"
+//
+// This operation requires manager of the team or superuser permission.
+func decodeOverrideParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "override"
+	if len(stuff) < 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opOverride{team: strings.ToUpper(stuff[1]), by: r}
+	if len(stuff) != 3 || strings.ToLower(stuff[2]) != "off" {
+		if values.id, values.name = decodeUserEntity(stuff[2]); values.id == "" || values.name == "" {
+			log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		now := time.Now()
+		if len(stuff) == 5 && strings.Contains(stuff[3], "T") {
+			start, err1 := time.ParseInLocation(overrideFormat, stuff[3], timezone)
+			end, err2 := time.ParseInLocation(overrideFormat, stuff[4], timezone)
+			if err1 != nil || err2 != nil || !end.After(start) || !end.After(now) {
+				log.Warningf(ctx, "(%s) invalid start/end - %v", op, stuff)
+				return op, nil, errorInput
+			}
+			// Already started, it's a plain override.
+			if start.After(now) {
+				values.start = start
+			}
+			values.until = end
+		} else {
+			until, ok := decodeUntil(stuff[3:], now)
+			if !ok {
+				log.Warningf(ctx, "(%s) invalid until - %v", op, stuff)
+				return op, nil, errorInput
+			}
+			values.until = until
+		}
+	}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeQuietParams {{{
+
+// quiet {team} {HH:MM-HH:MM} {secondary|label}
+// quiet {team} off
+//   team   - required
+//   window - optional, show the current quiet hours if omitted
+//   to     - required with window, "secondary" or a member label
+//
+// This operation requires manager of the team or superuser permission.
+func decodeQuietParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "quiet"
+	values := opQuiet{by: r}
+	switch {
+	case len(stuff) == 2:
+		values.show = true
+	case len(stuff) == 3 && strings.ToLower(stuff[2]) == "off":
+	case len(stuff) == 4:
+		window := strings.SplitN(stuff[2], "-", 2)
+		if len(window) != 2 {
+			log.Warningf(ctx, "(%s) invalid window %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		h1, m1 := decodeTimeOfDay(window[0])
+		h2, m2 := decodeTimeOfDay(window[1])
+		if h1 < 0 || h2 < 0 || h1*60+m1 == h2*60+m2 {
+			log.Warningf(ctx, "(%s) invalid window %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.quiet = ShiftProperty{Label: stuff[3], Start: h1*60 + m1, End: h2*60 + m2}
+		if strings.ToLower(stuff[3]) == roleSecondary {
+			values.quiet.Label = roleSecondary
+		}
+	default:
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values.team = strings.ToUpper(stuff[1])
+	// This operation requires permission.
+	if !values.show && !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeDigestParams {{{
+
+// digest {team} {#channel|off}
+//   team    - required
+//   channel - optional, show the current channel if omitted
+//
+// This operation requires manager of the team or superuser permission.
+func decodeDigestParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "digest"
+	if len(stuff) != 2 && len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opDigest{team: strings.ToUpper(stuff[1]), show: len(stuff) == 2, by: r}
+	if len(stuff) == 3 && strings.ToLower(stuff[2]) != "off" {
+		// Channel ids are required, bare "#name" isn't expanded by Slack.
+		id, name := decodeDestination(stuff[2])
+		if !strings.HasPrefix(stuff[2], "<#") || id == "" {
+			log.Warningf(ctx, "(%s) invalid channel %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.channel = ChannelProperty{Id: id, Name: name[1:]}
+	}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeCoverNeededParams {{{
+
+// cover-needed {team} {from} {to}
+// cover-needed {team} take {offer}
+//   team     - required
+//   from, to - required, "YYYY-MM-DDTHH:MM" or a day "YYYY-MM-DD" (to the end of it)
+//   take     - from the button of the offer, with the id it was saved with
+//
+// Offering and taking are up to members of the team, checked by the operation.
+func decodeCoverNeededParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "cover-needed"
+	values := opCoverNeeded{by: r}
+	switch {
+	case len(stuff) == 4:
+		values.team = strings.ToUpper(stuff[1])
+		from, ok1 := decodeCoverTime(stuff[2], false)
+		to, ok2 := decodeCoverTime(stuff[3], true)
+		if !ok1 || !ok2 || !to.After(from) || !to.After(time.Now()) {
+			log.Warningf(ctx, "(%s) invalid from/to - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		values.from, values.to = from, to
+	case len(stuff) == 4 && strings.ToLower(stuff[2]) == "take":
+		values.team = strings.ToUpper(stuff[1])
+		id, err := strconv.ParseInt(stuff[3], 10, 64)
+		if err != nil || id <= 0 {
+			log.Warningf(ctx, "(%s) invalid offer - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		values.take, values.offer = true, id
+	default:
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeCoverTime {{{
+
+// Decode "YYYY-MM-DDTHH:MM" or a day "YYYY-MM-DD", which is taken from its start,
+// or to its end if end is set.
+func decodeCoverTime(s string, end bool) (time.Time, bool) {
+	if t, err := time.ParseInLocation(overrideFormat, s, timezone); err == nil {
+		return t, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, timezone)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, true
+} // }}}
+
+// func decodeRestParams {{{
+
+// rest {team} {days|off}
+//   team - required
+//   days - optional, number of days or weeks ("2w"), show the current minimum if omitted
+//
+// This operation requires manager of the team or superuser permission, except
+// for showing the current minimum.
+func decodeRestParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "rest"
+	if len(stuff) != 2 && len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opRest{team: strings.ToUpper(stuff[1]), show: len(stuff) == 2, by: r}
+	if s := strings.ToLower(stuff[len(stuff)-1]); len(stuff) == 3 && s != "off" {
+		unit := 1
+		if strings.HasSuffix(s, "w") {
+			unit = 7
+		}
+		n, err := strconv.Atoi(strings.TrimRight(s, "dw"))
+		if err != nil || n < 1 || n*unit > maxRestDays {
+			log.Warningf(ctx, "(%s) invalid days %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.days = n * unit
+	}
+	// This operation requires permission.
+	if !values.show && !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeCapParams {{{
+
+// cap {team} {shifts|off}
+//   team   - required
+//   shifts - optional, show the current cap if omitted
+//
+// This operation requires manager of the team or superuser permission, except
+// for showing the current cap.
+func decodeCapParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "cap"
+	if len(stuff) != 2 && len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opCap{team: strings.ToUpper(stuff[1]), show: len(stuff) == 2, by: r}
+	if len(stuff) == 3 && strings.ToLower(stuff[2]) != "off" {
+		n, err := strconv.Atoi(stuff[2])
+		if err != nil || n < 1 || n > maxMonthlyCap {
+			log.Warningf(ctx, "(%s) invalid shifts %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.shifts = n
+	}
+	// This operation requires permission.
+	if !values.show && !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeStreakParams {{{
+
+// streak {team} {days|off}
+//   team - required
+//   days - optional, days or weeks (ie. "1w"), show the current limit if omitted
+//
+// This operation requires manager of the team or superuser permission, except
+// for showing the current limit.
+func decodeStreakParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "streak"
+	if len(stuff) != 2 && len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opStreak{team: strings.ToUpper(stuff[1]), show: len(stuff) == 2, by: r}
+	if s := strings.ToLower(stuff[len(stuff)-1]); len(stuff) == 3 && s != "off" {
+		unit := 1
+		if strings.HasSuffix(s, "w") {
+			unit = 7
+		}
+		n, err := strconv.Atoi(strings.TrimRight(s, "dw"))
+		if err != nil || n < 1 || n*unit > maxStreakDays {
+			log.Warningf(ctx, "(%s) invalid days %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.days = n * unit
+	}
+	// This operation requires permission.
+	if !values.show && !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodePlanParams {{{
+
+// plan {team} flush {YYYY-MM-DD} {HH:MM}
+// plan {team} copy {source_team} {YYYY-MM-DD} {HH:MM}
+// plan {team} cancel
+//   team        - required
+//   source_team - required to copy
+//   date, time  - required unless cancel, when the change is applied
+//
+// This operation requires manager of the team or superuser permission, except
+// for showing the current plan.
+func decodePlanParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "plan"
+	if len(stuff) < 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opPlan{team: strings.ToUpper(stuff[1]), show: len(stuff) == 2, by: r}
+	if !values.show {
+		action, at := strings.ToLower(stuff[2]), stuff[3:]
+		switch {
+		case action == "cancel" && len(stuff) == 3:
+			values.cancel = true
+		case action == "flush" && len(stuff) == 5:
+		case action == "copy" && len(stuff) == 6:
+			values.source = strings.ToUpper(stuff[3])
+			at = stuff[4:]
+		default:
+			log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		if values.source == values.team {
+			log.Warningf(ctx, "(%s) same team - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		if !values.cancel {
+			var err error
+			values.apply, err = time.ParseInLocation(dateFormat, at[0]+" "+at[1], timezone)
+			if err != nil {
+				log.Warningf(ctx, "(%s) invalid time %v - %s", op, at, err)
+				return op, nil, errorInput
+			}
+		}
+	}
+	// This operation requires permission.
+	if !values.show && !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeOnboardParams {{{
+
+// onboard {team} {shifts|off}
+//   team   - required
+//   shifts - optional, show the current setting if omitted
+//
+// This operation requires manager of the team or superuser permission, except
+// for showing the current setting.
+func decodeOnboardParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "onboard"
+	if len(stuff) != 2 && len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opOnboard{team: strings.ToUpper(stuff[1]), show: len(stuff) == 2, by: r}
+	if len(stuff) == 3 && strings.ToLower(stuff[2]) != "off" {
+		n, err := strconv.Atoi(stuff[2])
+		if err != nil || n < 1 || n > maxOnboarding {
+			log.Warningf(ctx, "(%s) invalid shifts %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.shifts = n
+	}
+	// This operation requires permission.
+	if !values.show && !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeHandoffParams {{{
+
+// handoff {team} {accept|decline|resume}
+//   team   - required
+//   action - required
+//
+// Accepting and declining is up to the incoming primary, checked by the operation.
+// "resume" requires manager of the team or superuser permission.
+func decodeHandoffParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "handoff"
+	if len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opHandoff{team: strings.ToUpper(stuff[1]), action: strings.ToLower(stuff[2]), by: r}
+	switch values.action {
+	case "accept", "decline":
+	case "resume":
+		// This operation requires permission.
+		if !userHasPerm(ctx, values.by.id, values.team) {
+			log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+			return op, nil, errorNoPerm
+		}
+	default:
+		log.Warningf(ctx, "(%s) invalid action %s", op, stuff[2])
+		return op, nil, errorInput
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeShiftParams {{{
+
+// shift {team}
+// shift {team} {label} {HH:MM-HH:MM}
+// shift {team} {label} off
+//   team   - required
+//   label  - optional, show the shifts of the team if omitted
+//   window - required with label, times in the team's timezone
+//
+// This operation requires manager of the team or superuser permission, except
+// for showing the shifts.
+func decodeShiftParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "shift"
+	if len(stuff) != 2 && len(stuff) != 4 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opShift{team: strings.ToUpper(stuff[1]), by: r}
+	if len(stuff) == 2 {
+		return op, values, ""
+	}
+	values.label = stuff[2]
+	if strings.ToLower(stuff[3]) == "off" {
+		values.cancel = true
+	} else {
+		window := strings.SplitN(stuff[3], "-", 2)
+		if len(window) != 2 {
+			log.Warningf(ctx, "(%s) invalid window %s", op, stuff[3])
+			return op, nil, errorInput
+		}
+		h1, m1 := decodeTimeOfDay(window[0])
+		h2, m2 := decodeTimeOfDay(window[1])
+		if h1 < 0 || h2 < 0 || h1*60+m1 == h2*60+m2 {
+			log.Warningf(ctx, "(%s) invalid window %s", op, stuff[3])
+			return op, nil, errorInput
+		}
+		values.start, values.end = h1*60+m1, h2*60+m2
+	}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeAssignParams {{{
+
+// assign {team}
+// assign {team} {@slackusername} {YYYY-MM-DD..YYYY-MM-DD}
+// assign {team} {@slackusername} off
+//   team  - required
+//   name  - optional, show the assignments of the team if omitted
+//   dates - required with name, first and last day, or a single day
+//
+// This operation requires manager of the team or superuser permission.
+func decodeAssignParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "assign"
+	if len(stuff) != 2 && len(stuff) != 4 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opAssign{team: strings.ToUpper(stuff[1]), by: r}
+	if len(stuff) == 2 {
+		return op, values, ""
+	}
+	if values.id, values.name = decodeUserEntity(stuff[2]); values.id == "" || values.name == "" {
+		log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+		return op, nil, errorInput
+	}
+	if strings.ToLower(stuff[3]) == "off" {
+		values.cancel = true
+	} else {
+		days := strings.SplitN(stuff[3], "..", 2)
+		if len(days) == 1 {
+			days = append(days, days[0])
+		}
+		var err error
+		if values.from, err = time.Parse("2006-01-02", days[0]); err != nil {
+			log.Warningf(ctx, "(%s) invalid date %s", op, days[0])
+			return op, nil, errorInput
+		}
+		if values.to, err = time.Parse("2006-01-02", days[1]); err != nil || values.to.Before(values.from) {
+			log.Warningf(ctx, "(%s) invalid date %s", op, days[1])
+			return op, nil, errorInput
+		}
+	}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeAwayParams {{{
+
+// away {team} {@slackusername} {until}
+// away {team} {@slackusername} off
+//   team  - required
+//   name  - required
+//   until - required, "YYYY-MM-DD HH:MM" or a duration ("12h", "3d"), or "off"
+//
+// This operation requires the member themselves, manager of the team or superuser permission.
+func decodeAwayParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "away"
+	if len(stuff) < 4 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opAway{team: strings.ToUpper(stuff[1]), by: r}
+	if values.id, values.name = decodeUserEntity(stuff[2]); values.id == "" || values.name == "" {
+		log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+		return op, nil, errorInput
+	}
+	if len(stuff) != 4 || strings.ToLower(stuff[3]) != "off" {
+		until, ok := decodeUntil(stuff[3:], time.Now())
+		if !ok {
+			log.Warningf(ctx, "(%s) invalid until - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		values.until = until
+	}
+	// This operation requires permission, unless marking oneself.
+	if values.id != values.by.id && !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeFallbackParams {{{
+
+// fallback {team} {@backup} for {@slackusername}
+// fallback {team} off for {@slackusername}
+//   team   - required
+//   backup - required, "off" to clear
+//   name   - required
+//
+// This operation requires the member themselves, manager of the team or superuser permission.
+func decodeFallbackParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "fallback"
+	if len(stuff) != 5 || strings.ToLower(stuff[3]) != "for" {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opFallback{team: strings.ToUpper(stuff[1]), by: r}
+	if values.id, values.name = decodeUserEntity(stuff[4]); values.id == "" || values.name == "" {
+		log.Warningf(ctx, "(%s) invalid username %s", op, stuff[4])
+		return op, nil, errorInput
+	}
+	if strings.ToLower(stuff[2]) != "off" {
+		if values.fallbackId, values.fallbackName = decodeUserEntity(stuff[2]); values.fallbackId == "" || values.fallbackName == "" {
+			log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		if values.fallbackId == values.id {
+			log.Warningf(ctx, "(%s) fallback of %s is the member", op, values.name)
+			return op, nil, errorInput
+		}
+	}
+	// This operation requires permission, unless setting one's own fallback.
+	if values.id != values.by.id && !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeUntil {{{
+
+// Decode end time given as "YYYY-MM-DD HH:MM" in the configured timezone, or as a
+// duration from now ("90m", "12h", "3d"). The time has to be in the future.
+func decodeUntil(stuff []string, now time.Time) (time.Time, bool) {
+	var until time.Time
+	switch len(stuff) {
+	case 1:
+		s := strings.ToLower(stuff[0])
+		if strings.HasSuffix(s, "d") {
+			days, err := strconv.Atoi(s[:len(s)-1])
+			if err != nil {
+				return until, false
+			}
+			until = now.AddDate(0, 0, days)
+			break
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return until, false
+		}
+		until = now.Add(d)
+	case 2:
+		t, err := time.ParseInLocation(dateFormat, stuff[0]+" "+stuff[1], timezone)
+		if err != nil {
+			return until, false
+		}
+		until = t
+	default:
+		return until, false
+	}
+	return until, until.After(now)
+} // }}}
+
+// func decodeLabelParams {{{
+
+// label {team} {@slackusername} {label}
+// label {team} {position} {label}
+//   team             - required
+//   name or position - required
+//   label            - optional, clears the label if omitted
+//
+// This operation requires manager of the team or superuser permission.
+func decodeLabelParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "label"
+	if len(stuff) < 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opLabel{team: strings.ToUpper(stuff[1]), by: r}
+	if pos, err := strconv.Atoi(stuff[2]); err == nil {
+		if pos < 1 {
+			log.Warningf(ctx, "(%s) invalid position %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.position = pos
+	} else if values.id, _ = decodeUserEntity(stuff[2]); values.id == "" {
+		log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+		return op, nil, errorInput
+	}
+	if len(stuff) > 3 {
+		values.label = strings.ToLower(strings.Join(stuff[3:], " "))
+	}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeShuffleParams {{{
+
+// shuffle {team}
+// reverse {team}
+//   team - required
+//
+// This operation requires manager of the team or superuser permission.
+func decodeShuffleParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := stuff[0]
+	if len(stuff) != 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opShuffle{team: strings.ToUpper(stuff[1]), by: r}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeUndoParams {{{
+
+// undo {team}
+//   team - required
+//
+// This operation requires manager of the team or superuser permission.
+// Reverting register/unregister additionally requires superuser permission, which
+// is checked once we know what the last change was.
+func decodeUndoParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "undo"
+	if len(stuff) != 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opUndo{team: strings.ToUpper(stuff[1]), by: r}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeFlushParams {{{
+
+// flush {team}
+// flush-managers {team}
+//   team - required
+//
+// This operation requires manager of the team or superuser permission.
+// Clearing managers requires superuser permission.
+func decodeFlushParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := stuff[0]
+	if len(stuff) != 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opFlush{team: strings.ToUpper(stuff[1]), managers: op == "flush-managers", by: r}
+	// Managers are otherwise only removed by unregister.
+	if values.managers && !userIsExempt(ctx, values.by.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeRegisterParams {{{
+
+// register {team} {@slackusername}
+//   team - required
+//   name - optional
+//
+// This operation requires superuser permission.
+func decodeRegisterParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "register"
+	if len(stuff) < 2 || len(stuff) > 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opRegister{team: strings.ToUpper(stuff[1]), by: r}
+	if len(stuff) == 3 {
+		// The manager info is given, let's decode.
+		id, name := decodeUserEntity(stuff[2])
+		if id == "" || name == "" {
+			log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.name = name
+		values.id = id
+	}
+	// This operation requires special permission - only "exempt" users can add a
+	// new team.
+	if !userIsExempt(ctx, values.by.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeUnregisterParams {{{
+
+// unregister {team} {@slackusername}
+//   team - required
+//   name - optional
+//
+// This operation requires superuser permission.
+func decodeUnregisterParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "unregister"
+	if len(stuff) < 2 || len(stuff) > 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opUnregister{team: strings.ToUpper(stuff[1]), by: r}
+	if len(stuff) == 3 {
+		id, name := decodeUserEntity(stuff[2])
+		if id == "" || name == "" {
+			log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		values.name = name
+		values.id = id
+	}
+	// This operation requires special permission - only "exempt" users can remove a
+	// manager from a team.
+	if !userIsExempt(ctx, values.by.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodePromoteParams {{{
+
+// promote {team} {@slackusername}
+// demote {team} {@slackusername}
+//   team - required
+//   name - required
+//
+// This operation requires manager of the team or superuser permission.
+func decodePromoteParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := stuff[0]
+	if len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opPromote{team: strings.ToUpper(stuff[1]), demote: op == "demote", by: r}
+	values.id, values.name = decodeUserEntity(stuff[2])
+	if values.id == "" || values.name == "" {
+		log.Warningf(ctx, "(%s) invalid username %s", op, stuff[2])
+		return op, nil, errorInput
+	}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeRenameParams {{{
+
+// rename {team} {newname}
+//   team    - required
+//   newname - required
+//
+// This operation requires superuser permission.
+func decodeRenameParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "rename"
+	if len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opRename{team: strings.ToUpper(stuff[1]), name: strings.ToUpper(stuff[2]), by: r}
+	if values.team == values.name {
+		log.Warningf(ctx, "(%s) same team name - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	// Team names are the datastore keys, so this is as powerful as register/unregister.
+	if !userIsExempt(ctx, values.by.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeImportParams {{{
+
+// import {team} {json}
+//   team - required
+//   json - required, as printed by "export", optionally in a code block
+//
+// This operation requires superuser permission.
+func decodeImportParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "import"
+	if len(stuff) < 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	// Replaces managers too, so this is as powerful as register/unregister.
+	if !userIsExempt(ctx, r.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, r.name)
+		return op, nil, errorNoPerm
+	}
+	values := opImport{team: strings.ToUpper(stuff[1]), by: r}
+
+	// Slack escapes &, < and > in the text, and may turn quotes into smart quotes.
+	payload := html.UnescapeString(strings.TrimSpace(strings.Join(stuff[2:], " ")))
+	payload = strings.NewReplacer("“", "\"", "”", "\"").Replace(payload)
+	payload = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(payload, "```"), "```"))
+	if err := json.Unmarshal([]byte(payload), &values.data); err != nil {
+		log.Warningf(ctx, "(%s) invalid json - %s", op, err)
+		return op, nil, fmt.Sprintf("Sorry! That's not valid JSON (%s) %s", err, humanErrorEmoji)
+	}
+	for _, m := range values.data.Managers {
+		if m.Id == "" {
+			return op, nil, fmt.Sprintf("Sorry! Every manager needs an id %s", humanErrorEmoji)
+		}
+	}
+	seen := map[string]bool{}
+	for _, u := range values.data.Rotation {
+		if u.Id == "" {
+			return op, nil, fmt.Sprintf("Sorry! Every member of the rotation needs an id %s", humanErrorEmoji)
+		}
+		if seen[u.Id] {
+			return op, nil, fmt.Sprintf("Sorry! %s is in the rotation more than once %s", u.Id, humanErrorEmoji)
+		}
+		seen[u.Id] = true
+	}
+	return op, values, ""
+} // }}}
+
+// func resolveAliases {{{
+
+// Replace team aliases in the operation parameters with the team name, so
+// operations only ever see the real name.
+func resolveAliases(op string, stuff []string) {
+	var idx []int
+	switch op {
+	case "list", "next", "who", "at", "history", "stats", "fairness", "export", "page", "add", "remove", "swap", "move", "rotate", "shuffle", "reverse", "label", "away", "fallback", "override", "assign", "cover-needed", "shift", "quiet", "digest", "onboard", "rest", "cap", "streak", "plan", "handoff", "note", "describe", "cadence", "schedule", "simulate",
+		"promote", "demote", "undo", "flush", "flush-managers", "register", "unregister", "rename", "import", "restrict", "permit", "report", "alias", "unalias":
+		idx = []int{1}
+	case "copy":
+		idx = []int{1, 2}
+	}
+	oncallMut.RLock()
+	defer oncallMut.RUnlock()
+	for _, i := range idx {
+		if i >= len(stuff) {
+			continue
+		}
+		if r := findRotation(strings.ToUpper(stuff[i])); r != nil {
+			stuff[i] = r.Team
+		}
+	}
+} // }}}
+
+// func decodeAliasParams {{{
+
+// alias {team} {alias}
+// unalias {team} {alias}
+//   team  - required
+//   alias - optional for "alias", show current aliases if omitted
+//
+// This operation requires manager of the team or superuser permission.
+func decodeAliasParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := strings.ToLower(stuff[0])
+	if len(stuff) < 2 || len(stuff) > 3 || (op == "unalias" && len(stuff) != 3) {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opAlias{team: strings.ToUpper(stuff[1]), remove: op == "unalias", by: r}
+	if len(stuff) == 3 {
+		values.alias = strings.ToUpper(stuff[2])
+	}
+	// Showing aliases is fine for anyone.
+	if values.alias != "" && !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeOpaliasParams {{{
+
+// opalias {alias} {operation}
+// opalias {alias} off
+//   alias     - optional, show current aliases if omitted
+//   operation - required with alias
+//
+// This operation requires superuser permission.
+func decodeOpaliasParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "opalias"
+	if len(stuff) == 1 {
+		return op, opOpalias{by: r}, ""
+	}
+	if len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	if !userIsExempt(ctx, r.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, r.name)
+		return op, nil, errorNoPerm
+	}
+	values := opOpalias{alias: strings.ToLower(stuff[1]), operation: strings.ToLower(stuff[2]), by: r}
+	if values.operation == "off" {
+		values.operation = ""
+	}
+	if isOperation(values.alias) {
+		return op, nil, fmt.Sprintf("Sorry! %s is an operation already %s", values.alias, humanErrorEmoji)
+	}
+	if values.operation != "" && !isOperation(values.operation) {
+		return op, nil, fmt.Sprintf("Sorry! %s is not an operation %s", values.operation, humanErrorEmoji)
+	}
+	return op, values, ""
+} // }}}
+
+// func isOperation {{{
+
+// Check if the name is an operation, not counting aliases.
+func isOperation(name string) bool {
+	return stringInSlice(name, operations)
+} // }}}
+
+// func stringInSlice {{{
+
+// Check if the string is in the list.
+func stringInSlice(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+} // }}}
+
+// func truncate {{{
+
+// Shorten the string to at most max bytes, marking it was shortened.
+// It's cut at a character boundary so it stays valid UTF-8.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max] + "..."
+} // }}}
+
+// func resolveOperation {{{
+
+// Return the operation the alias stands for, or the name itself if it isn't an alias.
+func resolveOperation(name string) string {
+	opAliasMut.RLock()
+	defer opAliasMut.RUnlock()
+	if o, ok := opAliases[name]; ok {
+		return o
+	}
+	return name
+} // }}}
+
+// func checkChannel {{{
+
+// Check if the operation changing a team is run from a channel allowed by "restrict".
+// Empty string is returned if the operation can go ahead.
+func checkChannel(ctx context.Context, op string, stuff []string, channel string) string {
+	switch op {
+	case "add", "remove", "swap", "move", "copy", "rotate", "shuffle", "reverse", "fairness", "label", "away", "fallback", "override", "assign", "shift", "quiet", "digest", "onboard", "rest", "cap", "streak", "plan", "note", "describe", "cadence", "schedule", "promote", "demote", "undo", "flush", "flush-managers", "unregister", "rename", "import", "report", "alias", "unalias":
+	default:
+		return ""
+	}
+	// "copy" changes the second team.
+	idx := 1
+	if op == "copy" {
+		idx = 2
+	}
+	if len(stuff) <= idx {
+		return ""
+	}
+	// Previewing doesn't change anything.
+	if op == "schedule" && len(stuff) > 2 && strings.ToLower(stuff[2]) == "preview" {
+		return ""
+	}
+	if op == "fairness" && strings.ToLower(stuff[len(stuff)-1]) != "rebalance" {
+		return ""
+	}
+	team := strings.ToUpper(stuff[idx])
+	oncallMut.RLock()
+	defer oncallMut.RUnlock()
+	r := findRotation(team)
+	if r == nil || len(r.Channels) == 0 {
+		return ""
+	}
+	for _, c := range r.Channels {
+		if c.Id == channel {
+			return ""
+		}
+	}
+	log.Warningf(ctx, "(%s) %s can't be changed from channel %s", op, team, channel)
+	return fmt.Sprintf("Sorry! %s can only be changed from %s %s", team, describeChannels(r.Channels), humanErrorEmoji)
+} // }}}
+
+// func externalRequest {{{
+
+// Check if the request comes from a workspace other than the one the command is
+// installed in, ie. through a shared channel. Workspaces of the same Enterprise
+// Grid org aren't if "slack_team_id" is the org. Always false without it.
+func externalRequest(params slackCommandParams) bool {
+	if homeTeamId == "" {
+		return false
+	}
+	return params.TeamId != homeTeamId && params.EnterpriseId != homeTeamId
+} // }}}
+
+// func boundToChannel {{{
+
+// Check if the team is bound to the channel by "restrict".
+func boundToChannel(team, channel string) bool {
+	oncallMut.RLock()
+	defer oncallMut.RUnlock()
+	if r := findRotation(team); r != nil {
+		for _, c := range r.Channels {
+			if c.Id == channel {
+				return true
+			}
+		}
+	}
+	return false
+} // }}}
+
+// func checkExternal {{{
+
+// Check if the operation from another workspace can go ahead. Only displaying a
+// team bound to the shared channel it's run from (see "restrict") is, so users
+// of other orgs don't get to see other teams, or their managers and phones.
+// Empty string is returned if the operation can go ahead.
+func checkExternal(ctx context.Context, op string, stuff []string, params slackCommandParams) string {
+	if !stringInSlice(op, externalOperations) {
+		log.Warningf(ctx, "(%s) %s of %s is not allowed from another workspace", op, params.UserName, params.TeamId)
+		return fmt.Sprintf("Sorry! Only %s can be run from another workspace %s", strings.Join(externalOperations, ", "), humanErrorEmoji)
+	}
+	if op == "help" {
+		return ""
+	}
+	if len(stuff) < 2 || stuff[1] == "" {
+		return fmt.Sprintf("Sorry! From another workspace, `%s` needs the team this channel is for %s", op, humanErrorEmoji)
+	}
+	// Aliases of the team are resolved on a copy, the caller does it again.
+	args := append([]string(nil), stuff...)
+	resolveAliases(op, args)
+	team := strings.ToUpper(args[1])
+	if boundToChannel(team, params.ChannelId) {
+		return ""
+	}
+	log.Warningf(ctx, "(%s) %s of %s can't see %s from channel %s", op, params.UserName, params.TeamId, team, params.ChannelId)
+	return fmt.Sprintf("Sorry! From another workspace, only teams bound to this channel can be shown %s", humanErrorEmoji)
+} // }}}
+
+// func checkPermission {{{
+
+// Check if the requestor has the role "permit" set for the operation of the team,
+// ie. operations anyone can run by default restricted to managers. Operations
+// checking permission themselves get the role from userHasPerm as well.
+// A role in shadow mode is only logged where it would decide otherwise than the
+// default one.
+// Empty string is returned if the operation can go ahead.
+func checkPermission(ctx context.Context, op string, stuff []string, r opRequestor) string {
+	if len(stuff) < 2 {
+		return ""
+	}
+	team := strings.ToUpper(stuff[1])
+	rule, ok := teamPermissionRule(team, op)
+	if !ok {
+		return ""
+	}
+	if time.Now().Before(rule.Enforce) {
+		would := userHasRole(ctx, r.id, team, rule.Role)
+		if would != userHasRole(ctx, r.id, team, defaultRole(op)) {
+			verdict := "denied"
+			if would {
+				verdict = "allowed"
+			}
+			log.Infof(ctx, "(%s) shadow permission: user %s would be %s by %s requiring %s (enforced from %s)", op, r.name, verdict, team, rule.Role, rule.Enforce.In(timezone).Format(dateFormat))
+		}
+		return ""
+	}
+	if userHasRole(ctx, r.id, team, rule.Role) {
+		return ""
+	}
+	log.Warningf(ctx, "(%s) user %s has no perm, %s requires %s", op, r.name, team, rule.Role)
+	return errorNoPerm
+} // }}}
+
+// func decodePermitParams {{{
+
+// permit {team}
+// permit {team} {operation} {everyone|member|manager|superuser|default} {--shadow}
+//   team      - required
+//   operation - optional, show the permissions of the team if omitted
+//   role      - required with operation, "default" goes back to the default
+//   --shadow  - optional, only log what the role would decide for a week first
+//
+// This operation requires superuser permission.
+func decodePermitParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "permit"
+	var shadow bool
+	if len(stuff) == 5 && strings.ToLower(stuff[4]) == "--shadow" {
+		shadow = true
+		stuff = stuff[:4]
+	}
+	if len(stuff) != 2 && len(stuff) != 4 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opPermit{team: strings.ToUpper(stuff[1]), shadow: shadow, by: r}
+	if len(stuff) == 4 {
+		values.operation = resolveOperation(strings.ToLower(stuff[2]))
+		if !stringInSlice(values.operation, permitOperations) {
+			log.Warningf(ctx, "(%s) invalid operation %s", op, stuff[2])
+			return op, nil, errorInput
+		}
+		if role := strings.ToLower(stuff[3]); role != "default" {
+			if !stringInSlice(role, permRoles) {
+				log.Warningf(ctx, "(%s) invalid role %s", op, stuff[3])
+				return op, nil, errorInput
+			}
+			values.role = role
+		}
+		// Nothing to try out when going back to the default.
+		if values.shadow && values.role == "" {
+			log.Warningf(ctx, "(%s) shadow without role - %v", op, stuff)
+			return op, nil, errorInput
+		}
+	}
+	if !userIsExempt(ctx, values.by.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeRestrictParams {{{
+
+// restrict {team} {#channel} ...
+// restrict {team} off
+//   team    - required
+//   channel - optional, show current restriction if omitted
+//
+// This operation requires superuser permission.
+func decodeRestrictParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "restrict"
+	if len(stuff) < 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opRestrict{team: strings.ToUpper(stuff[1]), by: r}
+	switch {
+	case len(stuff) == 2:
+		values.show = true
+	case len(stuff) == 3 && strings.ToLower(stuff[2]) == "off":
+	default:
+		for _, entity := range stuff[2:] {
+			// Channel ids are required, bare "#name" isn't expanded by Slack.
+			id, name := decodeDestination(entity)
+			if !strings.HasPrefix(entity, "<#") || id == "" {
+				log.Warningf(ctx, "(%s) invalid channel %s", op, entity)
+				return op, nil, errorInput
+			}
+			values.channels = append(values.channels, ChannelProperty{Id: id, Name: name[1:]})
+		}
+	}
+	if !userIsExempt(ctx, values.by.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeUpdateParams {{{
+//
+// update
+//
+// This operation updates the requested user's Slack information.
 func decodeUpdateParams(ctx context.Context, r opRequestor) (string, interface{}, string) {
 	return "update", opUpdate{id: r.id, name: r.name}, ""
 } // }}}
 
+// func decodeLinkMeParams {{{
+
+// link-me {pagerduty|github} {email|handle|off}
+//   system - optional, show the requestor's identities if omitted
+//   handle - required with system, PagerDuty email or GitHub handle
+//
+// Identities are only linked for the requestor.
+func decodeLinkMeParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "link-me"
+	if len(stuff) != 1 && len(stuff) != 3 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opLinkMe{by: r}
+	if len(stuff) == 1 {
+		return op, values, ""
+	}
+	values.system = strings.ToLower(stuff[1])
+	handle := stuff[2]
+	switch {
+	case strings.ToLower(handle) == "off":
+		handle = ""
+	case values.system == "pagerduty":
+		// Slack links emails as <mailto:a@b.c|a@b.c>.
+		if strings.HasPrefix(handle, "<mailto:") && strings.HasSuffix(handle, ">") && strings.Contains(handle, "|") {
+			handle = handle[strings.Index(handle, "|")+1 : len(handle)-1]
+		}
+		if at := strings.Index(handle, "@"); at < 1 || !strings.Contains(handle[at:], ".") || strings.ContainsAny(handle, "<>| ") {
+			log.Warningf(ctx, "(%s) invalid email %s", op, handle)
+			return op, nil, errorInput
+		}
+	case values.system == "github":
+		handle = strings.TrimPrefix(handle, "@")
+		if handle == "" || len(handle) > 39 || strings.HasPrefix(handle, "-") ||
+			strings.Trim(strings.ToLower(handle), "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+			log.Warningf(ctx, "(%s) invalid handle %s", op, handle)
+			return op, nil, errorInput
+		}
+	default:
+		log.Warningf(ctx, "(%s) invalid system %s", op, stuff[1])
+		return op, nil, errorInput
+	}
+	values.handle = handle
+	return op, values, ""
+} // }}}
+
+// func decodeSetphoneParams {{{
+
+// setphone {@slackusername} {number}
+//   name   - required
+//   number - optional, clears the phone if omitted
+//
+// Users can set their own phone, setting someone else's requires manager or
+// superuser permission.
+func decodeSetphoneParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "setphone"
+	if len(stuff) < 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	id, name := decodeUserEntity(stuff[1])
+	if id == "" || name == "" {
+		log.Warningf(ctx, "(%s) invalid username %s", op, stuff[1])
+		return op, nil, errorInput
+	}
+	values := opSetphone{id: id, name: name, by: r}
+	if len(stuff) > 2 {
+		phone := strings.Join(stuff[2:], " ")
+		// Slack links phone numbers as <tel:+1234|+1234>.
+		if strings.HasPrefix(phone, "<tel:") && strings.HasSuffix(phone, ">") && strings.Contains(phone, "|") {
+			phone = phone[strings.Index(phone, "|")+1 : len(phone)-1]
+		}
+		if strings.Trim(phone, "+-()0123456789 ") != "" || len(phone) > 32 {
+			log.Warningf(ctx, "(%s) invalid phone %s", op, phone)
+			return op, nil, errorInput
+		}
+		values.phone = phone
+	}
+	// This operation requires permission unless it's for the requestor.
+	if id != r.id && !userIsManager(ctx, r.id) && !userIsExempt(ctx, r.id) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeReportParams {{{
+
+// report {team}
+// report {team} daily {HH:MM} to {#channel|@slackusername}
+// report {team} weekly {day} {HH:MM} to {#channel|@slackusername}
+// report {team} cancel {#channel|@slackusername}
+//   team - required
+//   dest - optional, the team's channel if omitted
+//
+// This operation requires manager of the team or superuser permission.
+func decodeReportParams(ctx context.Context, r opRequestor, stuff []string) (string, interface{}, string) {
+	op := "report"
+	if len(stuff) < 2 {
+		log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+		return op, nil, errorInput
+	}
+	values := opReport{team: strings.ToUpper(stuff[1]), by: r}
+	// This operation requires permission.
+	if !userHasPerm(ctx, values.by.id, values.team) {
+		log.Warningf(ctx, "(%s) user %s has no perm", op, values.by.name)
+		return op, nil, errorNoPerm
+	}
+	if len(stuff) == 2 {
+		return op, values, ""
+	}
+
+	var dest string
+	switch strings.ToLower(stuff[2]) {
+	case "cancel":
+		if len(stuff) > 4 {
+			log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		values.cancel = true
+		if len(stuff) == 4 {
+			dest = stuff[3]
+		}
+	case "daily":
+		if (len(stuff) != 4 && len(stuff) != 6) || (len(stuff) == 6 && strings.ToLower(stuff[4]) != "to") {
+			log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		values.frequency = "daily"
+		if values.hour, values.minute = decodeTimeOfDay(stuff[3]); values.hour < 0 {
+			log.Warningf(ctx, "(%s) invalid time - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		if len(stuff) == 6 {
+			dest = stuff[5]
+		}
+	case "weekly":
+		if (len(stuff) != 5 && len(stuff) != 7) || (len(stuff) == 7 && strings.ToLower(stuff[5]) != "to") {
+			log.Warningf(ctx, "(%s) invalid # of params - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		values.frequency = "weekly"
+		var ok bool
+		if values.weekday, ok = decodeWeekday(stuff[3]); !ok {
+			log.Warningf(ctx, "(%s) invalid day - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		if values.hour, values.minute = decodeTimeOfDay(stuff[4]); values.hour < 0 {
+			log.Warningf(ctx, "(%s) invalid time - %v", op, stuff)
+			return op, nil, errorInput
+		}
+		if len(stuff) == 7 {
+			dest = stuff[6]
+		}
+	default:
+		log.Warningf(ctx, "(%s) invalid input - %v", op, stuff)
+		return op, nil, errorInput
+	}
+
+	// No destination, the team's channel is looked up by the operation.
+	if dest == "" {
+		return op, values, ""
+	}
+	if values.dest, values.destName = decodeDestination(dest); values.dest == "" {
+		log.Warningf(ctx, "(%s) invalid destination %s", op, dest)
+		return op, nil, errorInput
+	}
+	return op, values, ""
+} // }}}
+
+// func decodeTimeOfDay {{{
+
+// Decode "HH:MM" into hour and minute. Hour is -1 if the input is invalid.
+func decodeTimeOfDay(s string) (int, int) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return -1, -1
+	}
+	return t.Hour(), t.Minute()
+} // }}}
+
+// func decodeWeekday {{{
+
+// Decode full or short (3 letters) English name of day of week.
+func decodeWeekday(s string) (time.Weekday, bool) {
+	s = strings.ToLower(s)
+	if len(s) < 3 {
+		return 0, false
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+} // }}}
+
+// func decodeDestination {{{
+
+// Decode a channel or a user to post messages to into its id and display name.
+//
+// Accepts expanded entities (<#C1234|general>, <@U1234|name>) as well as a plain
+// "#channel" name.
+func decodeDestination(entity string) (string, string) {
+	if strings.HasPrefix(entity, "<@") {
+		id, name := decodeUserEntity(entity)
+		if id == "" {
+			return "", ""
+		}
+		return id, "@" + name
+	}
+	if strings.HasPrefix(entity, "<#") && strings.HasSuffix(entity, ">") {
+		items := strings.Split(entity[2:len(entity)-1], "|")
+		if len(items) != 2 || items[0] == "" {
+			return "", ""
+		}
+		return items[0], "#" + items[1]
+	}
+	if len(entity) > 1 && entity[0] == '#' {
+		return entity, entity
+	}
+	return "", ""
+} // }}}
+
 // func getCurrentRotation {{{
 
 // Return current oncall rotation for the requested team.
 func getCurrentRotation(team string) *oncallProperty {
 	oncallMut.RLock()
 	defer oncallMut.RUnlock()
-	for _, r := range rotations {
-		if r.Team == team {
-			return r
+	return findRotation(team)
+} // }}}
+
+// func rotationRole {{{
+
+// Return the role of the position (1-origin) in the team's on-call list at the time,
+// or empty string if the position has no particular role.
+// Caller must hold oncallMut.
+func rotationRole(r *oncallProperty, position int, t time.Time) string {
+	if position < 1 || position > len(r.Rotations) || r.Rotations[position-1].Shadow {
+		return ""
+	}
+	// Position among members on duty, counted from the current primary.
+	members := onDutyMembers(r, t)
+	k := -1
+	for i, idx := range members {
+		if idx == position-1 {
+			k = i
+			break
+		}
+	}
+	if k < 0 {
+		// Away.
+		return ""
+	}
+	for role, p := range rolePositions {
+		if p == k+1 {
+			// Someone else is covering.
+			if _, ok := primaryCover(r, t); ok && role == rolePrimary {
+				return ""
+			}
+			return role
+		}
+	}
+	return ""
+} // }}}
+
+// func memberByRole {{{
+
+// Return the on-call list member in the role right now.
+// Caller must hold oncallMut.
+func memberByRole(r *oncallProperty, role string) (RotationProperty, bool) {
+	res := oncall.Resolve(engineTeam(r), time.Now())
+	m := res.Primary
+	if role == roleSecondary {
+		m = res.Secondary
+	}
+	if m == nil {
+		return RotationProperty{}, false
+	}
+	return rotationMember(*m), true
+} // }}}
+
+// func onDutyMembers {{{
+
+// Return indexes of the list members taking part in the rotation at the time,
+// starting from the current primary, with members away skipped unless their
+// fallback covers them. During a shift only members with its label are on duty,
+// unless none of them is.
+// Caller must hold oncallMut.
+func onDutyMembers(r *oncallProperty, t time.Time) []int {
+	return engineTeam(r).OnDuty(t)
+} // }}}
+
+// func activeShift {{{
+
+// Return the shift of the team at the time, if any.
+func activeShift(r *oncallProperty, t time.Time) *ShiftProperty {
+	team := engineTeam(r)
+	s := team.ActiveShift(t)
+	for i := range team.Shifts {
+		if s == &team.Shifts[i] {
+			return &r.Shifts[i]
+		}
+	}
+	return nil
+} // }}}
+
+// func shiftCovers {{{
+
+// Check if the minute of the day is within the shift.
+func shiftCovers(s ShiftProperty, m int) bool {
+	return engineShift(s).Covers(m)
+} // }}}
+
+// func quietMember {{{
+
+// Return who takes primary on-call of the team at the time because of its quiet
+// hours - the secondary, or the first member on duty with the label. False outside
+// of quiet hours, or if there's no such member.
+// Caller must hold oncallMut.
+func quietMember(r *oncallProperty, t time.Time) (RotationProperty, bool) {
+	m, ok := engineTeam(r).QuietMember(t)
+	return rotationMember(m), ok
+} // }}}
+
+// func describeShift {{{
+
+// Human readable shift.
+func describeShift(s ShiftProperty) string {
+	return fmt.Sprintf("%s %02d:%02d-%02d:%02d", s.Label, s.Start/60, s.Start%60, s.End/60, s.End%60)
+} // }}}
+
+// func memberAway {{{
+
+// Check if the member is marked away at the time.
+func memberAway(u RotationProperty, t time.Time) bool {
+	return engineMember(u).Away(t)
+} // }}}
+
+// func memberOnDuty {{{
+
+// Return who takes the member's turns at the time - the member, or the fallback
+// while the member is away. False if the member is away without a fallback.
+func memberOnDuty(u RotationProperty, t time.Time) (RotationProperty, bool) {
+	m, ok := engineMember(u).OnDuty(t)
+	return rotationMember(m), ok
+} // }}}
+
+// func rotationMembers {{{
+
+// Return indexes of the list members taking part in the rotation, ie. everyone
+// but shadows.
+func rotationMembers(r []RotationProperty) []int {
+	return engineRotation(r).Members()
+} // }}}
+
+// func advanceRotation {{{
+
+// Return a copy of the list with the rotation advanced n times - members move up n
+// slots among themselves, and shadows stay where they are.
+func advanceRotation(r []RotationProperty, n int) []RotationProperty {
+	// Moved here rather than converted back from oncall.Rotation.Advance, which
+	// would drop what the oncall package doesn't know of (ie. ShadowLeft).
+	advanced := append([]RotationProperty(nil), r...)
+	members := rotationMembers(r)
+	for i, idx := range members {
+		advanced[idx] = r[members[(i+n)%len(members)]]
+	}
+	return advanced
+} // }}}
+
+// func activeOverride {{{
+
+// Return the user covering primary on-call of the team at the time, if any.
+// Caller must hold oncallMut.
+func activeOverride(r *oncallProperty, t time.Time) (RotationProperty, bool) {
+	if r.OverrideId == "" || t.Before(r.OverrideStart) || !t.Before(r.OverrideUntil) {
+		return RotationProperty{}, false
+	}
+	return RotationProperty{Name: r.OverrideName, Id: r.OverrideId, Label: "override"}, true
+} // }}}
+
+// func upcomingOverride {{{
+
+// Return the user scheduled to cover primary on-call of the team after the time,
+// if any.
+// Caller must hold oncallMut.
+func upcomingOverride(r *oncallProperty, t time.Time) (RotationProperty, bool) {
+	if r.OverrideId == "" || !t.Before(r.OverrideStart) {
+		return RotationProperty{}, false
+	}
+	return RotationProperty{Name: r.OverrideName, Id: r.OverrideId, Label: "override"}, true
+} // }}}
+
+// func activeAssignment {{{
+
+// Return the assignment of the team at the time, if any.
+// Caller must hold oncallMut.
+func activeAssignment(r *oncallProperty, t time.Time) *assignmentProperty {
+	for _, a := range assignments[r.Team] {
+		if !t.Before(a.Start) && t.Before(a.End) {
+			return a
 		}
 	}
 	return nil
 } // }}}
+
+// func primaryCover {{{
+
+// Return the user covering primary on-call of the team at the time instead of
+// the rotation - the override, or else the assigned member.
+// Caller must hold oncallMut.
+func primaryCover(r *oncallProperty, t time.Time) (RotationProperty, bool) {
+	m, ok := engineTeam(r).Cover(t)
+	return rotationMember(m), ok
+} // }}}
+
+// func insertAssignment {{{
+
+// Insert the assignment keeping the list sorted by start.
+func insertAssignment(list []*assignmentProperty, a *assignmentProperty) []*assignmentProperty {
+	i := len(list)
+	for i > 0 && list[i-1].Start.After(a.Start) {
+		i--
+	}
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = a
+	return list
+} // }}}
+
+// func assignmentRange {{{
+
+// Return start and end of an assignment from the first to the last day. Days
+// change at the team's handoff time if it has a cadence, at midnight otherwise.
+func assignmentRange(r *oncallProperty, from, to time.Time) (time.Time, time.Time) {
+	loc := teamLocation(r)
+	var hour, minute int
+	if r.Cadence != "" {
+		a := r.Anchor.In(loc)
+		hour, minute = a.Hour(), a.Minute()
+	}
+	start := time.Date(from.Year(), from.Month(), from.Day(), hour, minute, 0, 0, loc)
+	end := time.Date(to.Year(), to.Month(), to.Day()+1, hour, minute, 0, 0, loc)
+	return start, end
+} // }}}
+
+// func rotationOffset {{{
+
+// Return how many positions the primary has moved down the stored on-call list
+// because of the team's cadence. Always 0 for teams rotated manually.
+// Caller must hold oncallMut.
+func rotationOffset(r *oncallProperty, t time.Time) int {
+	return teamSchedule(r).Offset(t, len(rotationMembers(r.Rotations)))
+} // }}}
+
+// func cadenceAdvances {{{
+
+// Return number of handoffs between the team's anchor and the time.
+func cadenceAdvances(r *oncallProperty, t time.Time) int {
+	return teamSchedule(r).Advances(t)
+} // }}}
+
+// func handoffTime {{{
+
+// Return time of the n-th handoff from the team's anchor, in the team's timezone.
+func handoffTime(r *oncallProperty, n int) time.Time {
+	return teamSchedule(r).Handoff(n)
+} // }}}
+
+// func teamLocation {{{
+
+// Return timezone handoffs of the team happen in.
+func teamLocation(r *oncallProperty) *time.Location {
+	if r.Timezone == "" {
+		return timezone
+	}
+	teamLocationMut.Lock()
+	defer teamLocationMut.Unlock()
+	if loc, ok := teamLocations[r.Timezone]; ok {
+		return loc
+	}
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		loc = timezone
+	}
+	teamLocations[r.Timezone] = loc
+	return loc
+} // }}}
+
+// func scheduleAnchor {{{
+
+// Return the anchor making the next handoff of the cadence after the time fall on
+// the weekday (ignored for "daily") and time of day in the timezone.
+func scheduleAnchor(cadence string, weekday time.Weekday, hour, minute int, loc *time.Location, now time.Time) time.Time {
+	now = now.In(loc)
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, loc)
+	step := 1
+	if cadence != "daily" {
+		next = next.AddDate(0, 0, (int(weekday)-int(next.Weekday())+7)%7)
+		step = 7
+	}
+	if !next.After(now) {
+		next = next.AddDate(0, 0, step)
+	}
+	return next.AddDate(0, 0, -oncall.CadenceDays(cadence))
+} // }}}
+
+// func labeledPosition {{{
+
+// Return the position (1-origin) in the whole list of the n-th member with the label,
+// or 0 if there's no such member. Empty label counts the whole list.
+func labeledPosition(r []RotationProperty, label string, n int) int {
+	for i, u := range r {
+		if label != "" && u.Label != label {
+			continue
+		}
+		n--
+		if n == 0 {
+			return i + 1
+		}
+	}
+	return 0
+} // }}}
+
+// func describePosition {{{
+
+// Human readable position, as decodePosition takes it.
+func describePosition(label string, n int) string {
+	if label == "" {
+		return strconv.Itoa(n)
+	}
+	return fmt.Sprintf("%s:%d", label, n)
+} // }}}
+
+// func findRotation {{{
+
+// Same as getCurrentRotation, for callers already holding oncallMut.
+// Aliases of teams are matched as well.
+func findRotation(team string) *oncallProperty {
+	return rotationsIndex().teams[team]
+} // }}}
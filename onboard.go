@@ -0,0 +1,70 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// func onboard {{{
+
+// onboard {team} {shifts|off}
+//
+// Set how many scheduled handoffs new members of the team shadow the primary for
+// before joining the rotation. Without shifts, display the current setting.
+func onboard(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opOnboard)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "onboard")}
+	}
+
+	res := slackResponse{}
+	oncallMut.Lock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if p.show {
+		if current.Onboarding == 0 {
+			res.Text = fmt.Sprintf("%s has no onboarding, new members join the rotation right away", p.team)
+		} else {
+			res.Text = fmt.Sprintf("New members of %s shadow the primary for %d shifts before joining the rotation", p.team, current.Onboarding)
+		}
+		oncallMut.Unlock()
+		return res
+	}
+	// Shifts are counted by scheduled handoffs.
+	if p.shifts > 0 && current.Cadence == "" {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, %s has no cadence, set one with `%s cadence` first %s", p.team, commandName(ctx), humanErrorEmoji)
+		return res
+	}
+
+	previous := *current
+	current.Onboarding = p.shifts
+	current.Updated = time.Now()
+	current.UpdatedBy = p.by.name
+	if err := resetState(ctx, current); err != nil {
+		log.Warningf(ctx, "(onboard) error saving state - %s", err)
+		*current = previous
+		oncallMut.Unlock()
+		res.Text = errorExternal
+		return res
+	}
+	detail := "stopped onboarding"
+	if p.shifts > 0 {
+		detail = fmt.Sprintf("new members shadow for %d shifts", p.shifts)
+	}
+	recordHistory(ctx, p.team, "onboard", p.by.name, detail, current.Rotations)
+	oncallMut.Unlock()
+
+	if p.shifts == 0 {
+		res.Text = fmt.Sprintf("Success! Onboarding of %s is stopped, new members join the rotation right away", p.team)
+		return res
+	}
+	res.Text = fmt.Sprintf("Success! New members of %s will shadow the primary for %d shifts before joining the rotation", p.team, p.shifts)
+	return res
+} // }}}
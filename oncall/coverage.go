@@ -0,0 +1,116 @@
+package oncall
+
+import (
+	"sort"
+	"time"
+)
+
+// Conflict is a time two covers of a team overlap. Only the first of them, as
+// listed in Team.Covers, is on-call.
+type Conflict struct {
+	Start, End time.Time
+	Winner     Cover
+	Loser      Cover
+}
+
+// Gap is a time nobody is primary on-call of a team.
+type Gap struct {
+	Start, End time.Time
+}
+
+// Conflicts in the order they start.
+type byStart []Conflict
+
+func (c byStart) Len() int           { return len(c) }
+func (c byStart) Less(i, j int) bool { return c[i].Start.Before(c[j].Start) }
+func (c byStart) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// Times in order.
+type timeOrder []time.Time
+
+func (t timeOrder) Len() int           { return len(t) }
+func (t timeOrder) Less(i, j int) bool { return t[i].Before(t[j]) }
+func (t timeOrder) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// func Team.Conflicts {{{
+
+// Conflicts returns the overlaps of the team's covers between the times, in the
+// order they start.
+func (team *Team) Conflicts(from, to time.Time) []Conflict {
+	var conflicts []Conflict
+	for i, a := range team.Covers {
+		for _, b := range team.Covers[i+1:] {
+			start, end := latest(a.Start, b.Start, from), earliest(a.End, b.End, to)
+			if start.Before(end) {
+				conflicts = append(conflicts, Conflict{Start: start, End: end, Winner: a, Loser: b})
+			}
+		}
+	}
+	sort.Stable(byStart(conflicts))
+	return conflicts
+} // }}}
+
+// func Team.Gaps {{{
+
+// Gaps returns the times between the times nobody would be primary on-call of
+// the team, ie. everyone away without a fallback and no cover.
+// Nobody being primary only changes when a cover starts or ends, or a member is
+// back, so only those moments are checked.
+func (team *Team) Gaps(from, to time.Time) []Gap {
+	moments := []time.Time{from}
+	add := func(t time.Time) {
+		if t.After(from) && t.Before(to) {
+			moments = append(moments, t)
+		}
+	}
+	for _, c := range team.Covers {
+		add(c.Start)
+		add(c.End)
+	}
+	for _, m := range team.Rotation {
+		add(m.AwayUntil)
+	}
+	sort.Sort(timeOrder(moments))
+
+	var gaps []Gap
+	for i, t := range moments {
+		end := to
+		if i+1 < len(moments) {
+			end = moments[i+1]
+		}
+		if !t.Before(end) || Resolve(team, t).Primary != nil {
+			continue
+		}
+		// Joined to the previous gap if it ran up to here.
+		if n := len(gaps); n > 0 && gaps[n-1].End.Equal(t) {
+			gaps[n-1].End = end
+			continue
+		}
+		gaps = append(gaps, Gap{Start: t, End: end})
+	}
+	return gaps
+} // }}}
+
+// func latest {{{
+
+func latest(times ...time.Time) time.Time {
+	t := times[0]
+	for _, o := range times[1:] {
+		if o.After(t) {
+			t = o
+		}
+	}
+	return t
+} // }}}
+
+// func earliest {{{
+
+func earliest(times ...time.Time) time.Time {
+	t := times[0]
+	for _, o := range times[1:] {
+		if o.Before(t) {
+			t = o
+		}
+	}
+	return t
+} // }}}
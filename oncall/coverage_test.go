@@ -0,0 +1,118 @@
+package oncall
+
+import (
+	"testing"
+	"time"
+)
+
+// func TestConflicts {{{
+
+func TestConflicts(t *testing.T) {
+	at := func(h int) time.Time { return testAnchor.Add(time.Duration(h) * time.Hour) }
+	cover := func(id string, start, end int) Cover {
+		return Cover{Member: Member{ID: id}, Start: at(start), End: at(end)}
+	}
+	type want struct {
+		start, end    int
+		winner, loser string
+	}
+
+	cases := []struct {
+		name     string
+		covers   []Cover
+		from, to int
+		want     []want
+	}{
+		{"no covers", nil, 0, 48, nil},
+		{"apart", []Cover{cover("U1", 0, 10), cover("U2", 10, 20)}, 0, 48, nil},
+		{"overlap", []Cover{cover("U1", 0, 10), cover("U2", 5, 20)}, 0, 48, []want{{5, 10, "U1", "U2"}}},
+		{"within", []Cover{cover("U2", 5, 8), cover("U1", 0, 10)}, 0, 48, []want{{5, 8, "U2", "U1"}}},
+		{"clipped to window", []Cover{cover("U1", 0, 30), cover("U2", 10, 40)}, 20, 35, []want{{20, 30, "U1", "U2"}}},
+		{"outside window", []Cover{cover("U1", 0, 10), cover("U2", 5, 20)}, 12, 48, nil},
+		{"in start order", []Cover{cover("U1", 20, 30), cover("U2", 25, 35), cover("U3", 0, 22)}, 0, 48, []want{
+			{20, 22, "U1", "U3"},
+			{25, 30, "U1", "U2"},
+		}},
+	}
+	for _, c := range cases {
+		team := testTeam()
+		team.Covers = c.covers
+		got := team.Conflicts(at(c.from), at(c.to))
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %d conflicts, want %d", c.name, len(got), len(c.want))
+			continue
+		}
+		for i, w := range c.want {
+			g := got[i]
+			if !g.Start.Equal(at(w.start)) || !g.End.Equal(at(w.end)) || g.Winner.Member.ID != w.winner || g.Loser.Member.ID != w.loser {
+				t.Errorf("%s: conflict %d is %s-%s %s over %s, want %+v", c.name, i, g.Start, g.End, g.Winner.Member.ID, g.Loser.Member.ID, w)
+			}
+		}
+	}
+} // }}}
+
+// func TestGaps {{{
+
+func TestGaps(t *testing.T) {
+	at := func(h int) time.Time { return testAnchor.Add(time.Duration(h) * time.Hour) }
+	awayUntil := func(h int) func(*Team) {
+		return func(team *Team) {
+			for i := range team.Rotation {
+				team.Rotation[i].AwayUntil = at(h)
+			}
+		}
+	}
+	type want struct{ start, end int }
+
+	cases := []struct {
+		name     string
+		setup    func(*Team)
+		from, to int
+		want     []want
+	}{
+		{"covered", nil, 0, 48, nil},
+		{"everyone away", awayUntil(10), 0, 48, []want{{0, 10}}},
+		{"away past window", awayUntil(100), 0, 48, []want{{0, 48}}},
+		{"fallback covers", func(team *Team) {
+			awayUntil(10)(team)
+			team.Rotation[2].FallbackID = "U5"
+		}, 0, 48, nil},
+		{"cover fills", func(team *Team) {
+			awayUntil(10)(team)
+			team.Covers = []Cover{{Member: Member{ID: "U9"}, Start: at(3), End: at(5)}}
+		}, 0, 48, []want{{0, 3}, {5, 10}}},
+		{"back at different times", func(team *Team) {
+			awayUntil(10)(team)
+			team.Rotation[1].AwayUntil = at(20)
+			team.Covers = []Cover{{Member: Member{ID: "U9"}, Start: at(-5), End: at(15)}}
+		}, 0, 48, nil},
+		{"joined across moments", func(team *Team) {
+			awayUntil(10)(team)
+			team.Rotation[3].AwayUntil = at(6)
+			team.Covers = []Cover{{Member: Member{ID: "U9"}, Start: at(-5), End: at(2)}}
+		}, 0, 48, []want{{2, 10}}},
+		{"shadow doesn't count", func(team *Team) {
+			for i := range team.Rotation {
+				if !team.Rotation[i].Shadow {
+					team.Rotation[i].AwayUntil = at(10)
+				}
+			}
+		}, 0, 48, []want{{0, 10}}},
+	}
+	for _, c := range cases {
+		team := testTeam()
+		if c.setup != nil {
+			c.setup(team)
+		}
+		got := team.Gaps(at(c.from), at(c.to))
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for i, w := range c.want {
+			if !got[i].Start.Equal(at(w.start)) || !got[i].End.Equal(at(w.end)) {
+				t.Errorf("%s: gap %d is %s-%s, want %+v", c.name, i, got[i].Start, got[i].End, w)
+			}
+		}
+	}
+} // }}}
@@ -0,0 +1,183 @@
+// Package oncall is the scheduling and resolution engine of the on-call bot,
+// free of Slack and datastore types, so Go services can embed it to work out
+// who's on-call instead of calling the bot over HTTP.
+//
+//	team := &oncall.Team{
+//		Name:     "SRE",
+//		Rotation: oncall.Rotation{{ID: "U1", Name: "alice"}, {ID: "U2", Name: "bob"}},
+//		Schedule: oncall.Schedule{Cadence: "weekly", Anchor: anchor, Location: loc},
+//	}
+//	if p := oncall.Resolve(team, time.Now()).Primary; p != nil {
+//		fmt.Println(p.Name, "is on-call")
+//	}
+package oncall
+
+import (
+	"strings"
+	"time"
+)
+
+// Role names.
+const (
+	Primary   = "primary"
+	Secondary = "secondary"
+)
+
+// Cover is someone on primary on-call instead of the rotation from Start
+// (inclusive) to End (exclusive), ie. an override or a dated assignment.
+type Cover struct {
+	Member     Member
+	Start, End time.Time
+}
+
+// Team is everything deciding who's on-call for a team.
+type Team struct {
+	Name     string
+	Rotation Rotation
+	Schedule Schedule
+	// Shifts are windows in which only members with the shift's label are on duty.
+	// Everyone is on duty outside of them.
+	Shifts []Shift
+	// Quiet is the daily hours in which the primary goes to the secondary (label
+	// Secondary) or the first member on duty with the label. None if Label is empty.
+	Quiet Shift
+	// Covers take primary on-call over the rotation, the first one active wins.
+	Covers []Cover
+}
+
+// Teams is a set of teams.
+type Teams []*Team
+
+// func Teams.Find {{{
+
+// Find returns the team with the name, case insensitive, or nil.
+func (ts Teams) Find(name string) *Team {
+	for _, t := range ts {
+		if strings.EqualFold(t.Name, name) {
+			return t
+		}
+	}
+	return nil
+} // }}}
+
+// Resolution is who's in each role of a team at a time. Nil if nobody is.
+type Resolution struct {
+	Primary   *Member
+	Secondary *Member
+}
+
+// func Resolve {{{
+
+// Resolve returns who's primary and secondary on-call of the team at the time.
+// The primary is the active cover if any, else the quiet hours member, else the
+// first member on duty. The secondary is the second member on duty, or the
+// first one if the quiet hours handed the primary to the second.
+func Resolve(team *Team, t time.Time) Resolution {
+	var res Resolution
+	onDuty := team.OnDuty(t)
+	if len(onDuty) > 1 {
+		m, _ := team.Rotation[onDuty[1]].OnDuty(t)
+		res.Secondary = &m
+	}
+	if m, ok := team.Cover(t); ok {
+		res.Primary = &m
+	} else if m, ok := team.QuietMember(t); ok {
+		res.Primary = &m
+		if res.Secondary != nil && res.Secondary.ID == m.ID {
+			s, _ := team.Rotation[onDuty[0]].OnDuty(t)
+			res.Secondary = &s
+		}
+	} else if len(onDuty) > 0 {
+		m, _ := team.Rotation[onDuty[0]].OnDuty(t)
+		res.Primary = &m
+	}
+	return res
+} // }}}
+
+// func Team.minute {{{
+
+// Minute of the day of the time in the team's timezone.
+func (team *Team) minute(t time.Time) int {
+	t = t.In(team.Schedule.location())
+	return t.Hour()*60 + t.Minute()
+} // }}}
+
+// func Team.OnDuty {{{
+
+// OnDuty returns indexes in the rotation of the members taking part at the time,
+// starting from the primary, with members away skipped unless their fallback
+// covers them. During a shift only members with its label are on duty, unless
+// none of them is.
+func (team *Team) OnDuty(t time.Time) []int {
+	members := team.Rotation.Members()
+	n := len(members)
+	offset := team.Schedule.Offset(t, n)
+	onDuty := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		idx := members[(offset+i)%n]
+		if _, ok := team.Rotation[idx].OnDuty(t); ok {
+			onDuty = append(onDuty, idx)
+		}
+	}
+	if s := team.ActiveShift(t); s != nil {
+		var inShift []int
+		for _, idx := range onDuty {
+			if team.Rotation[idx].Label == s.Label {
+				inShift = append(inShift, idx)
+			}
+		}
+		if inShift != nil {
+			return inShift
+		}
+	}
+	return onDuty
+} // }}}
+
+// func Team.ActiveShift {{{
+
+// ActiveShift returns the shift of the team at the time, or nil.
+func (team *Team) ActiveShift(t time.Time) *Shift {
+	m := team.minute(t)
+	for i, s := range team.Shifts {
+		if s.Covers(m) {
+			return &team.Shifts[i]
+		}
+	}
+	return nil
+} // }}}
+
+// func Team.Cover {{{
+
+// Cover returns who covers primary on-call over the rotation at the time, if anyone.
+func (team *Team) Cover(t time.Time) (Member, bool) {
+	for _, c := range team.Covers {
+		if !t.Before(c.Start) && t.Before(c.End) {
+			return c.Member, true
+		}
+	}
+	return Member{}, false
+} // }}}
+
+// func Team.QuietMember {{{
+
+// QuietMember returns who takes primary on-call at the time because of the quiet
+// hours. False outside of quiet hours, or if there's no such member.
+func (team *Team) QuietMember(t time.Time) (Member, bool) {
+	q := team.Quiet
+	if q.Label == "" || !q.Covers(team.minute(t)) {
+		return Member{}, false
+	}
+	onDuty := team.OnDuty(t)
+	if q.Label == Secondary {
+		if len(onDuty) < 2 {
+			return Member{}, false
+		}
+		return team.Rotation[onDuty[1]].OnDuty(t)
+	}
+	for _, idx := range onDuty {
+		if team.Rotation[idx].Label == q.Label {
+			return team.Rotation[idx].OnDuty(t)
+		}
+	}
+	return Member{}, false
+} // }}}
@@ -0,0 +1,102 @@
+package oncall
+
+import (
+	"testing"
+	"time"
+)
+
+// Monday of the first handoff of testTeam.
+var testAnchor = time.Date(2026, 10, 5, 9, 0, 0, 0, time.UTC)
+
+// func testTeam {{{
+
+// Weekly rotation of three members and a shadow, with no shifts or covers.
+func testTeam() *Team {
+	return &Team{
+		Name: "OPS",
+		Rotation: Rotation{
+			{ID: "U1", Name: "alice", Label: "eu"},
+			{ID: "U2", Name: "bob", Label: "us"},
+			{ID: "U3", Name: "carol", Label: "eu"},
+			{ID: "U4", Name: "dave", Shadow: true},
+		},
+		Schedule: Schedule{Cadence: "weekly", Anchor: testAnchor},
+	}
+} // }}}
+
+// func memberID {{{
+
+func memberID(m *Member) string {
+	if m == nil {
+		return ""
+	}
+	return m.ID
+} // }}}
+
+// func TestResolve {{{
+
+func TestResolve(t *testing.T) {
+	cover := Cover{Member: Member{ID: "U9", Name: "erin", Label: "override"}, Start: testAnchor, End: testAnchor.Add(24 * time.Hour)}
+	cases := []struct {
+		name      string
+		setup     func(*Team)
+		at        time.Time
+		primary   string
+		secondary string
+	}{
+		{"first of the list", nil, testAnchor, "U1", "U2"},
+		{"after a handoff", nil, testAnchor.AddDate(0, 0, 7), "U2", "U3"},
+		{"after a round", nil, testAnchor.AddDate(0, 0, 21), "U1", "U2"},
+		{"handoff applied", func(team *Team) {
+			team.Rotation = team.Rotation.Advance(1)
+			team.Schedule.Advanced = 1
+		}, testAnchor.AddDate(0, 0, 7), "U2", "U3"},
+		{"paused", func(team *Team) { team.Schedule.Paused = true }, testAnchor.AddDate(0, 0, 7), "U1", "U2"},
+		{"away", func(team *Team) { team.Rotation[0].AwayUntil = testAnchor.Add(time.Hour) }, testAnchor, "U2", "U3"},
+		{"back from away", func(team *Team) { team.Rotation[0].AwayUntil = testAnchor.Add(time.Hour) }, testAnchor.Add(time.Hour), "U1", "U2"},
+		{"fallback", func(team *Team) {
+			team.Rotation[0].AwayUntil = testAnchor.Add(time.Hour)
+			team.Rotation[0].FallbackID, team.Rotation[0].FallbackName = "U5", "frank"
+		}, testAnchor, "U5", "U2"},
+		{"everyone away", func(team *Team) {
+			for i := range team.Rotation {
+				team.Rotation[i].AwayUntil = testAnchor.Add(time.Hour)
+			}
+		}, testAnchor, "", ""},
+		{"cover", func(team *Team) { team.Covers = []Cover{cover} }, testAnchor, "U9", "U2"},
+		{"cover ended", func(team *Team) { team.Covers = []Cover{cover} }, cover.End, "U1", "U2"},
+		{"first cover wins", func(team *Team) {
+			other := cover
+			other.Member.ID = "U8"
+			team.Covers = []Cover{cover, other}
+		}, testAnchor, "U9", "U2"},
+		{"shift", func(team *Team) { team.Shifts = []Shift{{Label: "us", Start: 0, End: 8 * 60}} }, testAnchor.Add(18 * time.Hour), "U2", ""},
+		{"outside shift", func(team *Team) { team.Shifts = []Shift{{Label: "us", Start: 0, End: 8 * 60}} }, testAnchor, "U1", "U2"},
+		{"shift nobody is on", func(team *Team) {
+			team.Shifts = []Shift{{Label: "us", Start: 0, End: 8 * 60}}
+			team.Rotation[1].AwayUntil = testAnchor.AddDate(0, 0, 1)
+		}, testAnchor.Add(18 * time.Hour), "U1", "U3"},
+		{"quiet to secondary", func(team *Team) { team.Quiet = Shift{Label: Secondary, Start: 22 * 60, End: 6 * 60} }, testAnchor.Add(14 * time.Hour), "U2", "U1"},
+		{"quiet to label", func(team *Team) { team.Quiet = Shift{Label: "eu", Start: 22 * 60, End: 6 * 60} }, testAnchor.AddDate(0, 0, 7).Add(14 * time.Hour), "U3", "U2"},
+		{"quiet to label further down", func(team *Team) { team.Quiet = Shift{Label: "us", Start: 22 * 60, End: 6 * 60} }, testAnchor.AddDate(0, 0, 14).Add(14 * time.Hour), "U2", "U1"},
+		{"quiet to primary's label", func(team *Team) { team.Quiet = Shift{Label: "eu", Start: 22 * 60, End: 6 * 60} }, testAnchor.Add(14 * time.Hour), "U1", "U2"},
+		{"quiet over", func(team *Team) { team.Quiet = Shift{Label: Secondary, Start: 22 * 60, End: 6 * 60} }, testAnchor.Add(22 * time.Hour), "U1", "U2"},
+		{"cover in quiet hours", func(team *Team) {
+			team.Quiet = Shift{Label: Secondary, Start: 22 * 60, End: 6 * 60}
+			team.Covers = []Cover{cover}
+		}, testAnchor.Add(14 * time.Hour), "U9", "U2"},
+	}
+	for _, c := range cases {
+		team := testTeam()
+		if c.setup != nil {
+			c.setup(team)
+		}
+		res := Resolve(team, c.at)
+		if got := memberID(res.Primary); got != c.primary {
+			t.Errorf("%s: primary %q, want %q", c.name, got, c.primary)
+		}
+		if got := memberID(res.Secondary); got != c.secondary {
+			t.Errorf("%s: secondary %q, want %q", c.name, got, c.secondary)
+		}
+	}
+} // }}}
@@ -0,0 +1,70 @@
+package oncall
+
+import "time"
+
+// Member is a person in the on-call list of a team.
+type Member struct {
+	ID    string
+	Name  string
+	Label string
+	// Shadow is a trainee, listed but never on-call.
+	Shadow bool
+	// AwayUntil is when the member is back from vacation. The member is skipped
+	// when resolving who's on-call until then.
+	AwayUntil time.Time
+	// FallbackID and FallbackName is the backup covering the member while away.
+	FallbackID   string
+	FallbackName string
+}
+
+// func Member.Away {{{
+
+// Away reports whether the member is away at the time.
+func (m Member) Away(t time.Time) bool {
+	return t.Before(m.AwayUntil)
+} // }}}
+
+// func Member.OnDuty {{{
+
+// OnDuty returns who takes the member's turns at the time - the member, or the
+// fallback while the member is away. False if the member is away without a fallback.
+func (m Member) OnDuty(t time.Time) (Member, bool) {
+	if !m.Away(t) {
+		return m, true
+	}
+	if m.FallbackID == "" {
+		return Member{}, false
+	}
+	return Member{ID: m.FallbackID, Name: m.FallbackName, Label: m.Label}, true
+} // }}}
+
+// Rotation is the on-call list of a team, primary first as of the last handoff
+// applied to it.
+type Rotation []Member
+
+// func Rotation.Members {{{
+
+// Members returns indexes of the members taking part in the rotation, ie. everyone
+// but shadows.
+func (r Rotation) Members() []int {
+	members := make([]int, 0, len(r))
+	for i, m := range r {
+		if !m.Shadow {
+			members = append(members, i)
+		}
+	}
+	return members
+} // }}}
+
+// func Rotation.Advance {{{
+
+// Advance returns a copy of the rotation advanced n times - members move up n
+// slots among themselves, and shadows stay where they are.
+func (r Rotation) Advance(n int) Rotation {
+	advanced := append(Rotation(nil), r...)
+	members := r.Members()
+	for i, idx := range members {
+		advanced[idx] = r[members[(i+n)%len(members)]]
+	}
+	return advanced
+} // }}}
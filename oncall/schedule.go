@@ -0,0 +1,109 @@
+package oncall
+
+import "time"
+
+// Number of days between handoffs of each cadence.
+var cadenceDays = map[string]int{"daily": 1, "weekly": 7, "biweekly": 14}
+
+// func CadenceDays {{{
+
+// CadenceDays returns the number of days between handoffs of the cadence, 0 if
+// it isn't "daily", "weekly" or "biweekly".
+func CadenceDays(cadence string) int {
+	return cadenceDays[cadence]
+} // }}}
+
+// Schedule is when the primary of a team hands off to the next member.
+type Schedule struct {
+	// Cadence is one CadenceDays knows, empty for teams rotated manually.
+	Cadence string
+	// Anchor is the moment handoffs are counted from.
+	Anchor time.Time
+	// Location is the timezone of the team, which handoffs, shifts and quiet hours
+	// are in. UTC if nil.
+	Location *time.Location
+	// Advanced is the number of handoffs since the anchor already applied to the
+	// order of the rotation.
+	Advanced int
+	// Paused stops handoffs, the rotation stays in its order.
+	Paused bool
+}
+
+// func Schedule.location {{{
+
+func (s Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.UTC
+	}
+	return s.Location
+} // }}}
+
+// func Schedule.Advances {{{
+
+// Advances returns the number of handoffs between the anchor and the time.
+func (s Schedule) Advances(t time.Time) int {
+	days := CadenceDays(s.Cadence)
+	if days == 0 {
+		return 0
+	}
+	loc := s.location()
+	a := s.Anchor.In(loc)
+	t = t.In(loc)
+	if t.Before(a) {
+		return 0
+	}
+	// Count calendar days rather than 24h periods so DST changes don't move the handoff.
+	elapsed := int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Sub(
+		time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	// Handoff time of today isn't reached yet.
+	if t.Hour()*60+t.Minute() < a.Hour()*60+a.Minute() {
+		elapsed--
+	}
+	return elapsed / days
+} // }}}
+
+// func Schedule.Handoff {{{
+
+// Handoff returns the time of the n-th handoff from the anchor.
+func (s Schedule) Handoff(n int) time.Time {
+	return s.Anchor.In(s.location()).AddDate(0, 0, n*CadenceDays(s.Cadence))
+} // }}}
+
+// func Schedule.Offset {{{
+
+// Offset returns how many positions the primary has moved down a rotation of n
+// members at the time, from its order as of the last applied handoff.
+// Always 0 for teams rotated manually or paused.
+func (s Schedule) Offset(t time.Time, n int) int {
+	if s.Cadence == "" || s.Paused || n == 0 {
+		return 0
+	}
+	return ((s.Advances(t)-s.Advanced)%n + n) % n
+} // }}}
+
+// Shift is a daily time window, ie. the working hours of a region.
+type Shift struct {
+	// Label of the members on duty during the shift, or who takes the primary in
+	// quiet hours.
+	Label string
+	// Start and End are minutes after midnight in the team's timezone. The shift
+	// spans midnight if it ends before it starts.
+	Start, End int
+}
+
+// func Shift.Covers {{{
+
+// Covers reports whether the minute of the day is within the shift.
+func (s Shift) Covers(minute int) bool {
+	if s.Start < s.End {
+		return s.Start <= minute && minute < s.End
+	}
+	return s.Start <= minute || minute < s.End
+} // }}}
+
+// func Shift.Overlaps {{{
+
+// Overlaps reports whether the shifts share any minute of the day.
+func (s Shift) Overlaps(o Shift) bool {
+	return s.Covers(o.Start) || o.Covers(s.Start)
+} // }}}
@@ -0,0 +1,82 @@
+package oncall
+
+import (
+	"testing"
+	"time"
+)
+
+// func TestCadenceDays {{{
+
+func TestCadenceDays(t *testing.T) {
+	for cadence, want := range map[string]int{"daily": 1, "weekly": 7, "biweekly": 14, "": 0, "monthly": 0} {
+		if got := CadenceDays(cadence); got != want {
+			t.Errorf("%q: got %d, want %d", cadence, got, want)
+		}
+	}
+} // }}}
+
+// func TestAdvances {{{
+
+func TestAdvances(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchor := time.Date(2026, 10, 5, 9, 0, 0, 0, time.UTC)
+	// A week before clocks go forward in Berlin.
+	spring := time.Date(2026, 3, 23, 9, 0, 0, 0, berlin)
+
+	cases := []struct {
+		name     string
+		schedule Schedule
+		at       time.Time
+		want     int
+	}{
+		{"before anchor", Schedule{Cadence: "weekly", Anchor: anchor}, anchor.Add(-time.Hour), 0},
+		{"at anchor", Schedule{Cadence: "weekly", Anchor: anchor}, anchor, 0},
+		{"minute before handoff", Schedule{Cadence: "weekly", Anchor: anchor}, anchor.AddDate(0, 0, 7).Add(-time.Minute), 0},
+		{"at handoff", Schedule{Cadence: "weekly", Anchor: anchor}, anchor.AddDate(0, 0, 7), 1},
+		{"weeks later", Schedule{Cadence: "weekly", Anchor: anchor}, anchor.AddDate(0, 0, 21).Add(time.Hour), 3},
+		{"daily", Schedule{Cadence: "daily", Anchor: anchor}, anchor.AddDate(0, 0, 2), 2},
+		{"biweekly", Schedule{Cadence: "biweekly", Anchor: anchor}, anchor.AddDate(0, 0, 27), 1},
+		{"manual", Schedule{Anchor: anchor}, anchor.AddDate(0, 0, 7), 0},
+		{"unknown cadence", Schedule{Cadence: "monthly", Anchor: anchor}, anchor.AddDate(0, 0, 31), 0},
+		{"across DST", Schedule{Cadence: "weekly", Anchor: spring, Location: berlin}, time.Date(2026, 3, 30, 9, 0, 0, 0, berlin), 1},
+		{"before handoff across DST", Schedule{Cadence: "weekly", Anchor: spring, Location: berlin}, time.Date(2026, 3, 30, 8, 59, 0, 0, berlin), 0},
+		{"across DST in UTC", Schedule{Cadence: "weekly", Anchor: spring, Location: berlin}, time.Date(2026, 3, 30, 7, 0, 0, 0, time.UTC), 1},
+	}
+	for _, c := range cases {
+		if got := c.schedule.Advances(c.at); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+} // }}}
+
+// func TestOffset {{{
+
+func TestOffset(t *testing.T) {
+	anchor := time.Date(2026, 10, 5, 9, 0, 0, 0, time.UTC)
+	weeks := func(n int) time.Time { return anchor.AddDate(0, 0, 7*n) }
+
+	cases := []struct {
+		name     string
+		schedule Schedule
+		at       time.Time
+		members  int
+		want     int
+	}{
+		{"manual", Schedule{Anchor: anchor}, weeks(3), 3, 0},
+		{"paused", Schedule{Cadence: "weekly", Anchor: anchor, Paused: true}, weeks(3), 3, 0},
+		{"no members", Schedule{Cadence: "weekly", Anchor: anchor}, weeks(3), 0, 0},
+		{"nothing applied", Schedule{Cadence: "weekly", Anchor: anchor}, weeks(1), 3, 1},
+		{"wraps around", Schedule{Cadence: "weekly", Anchor: anchor}, weeks(4), 3, 1},
+		{"all applied", Schedule{Cadence: "weekly", Anchor: anchor, Advanced: 3}, weeks(3), 2, 0},
+		{"partly applied", Schedule{Cadence: "weekly", Anchor: anchor, Advanced: 2}, weeks(4), 3, 2},
+		{"applied ahead", Schedule{Cadence: "weekly", Anchor: anchor, Advanced: 5}, weeks(3), 3, 1},
+	}
+	for _, c := range cases {
+		if got := c.schedule.Offset(c.at, c.members); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+} // }}}
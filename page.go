@@ -0,0 +1,116 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// func page {{{
+
+// page {team} {message}
+//
+// DM the message to the primary on-call of the team. If the primary is away in
+// Slack or gone from Slack, their fallback gets it too. If the fallback isn't
+// reachable either, or there's no fallback or the list is empty, the managers get
+// it too so the page doesn't go unnoticed.
+func page(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opPage)
+	if !ok || p.team == "" || p.message == "" {
+		return slackResponse{Text: help(ctx, "page")}
+	}
+
+	res := slackResponse{}
+	oncallMut.RLock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.RUnlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	primary, hasPrimary := memberByRole(current, rolePrimary)
+	managers := append([]ManagerProperty(nil), current.Managers...)
+	oncallMut.RUnlock()
+
+	var targets []string
+	var backup bool
+	fallback := !hasPrimary
+	if hasPrimary {
+		reachable, err := userReachable(ctx, primary.Id)
+		if err != nil {
+			log.Warningf(ctx, "(page) error checking presence of %s - %s", primary.Name, err)
+		}
+		fallback = !reachable
+		targets = append(targets, primary.Id)
+		if fallback && primary.FallbackId != "" {
+			reachable, err = userReachable(ctx, primary.FallbackId)
+			if err != nil {
+				log.Warningf(ctx, "(page) error checking presence of %s - %s", primary.FallbackName, err)
+			}
+			fallback = !reachable
+			backup = true
+			targets = append(targets, primary.FallbackId)
+		}
+	}
+	if fallback {
+		for _, m := range managers {
+			targets = append(targets, m.Id)
+		}
+	}
+	if len(targets) == 0 {
+		res.Text = fmt.Sprintf("Sorry, %s has no one on-call nor managers to page %s", p.team, humanErrorEmoji)
+		return res
+	}
+
+	var paged []string
+	for _, id := range targets {
+		params := url.Values{}
+		params.Set("channel", id)
+		params.Set("text", fmt.Sprintf("<@%s> page for %s from <@%s|%s>: %s", id, p.team, p.by.id, p.by.name, p.message))
+		if err := callSlackAPI(ctx, "chat.postMessage", params); err != nil {
+			log.Warningf(ctx, "(page) error paging %s of %s - %s", id, p.team, err)
+			continue
+		}
+		paged = append(paged, fmt.Sprintf("<@%s>", id))
+	}
+	if len(paged) == 0 {
+		res.Text = errorExternal
+		return res
+	}
+
+	res.Text = fmt.Sprintf("Paged %s for %s", strings.Join(paged, ", "), p.team)
+	switch {
+	case fallback:
+		res.Text += " (primary on-call is not reachable, paged the managers)"
+	case backup:
+		res.Text += " (primary on-call is not reachable, paged their fallback)"
+	}
+	return res
+} // }}}
+
+// func userReachable {{{
+
+// Check if the user still exists in Slack and isn't away.
+// While Slack is slow the presence isn't checked and the user is treated as reachable.
+func userReachable(ctx context.Context, id string) (bool, error) {
+	u, err := getSlackUserDetail(ctx, id, false)
+	if err != nil {
+		return true, err
+	}
+	if u == nil {
+		return false, nil
+	}
+	if skipOptional(ctx, "presence lookup") {
+		return true, nil
+	}
+	start := time.Now()
+	presence, err := newSlackClient(ctx).GetUserPresenceContext(ctx, id)
+	observeSlackLatency(ctx, time.Since(start))
+	if err != nil {
+		return true, err
+	}
+	return presence.Presence != "away", nil
+} // }}}
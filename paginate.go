@@ -0,0 +1,71 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// func paginate {{{
+
+// Cut the attachments of the response to the page of "response_max_lines" lines,
+// so long responses (ie. "list all", "history") aren't rejected or mangled by
+// Slack. What's left out is counted in a last attachment, with buttons running
+// the command again for the previous and next page when it only displays things.
+// Responses fitting in one page are returned as they are.
+func paginate(res slackResponse, text string, page int) slackResponse {
+	max := responseMaxLines
+	if max == 0 {
+		return res
+	}
+	skip, budget := page*max, max
+	var before, after int
+	var attachments []attachment
+	for _, a := range res.Attachments {
+		lines := strings.Split(a.Text, "\n")
+		if skip >= len(lines) {
+			skip -= len(lines)
+			before += len(lines)
+			continue
+		}
+		lines, before, skip = lines[skip:], before+skip, 0
+		if budget == 0 {
+			after += len(lines)
+			continue
+		}
+		if len(lines) > budget {
+			after += len(lines) - budget
+			lines = lines[:budget]
+		}
+		budget -= len(lines)
+		a.Text = strings.Join(lines, "\n")
+		attachments = append(attachments, a)
+	}
+	if before == 0 && after == 0 {
+		return res
+	}
+
+	res.Attachments = attachments
+	if page > 0 {
+		res.Text += fmt.Sprintf(" _(page %d)_", page+1)
+	}
+	more := attachment{Color: defaultColor, Text: fmt.Sprintf("_%d more lines not shown_", after)}
+	if after == 0 {
+		more.Text = "_End of the list_"
+	}
+	stuff := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	if !stringInSlice(resolveOperation(strings.ToLower(stuff[0])), readOnlyOperations) {
+		// Running it again would change things again.
+		res.Attachments = append(res.Attachments, more)
+		return res
+	}
+	more.CallbackId = callbackPage
+	if page > 0 {
+		more.Actions = append(more.Actions, attachmentAction{Name: "previous", Text: "Previous", Type: "button", Value: strconv.Itoa(page-1) + " " + text})
+	}
+	if after > 0 {
+		more.Actions = append(more.Actions, attachmentAction{Name: "next", Text: "Next", Type: "button", Value: strconv.Itoa(page+1) + " " + text})
+	}
+	res.Attachments = append(res.Attachments, more)
+	return res
+} // }}}
@@ -0,0 +1,192 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"time"
+)
+
+// func plan {{{
+
+// plan {team} flush {YYYY-MM-DD} {HH:MM}
+// plan {team} copy {source_team} {YYYY-MM-DD} {HH:MM}
+// plan {team} cancel
+//
+// Schedule a flush of the on-call list of the team, or its replacement with the
+// list of the source team as it is then, ie. a staging team prepared for a
+// reorganization. The "/cron/plans" job applies it once the time has come, so
+// nobody needs to be online. The team has one plan at a time, without a plan
+// the current one is displayed.
+func plan(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opPlan)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "plan")}
+	}
+
+	res := slackResponse{}
+	oncallMut.RLock()
+	current := findRotation(p.team)
+	source := findRotation(p.source)
+	oncallMut.RUnlock()
+	if current == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+
+	existing, err := loadPlan(ctx, p.team)
+	if err != nil {
+		log.Warningf(ctx, "(plan) error loading plan - %s", err)
+		res.Text = errorExternal
+		return res
+	}
+	switch {
+	case p.show:
+		if existing == nil {
+			res.Text = fmt.Sprintf("%s has no planned change", p.team)
+		} else {
+			res.Text = fmt.Sprintf("%s will %s (planned by <@%s|%s>)", p.team, describePlan(existing), existing.ById, existing.By)
+		}
+		return res
+	case p.cancel:
+		if existing == nil {
+			res.Text = fmt.Sprintf("Sorry, %s has no planned change %s", p.team, humanErrorEmoji)
+			return res
+		}
+		if err = deletePlan(ctx, existing); err != nil {
+			log.Warningf(ctx, "(plan) error deleting plan - %s", err)
+			res.Text = errorExternal
+			return res
+		}
+		recordHistory(ctx, p.team, "plan", p.by.name, "cancelled plan to "+describePlan(existing), current.Rotations)
+		res.Text = fmt.Sprintf("Success! %s won't %s anymore", p.team, describePlan(existing))
+		return res
+	}
+
+	if !p.apply.After(time.Now()) {
+		res.Text = fmt.Sprintf("Sorry, %s is in the past %s", p.apply.In(timezone).Format(dateFormat), humanErrorEmoji)
+		return res
+	}
+	if p.source != "" && source == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.source, humanErrorEmoji)
+		return res
+	}
+	entity := &planProperty{Team: p.team, Source: p.source, Apply: p.apply, By: p.by.name, ById: p.by.id, Created: time.Now()}
+	if err = savePlan(ctx, entity); err != nil {
+		log.Warningf(ctx, "(plan) error saving plan - %s", err)
+		res.Text = errorExternal
+		return res
+	}
+	recordHistory(ctx, p.team, "plan", p.by.name, "planned to "+describePlan(entity), current.Rotations)
+	res.Text = fmt.Sprintf("Success! %s will %s, you and the managers will be told once it's done", p.team, describePlan(entity))
+	if existing != nil {
+		res.Text += fmt.Sprintf(" (replacing the plan to %s)", describePlan(existing))
+	}
+	return res
+} // }}}
+
+// func planCronHandler {{{
+
+// Cron handler applying planned changes whose time has come. The change can be
+// reverted by "undo" like any other, and the requester and the managers of the
+// team are told by DM.
+func planCronHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	// Only AppEngine cron is allowed to call this.
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		log.Warningf(ctx, "(cron) request not from cron")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := ensureState(ctx); err != nil {
+		http.Error(w, "error loading state", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	plans, err := loadPlansDue(ctx, now)
+	if err != nil {
+		log.Warningf(ctx, "(cron) error loading plans - %s", err)
+		http.Error(w, "error loading plans", http.StatusInternalServerError)
+		return
+	}
+
+	type notice struct {
+		text   string
+		notify []string
+	}
+	var notices []notice
+	oncallMut.Lock()
+	for _, pl := range plans {
+		current := findRotation(pl.Team)
+		if current == nil {
+			// Unregistered since, nothing to apply it to.
+			log.Warningf(ctx, "(cron) dropping plan of missing team %s", pl.Team)
+			if err = deletePlan(ctx, pl); err != nil {
+				log.Warningf(ctx, "(cron) error deleting plan of %s - %s", pl.Team, err)
+			}
+			continue
+		}
+		n := notice{notify: []string{pl.ById}}
+		for _, m := range current.Managers {
+			n.notify = append(n.notify, m.Id)
+		}
+
+		var rotations []RotationProperty
+		if pl.Source != "" {
+			source := findRotation(pl.Source)
+			if source == nil {
+				n.text = fmt.Sprintf("%s The plan to %s couldn't be applied, team %s does not exist anymore. The on-call list is unchanged.", externalErrorEmoji, describePlan(pl), pl.Source)
+				if err = deletePlan(ctx, pl); err != nil {
+					log.Warningf(ctx, "(cron) error deleting plan of %s - %s", pl.Team, err)
+				}
+				notices = append(notices, n)
+				continue
+			}
+			rotations = append([]RotationProperty(nil), source.Rotations...)
+		}
+
+		previous := *current
+		current.Rotations = rotations
+		current.Updated = now
+		current.UpdatedBy = pl.By
+		// Kept for "undo", and retried on the next run if it fails.
+		if err = replaceState(ctx, "plan", pl.By, &previous, current); err != nil {
+			log.Warningf(ctx, "(cron) error applying plan of %s - %s", pl.Team, err)
+			*current = previous
+			continue
+		}
+		if err = deletePlan(ctx, pl); err != nil {
+			log.Warningf(ctx, "(cron) error deleting plan of %s - %s", pl.Team, err)
+		}
+		recordHistory(ctx, pl.Team, "plan", pl.By, "applied plan to "+describePlan(pl), current.Rotations)
+		n.text = fmt.Sprintf("The planned change of %s has been applied, it was to %s. `%s undo %s` reverts it.", pl.Team, describePlan(pl), commandName(ctx), pl.Team)
+		notices = append(notices, n)
+	}
+	oncallMut.Unlock()
+
+	// Tell the requesters and managers outside of the lock.
+	for _, n := range notices {
+		notified := map[string]bool{}
+		for _, id := range n.notify {
+			if notified[id] {
+				continue
+			}
+			notified[id] = true
+			postDM(ctx, id, n.text)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+} // }}}
+
+// func describePlan {{{
+
+// Human readable planned change.
+func describePlan(p *planProperty) string {
+	at := p.Apply.In(timezone).Format(dateFormat)
+	if p.Source == "" {
+		return "flush its on-call list at " + at
+	}
+	return fmt.Sprintf("replace its on-call list with the one of %s at %s", p.Source, at)
+} // }}}
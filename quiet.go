@@ -0,0 +1,88 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"strings"
+	"time"
+)
+
+// func quiet {{{
+
+// quiet {team} {HH:MM-HH:MM} {secondary|label}
+//
+// Set the daily hours in which primary on-call of the team goes to the secondary,
+// or to a member with the label, ie. when the primary is a coordinator only
+// during the day. Without hours, display the current ones.
+func quiet(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opQuiet)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "quiet")}
+	}
+
+	res := slackResponse{}
+	oncallMut.Lock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	if p.show {
+		if str := describeQuiet(current, time.Now()); str == "" {
+			res.Text = fmt.Sprintf("%s has no quiet hours", p.team)
+		} else {
+			res.Text = fmt.Sprintf("Quiet hours of %s: %s", p.team, str)
+		}
+		oncallMut.Unlock()
+		return res
+	}
+	if l := p.quiet.Label; l != "" && l != roleSecondary && labeledPosition(current.Rotations, l, 1) == 0 {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, no one in the on-call list of %s is labeled %s %s", p.team, l, humanErrorEmoji)
+		return res
+	}
+
+	previous := *current
+	current.Quiet = p.quiet
+	current.Updated = time.Now()
+	current.UpdatedBy = p.by.name
+	if err := resetState(ctx, current); err != nil {
+		log.Warningf(ctx, "(quiet) error saving state - %s", err)
+		*current = previous
+		oncallMut.Unlock()
+		res.Text = errorExternal
+		return res
+	}
+	detail := "removed quiet hours"
+	if p.quiet.Label != "" {
+		detail = "set quiet hours " + describeQuiet(current, time.Now())
+	}
+	recordHistory(ctx, p.team, "quiet", p.by.name, detail, current.Rotations)
+	oncallMut.Unlock()
+
+	res.Text = fmt.Sprintf("Success! %s for %s\nNew list:", strings.ToUpper(detail[:1])+detail[1:], p.team)
+	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
+	return res
+} // }}}
+
+// func describeQuiet {{{
+
+// Human readable quiet hours of the team, empty if there are none.
+// Caller must hold oncallMut.
+func describeQuiet(r *oncallProperty, t time.Time) string {
+	q := r.Quiet
+	if q.Label == "" {
+		return ""
+	}
+	str := fmt.Sprintf("%02d:%02d-%02d:%02d", q.Start/60, q.Start%60, q.End/60, q.End%60)
+	if r.Timezone != "" {
+		str += " " + r.Timezone
+	}
+	str += " to " + q.Label
+	if u, ok := quietMember(r, t); ok {
+		str += fmt.Sprintf(" - _now <@%s|%s>_", u.Id, u.Name)
+	}
+	return str
+} // }}}
@@ -0,0 +1,113 @@
+package slackoncallbot
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Token bucket shared by every Slack API call of this instance.
+//
+// Slash command responses may use every token, background work (cron jobs,
+// unfurls) leaves the reserved part of the bucket alone so it never uses up the
+// quota a waiting user needs.
+type tokenBucket struct {
+	mu sync.Mutex
+	// Tokens added per second, and the most the bucket holds.
+	rate, burst float64
+	// Tokens only interactive requests may take.
+	reserve float64
+	tokens  float64
+	last    time.Time
+}
+
+var (
+	// Limiter of Slack API calls. nil if "slack_rate_limit" is 0.
+	slackLimiter *tokenBucket
+	// Returned when the request can't get a token before its deadline.
+	errRateLimited = errors.New("slack api rate limit reached")
+)
+
+// func newTokenBucket {{{
+
+// Create a full bucket allowing perMinute calls a minute on average.
+func newTokenBucket(perMinute int) *tokenBucket {
+	rate := float64(perMinute) / 60
+	burst := rate * 10
+	if burst < 2 {
+		burst = 2
+	}
+	return &tokenBucket{rate: rate, burst: burst, reserve: burst / 2, tokens: burst, last: time.Now()}
+} // }}}
+
+// func tokenBucket.take {{{
+
+// Take a token if there's one for the priority, otherwise return how long to wait
+// until there will be.
+func (b *tokenBucket) take(background bool) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	floor := 0.0
+	if background {
+		floor = b.reserve
+	}
+	if b.tokens-1 >= floor {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((floor + 1 - b.tokens) / b.rate * float64(time.Second))
+} // }}}
+
+// func tokenBucket.wait {{{
+
+// Block until a token is taken, or the context is done.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	background := ctx.Value(ctxKeyBackground) != nil
+	for {
+		d := b.take(background)
+		if d == 0 {
+			return nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(d).After(deadline) {
+			log.Warningf(ctx, "Slack API rate limit reached (background=%t), giving up", background)
+			return errRateLimited
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+} // }}}
+
+// Transport wrapper waiting for a token before each Slack API call.
+type limitedTransport struct {
+	base http.RoundTripper
+	// urlfetch transports don't carry the request context, so it's kept here.
+	ctx context.Context
+}
+
+// func limitedTransport.RoundTrip {{{
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if slackLimiter != nil {
+		ctx := t.ctx
+		if ctx == nil {
+			ctx = req.Context()
+		}
+		if err := slackLimiter.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(req)
+} // }}}
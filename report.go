@@ -0,0 +1,207 @@
+package slackoncallbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// func report {{{
+
+// report {team} ...
+//
+// List, schedule or cancel periodic reports of the team's on-call list, along
+// with its upcoming handoffs if it rotates on a cadence.
+// Reports are posted by the cron handler to a channel or as a DM. Incident
+// counts aren't part of them, the bot keeps no incident data.
+func report(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opReport)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "report")}
+	}
+
+	res := slackResponse{}
+	oncallMut.RLock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.RUnlock()
+		res.Text = fmt.Sprintf("Team %s is not registered in oncall command %s", p.team, humanErrorEmoji)
+		return res
+	}
+	channel, bound := teamChannel(current)
+	oncallMut.RUnlock()
+	// Reports go to the team's channel unless a destination is given.
+	if p.dest == "" && (p.frequency != "" || p.cancel) {
+		if !bound {
+			res.Text = fmt.Sprintf("Sorry, %s has no channel to post to, give a destination or bind one with `%s restrict` %s", p.team, commandName(ctx), humanErrorEmoji)
+			return res
+		}
+		p.dest, p.destName = channel.Id, "#"+channel.Name
+	}
+
+	reports, err := loadReports(ctx, p.team)
+	if err != nil {
+		log.Warningf(ctx, "(report) error loading reports - %s", err)
+		res.Text = errorExternal
+		return res
+	}
+
+	// No schedule given, display current reports.
+	if p.frequency == "" && !p.cancel {
+		if len(reports) == 0 {
+			res.Text = fmt.Sprintf("No reports scheduled for %s", p.team)
+			return res
+		}
+		var str []string
+		for _, r := range reports {
+			str = append(str, fmt.Sprintf("%s to %s (next: %s)", describeReport(r), r.DestinationName, r.Next.In(timezone).Format(dateFormat)))
+		}
+		res.Text = "Scheduled reports for: " + p.team
+		res.Attachments = []attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}}
+		return res
+	}
+
+	if p.cancel {
+		var removed int
+		for _, r := range reports {
+			if r.Destination != p.dest {
+				continue
+			}
+			if err = deleteState(ctx, r.Key); err != nil {
+				log.Warningf(ctx, "(report) error deleting report - %s", err)
+				res.Text = errorExternal
+				return res
+			}
+			removed++
+		}
+		if removed == 0 {
+			res.Text = fmt.Sprintf("Sorry, no reports of %s are sent to %s %s", p.team, p.destName, humanErrorEmoji)
+			return res
+		}
+		res.Text = fmt.Sprintf("Success! Reports of %s will no longer be sent to %s", p.team, p.destName)
+		return res
+	}
+
+	r := &reportProperty{
+		Team:            p.team,
+		Frequency:       p.frequency,
+		Weekday:         int(p.weekday),
+		Hour:            p.hour,
+		Minute:          p.minute,
+		Destination:     p.dest,
+		DestinationName: p.destName,
+		CreatedBy:       p.by.name,
+	}
+	r.Next = nextReportTime(r, time.Now())
+	if err = saveReport(ctx, r); err != nil {
+		log.Warningf(ctx, "(report) error saving report - %s", err)
+		res.Text = errorExternal
+		return res
+	}
+	res.Text = fmt.Sprintf("Success! On-call list for %s will be posted %s to %s, starting %s", p.team, describeReport(r), r.DestinationName, r.Next.In(timezone).Format(dateFormat))
+	return res
+} // }}}
+
+// func describeReport {{{
+
+// Human readable schedule of the report, ie. "weekly on Monday at 09:00".
+func describeReport(r *reportProperty) string {
+	at := fmt.Sprintf("%02d:%02d", r.Hour, r.Minute)
+	if r.Frequency == "weekly" {
+		return fmt.Sprintf("weekly on %s at %s", time.Weekday(r.Weekday), at)
+	}
+	return "daily at " + at
+} // }}}
+
+// func nextReportTime {{{
+
+// Return the first time after "from" the report should be posted.
+// Report times are in the configured timezone.
+func nextReportTime(r *reportProperty, from time.Time) time.Time {
+	from = from.In(timezone)
+	next := time.Date(from.Year(), from.Month(), from.Day(), r.Hour, r.Minute, 0, 0, timezone)
+	if r.Frequency == "weekly" {
+		next = next.AddDate(0, 0, (r.Weekday-int(next.Weekday())+7)%7)
+		if !next.After(from) {
+			next = next.AddDate(0, 0, 7)
+		}
+		return next
+	}
+	if !next.After(from) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+} // }}}
+
+// func reportCronHandler {{{
+
+// Cron handler posting scheduled reports which are due.
+func reportCronHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	// Only AppEngine cron is allowed to call this.
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		log.Warningf(ctx, "(cron) request not from cron")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := ensureState(ctx); err != nil {
+		http.Error(w, "error loading state", http.StatusInternalServerError)
+		return
+	}
+
+	reports, err := loadReports(ctx, "")
+	if err != nil {
+		log.Warningf(ctx, "(cron) error loading reports - %s", err)
+		http.Error(w, "error loading reports", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	for _, rep := range reports {
+		if rep.Next.After(now) {
+			continue
+		}
+		if err = postReport(ctx, rep); err != nil {
+			log.Warningf(ctx, "(cron) error posting report of %s to %s - %s", rep.Team, rep.DestinationName, err)
+		}
+		// Schedule next one even if this one failed, so a broken destination
+		// won't be retried on every cron run.
+		rep.Next = nextReportTime(rep, now)
+		if err = saveReport(ctx, rep); err != nil {
+			log.Warningf(ctx, "(cron) error saving report - %s", err)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+} // }}}
+
+// func postReport {{{
+
+// Post on-call list of the team to the report's destination, followed by the
+// next handoffs if the team rotates on a cadence.
+func postReport(ctx context.Context, r *reportProperty) error {
+	attachments := []attachment{generateOncallList(ctx, r.Team)}
+	oncallMut.RLock()
+	if current := findRotation(r.Team); current != nil && current.Cadence != "" {
+		attachments = append(attachments, attachment{
+			Color: defaultColor,
+			Title: fmt.Sprintf("Next %d handoffs (%s)", reportHandoffs, describeCadence(current)),
+			Text:  strings.Join(upcomingHandoffs(current, time.Now(), reportHandoffs), "\n"),
+		})
+	}
+	oncallMut.RUnlock()
+	att, err := json.Marshal(attachments)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("channel", r.Destination)
+	params.Set("text", "On-call list for: "+r.Team)
+	params.Set("attachments", string(att))
+	return callSlackAPI(ctx, "chat.postMessage", params)
+} // }}}
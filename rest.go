@@ -0,0 +1,105 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"github.com/fladz/slack-oncall-command/oncall"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// func rest {{{
+
+// rest {team} {days|off}
+//
+// Set the days a member of the team rests after a primary shift before serving
+// primary again. Reordering the list ("swap", "move", "rotate", ...) is refused
+// if it would break it, and scheduled handoffs breaking it are flagged to the
+// managers. Without days, display the current minimum rest.
+func rest(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opRest)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "rest")}
+	}
+	return setLimit(ctx, restLimit, p.team, p.by, p.days, p.show)
+} // }}}
+
+// Minimum rest between primary shifts, looking back and ahead as many days.
+var restLimit = primaryLimit{
+	op:    "rest",
+	value: func(r *oncallProperty) *int { return &r.MinRest },
+	window: func(days int, now time.Time) (time.Time, time.Time) {
+		rest := time.Duration(days) * 24 * time.Hour
+		return now.Add(-rest), now.Add(rest)
+	},
+	breach: restBreach,
+	rule: func(days int) string {
+		return fmt.Sprintf("rest at least %d day(s) between primary shifts", days)
+	},
+	none: "no minimum rest between primary shifts",
+	detail: func(days int) string {
+		if days == 0 {
+			return "removed minimum rest"
+		}
+		return fmt.Sprintf("set minimum rest of %d day(s)", days)
+	},
+	warn: func(r *oncallProperty) string {
+		if days := rotationCycleDays(r); days > 0 && days < r.MinRest {
+			return fmt.Sprintf("With %d members rotating %s everyone is primary again every %d days, scheduled handoffs will break it", len(rotationMembers(r.Rotations)), r.Cadence, days)
+		}
+		return ""
+	},
+}
+
+// func rotationCycleDays {{{
+
+// Return how many days it takes the cadence to get through everyone in the
+// rotation of the team, 0 if it rotates manually.
+// Caller must hold oncallMut.
+func rotationCycleDays(r *oncallProperty) int {
+	if r.Cadence == "" {
+		return 0
+	}
+	return len(rotationMembers(r.Rotations)) * oncall.CadenceDays(r.Cadence)
+} // }}}
+
+// func restBreach {{{
+
+// Check if any of the primaries starting would serve within the minimum rest of
+// the end of their last primary shift, given the shifts served within the rest.
+func restBreach(days int, w primaryWalk) (primaryStart, string) {
+	rest := time.Duration(days) * 24 * time.Hour
+	last := map[string]time.Time{}
+	for id, s := range w.served {
+		last[id] = s.last
+	}
+	for _, s := range w.starts {
+		if s.relieved != "" {
+			last[s.relieved] = s.at
+		}
+		// A shift running up to the start is the same one going on.
+		if end, ok := last[s.member.ID]; ok && end.Before(s.at) && s.at.Sub(end) < rest {
+			return s, describeRestGap(s.at.Sub(end)) + " after their last primary shift"
+		}
+	}
+	return primaryStart{}, ""
+} // }}}
+
+// func describeRestTime {{{
+
+// Human readable moment someone would be primary.
+func describeRestTime(t, now time.Time) string {
+	if !t.After(now) {
+		return "now"
+	}
+	return "from " + t.In(timezone).Format("Mon "+dateFormat)
+} // }}}
+
+// func describeRestGap {{{
+
+// Human readable time between primary shifts.
+func describeRestGap(d time.Duration) string {
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%d hour(s)", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d day(s)", int(d.Hours()/24))
+} // }}}
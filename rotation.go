@@ -0,0 +1,300 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// func rotationCronHandler {{{
+
+// Cron handler applying handoffs of teams with a cadence.
+//
+// The primary of such teams is already computed from the time elapsed since the
+// anchor, so this only writes the handoffs into the stored list and tells people
+// about it. Since the number of handoffs is computed from the anchor rather than
+// counted per run, missed runs (instance down over a weekend) are all caught up
+// on the next one instead of leaving the list behind.
+//
+// The new primary is asked to accept the handoff, declining it pauses handoffs of
+// the team until "handoff resume". Members onboarding count down the handoffs they
+// shadow the primary for and join the rotation after the last.
+//
+// Teams bound to a channel (the first one of "restrict") also get handoffs posted
+// there, and an alert when nobody is on-call. Handoffs breaking the minimum rest
+// of the team are flagged in the messages. Managers are told once of covers
+// overlapping and times nobody will be on-call in the coming days.
+//
+// With "handoff_reminder" set, the outgoing and incoming primary are also DMed
+// once the next handoff is that close.
+func rotationCronHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	// Only AppEngine cron is allowed to call this.
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		log.Warningf(ctx, "(cron) request not from cron")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := ensureState(ctx); err != nil {
+		http.Error(w, "error loading state", http.StatusInternalServerError)
+		return
+	}
+
+	type handoff struct {
+		team   string
+		missed int
+		notify []string
+		// Team's channel, if bound.
+		channel string
+		// Heads-up about the limits of the team the new primary breaks, if any.
+		limits  string
+		primary RotationProperty
+		// Members onboarding, still shadowing the primary or now in the rotation.
+		shadows, graduated []RotationProperty
+	}
+	var handoffs []handoff
+	type reminder struct {
+		team               string
+		at                 time.Time
+		outgoing, incoming RotationProperty
+	}
+	var reminders []reminder
+	type gap struct {
+		team, channel, text string
+	}
+	var gaps []gap
+	type coverage struct {
+		team   string
+		issues []string
+		notify []string
+	}
+	var coverages []coverage
+
+	now := time.Now()
+	// Number of the last handoff due of the team, 0 if none is.
+	// Caller must hold oncallMut.
+	dueHandoff := func(t *oncallProperty) int {
+		if t.Cadence == "" || t.Paused || len(rotationMembers(t.Rotations)) == 0 {
+			return 0
+		}
+		if due := cadenceAdvances(t, now); due > t.Advanced {
+			return due
+		}
+		return 0
+	}
+	// History the handoffs are checked against is loaded before taking oncallMut,
+	// keyed by team. Teams failing to load it aren't checked.
+	limitHistory := map[string][]*historyProperty{}
+	limitSince := map[string]time.Time{}
+	oncallMut.RLock()
+	for _, t := range rotations {
+		if due := dueHandoff(t); due > 0 {
+			if since, ok := limitsSince(t, handoffTime(t, due)); ok {
+				limitSince[t.Team] = since
+			}
+		}
+	}
+	oncallMut.RUnlock()
+	for team, since := range limitSince {
+		entries, err := loadHistoryFrom(ctx, team, since)
+		if err != nil {
+			log.Warningf(ctx, "(cron) error loading history of %s - %s", team, err)
+			continue
+		}
+		limitHistory[team] = entries
+	}
+
+	oncallMut.Lock()
+	for _, t := range rotations {
+		due := dueHandoff(t)
+		if due == 0 {
+			continue
+		}
+		previous := copyState(t)
+		t.Rotations = advanceRotation(t.Rotations, rotationOffset(t, now))
+		t.Advanced = due
+		// The list is now in order, first member not away (or their fallback) is the primary (override aside).
+		h := handoff{team: t.Team, missed: due - previous.Advanced, primary: t.Rotations[rotationMembers(t.Rotations)[0]]}
+		if onDuty := onDutyMembers(t, now); len(onDuty) > 0 {
+			h.primary, _ = memberOnDuty(t.Rotations[onDuty[0]], now)
+		}
+		t.Pending = h.primary.Id
+		// Handoffs go ahead regardless, the managers are told to sort it out.
+		if entries, ok := limitHistory[t.Team]; ok {
+			h.limits = handoffViolations(&previous, entries, primaryStart{member: engineMember(h.primary), at: handoffTime(t, due), end: handoffTime(t, due+1)})
+		}
+		// Each handoff is a shift shadowed, onboarding members join the rotation after their last.
+		for i := range t.Rotations {
+			s := &t.Rotations[i]
+			if !s.Shadow || s.ShadowLeft == 0 {
+				continue
+			}
+			if s.ShadowLeft -= h.missed; s.ShadowLeft > 0 {
+				h.shadows = append(h.shadows, *s)
+				continue
+			}
+			s.ShadowLeft, s.Shadow = 0, false
+			h.graduated = append(h.graduated, *s)
+		}
+		// Undoing an earlier change would revert the handoff too, it's dropped.
+		if err := resetState(ctx, t); err != nil {
+			log.Warningf(ctx, "(cron) error saving state of %s - %s", t.Team, err)
+			*t = previous
+			continue
+		}
+		for _, m := range t.Managers {
+			h.notify = append(h.notify, m.Id)
+		}
+		if c, ok := teamChannel(t); ok {
+			h.channel = c.Id
+		}
+		recordHistory(ctx, t.Team, "rotate", "cron", fmt.Sprintf("%d scheduled handoff(s), <@%s> is now primary", h.missed, h.primary.Id), t.Rotations)
+		handoffs = append(handoffs, h)
+	}
+	// Remind the primaries of the next handoff once, as it gets close.
+	for _, t := range rotations {
+		if handoffReminder == 0 || t.Cadence == "" || t.Paused {
+			continue
+		}
+		next := cadenceAdvances(t, now) + 1
+		at := handoffTime(t, next)
+		if t.Reminded >= next || at.Sub(now) > handoffReminder {
+			continue
+		}
+		outgoing, incoming := onDutyMembers(t, now), onDutyMembers(t, at)
+		if len(outgoing) == 0 || len(incoming) == 0 {
+			continue
+		}
+		reminded := t.Reminded
+		t.Reminded = next
+		if err := saveState(ctx, t); err != nil {
+			log.Warningf(ctx, "(cron) error saving state of %s - %s", t.Team, err)
+			t.Reminded = reminded
+			continue
+		}
+		rm := reminder{team: t.Team, at: at}
+		rm.outgoing, _ = memberOnDuty(t.Rotations[outgoing[0]], now)
+		rm.incoming, _ = memberOnDuty(t.Rotations[incoming[0]], at)
+		// Nobody to hand off to, ie. the only member not away.
+		if rm.outgoing.Id != rm.incoming.Id {
+			reminders = append(reminders, rm)
+		}
+	}
+	// Alert the team's channel once when nobody is on-call, and once it's covered again.
+	for _, t := range rotations {
+		c, ok := teamChannel(t)
+		if !ok {
+			continue
+		}
+		primary, covered := memberByRole(t, rolePrimary)
+		if covered != t.Gap {
+			continue
+		}
+		t.Gap = !covered
+		if err := saveState(ctx, t); err != nil {
+			log.Warningf(ctx, "(cron) error saving state of %s - %s", t.Team, err)
+			t.Gap = covered
+			continue
+		}
+		g := gap{team: t.Team, channel: c.Id, text: fmt.Sprintf("%s Nobody is on-call for %s, everyone is away or off shift. Managers, please sort out the cover.", externalErrorEmoji, t.Team)}
+		if covered {
+			g.text = fmt.Sprintf("On-call of %s is covered again, <@%s|%s> is primary.", t.Team, primary.Id, primary.Name)
+		}
+		gaps = append(gaps, g)
+	}
+	// Tell the managers once of overlapping covers and times nobody will be on-call.
+	for _, t := range rotations {
+		issues, key := coverageIssues(t, now)
+		if key == t.Coverage {
+			continue
+		}
+		told := t.Coverage
+		t.Coverage = key
+		if err := saveState(ctx, t); err != nil {
+			log.Warningf(ctx, "(cron) error saving state of %s - %s", t.Team, err)
+			t.Coverage = told
+			continue
+		}
+		if issues == nil {
+			continue
+		}
+		c := coverage{team: t.Team, issues: issues}
+		for _, m := range t.Managers {
+			c.notify = append(c.notify, m.Id)
+		}
+		coverages = append(coverages, c)
+	}
+	oncallMut.Unlock()
+
+	// Tell the managers, and ask the new primary to accept, outside of the lock.
+	for _, h := range handoffs {
+		text := fmt.Sprintf("On-call of %s has been handed off, <@%s|%s> is now primary.", h.team, h.primary.Id, h.primary.Name)
+		if h.missed > 1 {
+			text += fmt.Sprintf(" (%d handoffs were applied at once since the previous ones were missed)", h.missed)
+		}
+		text += h.limits
+		primaryText := text
+		for _, s := range h.shadows {
+			primaryText += fmt.Sprintf(" <@%s|%s> is shadowing you.", s.Id, s.Name)
+		}
+		if err := askHandoff(ctx, h.team, h.primary.Id, primaryText); err != nil {
+			log.Warningf(ctx, "(cron) error asking %s to accept %s handoff - %s", h.primary.Id, h.team, err)
+		}
+		notified := map[string]bool{h.primary.Id: true}
+		for _, id := range h.notify {
+			if notified[id] {
+				continue
+			}
+			notified[id] = true
+			postDM(ctx, id, text)
+		}
+		if h.channel != "" {
+			params := url.Values{}
+			params.Set("channel", h.channel)
+			params.Set("text", text)
+			if err := callSlackAPI(ctx, "chat.postMessage", params); err != nil {
+				log.Warningf(ctx, "(cron) error posting %s handoff to %s - %s", h.team, h.channel, err)
+			}
+		}
+		dms := map[string]string{}
+		for _, s := range h.shadows {
+			dms[s.Id] = fmt.Sprintf("On-call of %s has been handed off, you are shadowing <@%s|%s> (%d shifts left before you join the rotation).", h.team, h.primary.Id, h.primary.Name, s.ShadowLeft)
+		}
+		for _, s := range h.graduated {
+			dms[s.Id] = fmt.Sprintf("You are done shadowing on %s and now in its on-call rotation, welcome aboard!", h.team)
+		}
+		for id, text := range dms {
+			postDM(ctx, id, text)
+		}
+	}
+	for _, rm := range reminders {
+		when := rm.at.Format("Mon " + dateFormat)
+		dms := map[string]string{
+			rm.incoming.Id: fmt.Sprintf("Heads up! You take over primary on-call of %s from <@%s|%s> on %s.", rm.team, rm.outgoing.Id, rm.outgoing.Name, when),
+			rm.outgoing.Id: fmt.Sprintf("Heads up! <@%s|%s> takes over primary on-call of %s from you on %s.", rm.incoming.Id, rm.incoming.Name, rm.team, when),
+		}
+		for id, text := range dms {
+			postDM(ctx, id, text)
+		}
+	}
+	for _, g := range gaps {
+		params := url.Values{}
+		params.Set("channel", g.channel)
+		params.Set("text", g.text)
+		if err := callSlackAPI(ctx, "chat.postMessage", params); err != nil {
+			log.Warningf(ctx, "(cron) error alerting %s of %s gap - %s", g.channel, g.team, err)
+		}
+	}
+	for _, c := range coverages {
+		text := fmt.Sprintf("%s Coverage of %s needs sorting out:\n%s", externalErrorEmoji, c.team, strings.Join(c.issues, "\n"))
+		for _, id := range c.notify {
+			postDM(ctx, id, text)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+} // }}}
@@ -0,0 +1,169 @@
+package slackoncallbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Points of each part of the health score, adding up to 100.
+const (
+	// At least 2 people on duty, so there's a secondary.
+	scoreCoverage = 30
+	// At least 1 manager to escalate to.
+	scoreManagers = 20
+	// Everyone on duty has a phone, in proportion.
+	scorePhones = 30
+	// The list was changed recently.
+	scoreRecency = 20
+)
+
+// Health score of a team and what it lost points for.
+type healthScores []healthScore
+type healthScore struct {
+	team   string
+	score  int
+	issues []string
+}
+
+// Healthiest first, ties by team name.
+func (h healthScores) Len() int {
+	return len(h)
+}
+func (h healthScores) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score > h[j].score
+	}
+	return h[i].team < h[j].team
+}
+func (h healthScores) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+// func teamHealth {{{
+
+// Compute the health score of the team at the time.
+// Takes a copy of the team since phones are looked up in Slack, so the caller
+// must not hold oncallMut.
+func teamHealth(ctx context.Context, r oncallProperty, now time.Time) healthScore {
+	h := healthScore{team: r.Team}
+
+	onDuty := onDutyMembers(&r, now)
+	switch {
+	case len(onDuty) >= 2:
+		h.score += scoreCoverage
+	case len(onDuty) == 1:
+		h.score += scoreCoverage / 2
+		h.issues = append(h.issues, "no secondary")
+	default:
+		h.issues = append(h.issues, "nobody on duty")
+	}
+
+	if len(r.Managers) > 0 {
+		h.score += scoreManagers
+	} else {
+		h.issues = append(h.issues, "no manager")
+	}
+
+	var phones int
+	for _, idx := range onDuty {
+		u, _ := memberOnDuty(r.Rotations[idx], now)
+		if user, err := getSlackUserDetail(ctx, u.Id, false); err == nil && user != nil && user.phone != "" {
+			phones++
+		}
+	}
+	if len(onDuty) > 0 {
+		h.score += scorePhones * phones / len(onDuty)
+		if phones < len(onDuty) {
+			h.issues = append(h.issues, fmt.Sprintf("%d without phone", len(onDuty)-phones))
+		}
+	}
+
+	switch days := int(now.Sub(r.Updated).Hours() / 24); {
+	case days <= 30:
+		h.score += scoreRecency
+	case days <= 90:
+		h.score += scoreRecency / 2
+		h.issues = append(h.issues, fmt.Sprintf("not updated for %d days", days))
+	default:
+		h.issues = append(h.issues, fmt.Sprintf("not updated for %d days", days))
+	}
+	return h
+} // }}}
+
+// func describeHealth {{{
+
+// Human readable health score.
+func describeHealth(h healthScore) string {
+	str := fmt.Sprintf("health: %d/100", h.score)
+	if len(h.issues) > 0 {
+		str += " (" + strings.Join(h.issues, ", ") + ")"
+	}
+	return str
+} // }}}
+
+// func healthCronHandler {{{
+
+// Cron handler posting the ranking of all teams by health score to the
+// "health_channel", ie. monthly for leadership.
+func healthCronHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	// Only AppEngine cron is allowed to call this.
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		log.Warningf(ctx, "(cron) request not from cron")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if healthChannel == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err := ensureState(ctx); err != nil {
+		http.Error(w, "error loading state", http.StatusInternalServerError)
+		return
+	}
+
+	// Copy over teams so we don't hold the lock while talking to Slack.
+	oncallMut.RLock()
+	teams := make([]oncallProperty, 0, len(rotations))
+	for _, t := range rotations {
+		teams = append(teams, *t)
+	}
+	oncallMut.RUnlock()
+
+	now := time.Now()
+	scores := make(healthScores, 0, len(teams))
+	for _, t := range teams {
+		scores = append(scores, teamHealth(ctx, t, now))
+	}
+	sort.Sort(scores)
+
+	var str []string
+	for i, h := range scores {
+		str = append(str, fmt.Sprintf("%d: %s - %s", i+1, h.team, describeHealth(h)))
+	}
+	if str == nil {
+		str = []string{errorNoRotation}
+	}
+	att, err := json.Marshal([]attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}})
+	if err != nil {
+		log.Warningf(ctx, "(cron) error encoding health ranking - %s", err)
+		http.Error(w, "error encoding ranking", http.StatusInternalServerError)
+		return
+	}
+	params := url.Values{}
+	params.Set("channel", healthChannel)
+	params.Set("text", fmt.Sprintf("On-call health ranking as of %s", now.In(timezone).Format(dateFormat)))
+	params.Set("attachments", string(att))
+	if err = callSlackAPI(ctx, "chat.postMessage", params); err != nil {
+		log.Warningf(ctx, "(cron) error posting health ranking to %s - %s", healthChannel, err)
+	}
+	w.WriteHeader(http.StatusOK)
+} // }}}
@@ -0,0 +1,128 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"strings"
+	"time"
+)
+
+// func shift {{{
+
+// shift {team} {label} {HH:MM-HH:MM}
+//
+// Set the daily window in which only members of the team with the label are on
+// duty, so a team spanning regions hands primary on-call to the region whose
+// working hours it is. Without a label, display the shifts of the team.
+func shift(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opShift)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "shift")}
+	}
+
+	res := slackResponse{}
+	if p.label == "" {
+		oncallMut.RLock()
+		r := findRotation(p.team)
+		if r == nil {
+			oncallMut.RUnlock()
+			res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+			return res
+		}
+		str := describeShifts(r, time.Now())
+		oncallMut.RUnlock()
+		if str == nil {
+			res.Text = fmt.Sprintf("No shifts for %s, everyone is on duty around the clock", p.team)
+			return res
+		}
+		res.Text = "Shifts for: " + p.team
+		res.Attachments = []attachment{{Color: defaultColor, Text: strings.Join(str, "\n")}}
+		return res
+	}
+
+	oncallMut.Lock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	var shifts []ShiftProperty
+	var found bool
+	for _, s := range current.Shifts {
+		if s.Label == p.label {
+			found = true
+			continue
+		}
+		shifts = append(shifts, s)
+	}
+	var detail string
+	if p.cancel {
+		if !found {
+			oncallMut.Unlock()
+			res.Text = fmt.Sprintf("Sorry, %s has no shift for %s %s", p.team, p.label, humanErrorEmoji)
+			return res
+		}
+		detail = "removed shift of " + p.label
+	} else {
+		if labeledPosition(current.Rotations, p.label, 1) == 0 {
+			oncallMut.Unlock()
+			res.Text = fmt.Sprintf("Sorry, no one in the on-call list of %s is labeled %s %s", p.team, p.label, humanErrorEmoji)
+			return res
+		}
+		s := ShiftProperty{Label: p.label, Start: p.start, End: p.end}
+		for _, o := range shifts {
+			if shiftsOverlap(s, o) {
+				oncallMut.Unlock()
+				res.Text = fmt.Sprintf("Sorry, it overlaps the shift %s %s", describeShift(o), humanErrorEmoji)
+				return res
+			}
+		}
+		shifts = append(shifts, s)
+		detail = "set shift " + describeShift(s)
+	}
+	previous := *current
+	current.Shifts = shifts
+	current.Updated = time.Now()
+	current.UpdatedBy = p.by.name
+	if err := resetState(ctx, current); err != nil {
+		log.Warningf(ctx, "(shift) error saving state - %s", err)
+		*current = previous
+		oncallMut.Unlock()
+		res.Text = errorExternal
+		return res
+	}
+	recordHistory(ctx, p.team, "shift", p.by.name, detail, current.Rotations)
+	oncallMut.Unlock()
+
+	res.Text = fmt.Sprintf("Success! %s%s for %s\nNew list:", strings.ToUpper(detail[:1]), detail[1:], p.team)
+	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
+	return res
+} // }}}
+
+// func describeShifts {{{
+
+// Return a line per shift of the team, marking the one active at the time.
+// Caller must hold oncallMut.
+func describeShifts(r *oncallProperty, t time.Time) (str []string) {
+	active := activeShift(r, t)
+	for i, s := range r.Shifts {
+		line := describeShift(s)
+		if r.Timezone != "" {
+			line += " " + r.Timezone
+		}
+		if active == &r.Shifts[i] {
+			line += " - _now_"
+		}
+		str = append(str, line)
+	}
+	return
+} // }}}
+
+// func shiftsOverlap {{{
+
+// Check if the shifts share any minute of the day.
+func shiftsOverlap(a, b ShiftProperty) bool {
+	return engineShift(a).Overlaps(engineShift(b))
+} // }}}
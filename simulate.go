@@ -0,0 +1,55 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"strings"
+	"time"
+)
+
+// func simulate {{{
+
+// simulate {team} {daily|weekly|biweekly} from {YYYY-MM-DD} {HH:MM} {n}
+//
+// Show who would be primary on-call of the team after each of the next n handoffs
+// if its cadence started from the date, with the current order, aways, overrides
+// and assignments. Nothing is saved, so managers can compare options before
+// setting the "cadence".
+func simulate(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opSimulate)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "simulate")}
+	}
+
+	res := slackResponse{}
+	oncallMut.RLock()
+	defer oncallMut.RUnlock()
+	r := findRotation(p.team)
+	if r == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+
+	// Same as "cadence" does, on a copy of the team.
+	now := time.Now()
+	sim := *r
+	sim.Rotations = advanceRotation(r.Rotations, rotationOffset(r, now))
+	loc := teamLocation(r)
+	if p.hour < 0 {
+		p.hour, p.minute = 0, 0
+		if r.Cadence != "" {
+			a := r.Anchor.In(loc)
+			p.hour, p.minute = a.Hour(), a.Minute()
+		}
+	}
+	sim.Cadence = p.cadence
+	sim.Anchor = time.Date(p.date.Year(), p.date.Month(), p.date.Day(), p.hour, p.minute, 0, 0, loc)
+	sim.Paused = false
+	sim.Advanced = cadenceAdvances(&sim, now)
+
+	str := []string{"Now - " + describePrimaryPeriod(&sim, now, handoffTime(&sim, sim.Advanced+1))}
+	str = append(str, upcomingHandoffs(&sim, now, p.handoffs)...)
+	res.Text = fmt.Sprintf("Simulated %d handoffs of %s (%s), nothing is changed:", p.handoffs, p.team, describeCadence(&sim))
+	res.Attachments = []attachment{{Color: defaultColor, Text: strings.Join(str, "\n"), Footer: "Currently: " + describeCadence(r)}}
+	return res
+} // }}}
@@ -0,0 +1,112 @@
+package slackoncallbot
+
+import (
+	"fmt"
+	"github.com/fladz/slack-oncall-command/oncall"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// func streak {{{
+
+// streak {team} {days|off}
+//
+// Set the most days in a row a member of the team serves primary, ie. for labor
+// rules. Reordering the list ("swap", "move", "rotate", ...) is refused if it
+// would break it, and scheduled handoffs breaking it are flagged to the managers.
+// Without days, display the current limit.
+func streak(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opStreak)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "streak")}
+	}
+	return setLimit(ctx, streakLimit, p.team, p.by, p.days, p.show)
+} // }}}
+
+// Most days in a row as primary, looking back as many days and ahead a day
+// more, so a shift starting now and going past the end breaks it.
+var streakLimit = primaryLimit{
+	op:    "streak",
+	value: func(r *oncallProperty) *int { return &r.MaxStreak },
+	window: func(days int, now time.Time) (time.Time, time.Time) {
+		streak := time.Duration(days) * 24 * time.Hour
+		return now.Add(-streak), now.Add(streak + 24*time.Hour)
+	},
+	breach: streakBreach,
+	rule: func(days int) string {
+		return fmt.Sprintf("serve at most %d day(s) in a row as primary", days)
+	},
+	none: "no limit of days in a row as primary",
+	detail: func(days int) string {
+		if days == 0 {
+			return "removed limit of days in a row as primary"
+		}
+		return fmt.Sprintf("set limit of %d day(s) in a row as primary", days)
+	},
+	warn: func(r *oncallProperty) string {
+		if days := oncall.CadenceDays(r.Cadence); days > r.MaxStreak {
+			return fmt.Sprintf("%s rotates %s, scheduled handoffs will break it", r.Team, r.Cadence)
+		}
+		return ""
+	},
+}
+
+// func streakBreach {{{
+
+// Check if the current primary staying on or any of the primaries starting would
+// serve longer in a row than the limit, from the start of their shift until the
+// next primary starts. A shift still going at the end of the walk counts until then.
+func streakBreach(days int, w primaryWalk) (primaryStart, string) {
+	limit := time.Duration(days) * 24 * time.Hour
+	starts := w.starts
+	if w.current != nil {
+		// The current shift started before now if it's recorded up to now.
+		current := primaryStart{member: *w.current, at: w.now, end: w.until}
+		if len(starts) > 0 {
+			current.end = starts[0].at
+		}
+		since := w.now
+		if s, ok := w.served[w.current.ID]; ok && !s.last.Before(w.now) {
+			since = s.start
+		}
+		if current.end.After(w.now) && current.end.Sub(since) > limit {
+			return current, describeStreak(current.end.Sub(since))
+		}
+	}
+	for _, s := range starts {
+		// Joined to their shift running up to the start, if any.
+		since := s.at
+		if served, ok := w.served[s.member.ID]; ok && !served.last.Before(s.at) {
+			since = served.start
+		}
+		if s.end.Sub(since) > limit {
+			return s, describeStreak(s.end.Sub(since))
+		}
+	}
+	return primaryStart{}, ""
+} // }}}
+
+// func describeStreak {{{
+
+// Human readable time in a row as primary, in days started.
+func describeStreak(d time.Duration) string {
+	return fmt.Sprintf("for %d day(s) in a row", int((d+24*time.Hour-1)/(24*time.Hour)))
+} // }}}
+
+// func overrideStreak {{{
+
+// Return why the override of the team from the time (now if zero) until the
+// other breaks its limit of days in a row as primary, empty if it doesn't.
+// Caller must hold oncallMut.
+func overrideStreak(r *oncallProperty, start, until time.Time) string {
+	if r.MaxStreak == 0 {
+		return ""
+	}
+	if start.IsZero() {
+		start = time.Now()
+	}
+	if d := until.Sub(start); d > time.Duration(r.MaxStreak)*24*time.Hour {
+		return fmt.Sprintf("<@%s> covers primary %s, members of %s %s", r.OverrideName, describeStreak(d), r.Team, streakLimit.rule(r.MaxStreak))
+	}
+	return ""
+} // }}}
@@ -0,0 +1,184 @@
+package slackoncallbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"strings"
+	"time"
+)
+
+// func export {{{
+
+// export {team}
+//
+// Display the team as a JSON code block, which "import" takes back as is.
+func export(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opExport)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "export")}
+	}
+
+	res := slackResponse{}
+	oncallMut.RLock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.RUnlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist %s", p.team, humanErrorEmoji)
+		return res
+	}
+	e := exportTeam(current)
+	oncallMut.RUnlock()
+
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		log.Warningf(ctx, "(export) error encoding %s - %s", p.team, err)
+		res.Text = errorExternal
+		return res
+	}
+	res.Text = fmt.Sprintf("Export of %s:\n```\n%s\n```", p.team, b)
+	return res
+} // }}}
+
+// func exportTeam {{{
+
+// Convert the team into its export format.
+// The caller must hold oncallMut.
+func exportTeam(r *oncallProperty) exportedTeam {
+	e := exportedTeam{
+		Team:        r.Team,
+		Aliases:     r.Aliases,
+		Managers:    []exportedRef{},
+		Rotation:    []exportedMember{},
+		Cadence:     r.Cadence,
+		Timezone:    r.Timezone,
+		Notes:       r.Notes,
+		Description: r.Description,
+		Updated:     r.Updated,
+		UpdatedBy:   r.UpdatedBy,
+	}
+	if r.Cadence != "" {
+		e.Anchor = r.Anchor.In(teamLocation(r)).Format(time.RFC3339)
+	}
+	for _, m := range r.Managers {
+		e.Managers = append(e.Managers, exportedRef{Id: m.Id, Name: m.Name})
+	}
+	for _, u := range r.Rotations {
+		e.Rotation = append(e.Rotation, exportedMember{Id: u.Id, Name: u.Name, Label: u.Label, Shadow: u.Shadow})
+	}
+	for _, c := range r.Channels {
+		e.Channels = append(e.Channels, exportedRef{Id: c.Id, Name: c.Name})
+	}
+	return e
+} // }}}
+
+// func importTeam {{{
+
+// import {team} {json}
+//
+// Replace managers and on-call list (with labels and shadows) of the team with the
+// ones exported by "export". Everyone is checked against Slack first, and the
+// names are taken from Slack rather than the payload.
+// Other settings of the team (cadence, aliases, ...) are left as they are.
+func importTeam(ctx context.Context, params interface{}) slackResponse {
+	p, ok := params.(opImport)
+	if !ok || p.team == "" {
+		return slackResponse{Text: help(ctx, "import")}
+	}
+
+	res := slackResponse{}
+	if getCurrentRotation(p.team) == nil {
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist, `register` it first %s", p.team, humanErrorEmoji)
+		return res
+	}
+
+	// Make sure everyone still exists in Slack.
+	var missing []string
+	names := map[string]string{}
+	check := func(id string) error {
+		if _, ok := names[id]; ok {
+			return nil
+		}
+		u, err := getSlackUserDetail(ctx, id, false)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			missing = append(missing, id)
+			names[id] = ""
+			return nil
+		}
+		names[id] = u.name
+		return nil
+	}
+	for _, m := range p.data.Managers {
+		if err := check(m.Id); err != nil {
+			log.Warningf(ctx, "(import) error getting user %s - %s", m.Id, err)
+			res.Text = errorExternal
+			return res
+		}
+	}
+	for _, u := range p.data.Rotation {
+		if err := check(u.Id); err != nil {
+			log.Warningf(ctx, "(import) error getting user %s - %s", u.Id, err)
+			res.Text = errorExternal
+			return res
+		}
+	}
+	if len(missing) > 0 {
+		res.Text = fmt.Sprintf("Sorry! These users don't exist in Slack, nothing has been changed: %s %s", strings.Join(missing, ", "), humanErrorEmoji)
+		return res
+	}
+
+	managers := make([]ManagerProperty, 0, len(p.data.Managers))
+	for _, m := range p.data.Managers {
+		if !hasManager(managers, m.Id) {
+			managers = append(managers, ManagerProperty{Name: names[m.Id], Id: m.Id})
+		}
+	}
+	members := make([]RotationProperty, 0, len(p.data.Rotation))
+	for _, u := range p.data.Rotation {
+		members = append(members, RotationProperty{Name: names[u.Id], Id: u.Id, Label: u.Label, Shadow: u.Shadow})
+	}
+
+	oncallMut.Lock()
+	current := findRotation(p.team)
+	if current == nil {
+		oncallMut.Unlock()
+		res.Text = fmt.Sprintf("Sorry, team %s does not exist, `register` it first %s", p.team, humanErrorEmoji)
+		return res
+	}
+	previous := *current
+	current.Managers = managers
+	current.Rotations = members
+	current.Updated = time.Now()
+	current.UpdatedBy = p.by.name
+	// Keep the current state so this change can be undone.
+	if err := replaceState(ctx, "import", p.by.name, &previous, current); err != nil {
+		log.Warningf(ctx, "(import) error saving state - %s", err)
+		*current = previous
+		res.Text = errorExternal
+		oncallMut.Unlock()
+		return res
+	}
+	detail := fmt.Sprintf("imported %d manager(s) and %d member(s)", len(managers), len(members))
+	recordHistory(ctx, p.team, "import", p.by.name, detail, current.Rotations)
+	oncallMut.Unlock()
+
+	// Fix manager flags of managers who came or went.
+	for _, m := range managers {
+		if !hasManager(previous.Managers, m.Id) {
+			userAddManagerFlag(ctx, m.Id)
+		}
+	}
+	for _, m := range previous.Managers {
+		if !hasManager(managers, m.Id) {
+			userSubManagerFlag(ctx, m.Id)
+		}
+	}
+
+	res.Text = fmt.Sprintf("Success! %s%s in the on-call list for %s\nNew list:", strings.ToUpper(detail[:1]), detail[1:], p.team)
+	res.Attachments = []attachment{generateOncallList(ctx, p.team)}
+	return res
+} // }}}
@@ -21,32 +21,115 @@ import (
 // text=94070
 // response_url=https://hooks.slack.com/commands/1234/5678
 type slackCommandParams struct {
-	Token       string `schema:"token"`
-	TeamId      string `schema:"team_id"`
-	TeamDomain  string `schema:"team_domain"`
-	ChannelId   string `schema:"channel_id"`
-	ChannelName string `schema:"channel_name"`
-	UserId      string `schema:"user_id"`
-	UserName    string `schema:"user_name"`
-	Command     string `schema:"command"`
-	Text        string `schema:"text"`
-	ResponseURL string `schema:"response_url"`
+	Token        string `schema:"token"`
+	TeamId       string `schema:"team_id"`
+	TeamDomain   string `schema:"team_domain"`
+	EnterpriseId string `schema:"enterprise_id"`
+	ChannelId    string `schema:"channel_id"`
+	ChannelName  string `schema:"channel_name"`
+	UserId       string `schema:"user_id"`
+	UserName     string `schema:"user_name"`
+	Command      string `schema:"command"`
+	Text         string `schema:"text"`
+	ResponseURL  string `schema:"response_url"`
 }
 
 type slackResponse struct {
 	Type        string       `json:"response_type,omitempty"`
 	Text        string       `json:"text,omitempty"`
 	Attachments []attachment `json:"attachments,omitempty"`
+	// Instead of attachments for teams set in "block_kit".
+	Blocks []block `json:"blocks,omitempty"`
+	// Replace the message clicked, when sent to "response_url".
+	ReplaceOriginal bool `json:"replace_original,omitempty"`
 }
 
 // Slack "attachment" response struct.
 // Note this is much shorter version of the full struct as we don't need
 // such a fancy display for oncall.
 type attachment struct {
-	Title  string `json:"title,omitempty"`
-	Text   string `json:"text"`
-	Color  string `json:"color,omitempty"`
-	Footer string `json:"footer,omitempty"`
+	Title      string             `json:"title,omitempty"`
+	Text       string             `json:"text"`
+	Color      string             `json:"color,omitempty"`
+	Footer     string             `json:"footer,omitempty"`
+	CallbackId string             `json:"callback_id,omitempty"`
+	Actions    []attachmentAction `json:"actions,omitempty"`
+}
+
+// Interactive element (button/menu) in an attachment.
+type attachmentAction struct {
+	Name    string         `json:"name"`
+	Text    string         `json:"text"`
+	Type    string         `json:"type"`
+	Value   string         `json:"value,omitempty"`
+	Style   string         `json:"style,omitempty"`
+	Options []actionOption `json:"options,omitempty"`
+}
+type actionOption struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// Slack Block Kit block, the fields of the kinds legacy attachments convert to.
+type block struct {
+	Type     string         `json:"type"`
+	BlockId  string         `json:"block_id,omitempty"`
+	Text     *blockText     `json:"text,omitempty"`
+	Elements []blockElement `json:"elements,omitempty"`
+}
+
+// Element of a context (text) or actions (button/menu) block.
+type blockElement struct {
+	Type string `json:"type"`
+	// Text object of buttons, plain string of "mrkdwn" context elements.
+	Text        interface{}   `json:"text,omitempty"`
+	ActionId    string        `json:"action_id,omitempty"`
+	Value       string        `json:"value,omitempty"`
+	Style       string        `json:"style,omitempty"`
+	Placeholder *blockText    `json:"placeholder,omitempty"`
+	Options     []blockOption `json:"options,omitempty"`
+}
+type blockText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+type blockOption struct {
+	Text  blockText `json:"text"`
+	Value string    `json:"value"`
+}
+
+// Payload Slack sends to the interactive endpoint when a user clicks a button
+// or picks a menu item in one of our messages.
+type slackInteraction struct {
+	Type        string `json:"type"`
+	CallbackId  string `json:"callback_id"`
+	Token       string `json:"token"`
+	ResponseURL string `json:"response_url"`
+	Team        struct {
+		Id           string `json:"id"`
+		Domain       string `json:"domain"`
+		EnterpriseId string `json:"enterprise_id"`
+	} `json:"team"`
+	Channel struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+	User struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"user"`
+	Actions []struct {
+		Name            string         `json:"name"`
+		Type            string         `json:"type"`
+		Value           string         `json:"value"`
+		SelectedOptions []actionOption `json:"selected_options"`
+		// Block Kit ("block_actions") interactions.
+		ActionId       string `json:"action_id"`
+		BlockId        string `json:"block_id"`
+		SelectedOption struct {
+			Value string `json:"value"`
+		} `json:"selected_option"`
+	} `json:"actions"`
 }
 
 // Summarized user information we need for oncall operations.
@@ -56,6 +139,8 @@ type slackUser struct {
 	isAdmin     bool
 	isManager   int
 	phone       string
+	// Phone came from the bot's override rather than the Slack profile.
+	phoneOverride bool
 	// Timestamp of the user retrieved from Slack API
 	retrieved time.Time
 }
@@ -69,22 +154,393 @@ type oncallProperty struct {
 	Rotations []RotationProperty `datastore:"users"`
 	Updated   time.Time          `datastore:"updated"`
 	UpdatedBy string             `datastore:"updated_by"`
+	// How often the primary hands off to the next person ("daily", "weekly", "biweekly"), and
+	// the moment handoffs are counted from. Empty cadence means manual rotation only.
+	Cadence string    `datastore:"cadence"`
+	Anchor  time.Time `datastore:"anchor"`
+	// Number of handoffs since the anchor already reflected in the order of Rotations.
+	Advanced int `datastore:"advanced"`
+	// Number of the last handoff the primaries were reminded of.
+	Reminded int `datastore:"reminded"`
+	// Incoming primary of the last scheduled handoff who hasn't accepted it yet.
+	Pending string `datastore:"pending"`
+	// Scheduled handoffs are stopped since the incoming primary declined, until
+	// "handoff resume". The list stays as it was when declined.
+	Paused bool `datastore:"paused"`
+	// Nobody was on-call when the rotation cron last checked, the team's channel
+	// has been alerted.
+	Gap bool `datastore:"gap"`
+	// Timezone (IANA name) handoffs happen in. Configured timezone if empty.
+	Timezone string `datastore:"timezone"`
+	// Channels the team's on-call list can be changed from. Anywhere if empty.
+	Channels []ChannelProperty `datastore:"channels"`
+	// Other names the team can be looked up with.
+	Aliases []string `datastore:"aliases"`
+	// Someone covering primary on-call from the start (right away if zero) until the
+	// time, without changing the list. Ignored once the time has passed.
+	OverrideName  string    `datastore:"override_name"`
+	OverrideId    string    `datastore:"override_id"`
+	OverrideStart time.Time `datastore:"override_start"`
+	OverrideUntil time.Time `datastore:"override_until"`
+	OverrideBy    string    `datastore:"override_by"`
+	// Free text shown with the on-call list, ie. runbook links.
+	Notes string `datastore:"notes,noindex"`
+	// What the team covers, shown as the title of its on-call list.
+	Description string `datastore:"description,noindex"`
+	// Time windows only members with a label are on duty in, ie. follow-the-sun
+	// regions. Everyone is on duty outside of them.
+	Shifts []ShiftProperty `datastore:"shifts"`
+	// Role required for operations on the team instead of the default one.
+	Permissions []PermissionProperty `datastore:"permissions"`
+	// Daily hours in which primary on-call goes to the secondary, or to the first
+	// member on duty with the label, instead. Label is "secondary" or a member
+	// label, no quiet hours if empty.
+	Quiet ShiftProperty `datastore:"quiet"`
+	// Channel the weekly digest is posted to. No digest if empty.
+	DigestId   string `datastore:"digest_channel_id"`
+	DigestName string `datastore:"digest_channel_name"`
+	// Scheduled handoffs new members shadow the primary for before joining the
+	// rotation. No onboarding if 0.
+	Onboarding int `datastore:"onboarding"`
+	// Days members rest after a primary shift before serving primary again. No
+	// minimum if 0.
+	MinRest int `datastore:"min_rest"`
+	// Primary shifts each member serves at most in a month. No cap if 0.
+	MonthlyCap int `datastore:"monthly_cap"`
+	// Days in a row each member serves primary at most. No limit if 0.
+	MaxStreak int `datastore:"max_streak"`
+	// Key of the coverage issues the managers were last told of, empty if none.
+	Coverage string `datastore:"coverage,noindex"`
 }
 type ManagerProperty struct {
 	Name string `datastore:"manager_name"`
 	Id   string `datastore:"manager_id"`
 }
+type ChannelProperty struct {
+	Name string `datastore:"channel_name"`
+	Id   string `datastore:"channel_id"`
+}
+type PermissionProperty struct {
+	Operation string `datastore:"perm_operation"`
+	// One of permEveryone, permMember, permManager or permSuperuser.
+	Role string `datastore:"perm_role"`
+	// Until the time the role is only logged against the default one (shadow mode),
+	// not enforced.
+	Enforce time.Time `datastore:"perm_enforce"`
+}
+type ShiftProperty struct {
+	// Label of the members on duty during the shift.
+	Label string `datastore:"shift_label"`
+	// Minutes after midnight the shift starts and ends in the team's timezone.
+	// The shift spans midnight if it ends before it starts.
+	Start int `datastore:"shift_start"`
+	End   int `datastore:"shift_end"`
+}
 type RotationProperty struct {
 	Name  string `datastore:"name"`
 	Id    string `datastore:"id"`
 	Label string `datastore:"label"`
+	// Trainee shadowing the rotation, listed but never on-call.
+	Shadow bool `datastore:"shadow"`
+	// Scheduled handoffs left for a shadow added by onboarding to pair with the
+	// primary, it joins the rotation after the last one. 0 for shadows added by hand.
+	ShadowLeft int `datastore:"shadow_left"`
+	// Unavailable (ie. on vacation) until the time, skipped when picking who's on-call.
+	AwayUntil time.Time `datastore:"away_until"`
+	// Backup covering the member while away, or paged when the member is unreachable.
+	FallbackName string `datastore:"fallback_name"`
+	FallbackId   string `datastore:"fallback_id"`
+}
+
+// A team as written by "export", in JSON.
+type exportedTeam struct {
+	Team     string           `json:"team"`
+	Aliases  []string         `json:"aliases,omitempty"`
+	Managers []exportedRef    `json:"managers"`
+	Rotation []exportedMember `json:"rotation"`
+	Cadence  string           `json:"cadence,omitempty"`
+	// RFC3339, set only with a cadence.
+	Anchor      string        `json:"anchor,omitempty"`
+	Timezone    string        `json:"timezone,omitempty"`
+	Channels    []exportedRef `json:"channels,omitempty"`
+	Notes       string        `json:"notes,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Updated     time.Time     `json:"updated"`
+	UpdatedBy   string        `json:"updated_by"`
+}
+
+// Slack user or channel.
+type exportedRef struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+type exportedMember struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Label  string `json:"label,omitempty"`
+	Shadow bool   `json:"shadow,omitempty"`
+}
+
+// State of a team right before its last change, used to undo the change.
+type snapshotProperty struct {
+	Key       *datastore.Key `datastore:"-"`
+	Team      string         `datastore:"team"`
+	Operation string         `datastore:"operation"`
+	// The whole team as it was.
+	State oncallProperty `datastore:"state"`
+	// Only the lists were kept by snapshots taken before the whole team was.
+	Managers  []ManagerProperty  `datastore:"managers"`
+	Rotations []RotationProperty `datastore:"users"`
+	Updated   time.Time          `datastore:"updated"`
+	UpdatedBy string             `datastore:"updated_by"`
+	// When and by whom the change was made.
+	Taken   time.Time `datastore:"taken"`
+	TakenBy string    `datastore:"taken_by"`
+}
+
+// A change made to a team, along with the on-call list right after the change.
+type historyProperty struct {
+	Team      string             `datastore:"team"`
+	Operation string             `datastore:"operation"`
+	Detail    string             `datastore:"detail,noindex"`
+	By        string             `datastore:"by"`
+	Time      time.Time          `datastore:"time"`
+	Rotations []RotationProperty `datastore:"users,noindex"`
+}
+
+// Offer of "cover-needed" for someone to cover primary on-call of the team, its
+// button only carries the key. Taken once, by the first member clicking it.
+type offerProperty struct {
+	Key  *datastore.Key `datastore:"-"`
+	Team string         `datastore:"team"`
+	From time.Time      `datastore:"from,noindex"`
+	To   time.Time      `datastore:"to,noindex"`
+	// Who needs the cover, and when they asked.
+	Requester     string    `datastore:"requester,noindex"`
+	RequesterName string    `datastore:"requester_name,noindex"`
+	Created       time.Time `datastore:"created,noindex"`
+	// Who took it, empty while it's open.
+	TakenBy   string `datastore:"taken_by,noindex"`
+	TakenById string `datastore:"taken_by_id,noindex"`
+}
+
+// Scheduled report of a team's on-call list.
+type reportProperty struct {
+	Key       *datastore.Key `datastore:"-"`
+	Team      string         `datastore:"team"`
+	Frequency string         `datastore:"frequency"`
+	Weekday   int            `datastore:"weekday"`
+	Hour      int            `datastore:"hour"`
+	Minute    int            `datastore:"minute"`
+	// Channel/user id (or channel name) to post to, and its display name.
+	Destination     string    `datastore:"destination"`
+	DestinationName string    `datastore:"destination_name"`
+	Next            time.Time `datastore:"next"`
+	CreatedBy       string    `datastore:"created_by"`
+}
+
+// Member set on-call for a date range by "assign", covering primary over the rotation.
+type assignmentProperty struct {
+	Key  *datastore.Key `datastore:"-"`
+	Team string         `datastore:"team"`
+	Name string         `datastore:"name"`
+	Id   string         `datastore:"id"`
+	// Start (inclusive) and end (exclusive) of the assignment.
+	Start     time.Time `datastore:"start"`
+	End       time.Time `datastore:"end"`
+	CreatedBy string    `datastore:"created_by"`
+}
+
+// Operation run by an alias, keyed by the alias.
+type opAliasProperty struct {
+	Operation string    `datastore:"operation,noindex"`
+	Updated   time.Time `datastore:"updated"`
+	UpdatedBy string    `datastore:"updated_by"`
+}
+
+// Phone number set by the bot for a user who has none in the Slack profile.
+// Key is the Slack user_id.
+type phoneProperty struct {
+	Phone     string    `datastore:"phone,noindex"`
+	Updated   time.Time `datastore:"updated"`
+	UpdatedBy string    `datastore:"updated_by"`
+}
+
+// Identities of a user in systems outside Slack, set by "link-me" and keyed by
+// Slack user_id.
+type identityProperty struct {
+	PagerDuty string    `datastore:"pagerduty" json:"pagerduty,omitempty"`
+	GitHub    string    `datastore:"github" json:"github,omitempty"`
+	Updated   time.Time `datastore:"updated" json:"-"`
+}
+
+// Slash command received, kept for "admin replay" for "command_log_days".
+// Token and response URL are never recorded.
+type commandLogProperty struct {
+	Time        time.Time `datastore:"time"`
+	UserId      string    `datastore:"user_id,noindex"`
+	UserName    string    `datastore:"user_name,noindex"`
+	ChannelId   string    `datastore:"channel_id,noindex"`
+	ChannelName string    `datastore:"channel_name,noindex"`
+	Command     string    `datastore:"command,noindex"`
+	Text        string    `datastore:"text,noindex"`
+	// Text of the response, shortened.
+	Response string `datastore:"response,noindex"`
+	// Workspace and Enterprise Grid org the command came from.
+	TeamId       string `datastore:"team_id,noindex"`
+	EnterpriseId string `datastore:"enterprise_id,noindex"`
+}
+
+// Change of a team planned by "plan", keyed by the team. The on-call list is
+// flushed, or replaced with the one of the source team, at the time.
+type planProperty struct {
+	Key    *datastore.Key `datastore:"-"`
+	Team   string         `datastore:"team"`
+	Source string         `datastore:"source,noindex"`
+	Apply  time.Time      `datastore:"apply"`
+	// Who planned it, and when.
+	By      string    `datastore:"by,noindex"`
+	ById    string    `datastore:"by_id,noindex"`
+	Created time.Time `datastore:"created,noindex"`
+}
+
+// Bot token of another workspace or Enterprise Grid org, from "slack_org_tokens".
+type orgToken struct {
+	id, token string
+}
+
+// Last run of a cron job, keyed by the job name.
+type jobProperty struct {
+	LastRun    time.Time `datastore:"last_run"`
+	DurationMs int64     `datastore:"duration_ms,noindex"`
+	// HTTP status the job responded with, 4xx/5xx for failures.
+	Status      int       `datastore:"status,noindex"`
+	LastSuccess time.Time `datastore:"last_success"`
+	// The job being overdue was posted to "alert_channel" already.
+	Alerted bool `datastore:"alerted,noindex"`
+}
+
+// Roles of the first positions in an on-call list.
+// Rotating the list promotes secondary to primary.
+const (
+	rolePrimary   = "primary"
+	roleSecondary = "secondary"
+)
+
+// Positions (1-origin) of each role in the on-call list.
+var rolePositions = map[string]int{rolePrimary: 1, roleSecondary: 2}
+
+// Operations users can run, which an operation alias can stand for.
+var operations = []string{
+	"list", "next", "who", "at", "history", "stats", "fairness", "export", "directory", "broadcast-primaries", "admin", "page", "add", "remove", "swap", "move", "rotate", "cadence", "schedule", "simulate",
+	"copy", "shuffle", "reverse", "label", "away", "fallback", "override", "assign", "cover-needed", "shift", "quiet", "digest", "onboard", "rest", "cap", "streak", "plan", "handoff", "note", "describe", "undo", "flush", "register", "unregister", "rename", "import",
+	"restrict", "permit", "alias", "unalias", "opalias", "promote", "demote", "flush-managers", "update", "setphone", "link-me", "whoami", "report", "help",
+}
+
+// Operations reordering the on-call list, refused if they break the team's
+// minimum rest or monthly cap of primary shifts.
+var reorderOperations = []string{"swap", "move", "rotate", "shuffle", "reverse", "fairness"}
+
+// Operations users of other workspaces can run from a shared channel, only for
+// the teams bound to the channel.
+var externalOperations = []string{"list", "next", "who", "help"}
+
+// Operations which only display, so running them again (ie. for another page,
+// or by "admin replay") doesn't change anything.
+var readOnlyOperations = []string{"list", "next", "who", "at", "history", "stats", "export", "directory", "simulate", "whoami", "help"}
+
+// Roles "permit" can require for an operation, least privileged first.
+const (
+	permEveryone  = "everyone"
+	permMember    = "member"
+	permManager   = "manager"
+	permSuperuser = "superuser"
+)
+
+var permRoles = []string{permEveryone, permMember, permManager, permSuperuser}
+
+// How long "permit --shadow" logs the role before enforcing it.
+const permitShadow = 7 * 24 * time.Hour
+
+// Operations on a team whose required role "permit" can change. Operations as
+// powerful as register/unregister always require superuser.
+var permitOperations = []string{
+	"list", "next", "who", "at", "history", "stats", "fairness", "export", "page", "add", "remove", "swap", "move", "rotate", "cadence", "schedule", "simulate",
+	"shuffle", "reverse", "label", "away", "fallback", "override", "assign", "cover-needed", "shift", "quiet", "digest", "onboard", "rest", "cap", "streak", "plan", "note", "describe", "undo", "flush", "report",
+	"alias", "unalias", "promote", "demote",
 }
 
 const (
 	// Datastore kind for oncall states.
 	oncallKind = "oncall_list"
+	// Datastore kind for pre-change snapshots of teams.
+	snapshotKind = "oncall_snapshot"
+	// Datastore kind for change log of teams.
+	historyKind = "oncall_history"
+	// Datastore kind for scheduled reports.
+	reportKind = "oncall_report"
+	// Datastore kind for phone overrides.
+	phoneKind = "oncall_phone"
+	// Datastore kind for operation aliases.
+	opAliasKind = "oncall_opalias"
+	// Datastore kind for date-based assignments.
+	assignmentKind = "oncall_assignment"
+	// Datastore kind for identities in other systems.
+	identityKind = "oncall_identity"
+	// Datastore kind for last runs of cron jobs.
+	jobKind = "oncall_job"
+	// Datastore kind for received commands.
+	commandLogKind = "oncall_command"
+	// Datastore kind for planned changes of teams.
+	planKind = "oncall_plan"
+	// Datastore kind for offers of "cover-needed".
+	offerKind = "oncall_offer"
+	// Longest text and response of a command recorded.
+	commandLogMax = 1000
+	// Task queue handler offboarding users from every team.
+	offboardTaskPath = "/tasks/offboard"
+	// Callback id of the team picker menu.
+	callbackTeamPicker = "team_picker"
+	// Callback id of the confirm/cancel buttons of change previews.
+	callbackConfirm = "confirm_change"
+	// Callback id of the undo button shown after destructive changes.
+	callbackRestore = "restore_change"
+	// Callback id of the accept/decline buttons sent to the incoming primary.
+	callbackHandoff = "handoff"
+	// Callback id of the button taking an offer to cover primary on-call.
+	callbackCover = "cover"
+	// Callback id of the previous/next buttons of a response split into pages.
+	callbackPage = "page"
+	// Most attachments Slack displays in one message without truncating.
+	maxAttachments = 20
+	// Days of changes counted by "stats".
+	statsDays = 30
+	// Months of history "fairness" counts by default, and at most.
+	defaultFairnessMonths = 3
+	maxFairnessMonths     = 12
 	// Short representation of modified timestamp.
 	dateFormat = "2006-01-02 15:04"
+	// Start and end of an override scheduled ahead.
+	overrideFormat = "2006-01-02T15:04"
+	// Lines per page of a response unless "response_max_lines" is set. Slack
+	// rejects messages with more than 100 attachments, which "list all" can reach.
+	defaultMaxLines = 60
+	// Handoffs shown by "schedule preview" by default, a quarter of weekly ones.
+	defaultPreview = 13
+	// Days ahead overlapping covers and times nobody is on-call are looked for.
+	coverageDays = 14
+	// Longest minimum rest "rest" takes, in days.
+	maxRestDays = 90
+	// Most primary shifts a month "cap" takes, one a day.
+	maxMonthlyCap = 31
+	// Longest limit of days in a row as primary "streak" takes.
+	maxStreakDays = 90
+	// Most handoffs "onboard" lets new members shadow for.
+	maxOnboarding = 20
+	// Most handoffs "schedule preview" shows.
+	maxPreview = 52
+	// Upcoming handoffs posted with a scheduled report.
+	reportHandoffs = 4
 )
 
 var (
@@ -94,8 +550,33 @@ var (
 	slackCommandToken string
 	// Token used to call Slack API.
 	slackAPIToken string
+	// Workspace or Enterprise Grid org the command is installed in. Commands from
+	// others (shared channels) are only allowed "externalOperations".
+	homeTeamId string
+	// Tokens of other workspaces or orgs users are looked up with if the
+	// installing one doesn't know them.
+	orgTokens []orgToken
+	// Teams whose command responses are rendered as Block Kit blocks instead of
+	// attachments, "ALL" for every team.
+	blockKitTeams map[string]bool
 	// Actual command to trigger oncall operations. Default "/oncall"
 	command string = "/oncall"
+	// Use the shared keep-alive HTTP transport to talk to Slack instead of urlfetch.
+	directTransport bool
+	// Average Slack API latency to start skipping optional work. 0 to disable.
+	degradeLatency time.Duration
+	// Set once manager flags are loaded.
+	managersLoaded bool
+	// Number of changes displayed by "history". Default 10.
+	historySize int
+	// Ask for confirmation with a before/after preview before "swap" and "move".
+	confirmReorder bool
+	// Months a phone set by "setphone" is trusted by the phone audit. Audit is disabled if negative.
+	phoneAuditMonths int
+	// How long before a scheduled handoff the outgoing and incoming primary are DMed. 0 to disable.
+	handoffReminder time.Duration
+	// Custom profile field (id or label) holding the phone, instead of the standard one.
+	phoneField string
 	// Slack user data cache duration.
 	cacheTimeout time.Duration
 	// Timeout per operation.
@@ -103,10 +584,28 @@ var (
 	opTimeout time.Duration
 	// Timezone to use for updated timestamp. Default GMT.
 	timezone *time.Location
+	// Timezones of teams loaded so far, by name.
+	teamLocations = map[string]*time.Location{}
+	// Mutex lock for accessing timezones of teams.
+	teamLocationMut sync.Mutex
 	// List of Slack user names to be treated as "superuser"
 	superusers []string
 	// Flag to tell us if Slack admins shouldn't be given superuser permission automatically.
 	adminDisabled bool
+	// Base URL this application is served at, used to link web views from Slack messages.
+	publicURL string
+	// Static token required to view the wallboard page. Wallboard is disabled if empty.
+	wallboardToken string
+	// Static token integrations read identities linked by "link-me" with. Disabled if empty.
+	identitiesToken string
+	// Channel to post the ranking of teams by health score to. Not posted if empty.
+	healthChannel string
+	// Channel to alert about overdue cron jobs in. No alerts if empty.
+	alertChannel string
+	// Days received commands are kept for "admin replay". Not recorded if 0.
+	commandLogDays int
+	// Lines of attachments shown per page of a response. No pages if 0.
+	responseMaxLines int
 	// Full name of "@admins" default Slack admin account.
 	// If sub-teamID is provided in configuration it'll be <!subteam^SUBTEAMID|@aminds>
 	// which will be displayed as "mention" and clickable.
@@ -123,21 +622,79 @@ var (
 	// List of users assigned in oncall rotation per team.
 	rotations oncallProperties
 	// Mutex lock for accessing oncall rotations.
-	oncallMut sync.RWMutex
+	oncallMut rotationsMutex
+	// Assignments not over yet by team, sorted by start.
+	// Guarded by oncallMut, nil until loaded.
+	assignments map[string][]*assignmentProperty
 	// Internal list of Slack users.
 	// Key is Slack user_id
 	slackUsers map[string]*slackUser
+	// Phone numbers set by "setphone", used when the Slack profile has none.
+	// Key is Slack user_id. Guarded by slackMut, nil until loaded.
+	phoneOverrides map[string]string
 	// Mutex lock for accessing Slack user map.
 	slackMut sync.RWMutex
+	// Other names of operations set by "opalias", ie. "ls" for "list".
+	// Key is the alias. Guarded by opAliasMut, nil until loaded.
+	opAliases map[string]string
+	// Mutex lock for accessing operation aliases.
+	opAliasMut sync.RWMutex
 	// Generic help text
 	helpList       string
+	helpNext       string
 	helpAdd        string
 	helpRemove     string
 	helpSwap       string
 	helpFlush      string
 	helpRegister   string
 	helpUnregister string
+	helpRename     string
+	helpSetphone   string
+	helpRestrict   string
+	helpPermit     string
+	helpAlias      string
 	helpUpdate     string
+	helpWhoami     string
+	helpLinkMe     string
+	helpReport     string
+	helpRotate     string
+	helpMove       string
+	helpCopy       string
+	helpShuffle    string
+	helpLabel      string
+	helpOverride   string
+	helpAssign     string
+	helpShift      string
+	helpDigest     string
+	helpHandoff    string
+	helpAt         string
+	helpOnboard    string
+	helpRest       string
+	helpCap        string
+	helpStreak     string
+	helpPlan       string
+	helpCover      string
+	helpQuiet      string
+	helpAway       string
+	helpFallback   string
+	helpNote       string
+	helpDescribe   string
+	helpExport     string
+	helpDirectory  string
+	helpBroadcast  string
+	helpAdmin      string
+	helpOpalias    string
+	helpPromote    string
+	helpFlushMgr   string
+	helpStats      string
+	helpFairness   string
+	helpImport     string
+	helpCadence    string
+	helpSchedule   string
+	helpSimulate   string
+	helpUndo       string
+	helpHistory    string
+	helpPage       string
 )
 
 // Operation requestor name and id.
@@ -155,6 +712,10 @@ type opAdd struct {
 	team string
 	// Optional custom label.
 	label string
+	// Optional position (1-origin) to add the user at. 0 to add at the end.
+	position int
+	// Add as a shadow, listed but never on-call.
+	shadow bool
 	// Requestor information.
 	by opRequestor
 }
@@ -165,14 +726,397 @@ type opSwap struct {
 	team string
 	// Positions to update.
 	positions []int
+	// Labels the positions are counted within, empty to count the whole list.
+	labels []string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "move" operation
+type opMove struct {
+	// Team to be updated.
+	team string
+	// Position to take the member from, and to put back to.
+	from, to int
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "copy" operation
+type opCopy struct {
+	// Team to copy the on-call list from.
+	source string
+	// Team to copy the on-call list to.
+	team string
+	// Copy managers of the source team as well.
+	managers bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "cadence" operation
+type opCadence struct {
+	// Team to be updated.
+	team string
+	// "daily", "weekly", "biweekly", or empty to stop rotating automatically.
+	cadence string
+	// First handoff.
+	anchor time.Time
+	// Handoff moment given by "schedule" instead of the anchor. Weekday is ignored
+	// for "daily".
+	schedule     bool
+	weekday      time.Weekday
+	hour, minute int
+	// Timezone set by "schedule", empty to keep the team's.
+	timezone string
+	// Number of upcoming handoffs to show instead of changing anything.
+	preview int
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "simulate" operation
+type opSimulate struct {
+	// Team to be simulated.
+	team string
+	// "daily", "weekly" or "biweekly".
+	cadence string
+	// Day of the first handoff.
+	date time.Time
+	// Time of the handoffs, hour is -1 to keep the team's.
+	hour, minute int
+	// Number of handoffs to show.
+	handoffs int
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "rotate" operation
+type opRotate struct {
+	// Team to be updated.
+	team string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "label" operation
+type opLabel struct {
+	// Team to be updated.
+	team string
+	// Member to be updated, either by user id or by position (1-origin).
+	id       string
+	position int
+	// New label, empty to clear.
+	label string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "override" operation
+type opOverride struct {
+	// Team to be updated.
+	team string
+	// User covering the primary on-call. Empty to end the override.
+	name string
+	id   string
+	// Start of the override, zero for right away, and its end.
+	start time.Time
+	until time.Time
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "assign" operation
+type opAssign struct {
+	// Team to be updated.
+	team string
+	// Member assigned. Empty to show the assignments of the team.
+	name string
+	id   string
+	// First and last day of the assignment.
+	from, to time.Time
+	// Remove upcoming assignments of the member instead.
+	cancel bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "permit" operation
+type opPermit struct {
+	// Team to be updated.
+	team string
+	// Operation to change. Empty to show the permissions of the team.
+	operation string
+	// Role required from now on, empty to go back to the default.
+	role string
+	// Only log what the role would allow or deny for a while before enforcing it.
+	shadow bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "quiet" operation
+type opQuiet struct {
+	// Team to be updated.
+	team string
+	// Hours and who takes primary on-call in them, empty label to remove them.
+	quiet ShiftProperty
+	// Display the current quiet hours instead.
+	show bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "at" operation
+type opAt struct {
+	// Team to look up.
+	team string
+	// Time to tell who was on-call at.
+	at time.Time
+}
+
+// Values needed for "handoff" operation
+type opHandoff struct {
+	// Team to be updated.
+	team string
+	// "accept", "decline" or "resume".
+	action string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "cover-needed" operation
+type opCoverNeeded struct {
+	// Team to be covered.
+	team string
+	// Time to be covered.
+	from, to time.Time
+	// Taking the offer, from its button.
+	take  bool
+	offer int64
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "rest" operation
+type opRest struct {
+	// Team to be updated.
+	team string
+	// Minimum days between primary shifts, 0 to remove it.
+	days int
+	// Display the current minimum rest instead.
+	show bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "cap" operation
+type opCap struct {
+	// Team to be updated.
+	team string
+	// Most primary shifts a month, 0 to remove the cap.
+	shifts int
+	// Display the current cap instead.
+	show bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "streak" operation
+type opStreak struct {
+	// Team to be updated.
+	team string
+	// Most days in a row as primary, 0 to remove the limit.
+	days int
+	// Display the current limit instead.
+	show bool
 	// Requestor information.
 	by opRequestor
 }
 
+// Values needed for "plan" operation
+type opPlan struct {
+	// Team to be updated.
+	team string
+	// Team whose list replaces the one of the team, empty to flush it.
+	source string
+	// When the change is applied.
+	apply time.Time
+	// Display or cancel the current plan instead.
+	show, cancel bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "onboard" operation
+type opOnboard struct {
+	// Team to be updated.
+	team string
+	// Handoffs new members shadow for, 0 to stop onboarding.
+	shifts int
+	// Display the current onboarding instead.
+	show bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "digest" operation
+type opDigest struct {
+	// Team to be updated.
+	team string
+	// Channel to post the digest to, empty to stop it.
+	channel ChannelProperty
+	// Display the current channel instead.
+	show bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "shift" operation
+type opShift struct {
+	// Team to be updated.
+	team string
+	// Label of the members on duty during the shift. Empty to show the shifts of the team.
+	label string
+	// Minutes after midnight the shift starts and ends.
+	start, end int
+	// Remove the shift instead.
+	cancel bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "away" operation
+type opAway struct {
+	// Team to be updated.
+	team string
+	// Member marked away.
+	name string
+	id   string
+	// Return time, zero to mark the member back.
+	until time.Time
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "fallback" operation
+type opFallback struct {
+	// Team to be updated.
+	team string
+	// Member the fallback is set for.
+	name string
+	id   string
+	// Fallback of the member. Empty to clear.
+	fallbackName string
+	fallbackId   string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "note" operation
+type opNote struct {
+	// Team to be updated.
+	team string
+	// Note text, empty to clear.
+	text string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "describe" operation
+type opDescribe struct {
+	// Team to be updated.
+	team string
+	// Description text, empty to clear.
+	text string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "shuffle" and "reverse" operations
+type opShuffle struct {
+	// Team to be updated.
+	team string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "undo" operation
+type opUndo struct {
+	// Team to revert the last change of.
+	team string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "page" operation
+type opPage struct {
+	// Team to page.
+	team string
+	// Message to send to the on-call.
+	message string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "broadcast-primaries" operation
+type opBroadcast struct {
+	// Message to send to every primary on-call.
+	message string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "directory" operation
+type opDirectory struct {
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "export" operation
+type opExport struct {
+	// Team to export.
+	team string
+}
+
+// Values needed for "stats" operation
+type opStats struct {
+	// Team to summarize.
+	team string
+}
+
+// Values needed for "fairness" operation
+type opFairness struct {
+	// Team to count the shifts of.
+	team string
+	// Months of history to count.
+	months int
+	// Propose a reordered list evening out the load.
+	rebalance bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "history" operation
+type opHistory struct {
+	// Team to display the changes of.
+	team string
+}
+
 // Values needed for "list" operation.
 type opList struct {
 	// Optional, list up oncall rotation for this team.
 	team string
+	// List up oncall rotation of every team.
+	all bool
+}
+
+// Values needed for "next" operation.
+type opNext struct {
+	// Team to show the on-call of.
+	team string
+	// Role to show, primary or secondary.
+	role string
 }
 
 // Values needed for "remove" operation.
@@ -181,6 +1125,10 @@ type opRemove struct {
 	name string
 	// Id of user to be removed from rotation.
 	id string
+	// Position (1-origin) to be removed instead of the user, 0 if the user is given.
+	position int
+	// Label the position is counted within, or the user's entry must have.
+	label string
 	// Name of team the requested user will be removed from.
 	team string
 	// Requestor information.
@@ -191,6 +1139,8 @@ type opRemove struct {
 type opFlush struct {
 	// team to be cleared its rotation.
 	team string
+	// Clear the managers instead of the rotation.
+	managers bool
 	// Requestor information.
 	by opRequestor
 }
@@ -219,12 +1169,148 @@ type opUnregister struct {
 	by opRequestor
 }
 
+// Values needed for "rename" operation.
+type opRename struct {
+	// Team to be renamed.
+	team string
+	// New name of the team.
+	name string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "import" operation.
+type opImport struct {
+	// Team to replace managers and on-call list of.
+	team string
+	// Decoded payload.
+	data exportedTeam
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "setphone" operation.
+type opSetphone struct {
+	// User to set the phone of.
+	id   string
+	name string
+	// Phone number, empty to clear.
+	phone string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "restrict" operation.
+type opRestrict struct {
+	// Team to be restricted.
+	team string
+	// Channels allowed to change the team, nil to lift the restriction.
+	channels []ChannelProperty
+	// Show current restriction only.
+	show bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "alias" and "unalias" operations.
+type opAlias struct {
+	// Team to be updated.
+	team string
+	// Alias to be added or removed. Show current aliases if empty.
+	alias string
+	// Remove the alias instead of adding.
+	remove bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "opalias" operation.
+type opOpalias struct {
+	// Alias to be set or removed. Show current aliases if empty.
+	alias string
+	// Operation the alias runs. Remove the alias if empty.
+	operation string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "promote" and "demote" operations.
+type opPromote struct {
+	// Team to be updated.
+	team string
+	// User to be made (or no longer be) a manager.
+	name string
+	id   string
+	// Remove from managers instead of adding.
+	demote bool
+	// Requestor information.
+	by opRequestor
+}
+
 // Values needed for "update" operation.
 type opUpdate struct {
 	id   string
 	name string
 }
 
+// Values needed for "report" operation.
+type opReport struct {
+	// Team to report.
+	team string
+	// "daily" or "weekly". Empty to list current reports of the team.
+	frequency string
+	// Day of week for weekly reports.
+	weekday time.Weekday
+	// Time of day to post the report.
+	hour, minute int
+	// Channel/user to post the report to.
+	dest, destName string
+	// Set if reports to dest should be cancelled.
+	cancel bool
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "admin" operation.
+type opAdmin struct {
+	// What to display - "jobs", "commands", or "replay" of a command, or "offboard"
+	// a user.
+	view string
+	// Command to replay, by its datastore id.
+	id int64
+	// User to offboard.
+	userId   string
+	userName string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "link-me" operation.
+type opLinkMe struct {
+	// System the identity is in, empty to show the requestor's identities.
+	system string
+	// Identity in the system, empty to remove it.
+	handle string
+	// Requestor information.
+	by opRequestor
+}
+
+// Values needed for "whoami" operation.
+type opWhoami struct {
+	id   string
+	name string
+}
+
+// Values needed to ask the user which team an operation is for.
+type opPick struct {
+	// Operation the team was omitted from.
+	op string
+	// Remaining parameters given to the operation.
+	args []string
+	// Requestor information.
+	by opRequestor
+}
+
 // Sort function for the team list.
 func (r oncallProperties) Len() int {
 	return len(r)
@@ -259,4 +1345,16 @@ var (
 // Context key
 type ctxKey int
 
-const ctxKeyUserId ctxKey = 1
+const (
+	ctxKeyUserId ctxKey = 1
+	// Set when the operation was confirmed from a preview.
+	ctxKeyConfirmed ctxKey = 2
+	// Set for background work, which gets lower priority for Slack API calls.
+	ctxKeyBackground ctxKey = 3
+	// Slash command the request was made with.
+	ctxKeyCommand ctxKey = 4
+	// Operation being decoded, for permissions set by "permit".
+	ctxKeyOperation ctxKey = 5
+	// Set when the request comes from another workspace, ie. a shared channel.
+	ctxKeyExternal ctxKey = 6
+)
@@ -2,10 +2,9 @@ package slackoncallbot
 
 import (
 	"errors"
-	"github.com/nlopes/slack"
+	"github.com/slack-go/slack"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/urlfetch"
 	"strings"
 	"time"
 )
@@ -13,31 +12,107 @@ import (
 // func userHasPerm {{{
 
 // Check if the requestor is a manager of the requested team, or an exempt user.
+// If "permit" changed the role the operation being decoded requires for the team,
+// that role is checked instead.
 func userHasPerm(ctx context.Context, id, team string) bool {
+	if op, ok := ctx.Value(ctxKeyOperation).(string); ok {
+		if role := teamPermission(team, op); role != "" {
+			return userHasRole(ctx, id, team, role)
+		}
+	}
+	return userIsTeamManager(ctx, id, team)
+} // }}}
+
+// func userIsTeamManager {{{
+
+// Check if the requestor is a manager of the requested team, or an exempt user.
+func userIsTeamManager(ctx context.Context, id, team string) bool {
 	// If the user is exempt, let them update.
 	if userIsExempt(ctx, id) {
 		return true
 	}
 
 	// If the user is a manager of the team, let them update.
-	var managers []ManagerProperty
 	oncallMut.RLock()
-	for _, r := range rotations {
+	defer oncallMut.RUnlock()
+	for _, r := range rotationsIndex().managers[id] {
 		if r.Team == team {
-			managers = r.Managers
+			return true
 		}
 	}
-	oncallMut.RUnlock()
-	if len(managers) == 0 {
+
+	return false
+} // }}}
+
+// func userHasRole {{{
+
+// Check if the requestor has at least the role (see "permit") for the team.
+func userHasRole(ctx context.Context, id, team, role string) bool {
+	switch role {
+	case permEveryone:
+		return true
+	case permMember:
+		if userIsTeamManager(ctx, id, team) {
+			return true
+		}
+		oncallMut.RLock()
+		defer oncallMut.RUnlock()
+		if r := findRotation(team); r != nil {
+			for _, u := range r.Rotations {
+				if u.Id == id {
+					return true
+				}
+			}
+		}
 		return false
+	case permManager:
+		return userIsTeamManager(ctx, id, team)
+	default:
+		return userIsExempt(ctx, id)
 	}
-	for _, manager := range managers {
-		if manager.Id == id {
-			return true
+} // }}}
+
+// func teamPermission {{{
+
+// Return the role "permit" set for the operation of the team, or empty string if
+// the operation requires the default one. Roles still in shadow mode don't count.
+func teamPermission(team, op string) string {
+	if p, ok := teamPermissionRule(team, op); ok && !time.Now().Before(p.Enforce) {
+		return p.Role
+	}
+	return ""
+} // }}}
+
+// func teamPermissionRule {{{
+
+// Return what "permit" set for the operation of the team, if anything.
+func teamPermissionRule(team, op string) (PermissionProperty, bool) {
+	oncallMut.RLock()
+	defer oncallMut.RUnlock()
+	r := findRotation(team)
+	if r == nil {
+		return PermissionProperty{}, false
+	}
+	for _, p := range r.Permissions {
+		if p.Operation == op {
+			return p, true
 		}
 	}
+	return PermissionProperty{}, false
+} // }}}
 
-	return false
+// func defaultRole {{{
+
+// Return the role the operation requires unless "permit" changed it.
+func defaultRole(op string) string {
+	switch op {
+	case "list", "next", "who", "at", "history", "stats", "fairness", "export", "page":
+		return permEveryone
+	case "away", "fallback", "cover-needed":
+		// For themselves.
+		return permMember
+	}
+	return permManager
 } // }}}
 
 // func userIsManager {{{
@@ -78,7 +153,7 @@ func userIsExempt(ctx context.Context, id string) bool {
 		return false
 	}
 	if user == nil {
-		log.Warningf(ctx, "Slack inactive user trying to hack us!!! %d", id)
+		log.Warningf(ctx, "Slack inactive user trying to hack us!!! %s", id)
 		return false
 	}
 
@@ -124,22 +199,45 @@ func decodeUserEntity(entity string) (string, string) {
 // func userConvert {{{
 
 // Convert *slack.User into our slackUser struct.
+// Phone set by "setphone" is used if the profile has none.
 func userConvert(s *slack.User) *slackUser {
-	return &slackUser{
+	u := &slackUser{
 		name:      s.Name,
 		isAdmin:   s.IsAdmin,
 		phone:     s.Profile.Phone,
 		retrieved: time.Now(),
 	}
+	if u.phone == "" {
+		slackMut.RLock()
+		u.phone = phoneOverrides[s.ID]
+		slackMut.RUnlock()
+		u.phoneOverride = u.phone != ""
+	}
+	return u
 } // }}}
 
 // func getSlackUser {{{
 
 // Call Slack API to get user information of requested user.
 func getSlackUser(ctx context.Context, id string) (*slackUser, error) {
-	c := slack.New(slackAPIToken)
-	slack.HTTPClient.Transport = &urlfetch.Transport{Context: ctx}
-	user, err := c.GetUserInfo(id)
+	// Don't bother Slack if the request is already cancelled or timed out.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	client := newSlackClient(ctx)
+	start := time.Now()
+	user, err := client.GetUserInfoContext(ctx, id)
+	observeSlackLatency(ctx, time.Since(start))
+	// Users of other workspaces, ie. added from a shared channel, are only known to
+	// the token of their own.
+	for i := 0; err != nil && err.Error() == "user_not_found" && i < len(orgTokens); i++ {
+		client = newOrgSlackClient(ctx, orgTokens[i].token)
+		start = time.Now()
+		if user, err = client.GetUserInfoContext(ctx, id); err == nil && debug {
+			log.Infof(ctx, "found user %s with the token of %s", id, orgTokens[i].id)
+		}
+		observeSlackLatency(ctx, time.Since(start))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -152,9 +250,39 @@ func getSlackUser(ctx context.Context, id string) (*slackUser, error) {
 		return nil, nil
 	}
 
+	// Phone from the custom field goes first, the standard one is used if it's empty.
+	if phone, err := getProfileFieldPhone(ctx, client, id); err != nil {
+		log.Warningf(ctx, "error getting profile field %s of %s - %s", phoneField, id, err)
+	} else if phone != "" {
+		user.Profile.Phone = phone
+	}
+
 	return userConvert(user), nil
 } // }}}
 
+// func getProfileFieldPhone {{{
+
+// Get the phone in the custom profile field configured by "phone_field".
+// The field can be given by its id ("Xf0123ABCD") or its label ("Pager phone").
+// Empty string is returned if it's not configured, or while Slack is slow.
+func getProfileFieldPhone(ctx context.Context, client *slack.Client, id string) (string, error) {
+	if phoneField == "" || skipOptional(ctx, "profile field") {
+		return "", nil
+	}
+	start := time.Now()
+	profile, err := client.GetUserProfileContext(ctx, &slack.GetUserProfileParameters{UserID: id, IncludeLabels: true})
+	observeSlackLatency(ctx, time.Since(start))
+	if err != nil {
+		return "", err
+	}
+	for fid, f := range profile.FieldsMap() {
+		if fid == phoneField || strings.EqualFold(f.Label, phoneField) {
+			return strings.TrimSpace(f.Value), nil
+		}
+	}
+	return "", nil
+} // }}}
+
 // func getSlackUserDetail {{{
 
 // Get detail of requested user.
@@ -193,8 +321,8 @@ func getSlackUserDetail(ctx context.Context, id string, force bool) (*slackUser,
 	}
 
 	if user != nil {
-		// If the data is too old, refresh.
-		if time.Now().After(user.retrieved.Add(cacheTimeout)) {
+		// If the data is too old, refresh. Slack is slow at the moment? old data will do.
+		if time.Now().After(user.retrieved.Add(cacheTimeout)) && !skipOptional(ctx, "profile refresh") {
 			// Too old, get a new one.
 			newuser, err := getSlackUser(ctx, id)
 			if err != nil {
@@ -245,15 +373,23 @@ func getSlackUserDetail(ctx context.Context, id string, force bool) (*slackUser,
 	return user, nil
 } // }}}
 
+// func userCached {{{
+
+// Check if we have the user's profile in memory.
+func userCached(id string) bool {
+	slackMut.RLock()
+	defer slackMut.RUnlock()
+	_, ok := slackUsers[id]
+	return ok
+} // }}}
+
 // func loadSuperusers {{{
 
 // Initial load of configured superusers.
 // Since the list of users in configuration is all user_name but we need user_id so the detail
 // can be saved in our user_id key Slack user map.
 func loadSuperusers(ctx context.Context) error {
-	c := slack.New(slackAPIToken)
-	slack.HTTPClient.Transport = &urlfetch.Transport{Context: ctx}
-	users, err := c.GetUsers()
+	users, err := newSlackClient(ctx).GetUsersContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -273,6 +409,10 @@ func loadSuperusers(ctx context.Context) error {
 						phone:       user.Profile.Phone,
 						retrieved:   time.Now(),
 					}
+					if user.Profile.Phone == "" && phoneOverrides[user.ID] != "" {
+						slackUsers[user.ID].phone = phoneOverrides[user.ID]
+						slackUsers[user.ID].phoneOverride = true
+					}
 					log.Infof(ctx, "loaded superuser detail - %s", user.Name)
 				}
 				superusers = append(superusers[:idx], superusers[idx+1:]...)
@@ -322,15 +462,34 @@ func userSubManagerFlag(ctx context.Context, id string) error {
 // func loadManagers {{{
 
 // Pre-query Slack profile of the managers, then set manager flag.
-func loadManagers(ctx context.Context) error {
+// If it fails half way, the flags already set are taken back so it can be run again.
+func loadManagers(ctx context.Context) (err error) {
+	var flagged []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		slackMut.Lock()
+		for _, id := range flagged {
+			if u := slackUsers[id]; u != nil {
+				u.isManager -= 1
+			}
+		}
+		slackMut.Unlock()
+	}()
 	oncallMut.RLock()
 	defer oncallMut.RUnlock()
 	for _, r := range rotations {
 		if len(r.Managers) > 0 {
 			for _, m := range r.Managers {
-				if err := userAddManagerFlag(ctx, m.Id); err != nil {
+				// Stop here if the request is already gone.
+				if err = ctx.Err(); err != nil {
+					return err
+				}
+				if err = userAddManagerFlag(ctx, m.Id); err != nil {
 					return err
 				}
+				flagged = append(flagged, m.Id)
 			}
 		}
 	}
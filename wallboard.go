@@ -0,0 +1,170 @@
+package slackoncallbot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// How long signed links in Slack messages stay valid.
+const signedLinkTTL = 24 * time.Hour
+
+// One row of the wallboard.
+type wallboardRow struct {
+	Team   string
+	Name   string
+	Phone  string
+	Label  string
+	Health string
+}
+
+// Minimal page for NOC screens, refreshed every minute.
+var wallboardTemplate = template.Must(template.New("wallboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>On-call</title>
+<style>
+body { background: #111; color: #eee; font-family: sans-serif; margin: 2em; }
+table { width: 100%; border-collapse: collapse; font-size: 3em; }
+td { padding: 0.3em 0.5em; border-bottom: 1px solid #333; }
+td.team { color: #EF203D; font-weight: bold; }
+td.label, p { color: #888; }
+</style>
+</head>
+<body>
+<table>
+{{range .Rows}}<tr><td class="team">{{.Team}}</td><td>{{.Name}}</td><td>{{.Phone}}</td><td class="label">{{.Label}}</td><td class="label">{{.Health}}</td></tr>
+{{end}}</table>
+<p>updated: {{.Updated}}</p>
+</body>
+</html>
+`))
+
+// func wallboardHandler {{{
+
+// GET /wallboard?token={wallboard_token}
+// GET /wallboard?expires={unix}&sig={signature}
+//
+// Read-only page showing the current primary on-call of all teams.
+// Disabled unless "wallboard_token" is configured. Links posted in Slack are
+// pre-signed so the static token itself is never shared in messages.
+func wallboardHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(appengine.NewContext(r), ctxKeyBackground, true)
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+
+	if wallboardToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !viewAllowed(r) {
+		log.Warningf(ctx, "(wallboard) invalid token from %s", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := ensureState(ctx); err != nil {
+		http.Error(w, "error loading state", http.StatusInternalServerError)
+		return
+	}
+
+	// Copy over primaries so we don't hold the lock while talking to Slack.
+	var rows []wallboardRow
+	var ids []string
+	var teams []oncallProperty
+	oncallMut.RLock()
+	for _, t := range rotations {
+		teams = append(teams, *t)
+		row := wallboardRow{Team: t.Team, Name: "-"}
+		var id string
+		if u, ok := memberByRole(t, rolePrimary); ok {
+			row.Name = "@" + u.Name
+			row.Label = u.Label
+			id = u.Id
+		}
+		rows = append(rows, row)
+		ids = append(ids, id)
+	}
+	oncallMut.RUnlock()
+
+	now := time.Now()
+	for i, id := range ids {
+		rows[i].Health = strconv.Itoa(teamHealth(ctx, teams[i], now).score)
+		if id == "" {
+			continue
+		}
+		if user, err := getSlackUserDetail(ctx, id, false); err != nil || user == nil || user.phone == "" {
+			rows[i].Phone = "-"
+		} else {
+			rows[i].Phone = user.phone
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := wallboardTemplate.Execute(w, struct {
+		Rows    []wallboardRow
+		Updated string
+	}{rows, now.In(timezone).Format(dateFormat)})
+	if err != nil {
+		log.Warningf(ctx, "(wallboard) error rendering - %s", err)
+	}
+} // }}}
+
+// func viewAllowed {{{
+
+// Check the request to a read-only page has the wallboard token or a valid
+// pre-signed link.
+func viewAllowed(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.FormValue("token")), []byte(wallboardToken)) == 1 ||
+		validLinkSignature(r.URL.Path, r.FormValue("expires"), r.FormValue("sig"))
+} // }}}
+
+// func signLink {{{
+
+// Return signature of the path valid until the expiry (unix time).
+// Wallboard token is used as the signing key.
+func signLink(path string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(wallboardToken))
+	mac.Write([]byte(path + "|" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+} // }}}
+
+// func validLinkSignature {{{
+
+// Check a pre-signed link is not expired and its signature matches.
+func validLinkSignature(path, expires, sig string) bool {
+	if expires == "" || sig == "" {
+		return false
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(signLink(path, exp)))
+} // }}}
+
+// func signedURL {{{
+
+// Return a pre-signed absolute URL of the path, or empty string if links are
+// not available (public_url or wallboard_token not configured).
+func signedURL(path string) string {
+	if publicURL == "" || wallboardToken == "" {
+		return ""
+	}
+	exp := time.Now().Add(signedLinkTTL).Unix()
+	v := url.Values{}
+	v.Set("expires", strconv.FormatInt(exp, 10))
+	v.Set("sig", signLink(path, exp))
+	return publicURL + path + "?" + v.Encode()
+} // }}}